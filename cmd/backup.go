@@ -2,9 +2,11 @@ package cmd
 
 import (
 	"bytes"
-	"encoding/base64"
+	"fmt"
+	"strings"
 
-	"github.com/martient/bifrost-backups/pkg/crypto"
+	"github.com/martient/bifrost-backups/pkg/hooks"
+	localfiles "github.com/martient/bifrost-backups/pkg/local_files"
 	localstorage "github.com/martient/bifrost-backups/pkg/local_storage"
 	"github.com/martient/bifrost-backups/pkg/postgresql"
 	"github.com/martient/bifrost-backups/pkg/s3"
@@ -50,47 +52,18 @@ var backupCmd = &cobra.Command{
 				return
 			}
 
-			var result *bytes.Buffer
-
-			switch database.Type {
-			case setup.Postgresql:
-				result, err = postgresql.RunBackup(database.Postgresql)
-			case setup.Sqlite3:
-				result, err = sqlite3.RunBackup(database.Sqlite3)
+			run := hooks.Run{Database: database.Name, Status: hooks.StatusStarted}
+			err = database.Hooks.Run(hooks.PreBackup, run)
+			if err == nil {
+				run.Storage, run.Backup, err = backupDatabase(database)
+			}
+			if hooks_err := finishHooks(database.Hooks, hooks.PostBackup, run, err); hooks_err != nil && err == nil {
+				err = hooks_err
 			}
-
 			if err != nil {
 				utils.LogError("Something went wrong during the backuping process: %s", "CLI", err)
 				return
 			}
-			for i := 0; i < len(database.Storages); i++ {
-				storage, err := setup.ReadStorageConfig(database.Storages[i])
-				if err != nil {
-					utils.LogError("Something went wrong during the config reading: %s", "CLI", err)
-					return
-				}
-				cipher_key, err := base64.StdEncoding.DecodeString(storage.CipherKey)
-				if err != nil {
-					utils.LogError("Something went wrong during the convertion of the cipher key process: %s", "CLI", err)
-					return
-				}
-				cipher_result, err := crypto.Cipher(cipher_key, result.Bytes())
-				if err != nil {
-					utils.LogError("Something went wrong during the encryption process: %s", "CLI", err)
-					return
-				}
-				switch storage.Type {
-				case setup.LocalStorage:
-					err = localstorage.StoreBackup(storage.LocalStorage, cipher_result, storage.Compression)
-				case setup.S3:
-					err = s3.StoreBackup(storage.S3, result, storage.Compression)
-				}
-				if err != nil {
-					utils.LogError("Something went wrong during the storing process: %s", "CLI", err)
-					return
-				}
-				utils.LogInfo("Backup of %s successfully stored with %s", "CLI", database.Name, storage.Name)
-			}
 		}
 	},
 }
@@ -99,3 +72,63 @@ func init() {
 	rootCmd.AddCommand(backupCmd)
 	backupCmd.Flags().String("name", "", "Database name")
 }
+
+// backupDatabase dumps the database and stores the dump in each of its storages, it returns
+// the storages and the names of the stored backups separated by commas
+func backupDatabase(database setup.Database) (string, string, error) {
+	result, err := dumpDatabase(database)
+	if err != nil {
+		return "", "", err
+	}
+
+	var storages, backups []string
+	for i := 0; i < len(database.Storages); i++ {
+		storage, err := setup.ReadStorageConfig(database.Storages[i])
+		if err != nil {
+			return strings.Join(storages, ","), strings.Join(backups, ","), fmt.Errorf("config reading failed: %w", err)
+		}
+		backup_name, err := storeBackup(storage, database.Name, result.Bytes())
+		if err != nil {
+			return strings.Join(storages, ","), strings.Join(backups, ","), err
+		}
+		utils.LogInfo("Backup %s of %s successfully stored with %s", "CLI", backup_name, database.Name, storage.Name)
+		storages = append(storages, storage.Name)
+		backups = append(backups, backup_name)
+	}
+	return strings.Join(storages, ","), strings.Join(backups, ","), nil
+}
+
+// storeBackup encrypts the dump for the storage and stores it, every storage type only
+// ever receives the encrypted backup
+func storeBackup(storage setup.Storage, database_name string, dump []byte) (string, error) {
+	cipher_result, err := encryptBackup(storage, dump)
+	if err != nil {
+		return "", fmt.Errorf("encryption failed: %w", err)
+	}
+	var backup_name string
+	switch storage.Type {
+	case setup.LocalStorage:
+		backup_name, err = localstorage.StoreBackup(storage.LocalStorage, database_name, cipher_result, storage.CompressionOptions())
+	case setup.S3:
+		backup_name, err = s3.StoreBackup(storage.S3, database_name, cipher_result, storage.CompressionOptions(), storage.RetentionDays)
+	default:
+		err = fmt.Errorf("unsupported storage type %d", storage.Type)
+	}
+	if err != nil {
+		return "", fmt.Errorf("storing with %s failed: %w", storage.Name, err)
+	}
+	return backup_name, nil
+}
+
+// dumpDatabase dumps the database with the tool of its type
+func dumpDatabase(database setup.Database) (*bytes.Buffer, error) {
+	switch database.Type {
+	case setup.Postgresql:
+		return postgresql.RunBackup(database.Postgresql)
+	case setup.Sqlite3:
+		return sqlite3.RunBackup(database.Sqlite3)
+	case setup.LocalFiles:
+		return localfiles.RunBackup(database.LocalFiles)
+	}
+	return nil, fmt.Errorf("unsupported database type %d", database.Type)
+}
@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/martient/bifrost-backups/pkg/crypto"
+	localfiles "github.com/martient/bifrost-backups/pkg/local_files"
+	localstorage "github.com/martient/bifrost-backups/pkg/local_storage"
+	"github.com/martient/bifrost-backups/pkg/s3"
+	"github.com/martient/bifrost-backups/pkg/setup"
+)
+
+func TestDumpAndRestoreLocalFiles(t *testing.T) {
+	source := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(source, "conf"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(source, "conf", "app.ini"), []byte("port=8080\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	database := setup.Database{Name: "files", Type: setup.LocalFiles, LocalFiles: localfiles.LocalFilesRequirements{Path: source}}
+	dump, err := dumpDatabase(database)
+	if err != nil {
+		t.Fatalf("dumpDatabase() error = %v", err)
+	}
+
+	database.LocalFiles.Path = t.TempDir()
+	if err := restoreDatabase(database, dump); err != nil {
+		t.Fatalf("restoreDatabase() error = %v", err)
+	}
+	content, err := os.ReadFile(filepath.Join(database.LocalFiles.Path, "conf", "app.ini"))
+	if err != nil || strings.TrimSpace(string(content)) != "port=8080" {
+		t.Errorf("restored app.ini = %q, %v, want port=8080", content, err)
+	}
+}
+
+func TestUnsupportedDatabaseType(t *testing.T) {
+	database := setup.Database{Name: "unknown", Type: setup.DatabaseType(42)}
+	if _, err := dumpDatabase(database); err == nil {
+		t.Error("dumpDatabase() of an unsupported type should fail")
+	}
+	if err := restoreDatabase(database, bytes.NewBufferString("dump")); err == nil {
+		t.Error("restoreDatabase() of an unsupported type should fail")
+	}
+}
+
+func TestStoreBackupEncrypts(t *testing.T) {
+	cipher_key, err := crypto.GenerateCipherKey(32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storages := []setup.Storage{{
+		Type:         setup.LocalStorage,
+		Name:         "local",
+		CipherKey:    cipher_key,
+		LocalStorage: localstorage.LocalStorageRequirements{FolderPath: t.TempDir()},
+	}}
+	// The S3 storage runs against an S3 compatible server, e.g. MinIO
+	if endpoint := os.Getenv("BIFROST_TEST_S3_ENDPOINT"); endpoint != "" {
+		t.Setenv("BIFROST_UPLOAD_STATE_DIR", t.TempDir())
+		storages = append(storages, setup.Storage{
+			Type:      setup.S3,
+			Name:      "s3",
+			CipherKey: cipher_key,
+			S3: s3.S3Requirements{
+				BucketName:      "bifrost-store-backup",
+				AccessKeyId:     os.Getenv("BIFROST_TEST_S3_ACCESS_KEY"),
+				AccessKeySecret: os.Getenv("BIFROST_TEST_S3_SECRET_KEY"),
+				Region:          "us-east-1",
+				Endpoint:        endpoint,
+				UsePathStyle:    true,
+			},
+		})
+	}
+
+	dump := []byte("CREATE TABLE users (name TEXT); INSERT INTO users VALUES ('alice');")
+	for _, storage := range storages {
+		t.Run(storage.Name, func(t *testing.T) {
+			backup_name, err := storeBackup(storage, "app", dump)
+			if err != nil {
+				t.Fatalf("storeBackup() error = %v", err)
+			}
+			stored, err := pullStorageBackup(storage, "app", backup_name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Contains(stored.Bytes(), []byte("alice")) {
+				t.Error("the stored backup holds the plaintext dump")
+			}
+			decrypted, err := decryptBackup(storage, stored.Bytes(), "")
+			if err != nil || !bytes.Equal(decrypted.Bytes(), dump) {
+				t.Errorf("decryptBackup() = %q, %v, want the dump", decrypted, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/martient/bifrost-backups/pkg/s3"
+	"github.com/martient/bifrost-backups/pkg/setup"
+	"github.com/martient/golang-utils/utils"
+	"github.com/spf13/cobra"
+)
+
+var checkStorageCmd = &cobra.Command{
+	Use:   "check-storage",
+	Short: "Check the protection of the registered storages",
+	Long:  `Verify that the S3 buckets used with object lock have versioning and object lock enabled`,
+	Run: func(cmd *cobra.Command, args []string) {
+		var names []string
+
+		name, _ := cmd.Flags().GetString("name")
+		if name == "" {
+			fetched_names, err := setup.GetStorageConfigName()
+			if err != nil {
+				utils.LogError("Something went wrong during the config reading: %s", "CLI", err)
+				os.Exit(1)
+			} else if len(fetched_names) == 0 {
+				utils.LogWarning("No storage found", "CLI")
+				return
+			}
+			names = append(names, fetched_names...)
+		} else {
+			names = append(names, name)
+		}
+
+		failed := false
+		for i := 0; i < len(names); i++ {
+			storage, err := setup.ReadStorageConfig(names[i])
+			if err != nil {
+				utils.LogError("Something went wrong during the config reading: %s", "CLI", err)
+				os.Exit(1)
+			}
+			if storage.Type != setup.S3 {
+				utils.LogInfo("Storage %s has no object lock support, skipped", "CLI", storage.Name)
+				continue
+			}
+			if err := s3.CheckBucketProtection(storage.S3); err != nil {
+				utils.LogErrorInterface("Storage %s is not protected: %v", "CLI", storage.Name, err)
+				failed = true
+				continue
+			}
+			utils.LogInfo("Storage %s has versioning and object lock enabled", "CLI", storage.Name)
+		}
+
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(checkStorageCmd)
+	checkStorageCmd.Flags().String("name", "", "Storage name (default, every registered storage)")
+}
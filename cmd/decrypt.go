@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"os"
+
+	"github.com/martient/bifrost-backups/pkg/compression"
+	"github.com/martient/bifrost-backups/pkg/crypto"
+	"github.com/martient/golang-utils/utils"
+	"github.com/spf13/cobra"
+)
+
+var decryptCmd = &cobra.Command{
+	Use:   "decrypt",
+	Short: "Decrypt a backup file without the bifrost configuration",
+	Long: `Decrypt a backup file copied from a storage into the database dump, e.g. to restore it by hand
+when the host and its configuration are lost. Compressed backups are detected and decompressed.
+The key is derived from the passphrase (prompted, or BIFROST_PASSPHRASE), read from --identity-file
+for backups encrypted for recipients, or given with --cipher-key.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		input, _ := cmd.Flags().GetString("input")
+		output, _ := cmd.Flags().GetString("output")
+		if input == "" || output == "" {
+			utils.LogError("input, output can't be empty", "CLI", nil)
+			os.Exit(1)
+		}
+
+		content, err := os.ReadFile(input) //#nosec G304 -- path provided by the operator
+		if err != nil {
+			utils.LogError("Could not read the backup: %s", "CLI", err)
+			os.Exit(1)
+		}
+		content, err = compression.Decompress(content)
+		if err != nil {
+			utils.LogError("Decompression failed: %s", "CLI", err)
+			os.Exit(1)
+		}
+
+		identity_file, _ := cmd.Flags().GetString("identity-file")
+		cipher_key, _ := cmd.Flags().GetString("cipher-key")
+		plaintext, err := decryptFile(content, identity_file, cipher_key)
+		if err != nil {
+			utils.LogError("Something went wrong during the decryption process: %s", "CLI", err)
+			os.Exit(1)
+		}
+
+		file, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600) //#nosec G304 -- path provided by the operator
+		if err != nil {
+			utils.LogError("Could not create the output file: %s", "CLI", err)
+			os.Exit(1)
+		}
+		defer file.Close()
+		if _, err := file.Write(plaintext.Bytes()); err != nil {
+			utils.LogError("Could not write the output file: %s", "CLI", err)
+			os.Exit(1)
+		}
+		utils.LogInfo("Backup decrypted to %s", "CLI", output)
+	},
+}
+
+func decryptFile(content []byte, identity_file string, cipher_key string) (*bytes.Buffer, error) {
+	switch {
+	case crypto.HasRecipients(content):
+		return decryptWithIdentityFile(content, identity_file)
+	case crypto.HasPassphrase(content):
+		passphrase, err := readPassphrase("Enter the backup passphrase: ")
+		if err != nil {
+			return nil, err
+		}
+		return crypto.DecipherWithPassphrase(passphrase, content)
+	case cipher_key != "":
+		key, err := base64.StdEncoding.DecodeString(cipher_key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cipher key: %w", err)
+		}
+		return crypto.Decipher(key, content)
+	default:
+		return nil, fmt.Errorf("the backup is encrypted with a storage cipher key, provide it with --cipher-key")
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(decryptCmd)
+	decryptCmd.Flags().StringP("input", "i", "", "Backup file to decrypt")
+	decryptCmd.Flags().StringP("output", "o", "", "File receiving the decrypted dump")
+	decryptCmd.Flags().String("identity-file", "", "Identity file able to decrypt the backups encrypted for recipients")
+	decryptCmd.Flags().String("cipher-key", "", "Storage cipher key (base64) of the backup")
+}
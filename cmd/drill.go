@@ -0,0 +1,236 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/martient/bifrost-backups/pkg/drill"
+	localfiles "github.com/martient/bifrost-backups/pkg/local_files"
+	"github.com/martient/bifrost-backups/pkg/postgresql"
+	"github.com/martient/bifrost-backups/pkg/setup"
+	"github.com/martient/bifrost-backups/pkg/sqlite3"
+	"github.com/martient/golang-utils/utils"
+	"github.com/spf13/cobra"
+)
+
+var drillCmd = &cobra.Command{
+	Use:   "drill",
+	Short: "Restore the latest backup into a scratch target, run the assertions of the drill and clean up",
+	Run: func(cmd *cobra.Command, args []string) {
+		if disableUpdateCheck, _ := rootCmd.Flags().GetBool("disable-update-check"); !disableUpdateCheck {
+			doConfirmAndSelfUpdate()
+		}
+
+		var names []string
+
+		name, _ := cmd.Flags().GetString("name")
+		storage_name, _ := cmd.Flags().GetString("storage-name")
+		identity_file, _ := cmd.Flags().GetString("identity-file")
+		report_path, _ := cmd.Flags().GetString("report")
+
+		if name == "" {
+			fetched_names, err := setup.GetDatabaseConfigName()
+			if err != nil {
+				utils.LogError("Something went wrong during the config reading: %s", "CLI", err)
+				os.Exit(1)
+			} else if len(fetched_names) == 0 {
+				utils.LogWarning("No backup source found", "CLI")
+				return
+			}
+			names = append(names, fetched_names...)
+		} else {
+			names = append(names, name)
+		}
+
+		if err := requireUnlock(); err != nil {
+			utils.LogError("Could not unlock the config: %s", "CLI", err)
+			os.Exit(1)
+		}
+
+		reports := []drill.Report{}
+		failed := 0
+		for _, name := range names {
+			database, err := setup.ReadDatabaseConfig(name)
+			if err != nil {
+				utils.LogError("Something went wrong during the config reading: %s", "CLI", err)
+				os.Exit(1)
+			}
+
+			report := runDrill(database, storage_name, identity_file)
+			for _, result := range report.Assertions {
+				if result.Passed {
+					utils.LogInfo("PASS %s: %s", "DRILL", result.Name, result.Value)
+				} else {
+					utils.LogErrorInterface("FAIL %s: %s", "DRILL", result.Name, result.Error)
+				}
+			}
+			if report.Passed {
+				utils.LogInfo("PASS %s restored from %s on %s in %s", "DRILL", database.Name, report.Backup, report.Storage, report.Duration)
+			} else {
+				utils.LogErrorInterface("FAIL %s restored from %s on %s: %s", "DRILL", database.Name, report.Backup, report.Storage, report.Error)
+				failed++
+			}
+			reports = append(reports, report)
+		}
+
+		if report_path != "" {
+			if err := writeReport(report_path, reports); err != nil {
+				utils.LogError("Failed to write the drill report: %s", "CLI", err)
+			}
+		}
+		utils.LogInfo("%d drill(s) passed, %d failed", "DRILL", len(reports)-failed, failed)
+		if failed > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+// runDrill restores the latest backup of the database into a scratch target, runs the
+// assertions of its drill on it and removes the target
+func runDrill(database setup.Database, storage_name string, identity_file string) (report drill.Report) {
+	report = drill.Report{Database: database.Name, StartedAt: time.Now().UTC(), Assertions: []drill.AssertionResult{}}
+	defer func() {
+		report.Duration = time.Since(report.StartedAt).Round(time.Millisecond).String()
+	}()
+	fail := func(format string, err error) drill.Report {
+		report.Error = fmt.Sprintf(format, err)
+		return report
+	}
+
+	storage, err := drillStorage(database, storage_name)
+	if err != nil {
+		return fail("%s", err)
+	}
+	report.Storage = storage.Name
+	if report.Backup, err = latestStorageBackup(storage, database.Name); err != nil {
+		return fail("%s", err)
+	}
+	result, err := pullStorageBackup(storage, database.Name, report.Backup)
+	if err != nil {
+		return fail("retrieving failed: %s", err)
+	}
+	dump, err := decryptBackup(storage, result.Bytes(), identity_file)
+	if err != nil {
+		return fail("decryption failed: %s", err)
+	}
+
+	var query func(query string) (string, error)
+	switch database.Type {
+	case setup.Sqlite3:
+		temp_dir, err := os.MkdirTemp("", "bifrost_drill_*")
+		if err != nil {
+			return fail("failed to create the scratch folder: %s", err)
+		}
+		defer os.RemoveAll(temp_dir)
+		target := sqlite3.Sqlite3Requirements{Path: filepath.Join(temp_dir, "drill.db")}
+		report.Target = target.Path
+		if err := sqlite3.RunRestoration(target, dump); err != nil {
+			return fail("restoration failed: %s", err)
+		}
+		query = func(query string) (string, error) { return sqlite3.RunQuery(target, query) }
+	case setup.Postgresql:
+		target := database.Postgresql
+		target.Name = database.Drill.ScratchDatabase
+		if target.Name == "" {
+			target.Name = fmt.Sprintf("%s_drill_%d", database.Postgresql.Name, report.StartedAt.Unix())
+		}
+		if target.Name == database.Postgresql.Name {
+			return fail("%s", fmt.Errorf("scratch database can't be the database itself"))
+		}
+		report.Target = target.Name
+		// A previous drill may have been interrupted before its clean up
+		if err := postgresql.DropDatabase(target); err != nil {
+			return fail("failed to drop the scratch database: %s", err)
+		}
+		if err := postgresql.CreateDatabase(target); err != nil {
+			return fail("failed to create the scratch database: %s", err)
+		}
+		defer func() {
+			if err := postgresql.DropDatabase(target); err != nil {
+				utils.LogError("Failed to drop the scratch database: %s", "DRILL", err)
+			}
+		}()
+		if err := postgresql.RunRestoration(target, dump); err != nil {
+			return fail("restoration failed: %s", err)
+		}
+		query = func(query string) (string, error) { return postgresql.RunQuery(target, query) }
+	case setup.LocalFiles:
+		if len(database.Drill.Assertions) > 0 {
+			return fail("%s", fmt.Errorf("local files databases can't run SQL assertions"))
+		}
+		temp_dir, err := os.MkdirTemp("", "bifrost_drill_*")
+		if err != nil {
+			return fail("failed to create the scratch folder: %s", err)
+		}
+		defer os.RemoveAll(temp_dir)
+		if report.Target, report.Files, err = drillLocalFiles(temp_dir, dump); err != nil {
+			return fail("%s", err)
+		}
+	default:
+		return fail("%s", fmt.Errorf("unsupported database type %d", database.Type))
+	}
+
+	var passed bool
+	if report.Assertions, passed = drill.Run(database.Drill.Assertions, query, time.Now()); !passed {
+		report.Error = "assertion failed"
+		return report
+	}
+	report.Passed = true
+	return report
+}
+
+// drillStorage returns the storage the drill restores from, the first one of the database by default
+func drillStorage(database setup.Database, storage_name string) (setup.Storage, error) {
+	for _, database_storage := range database.Storages {
+		if storage_name == "" || storage_name == database_storage {
+			return setup.ReadStorageConfig(database_storage)
+		}
+	}
+	if storage_name != "" {
+		return setup.Storage{}, fmt.Errorf("storage %s isn't a storage of the database", storage_name)
+	}
+	return setup.Storage{}, fmt.Errorf("database has no storage")
+}
+
+// drillLocalFiles restores the files into the scratch folder, a single file backup is
+// restored inside it under its archived name. It returns the target and the files restored.
+func drillLocalFiles(temp_dir string, dump *bytes.Buffer) (string, int, error) {
+	target := localfiles.LocalFilesRequirements{Path: localfiles.RestorePath(temp_dir, dump.Bytes())}
+	if err := localfiles.RunRestore(target, dump); err != nil {
+		return target.Path, 0, fmt.Errorf("restoration failed: %w", err)
+	}
+	files, err := countFiles(temp_dir)
+	if err != nil {
+		return target.Path, 0, fmt.Errorf("failed to list the restored files: %w", err)
+	} else if files == 0 {
+		return target.Path, 0, fmt.Errorf("no file restored")
+	}
+	return target.Path, files, nil
+}
+
+// countFiles returns the number of regular files under the folder
+func countFiles(path string) (int, error) {
+	count := 0
+	err := filepath.WalkDir(path, func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.Type().IsRegular() {
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+func init() {
+	rootCmd.AddCommand(drillCmd)
+	drillCmd.Flags().String("name", "", "Database name, every database when empty")
+	drillCmd.Flags().String("storage-name", "", "Restore from this storage instead of the first one of the database")
+	drillCmd.Flags().String("identity-file", "", "Identity file able to decrypt the backups encrypted for recipients")
+	drillCmd.Flags().String("report", "", "Write a JSON report of the drills to this file, - for the standard output")
+}
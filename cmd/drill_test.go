@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	localfiles "github.com/martient/bifrost-backups/pkg/local_files"
+)
+
+func TestDrillLocalFiles(t *testing.T) {
+	source := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(source, "conf"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"app.ini", filepath.Join("conf", "db.ini")} {
+		if err := os.WriteFile(filepath.Join(source, name), []byte("key=value\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		source     string
+		wantTarget func(temp_dir string) string
+		wantFiles  int
+	}{
+		{
+			name:       "Folder backup",
+			source:     source,
+			wantTarget: func(temp_dir string) string { return temp_dir },
+			wantFiles:  2,
+		},
+		{
+			name:       "Single file backup",
+			source:     filepath.Join(source, "app.ini"),
+			wantTarget: func(temp_dir string) string { return filepath.Join(temp_dir, "app.ini") },
+			wantFiles:  1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dump, err := localfiles.RunBackup(localfiles.LocalFilesRequirements{Path: tt.source})
+			if err != nil {
+				t.Fatal(err)
+			}
+			temp_dir := t.TempDir()
+			target, files, err := drillLocalFiles(temp_dir, dump)
+			if err != nil {
+				t.Fatalf("drillLocalFiles() error = %v", err)
+			}
+			if want := tt.wantTarget(temp_dir); target != want {
+				t.Errorf("drillLocalFiles() target = %v, want %v", target, want)
+			}
+			if files != tt.wantFiles {
+				t.Errorf("drillLocalFiles() files = %d, want %d", files, tt.wantFiles)
+			}
+			content, err := os.ReadFile(filepath.Join(temp_dir, "app.ini"))
+			if err != nil || strings.TrimSpace(string(content)) != "key=value" {
+				t.Errorf("restored app.ini = %q, %v, want key=value", content, err)
+			}
+		})
+	}
+
+	if _, _, err := drillLocalFiles(t.TempDir(), bytes.NewBuffer(nil)); err == nil {
+		t.Error("drillLocalFiles() of an empty backup should fail")
+	}
+}
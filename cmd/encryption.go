@@ -0,0 +1,129 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+
+	"github.com/martient/bifrost-backups/pkg/crypto"
+	"github.com/martient/bifrost-backups/pkg/setup"
+	"github.com/martient/golang-utils/utils"
+	"golang.org/x/term"
+)
+
+// encryptBackup encrypts the backup for the storage recipients, with its passphrase or with its cipher key,
+// a config protected by the master password encrypts for its recipient so backups run while locked
+func encryptBackup(storage setup.Storage, plaintext []byte) (*bytes.Buffer, error) {
+	if len(storage.Recipients) > 0 {
+		return crypto.CipherForRecipients(storage.Recipients, plaintext)
+	}
+	if storage.ProtectionRecipient != "" {
+		if storage.Passphrase != "" {
+			return nil, fmt.Errorf("storage %s uses a passphrase in a protected config, remove the passphrase", storage.Name)
+		}
+		return crypto.CipherForRecipients([]string{storage.ProtectionRecipient}, plaintext)
+	}
+	if storage.Passphrase != "" {
+		return crypto.CipherWithPassphrase(storage.Passphrase, plaintext)
+	}
+
+	cipher_key, err := base64.StdEncoding.DecodeString(storage.CipherKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cipher key: %w", err)
+	}
+	return crypto.Cipher(cipher_key, plaintext)
+}
+
+// decryptBackup decrypts a backup of the storage with its keyring, backups encrypted
+// for recipients need the identity file since the host only knows the public keys,
+// unless they were written for the protection recipient of the unlocked config
+func decryptBackup(storage setup.Storage, cipher_text []byte, identity_file string) (*bytes.Buffer, error) {
+	if crypto.HasRecipients(cipher_text) {
+		if identity_file == "" && storage.ProtectionRecipient != "" {
+			return setup.DecipherProtected(cipher_text)
+		}
+		return decryptWithIdentityFile(cipher_text, identity_file)
+	}
+	if crypto.HasPassphrase(cipher_text) {
+		passphrase := storage.Passphrase
+		if passphrase == "" {
+			var err error
+			if passphrase, err = readPassphrase("Enter the backup passphrase: "); err != nil {
+				return nil, err
+			}
+		}
+		return crypto.DecipherWithPassphrase(passphrase, cipher_text)
+	}
+
+	keys, err := storage.CipherKeys()
+	if err != nil {
+		return nil, err
+	}
+	return crypto.DecipherWithKeys(keys, cipher_text)
+}
+
+func decryptWithIdentityFile(cipher_text []byte, identity_file string) (*bytes.Buffer, error) {
+	if identity_file == "" {
+		return nil, fmt.Errorf("the backup is encrypted for recipients, provide an identity with --identity-file")
+	}
+	content, err := os.ReadFile(identity_file) //#nosec G304 -- path provided by the operator
+	if err != nil {
+		return nil, fmt.Errorf("could not read the identity file: %w", err)
+	}
+	identities, err := crypto.ParseIdentities(content)
+	if err != nil {
+		return nil, fmt.Errorf("invalid identity file: %w", err)
+	}
+	return crypto.DecipherWithIdentities(identities, cipher_text)
+}
+
+// requireUnlock unlocks a config protected by the master password, with the unlock token,
+// BIFROST_MASTER_PASSWORD or a prompt, before the commands reading the restore secrets
+func requireUnlock() error {
+	protected, err := setup.IsProtected()
+	if err != nil || !protected || setup.IsUnlocked() {
+		return err
+	}
+	if err := setup.UnlockWithToken(); err == nil {
+		return nil
+	} else if !errors.Is(err, setup.ErrConfigLocked) {
+		utils.LogWarning("Could not use the unlock token: %v", "CLI", err)
+	}
+
+	password := os.Getenv("BIFROST_MASTER_PASSWORD")
+	if password == "" {
+		if !term.IsTerminal(int(syscall.Stdin)) {
+			return setup.ErrConfigLocked
+		}
+		utils.LogInfo("Enter master password: ", "CLI")
+		input, err := term.ReadPassword(int(syscall.Stdin))
+		if err != nil {
+			return fmt.Errorf("failed to read the master password: %w", err)
+		}
+		password = string(input)
+	}
+	return setup.Unlock(password)
+}
+
+// readPassphrase reads the passphrase from BIFROST_PASSPHRASE, or prompts for it on the terminal
+func readPassphrase(prompt string) (string, error) {
+	if passphrase := os.Getenv("BIFROST_PASSPHRASE"); passphrase != "" {
+		return passphrase, nil
+	}
+	if !term.IsTerminal(int(syscall.Stdin)) {
+		return "", fmt.Errorf("a passphrase is required, set BIFROST_PASSPHRASE or run the command in a terminal")
+	}
+
+	utils.LogInfo(prompt, "CLI")
+	passphrase, err := term.ReadPassword(int(syscall.Stdin))
+	if err != nil {
+		return "", fmt.Errorf("failed to read the passphrase: %w", err)
+	}
+	if len(passphrase) == 0 {
+		return "", fmt.Errorf("passphrase can't be empty")
+	}
+	return string(passphrase), nil
+}
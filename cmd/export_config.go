@@ -30,7 +30,17 @@ var exportConfigCmd = &cobra.Command{
 			return
 		}
 
-		if config.MasterHash == "" {
+		if config.Protection != nil {
+			// The restore secrets stay sealed until the config is unlocked
+			if err := requireUnlock(); err != nil {
+				utils.LogError("Could not unlock the config: %s", "CLI", err)
+				return
+			}
+			if config, err = setup.ReadConfigUnciphered(); err != nil {
+				utils.LogError("Failed to read config", "CLI", err)
+				return
+			}
+		} else if config.MasterHash == "" {
 			utils.LogWarning("Master password not set. Please set one using init-master-password command to avoid security issues", "CLI")
 		} else {
 			// Prompt for master password
@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/martient/bifrost-backups/pkg/crypto"
+	"github.com/martient/golang-utils/utils"
+	"github.com/spf13/cobra"
+)
+
+var generateIdentityCmd = &cobra.Command{
+	Use:   "generate-identity",
+	Short: "Generate a key pair for public key encrypted backups",
+	Long: `Generate an X25519 identity and print its public recipient. Register the recipient on the
+storages with register-storage --recipient and keep the identity file away from the backup hosts,
+it is only needed by restore --identity-file.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		identity, recipient, err := crypto.GenerateIdentity()
+		if err != nil {
+			utils.LogError("Something went wrong during the identity generation: %s", "CLI", err)
+			os.Exit(1)
+		}
+		content := fmt.Sprintf("# created: %s\n# recipient: %s\n%s\n", time.Now().Format(time.RFC3339), recipient, identity)
+
+		output, _ := cmd.Flags().GetString("output")
+		if output == "" {
+			fmt.Print(content)
+			return
+		}
+
+		file, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600) //#nosec G304 -- path provided by the operator
+		if err != nil {
+			utils.LogError("Could not create the identity file: %s", "CLI", err)
+			os.Exit(1)
+		}
+		defer file.Close()
+		if _, err := file.WriteString(content); err != nil {
+			utils.LogError("Could not write the identity file: %s", "CLI", err)
+			os.Exit(1)
+		}
+		utils.LogInfo("Identity written to %s", "CLI", output)
+		fmt.Println(recipient)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(generateIdentityCmd)
+	generateIdentityCmd.Flags().StringP("output", "o", "", "Identity file to create (default: print it)")
+}
@@ -12,8 +12,13 @@ import (
 var initMasterPasswordCmd = &cobra.Command{
 	Use:   "init-master-password",
 	Short: "Initialize or change the master password",
-	Long:  `Set or change the master password used for exporting configuration files.`,
+	Long: `Set or change the master password used for exporting configuration files.
+With --protect, the restore secrets (cipher keys, passphrases) are also encrypted for a key
+wrapped by the master password: restore, export-config and the key commands then require it,
+or an unlock token, while the scheduled backups keep running unattended.`,
 	Run: func(cmd *cobra.Command, args []string) {
+		protect, _ := cmd.Flags().GetBool("protect")
+
 		// Read current config
 		config, err := setup.ReadConfigUnciphered()
 		if err != nil {
@@ -22,9 +27,10 @@ var initMasterPasswordCmd = &cobra.Command{
 		}
 
 		// If master password is already set, verify old password first
+		var currentPassword []byte
 		if config.MasterHash != "" {
 			utils.LogInfo("Enter current master password: ", "CLI")
-			currentPassword, err := term.ReadPassword(int(syscall.Stdin))
+			currentPassword, err = term.ReadPassword(int(syscall.Stdin))
 			if err != nil {
 				utils.LogError("Failed to read password", "CLI", err)
 				return
@@ -56,12 +62,26 @@ var initMasterPasswordCmd = &cobra.Command{
 			return
 		}
 
+		// The protection key is wrapped again, the restore secrets don't change
+		if err := setup.RewrapProtection(&config, string(currentPassword), string(password1)); err != nil {
+			utils.LogError("Failed to rewrap the protection key", "CLI", err)
+			return
+		}
+
 		// Set new master password
 		if err := setup.SetMasterPassword(&config, string(password1)); err != nil {
 			utils.LogError("Failed to set master password", "CLI", err)
 			return
 		}
 
+		if protect && config.Protection == nil {
+			if err := setup.EnableProtection(&config, string(password1)); err != nil {
+				utils.LogError("Failed to protect the config", "CLI", err)
+				return
+			}
+			utils.LogInfo("\nRestore secrets are now protected by the master password", "CLI")
+		}
+
 		// Update the config with the new master password
 		if err := setup.UpdateConfig(config); err != nil {
 			utils.LogError("Failed to update config", "CLI", err)
@@ -74,4 +94,5 @@ var initMasterPasswordCmd = &cobra.Command{
 
 func init() {
 	rootCmd.AddCommand(initMasterPasswordCmd)
+	initMasterPasswordCmd.Flags().Bool("protect", false, "Require the master password to restore and to read the restore secrets")
 }
@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"bufio"
+	"os"
+	"strings"
+
+	"github.com/martient/bifrost-backups/pkg/setup"
+	"github.com/martient/golang-utils/utils"
+	"github.com/spf13/cobra"
+)
+
+var recoverKeyCmd = &cobra.Command{
+	Use:   "recover-key [share...]",
+	Short: "Recover the storage cipher keys from Shamir shares",
+	Long: `Rebuild the cipher keys split by split-key and write them into the storages of the same
+name, register the storages again first on a fresh config. Shares are read from the arguments,
+or one per line from the standard input when none is given.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		shares := args
+		if len(shares) == 0 {
+			utils.LogInfo("Paste the shares, one per line, end with an empty line:", "CLI")
+			scanner := bufio.NewScanner(os.Stdin)
+			for scanner.Scan() {
+				line := strings.TrimSpace(scanner.Text())
+				if line == "" {
+					break
+				} else if strings.HasPrefix(line, "#") {
+					continue
+				}
+				shares = append(shares, line)
+			}
+		}
+
+		if err := requireUnlock(); err != nil {
+			utils.LogError("Could not unlock the config: %s", "CLI", err)
+			os.Exit(1)
+		}
+		keys, err := setup.RecoverCipherKeys(shares)
+		if err != nil {
+			utils.LogError("Something went wrong during the key recovery: %s", "CLI", err)
+			os.Exit(1)
+		}
+		missing, err := setup.ImportCipherKeys(keys)
+		if err != nil {
+			utils.LogError("Saved failed: %s", "CLI", err)
+			os.Exit(1)
+		}
+		for _, name := range missing {
+			utils.LogWarning("Storage %s isn't registered, register it and run recover-key again to import its keys", "CLI", name)
+		}
+		if len(missing) > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(recoverKeyCmd)
+}
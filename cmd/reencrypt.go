@@ -0,0 +1,139 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/martient/bifrost-backups/pkg/crypto"
+	localstorage "github.com/martient/bifrost-backups/pkg/local_storage"
+	"github.com/martient/bifrost-backups/pkg/s3"
+	"github.com/martient/bifrost-backups/pkg/setup"
+	"github.com/martient/golang-utils/utils"
+	"github.com/spf13/cobra"
+)
+
+var reencryptCmd = &cobra.Command{
+	Use:   "reencrypt",
+	Short: "Re-encrypt the backups of a storage with its active cipher key",
+	Long: `Re-encrypt the existing backups of a storage written with a retired cipher key, so the
+previous keys can eventually be dropped. Backups already using the active key are skipped,
+the command can be interrupted and started again, e.g. from a cron job. When the config is
+protected by the master password, the backups are re-encrypted for its recipient instead.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		name, _ := cmd.Flags().GetString("name")
+		if name == "" {
+			utils.LogError("name can't be empty", "CLI", nil)
+			os.Exit(1)
+		}
+		if err := requireUnlock(); err != nil {
+			utils.LogError("Could not unlock the config: %s", "CLI", err)
+			os.Exit(1)
+		}
+		storage, err := setup.ReadStorageConfig(name)
+		if err != nil {
+			utils.LogError("Something went wrong during the config reading: %s", "CLI", err)
+			os.Exit(1)
+		}
+		if err := reencryptBackups(storage); err != nil {
+			utils.LogError("Something went wrong during the re-encryption process: %s", "CLI", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// reencryptBackups rewrites every backup of the storage not encrypted with its active key, or
+// for the protection recipient when the config is protected by the master password. A backup
+// failing is logged and skipped so one bad object doesn't block the others.
+func reencryptBackups(storage setup.Storage) error {
+	protected := storage.ProtectionRecipient != ""
+	if len(storage.Recipients) > 0 {
+		return fmt.Errorf("storage %s encrypts for recipients, its backups can't be re-encrypted on this host", storage.Name)
+	}
+	if storage.Passphrase != "" && !protected {
+		return fmt.Errorf("storage %s derives its keys from a passphrase, each backup already has its own key", storage.Name)
+	}
+	keys, err := storage.CipherKeys()
+	if err != nil {
+		return err
+	}
+	activeID := crypto.KeyID(keys[0])
+	target := "key " + activeID
+	if protected {
+		target = "the protection recipient"
+	}
+
+	var names []string
+	switch storage.Type {
+	case setup.LocalStorage:
+		names, err = localstorage.ListBackups(storage.LocalStorage, "")
+	case setup.S3:
+		names, err = s3.ListBackups(storage.S3, "")
+	default:
+		return fmt.Errorf("unsupported storage type %d", storage.Type)
+	}
+	if err != nil {
+		return err
+	}
+
+	reencrypted, failed := 0, 0
+	for _, backup_name := range names {
+		var result *bytes.Buffer
+		switch storage.Type {
+		case setup.LocalStorage:
+			result, err = localstorage.PullBackup(storage.LocalStorage, "", backup_name)
+		case setup.S3:
+			result, err = s3.PullBackup(storage.S3, "", backup_name)
+		}
+		if err != nil {
+			utils.LogErrorInterface("Failed to retrieve backup %s: %v", "CLI", backup_name, err)
+			failed++
+			continue
+		}
+		if protected {
+			if crypto.HasRecipients(result.Bytes()) {
+				continue
+			}
+		} else if crypto.HasRecipients(result.Bytes()) || crypto.HasPassphrase(result.Bytes()) || crypto.BackupKeyID(result.Bytes()) == activeID {
+			continue
+		}
+
+		plaintext, err := decryptBackup(storage, result.Bytes(), "")
+		if err != nil {
+			utils.LogErrorInterface("Failed to decrypt backup %s: %v", "CLI", backup_name, err)
+			failed++
+			continue
+		}
+		cipher_result, err := encryptBackup(storage, plaintext.Bytes())
+		if err != nil {
+			utils.LogErrorInterface("Failed to encrypt backup %s: %v", "CLI", backup_name, err)
+			failed++
+			continue
+		}
+
+		switch storage.Type {
+		case setup.LocalStorage:
+			err = localstorage.ReplaceBackup(storage.LocalStorage, backup_name, cipher_result, storage.CompressionOptions())
+		case setup.S3:
+			err = s3.ReplaceBackup(storage.S3, backup_name, cipher_result, storage.CompressionOptions(), storage.RetentionDays)
+		}
+		if err != nil {
+			utils.LogErrorInterface("Failed to store backup %s: %v", "CLI", backup_name, err)
+			failed++
+			continue
+		}
+		reencrypted++
+		utils.LogInfo("Backup %s re-encrypted for %s", "CLI", backup_name, target)
+	}
+
+	utils.LogInfo("%d backup(s) of %s re-encrypted", "CLI", reencrypted, storage.Name)
+	if failed > 0 {
+		return fmt.Errorf("%d backup(s) couldn't be re-encrypted", failed)
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(reencryptCmd)
+	reencryptCmd.Flags().String("name", "", "Storage name")
+}
@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/martient/bifrost-backups/pkg/drill"
+	"github.com/martient/bifrost-backups/pkg/setup"
+	"github.com/martient/golang-utils/utils"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+var registerDrillCmd = &cobra.Command{
+	Use:   "register-drill",
+	Short: "Set the restore drill of a database",
+	Long:  `Set the restore drill of a database from a YAML file listing its scratch database and SQL assertions`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if disableUpdateCheck, _ := rootCmd.Flags().GetBool("disable-update-check"); !disableUpdateCheck {
+			doConfirmAndSelfUpdate()
+		}
+
+		name, _ := cmd.Flags().GetString("name")
+		file, _ := cmd.Flags().GetString("file")
+		if name == "" {
+			utils.LogError("name can't be empty", "CLI", nil)
+			os.Exit(1)
+		}
+
+		var database_drill drill.Drill
+		if file != "" {
+			data, err := os.ReadFile(file) //#nosec
+			if err != nil {
+				utils.LogError("Failed to read the drill file: %s", "CLI", err)
+				os.Exit(1)
+			}
+			if err := yaml.Unmarshal(data, &database_drill); err != nil {
+				utils.LogError("Failed to parse the drill file: %s", "CLI", err)
+				os.Exit(1)
+			}
+		}
+		if scratch_database, _ := cmd.Flags().GetString("scratch-database"); scratch_database != "" {
+			database_drill.ScratchDatabase = scratch_database
+		}
+
+		if err := setup.SetDatabaseDrill(name, database_drill); err != nil {
+			utils.LogError("Your drill haven't been registerd: %s", "CLI", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(registerDrillCmd)
+	registerDrillCmd.Flags().String("name", "", "Database name")
+	registerDrillCmd.Flags().String("file", "", "YAML file of the drill, without it the drill only checks the latest backup restores")
+	registerDrillCmd.Flags().String("scratch-database", "", "PostgreSQL database the backup is restored into, derived from the database name when empty")
+}
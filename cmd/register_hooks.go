@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/martient/bifrost-backups/pkg/hooks"
+	"github.com/martient/bifrost-backups/pkg/setup"
+	"github.com/martient/golang-utils/utils"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+var registerHooksCmd = &cobra.Command{
+	Use:   "register-hooks",
+	Short: "Set the hooks of a database",
+	Long:  `Set the shell commands and HTTP calls run before and after the backups and the restores of a database from a YAML file`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if disableUpdateCheck, _ := rootCmd.Flags().GetBool("disable-update-check"); !disableUpdateCheck {
+			doConfirmAndSelfUpdate()
+		}
+
+		name, _ := cmd.Flags().GetString("name")
+		file, _ := cmd.Flags().GetString("file")
+		if name == "" {
+			utils.LogError("name can't be empty", "CLI", nil)
+			os.Exit(1)
+		}
+
+		var database_hooks hooks.Hooks
+		if file != "" {
+			data, err := os.ReadFile(file) //#nosec
+			if err != nil {
+				utils.LogError("Failed to read the hooks file: %s", "CLI", err)
+				os.Exit(1)
+			}
+			if err := yaml.Unmarshal(data, &database_hooks); err != nil {
+				utils.LogError("Failed to parse the hooks file: %s", "CLI", err)
+				os.Exit(1)
+			}
+		}
+
+		if err := setup.SetDatabaseHooks(name, database_hooks); err != nil {
+			utils.LogError("Your hooks haven't been registerd: %s", "CLI", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(registerHooksCmd)
+	registerHooksCmd.Flags().String("name", "", "Database name")
+	registerHooksCmd.Flags().String("file", "", "YAML file of the hooks, without it the hooks of the database are removed")
+}
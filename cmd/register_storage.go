@@ -1,8 +1,13 @@
 package cmd
 
 import (
+	"fmt"
 	"os"
 
+	"github.com/martient/bifrost-backups/pkg/compression"
+	"github.com/martient/bifrost-backups/pkg/crypto"
+	"github.com/martient/bifrost-backups/pkg/retention"
+	"github.com/martient/bifrost-backups/pkg/s3"
 	"github.com/martient/bifrost-backups/pkg/setup"
 	"github.com/martient/golang-utils/utils"
 	"github.com/spf13/cobra"
@@ -21,6 +26,23 @@ var registerStorageCmd = &cobra.Command{
 		if interactive, _ := cmd.Flags().GetBool("interactive"); interactive {
 			setup.InteractiveRegisterStorage()
 		} else {
+			recipients, _ := cmd.Flags().GetStringSlice("recipient")
+			for _, recipient := range recipients {
+				if _, err := crypto.ParseRecipient(recipient); err != nil {
+					utils.LogError("Your storage haven't been registerd: %s", "CLI", err)
+					os.Exit(1)
+				}
+			}
+			compression_options, err := compressionFlags(cmd)
+			if err != nil {
+				utils.LogError("Your storage haven't been registerd: %s", "CLI", err)
+				os.Exit(1)
+			}
+			retention_policy := retentionFlags(cmd)
+			if err := retention_policy.Validate(); err != nil {
+				utils.LogError("Your storage haven't been registerd: %s", "CLI", err)
+				os.Exit(1)
+			}
 			storage_int, _ := cmd.Flags().GetInt64("type")
 			storage_type := setup.StorageType(storage_int)
 			switch storage_type {
@@ -34,19 +56,61 @@ var registerStorageCmd = &cobra.Command{
 				name, _ := cmd.Flags().GetString("name")
 				retention, _ := cmd.Flags().GetInt("retention")
 				cipher_key, _ := cmd.Flags().GetString("cipher-key")
-				compression, _ := cmd.Flags().GetBool("compression")
-				err = setup.RegisterStorage(storage_type, name, retention, cipher_key, compression, registered)
+				keep_compression, use_compression := keptCompression(cmd, name)
+				err = setup.RegisterStorage(storage_type, name, retention, cipher_key, use_compression, registered)
 				if err != nil {
 					utils.LogError("Saved failed: %s", "CLI", err)
 					os.Exit(1)
 				}
+				if !keep_compression {
+					registerCompression(name, compression_options)
+				}
+				if flagsChanged(cmd, retentionFlagNames...) {
+					registerRetention(name, retention_policy)
+				}
+				registerRecipients(cmd, name)
+				registerPassphrase(cmd, name)
 			case 2:
-				bucket_name, _ := cmd.Flags().GetString("bucket-name")
-				access_key_id, _ := cmd.Flags().GetString("access-key-id")
-				access_key_secret, _ := cmd.Flags().GetString("access-key-secret")
-				endpoint, _ := cmd.Flags().GetString("endpoint")
-				region, _ := cmd.Flags().GetString("region")
-				registered, err := setup.RegisterS3Storage(bucket_name, access_key_id, access_key_secret, endpoint, region)
+				requirements := s3.S3Requirements{}
+				requirements.BucketName, _ = cmd.Flags().GetString("bucket-name")
+				requirements.AccessKeyId, _ = cmd.Flags().GetString("access-key-id")
+				requirements.AccessKeySecret, _ = cmd.Flags().GetString("access-key-secret")
+				requirements.Endpoint, _ = cmd.Flags().GetString("endpoint")
+				requirements.Region, _ = cmd.Flags().GetString("region")
+				requirements.CredentialsMode, _ = cmd.Flags().GetString("credentials-mode")
+				requirements.SessionToken, _ = cmd.Flags().GetString("session-token")
+				requirements.Profile, _ = cmd.Flags().GetString("profile")
+				requirements.RoleArn, _ = cmd.Flags().GetString("role-arn")
+				requirements.ExternalId, _ = cmd.Flags().GetString("external-id")
+				requirements.RoleSessionName, _ = cmd.Flags().GetString("role-session-name")
+				requirements.WebIdentityTokenFile, _ = cmd.Flags().GetString("web-identity-token-file")
+				requirements.ServerSideEncryption, _ = cmd.Flags().GetString("sse")
+				requirements.SSEKMSKeyId, _ = cmd.Flags().GetString("sse-kms-key-id")
+				requirements.SSECustomerKey, _ = cmd.Flags().GetString("sse-customer-key")
+				requirements.StorageClass, _ = cmd.Flags().GetString("storage-class")
+				requirements.ChecksumAlgorithm, _ = cmd.Flags().GetString("checksum-algorithm")
+				requirements.ObjectLockMode, _ = cmd.Flags().GetString("object-lock-mode")
+				requirements.LegalHold, _ = cmd.Flags().GetBool("legal-hold")
+				requirements.UsePathStyle, _ = cmd.Flags().GetBool("path-style")
+				requirements.CABundle, _ = cmd.Flags().GetString("ca-bundle")
+				requirements.InsecureSkipVerify, _ = cmd.Flags().GetBool("insecure-skip-verify")
+				requirements.Proxy, _ = cmd.Flags().GetString("proxy")
+				requirements.TimeoutSeconds, _ = cmd.Flags().GetInt("timeout")
+				requirements.MaxAttempts, _ = cmd.Flags().GetInt("max-attempts")
+				requirements.MaxBackoffSeconds, _ = cmd.Flags().GetInt("max-backoff")
+				requirements.PartSizeMiB, _ = cmd.Flags().GetInt("part-size")
+				requirements.Concurrency, _ = cmd.Flags().GetInt("concurrency")
+				requirements.UploadRetries, _ = cmd.Flags().GetInt("upload-retries")
+				requirements.BandwidthLimitKiB, _ = cmd.Flags().GetInt("bandwidth-limit")
+				requirements.AbandonedUploadHours, _ = cmd.Flags().GetInt("abandoned-upload-hours")
+				tags, _ := cmd.Flags().GetString("tags")
+				parsed_tags, err := s3.ParseTags(tags)
+				if err != nil {
+					utils.LogError("Your storage haven't been registerd: %s", "CLI", err)
+					os.Exit(1)
+				}
+				requirements.Tags = parsed_tags
+				registered, err := setup.RegisterS3Storage(requirements)
 				if err != nil {
 					utils.LogError("Your storage haven't been registerd: %s", "CLI", err)
 					os.Exit(1)
@@ -54,12 +118,20 @@ var registerStorageCmd = &cobra.Command{
 				name, _ := cmd.Flags().GetString("name")
 				retention, _ := cmd.Flags().GetInt("retention")
 				cipher_key, _ := cmd.Flags().GetString("cipher-key")
-				compression, _ := cmd.Flags().GetBool("compression")
-				err = setup.RegisterStorage(storage_type, name, retention, cipher_key, compression, registered)
+				keep_compression, use_compression := keptCompression(cmd, name)
+				err = setup.RegisterStorage(storage_type, name, retention, cipher_key, use_compression, registered)
 				if err != nil {
 					utils.LogError("Saved failed: %s", "CLI", err)
 					os.Exit(1)
 				}
+				if !keep_compression {
+					registerCompression(name, compression_options)
+				}
+				if flagsChanged(cmd, retentionFlagNames...) {
+					registerRetention(name, retention_policy)
+				}
+				registerRecipients(cmd, name)
+				registerPassphrase(cmd, name)
 			default:
 				utils.LogWarning("Please choose between the available type of storage with --type", "CLI")
 				os.Exit(-1)
@@ -83,12 +155,162 @@ func init() {
 	registerStorageCmd.Flags().String("name", "default", "Storage name")
 	registerStorageCmd.Flags().String("path", "~/bifrost-backups", "Path for the output target folder in the local storage")
 	registerStorageCmd.Flags().Int("retention", 21, "How many days do you want to keep the backup")
+	registerStorageCmd.Flags().Int("keep-last", 0, "GFS retention: always keep the last backups")
+	registerStorageCmd.Flags().Int("keep-hourly", 0, "GFS retention: keep one backup per hour for the hours")
+	registerStorageCmd.Flags().Int("keep-daily", 0, "GFS retention: keep one backup per day for the days")
+	registerStorageCmd.Flags().Int("keep-weekly", 0, "GFS retention: keep one backup per week for the weeks")
+	registerStorageCmd.Flags().Int("keep-monthly", 0, "GFS retention: keep one backup per month for the months")
+	registerStorageCmd.Flags().Int("keep-yearly", 0, "GFS retention: keep one backup per year for the years")
+	registerStorageCmd.Flags().Int("min-keep", 0, "Minimum number of backups the retention never deletes, the newest backup is always kept")
 	registerStorageCmd.Flags().String("bucket-name", "", "Bucket name")
 	registerStorageCmd.Flags().String("account-id", "", "Account Id")
 	registerStorageCmd.Flags().String("access-key-id", "", "Access key Id")
 	registerStorageCmd.Flags().String("access-key-secret", "", "Access key secret")
 	registerStorageCmd.Flags().String("endpoint", "", "Endpoint")
 	registerStorageCmd.Flags().String("region", "auto", "Region of storage")
+	registerStorageCmd.Flags().String("credentials-mode", "", "S3 credentials mode (static, default, profile, assume_role, web_identity), empty to detect it from the keys")
+	registerStorageCmd.Flags().String("session-token", "", "Session token used with temporary static credentials")
+	registerStorageCmd.Flags().String("profile", "", "Named profile of the shared AWS configuration")
+	registerStorageCmd.Flags().String("role-arn", "", "Role ARN to assume (assume_role, web_identity)")
+	registerStorageCmd.Flags().String("external-id", "", "External ID used when assuming the role")
+	registerStorageCmd.Flags().String("role-session-name", "", "Session name used when assuming the role (default bifrost-backups)")
+	registerStorageCmd.Flags().String("web-identity-token-file", "", "Path of the web identity token file (web_identity)")
+	registerStorageCmd.Flags().String("sse", "", "S3 server side encryption (AES256, aws:kms, SSE-C), empty to use the bucket default")
+	registerStorageCmd.Flags().String("sse-kms-key-id", "", "KMS key ID used with aws:kms server side encryption")
+	registerStorageCmd.Flags().String("sse-customer-key", "", "Base64 encoded 256 bits key used with SSE-C server side encryption")
+	registerStorageCmd.Flags().String("storage-class", "", "S3 storage class (STANDARD, STANDARD_IA, GLACIER_IR, DEEP_ARCHIVE, ...)")
+	registerStorageCmd.Flags().String("tags", "", "S3 object tags ex:\"env=prod,type=postgresql,tier=gold\", each backup is also tagged with its database")
+	registerStorageCmd.Flags().String("checksum-algorithm", "", "S3 upload checksum (MD5, CRC32, CRC32C, SHA1, SHA256)")
+	registerStorageCmd.Flags().String("object-lock-mode", "", "S3 object lock mode (GOVERNANCE, COMPLIANCE), backups are locked for the retention days")
+	registerStorageCmd.Flags().Bool("legal-hold", false, "Place a legal hold on every S3 backup")
+	registerStorageCmd.Flags().Bool("path-style", false, "Use path-style addressing (MinIO, Ceph, ...)")
+	registerStorageCmd.Flags().String("ca-bundle", "", "Path of a PEM bundle of extra certificate authorities trusted for the endpoint")
+	registerStorageCmd.Flags().Bool("insecure-skip-verify", false, "Skip the TLS certificate verification (labs only)")
+	registerStorageCmd.Flags().String("proxy", "", "HTTP(S) proxy url, empty to use HTTP_PROXY/HTTPS_PROXY")
+	registerStorageCmd.Flags().Int("timeout", 0, "S3 connection and response headers timeout in seconds, transfers aren't capped (0 to disable)")
+	registerStorageCmd.Flags().Int("max-attempts", 0, "Maximum attempts of a S3 request (default 3)")
+	registerStorageCmd.Flags().Int("max-backoff", 0, "Maximum backoff between S3 request attempts in seconds (default 20)")
+	registerStorageCmd.Flags().Int("part-size", 0, "S3 multipart upload part size in MiB (default 100)")
+	registerStorageCmd.Flags().Int("concurrency", 0, "Number of S3 parts uploaded in parallel (default 4)")
+	registerStorageCmd.Flags().Int("upload-retries", 0, "Retries of a failed S3 upload request with backoff (default 3)")
+	registerStorageCmd.Flags().Int("bandwidth-limit", 0, "S3 upload bandwidth cap in KiB per second (0 for unlimited)")
+	registerStorageCmd.Flags().Int("abandoned-upload-hours", 0, "Age in hours after which unfinished S3 multipart uploads are aborted (default 24)")
 	registerStorageCmd.Flags().String("cipher-key", "", "Bring you own cipher key (AES256 32bits) or leave it empty to generate one")
 	registerStorageCmd.Flags().Bool("compression", true, "Enable compression (default: true)")
+	registerStorageCmd.Flags().String("compression-algorithm", "zstd", "Compression algorithm (zstd, gzip, xz, lz4, none)")
+	registerStorageCmd.Flags().Int("compression-level", 0, "Compression level, zstd 1-22, gzip and lz4 1-9, xz 0-9 (0 for the algorithm default)")
+	registerStorageCmd.Flags().Int("compression-threads", 0, "Threads compressing large backups with zstd or lz4 (0 for one per CPU)")
+	registerStorageCmd.Flags().Bool("passphrase", false, "Prompt for a passphrase the backup keys are derived from (Argon2id), BIFROST_PASSPHRASE skips the prompt")
+	registerStorageCmd.Flags().StringSlice("recipient", nil, "Public key the backups are encrypted for instead of the cipher key, repeat it for break-glass keys (see generate-identity)")
+}
+
+// compressionFlags reads the compression options, --compression=false disables it whatever the algorithm
+func compressionFlags(cmd *cobra.Command) (compression.Options, error) {
+	if use_compression, _ := cmd.Flags().GetBool("compression"); !use_compression {
+		return compression.Options{Algorithm: compression.None}, nil
+	}
+	name, _ := cmd.Flags().GetString("compression-algorithm")
+	algorithm, err := compression.ParseAlgorithm(name)
+	if err != nil {
+		return compression.Options{}, err
+	}
+	options := compression.Options{Algorithm: algorithm}
+	options.Level, _ = cmd.Flags().GetInt("compression-level")
+	options.Threads, _ = cmd.Flags().GetInt("compression-threads")
+	return options, options.Validate()
+}
+
+var (
+	compressionFlagNames = []string{"compression", "compression-algorithm", "compression-level", "compression-threads"}
+	retentionFlagNames   = []string{"keep-last", "keep-hourly", "keep-daily", "keep-weekly", "keep-monthly", "keep-yearly", "min-keep"}
+)
+
+// flagsChanged tells whether one of the flags is given, the settings of a storage registered
+// again are only replaced by the flags given
+func flagsChanged(cmd *cobra.Command, names ...string) bool {
+	for _, name := range names {
+		if cmd.Flags().Changed(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// keptCompression tells whether a storage registered again keeps its compression, it only
+// changes when one of the compression flags is given, and returns whether it compresses
+func keptCompression(cmd *cobra.Command, name string) (bool, bool) {
+	use_compression, _ := cmd.Flags().GetBool("compression")
+	if flagsChanged(cmd, compressionFlagNames...) {
+		return false, use_compression
+	}
+	existing, found, err := setup.LookupStorage(name)
+	if err != nil || !found {
+		return false, use_compression
+	}
+	return true, existing.Compression
+}
+
+// retentionFlags reads the GFS retention rules, none of them keeps the retention days behaviour,
+// a storage registered again keeps its rules unless one of the flags is given
+func retentionFlags(cmd *cobra.Command) retention.Policy {
+	policy := retention.Policy{}
+	policy.KeepLast, _ = cmd.Flags().GetInt("keep-last")
+	policy.KeepHourly, _ = cmd.Flags().GetInt("keep-hourly")
+	policy.KeepDaily, _ = cmd.Flags().GetInt("keep-daily")
+	policy.KeepWeekly, _ = cmd.Flags().GetInt("keep-weekly")
+	policy.KeepMonthly, _ = cmd.Flags().GetInt("keep-monthly")
+	policy.KeepYearly, _ = cmd.Flags().GetInt("keep-yearly")
+	policy.MinKeep, _ = cmd.Flags().GetInt("min-keep")
+	return policy
+}
+
+func registerRetention(name string, policy retention.Policy) {
+	if err := setup.SetStorageRetention(name, policy); err != nil {
+		utils.LogError("Saved failed: %s", "CLI", err)
+		os.Exit(1)
+	}
+}
+
+func registerCompression(name string, options compression.Options) {
+	if err := setup.SetStorageCompression(name, options); err != nil {
+		utils.LogError("Saved failed: %s", "CLI", err)
+		os.Exit(1)
+	}
+}
+
+func registerRecipients(cmd *cobra.Command, name string) {
+	recipients, _ := cmd.Flags().GetStringSlice("recipient")
+	if len(recipients) == 0 {
+		return
+	}
+	if err := setup.SetStorageRecipients(name, recipients); err != nil {
+		utils.LogError("Saved failed: %s", "CLI", err)
+		os.Exit(1)
+	}
+}
+
+func registerPassphrase(cmd *cobra.Command, name string) {
+	if use_passphrase, _ := cmd.Flags().GetBool("passphrase"); !use_passphrase {
+		return
+	}
+	passphrase, err := readPassphrase("Enter the storage passphrase: ")
+	if err != nil {
+		utils.LogError("Saved failed: %s", "CLI", err)
+		os.Exit(1)
+	}
+	if os.Getenv("BIFROST_PASSPHRASE") == "" {
+		confirmation, err := readPassphrase("Confirm the storage passphrase: ")
+		if err != nil {
+			utils.LogError("Saved failed: %s", "CLI", err)
+			os.Exit(1)
+		}
+		if confirmation != passphrase {
+			utils.LogError("Saved failed: %s", "CLI", fmt.Errorf("the passphrases don't match"))
+			os.Exit(1)
+		}
+	}
+	if err := setup.SetStoragePassphrase(name, passphrase); err != nil {
+		utils.LogError("Saved failed: %s", "CLI", err)
+		os.Exit(1)
+	}
 }
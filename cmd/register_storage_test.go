@@ -0,0 +1,158 @@
+package cmd
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/martient/bifrost-backups/pkg/retention"
+	"github.com/martient/bifrost-backups/pkg/setup"
+	"gopkg.in/yaml.v3"
+)
+
+func TestMain(m *testing.M) {
+	// The commands run in a child process since the config path is only read at start up
+	if args := os.Getenv("BIFROST_TEST_COMMAND"); args != "" {
+		rootCmd.SetArgs(strings.Fields(args))
+		if err := rootCmd.Execute(); err != nil {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runCommand runs the command line against the config at config_path
+func runCommand(t *testing.T, config_path string, args ...string) {
+	t.Helper()
+	args = append(args, "--no-encryption", "--disable-update-check")
+	command := exec.Command(os.Args[0]) //#nosec
+	command.Env = append(os.Environ(), "BIFROST_CONFIG="+config_path, "BIFROST_TEST_COMMAND="+strings.Join(args, " "))
+	if output, err := command.CombinedOutput(); err != nil {
+		t.Fatalf("%s failed: %v\n%s", strings.Join(args, " "), err, output)
+	}
+}
+
+// registeredStorage reads the storage back from the config at config_path
+func registeredStorage(t *testing.T, config_path string, name string) setup.Storage {
+	t.Helper()
+	data, err := os.ReadFile(config_path) //#nosec
+	if err != nil {
+		t.Fatalf("Failed to read config: %v", err)
+	}
+	config := setup.Config{}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	for _, storage := range config.Storages {
+		if storage.Name == name {
+			return storage
+		}
+	}
+	t.Fatalf("Storage %s not found", name)
+	return setup.Storage{}
+}
+
+func newTestConfig(t *testing.T) string {
+	t.Helper()
+	config_path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(config_path, []byte("version: \"1.0\"\n"), 0600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	return config_path
+}
+
+func TestRegisterStorageKeepsCompression(t *testing.T) {
+	tests := []struct {
+		name            string
+		first           []string
+		again           []string
+		wantCompression bool
+		wantAlgorithm   string
+		wantLevel       int
+	}{
+		{
+			name:            "Kept without compression flags",
+			first:           []string{"--compression-algorithm", "gzip", "--compression-level", "5"},
+			again:           []string{"--retention", "7"},
+			wantCompression: true,
+			wantAlgorithm:   "gzip",
+			wantLevel:       5,
+		},
+		{
+			name:            "Disabled compression kept",
+			first:           []string{"--compression=false"},
+			again:           []string{"--retention", "7"},
+			wantCompression: false,
+		},
+		{
+			name:            "Replaced by the algorithm flag",
+			first:           []string{"--compression-algorithm", "gzip", "--compression-level", "5"},
+			again:           []string{"--compression-algorithm", "xz"},
+			wantCompression: true,
+			wantAlgorithm:   "xz",
+		},
+		{
+			name:            "Disabled by the compression flag",
+			first:           []string{"--compression-algorithm", "gzip"},
+			again:           []string{"--compression=false"},
+			wantCompression: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config_path := newTestConfig(t)
+			register := []string{"register-storage", "--type", "1", "--name", "local", "--path", t.TempDir()}
+			runCommand(t, config_path, append(register, tt.first...)...)
+			runCommand(t, config_path, append(register, tt.again...)...)
+
+			storage := registeredStorage(t, config_path, "local")
+			if storage.Compression != tt.wantCompression {
+				t.Errorf("Compression = %v, want %v", storage.Compression, tt.wantCompression)
+			}
+			if storage.CompressionAlgorithm != tt.wantAlgorithm || storage.CompressionLevel != tt.wantLevel {
+				t.Errorf("Compression = %s level %d, want %s level %d", storage.CompressionAlgorithm, storage.CompressionLevel, tt.wantAlgorithm, tt.wantLevel)
+			}
+		})
+	}
+}
+
+func TestRegisterStorageKeepsRetention(t *testing.T) {
+	tests := []struct {
+		name  string
+		again []string
+		want  retention.Policy
+	}{
+		{
+			name:  "Kept without retention flags",
+			again: []string{"--retention", "14"},
+			want:  retention.Policy{KeepDaily: 7, KeepWeekly: 4},
+		},
+		{
+			name:  "Kept with other flags",
+			again: []string{"--compression-algorithm", "gzip"},
+			want:  retention.Policy{KeepDaily: 7, KeepWeekly: 4},
+		},
+		{
+			name:  "Replaced by a retention flag",
+			again: []string{"--keep-last", "3"},
+			want:  retention.Policy{KeepLast: 3},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config_path := newTestConfig(t)
+			register := []string{"register-storage", "--type", "1", "--name", "local", "--path", t.TempDir()}
+			runCommand(t, config_path, append(register, "--keep-daily", "7", "--keep-weekly", "4")...)
+			runCommand(t, config_path, append(register, tt.again...)...)
+
+			if got := registeredStorage(t, config_path, "local").Retention; got != tt.want {
+				t.Errorf("Retention = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
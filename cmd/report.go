@@ -0,0 +1,20 @@
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// writeReport writes a report as indented JSON to the file, - for the standard output
+func writeReport(path string, report any) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if path == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
@@ -2,16 +2,26 @@ package cmd
 
 import (
 	"bytes"
-	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
 
-	"github.com/martient/bifrost-backups/pkg/crypto"
-	localstorage "github.com/martient/bifrost-backups/pkg/local_storage"
+	"github.com/martient/bifrost-backups/pkg/backupname"
+	"github.com/martient/bifrost-backups/pkg/hooks"
+	localfiles "github.com/martient/bifrost-backups/pkg/local_files"
 	"github.com/martient/bifrost-backups/pkg/postgresql"
-	"github.com/martient/bifrost-backups/pkg/s3"
 	"github.com/martient/bifrost-backups/pkg/setup"
+	"github.com/martient/bifrost-backups/pkg/setup/interactives"
 	"github.com/martient/bifrost-backups/pkg/sqlite3"
+	internalutils "github.com/martient/bifrost-backups/pkg/utils"
 	"github.com/martient/golang-utils/utils"
 	"github.com/spf13/cobra"
+	"golang.org/x/term"
 )
 
 // generateCmd represents the load command
@@ -29,78 +39,316 @@ var restoreCmd = &cobra.Command{
 			return
 		}
 
+		if err := requireUnlock(); err != nil {
+			utils.LogError("Could not unlock the config: %s", "CLI", err)
+			return
+		}
 		database, err := setup.ReadDatabaseConfig(name)
 		if err != nil {
 			utils.LogError("Something went wrong during the config reading: %s", "CLI", err)
 			return
 		}
+		to_file, _ := cmd.Flags().GetString("to-file")
+		target, has_target, err := restoreTarget(cmd, database)
+		if err != nil {
+			utils.LogError("Invalid restore target: %s", "CLI", err)
+			return
+		}
+		if to_file != "" && has_target {
+			utils.LogError("--to-file can't be used with a restore target", "CLI", nil)
+			return
+		}
+		if has_target {
+			database = target
+			utils.LogInfo("Backup of %s is restored to %s instead of the registered database", "CLI", database.Name, restoreTargetName(database))
+		}
 		storage_name, _ := cmd.Flags().GetString("storage-name")
-		backup_name, _ := cmd.Flags().GetString("backup-name")
-		var result *bytes.Buffer
-		var cipher_key []byte
-
-		for i := 0; i < len(database.Storages); i++ {
-			storage, err := setup.ReadStorageConfig(database.Storages[i])
-			if err != nil {
-				utils.LogError("Something went wrong during the config reading: %s", "CLI", err)
+		identity_file, _ := cmd.Flags().GetString("identity-file")
+		selection, err := restoreSelection(cmd, to_file == "-")
+		if err != nil {
+			utils.LogError("Invalid backup selection: %s", "CLI", err)
+			return
+		}
+		storages := database.Storages
+		if storage_name != "" {
+			if !slices.Contains(database.Storages, storage_name) {
+				utils.LogErrorInterface("Storage %s isn't a storage of the database", "CLI", storage_name)
 				return
 			}
-			if storage_name != "" && storage_name == storage.Name {
-				switch storage.Type {
-				case setup.LocalStorage:
-					cipher_key, err = base64.StdEncoding.DecodeString(storage.CipherKey)
-					if err != nil {
-						utils.LogError("Something went wrong during the convertion of the cipher key process: %s", "CLI", err)
-						return
-					}
-					result, err = localstorage.PullBackup(storage.LocalStorage, backup_name, storage.Compression)
-				case setup.S3:
-					cipher_key, err = base64.StdEncoding.DecodeString(storage.CipherKey)
-					if err != nil {
-						utils.LogError("Something went wrong during the convertion of the cipher key process: %s", "CLI", err)
-						return
-					}
-					result, err = s3.PullBackup(storage.S3, backup_name, storage.Compression)
-				default:
-					utils.LogError("Unsupported storage type used during the restore process...", "CLI", nil)
-					return
-				}
-				if err != nil {
-					utils.LogError("Something went wrong during the retrieving process: %s", "CLI", err)
-					return
-				}
-				utils.LogInfo("Backup of %s successfully retrieved with %s", "CLI", database.Name, storage.Name)
-			}
+			storages = []string{storage_name}
 		}
-
-		if result == nil {
-			utils.LogWarning("The backup fetched seems to be empty...", "CLI")
+		decipher_result, source, backup_name, err := fetchRestoreBackup(database.Name, storages, selection, identity_file)
+		if errors.Is(err, interactives.ErrPickCanceled) {
+			utils.LogInfo("Restore canceled", "CLI")
+			return
+		} else if err != nil {
+			utils.LogError("Something went wrong during the retrieving process: %s", "CLI", err)
 			return
 		}
-
-		decipher_result, err := crypto.Decipher(cipher_key, result.Bytes())
-		if err != nil {
-			utils.LogError("Something went wrong during the encryption process: %s", "CLI", err)
+		utils.LogInfo("Backup %s of %s retrieved from %s", "CLI", backup_name, database.Name, source.Name)
+		if to_file != "" {
+			if err := writeDumpFile(to_file, decipher_result); err != nil {
+				utils.LogError("Something went wrong during the dump writing: %s", "CLI", err)
+				return
+			}
+			utils.LogInfo("Backup of %s successfully written to %s", "CLI", database.Name, to_file)
 			return
 		}
-		switch database.Type {
-		case setup.Postgresql:
-			err = postgresql.RunRestoration(database.Postgresql, decipher_result)
-		case setup.Sqlite3:
-			err = sqlite3.RunRestoration(database.Sqlite3, decipher_result)
+		if has_target && database.Type == setup.LocalFiles {
+			// A single file backup is restored inside the target folder
+			database.LocalFiles.Path = localfiles.RestorePath(database.LocalFiles.Path, decipher_result.Bytes())
+		}
+		run := hooks.Run{Database: database.Name, Storage: source.Name, Backup: backup_name, Status: hooks.StatusStarted}
+		err = database.Hooks.Run(hooks.PreRestore, run)
+		if err == nil {
+			err = restoreDatabase(database, decipher_result)
+		}
+		if hooks_err := finishHooks(database.Hooks, hooks.PostRestore, run, err); hooks_err != nil && err == nil {
+			err = hooks_err
 		}
 
 		if err != nil {
 			utils.LogError("Something went wrong during the restoring process: %s", "CLI", err)
 			return
 		}
-		utils.LogInfo("Backup of %s successfully restored", "CLI", database.Name)
+		utils.LogInfo("Backup of %s successfully restored from %s", "CLI", database.Name, source.Name)
 	},
 }
 
+// restoreDatabase restores the dump into the database with the tool of its type
+func restoreDatabase(database setup.Database, dump *bytes.Buffer) error {
+	switch database.Type {
+	case setup.Postgresql:
+		return postgresql.RunRestoration(database.Postgresql, dump)
+	case setup.Sqlite3:
+		return sqlite3.RunRestoration(database.Sqlite3, dump)
+	case setup.LocalFiles:
+		return localfiles.RunRestore(database.LocalFiles, dump)
+	}
+	return fmt.Errorf("unsupported database type %d", database.Type)
+}
+
+// restoreTarget returns the database with the target flags applied and whether one is set,
+// the flags must match the type of the database
+func restoreTarget(cmd *cobra.Command, database setup.Database) (setup.Database, bool, error) {
+	target_host, _ := cmd.Flags().GetString("target-host")
+	target_db, _ := cmd.Flags().GetString("target-db")
+	target_path, _ := cmd.Flags().GetString("target-path")
+	target_dir, _ := cmd.Flags().GetString("target-dir")
+	if target_host == "" && target_db == "" && target_path == "" && target_dir == "" {
+		return database, false, nil
+	}
+
+	switch database.Type {
+	case setup.Postgresql:
+		if target_path != "" || target_dir != "" {
+			return database, false, fmt.Errorf("--target-path and --target-dir don't apply to PostgreSQL, use --target-host and --target-db")
+		}
+		if target_host != "" {
+			database.Postgresql.Hostname = target_host
+		}
+		if target_db != "" {
+			database.Postgresql.Name = target_db
+		}
+	case setup.Sqlite3:
+		if target_host != "" || target_db != "" || target_dir != "" {
+			return database, false, fmt.Errorf("only --target-path applies to SQLite")
+		}
+		database.Sqlite3.Path = target_path
+	case setup.LocalFiles:
+		if target_host != "" || target_db != "" || target_path != "" {
+			return database, false, fmt.Errorf("only --target-dir applies to local files")
+		}
+		database.LocalFiles.Path = target_dir
+	default:
+		return database, false, fmt.Errorf("unsupported database type %d", database.Type)
+	}
+	for _, path := range []string{target_path, target_dir} {
+		if path != "" && !filepath.IsAbs(path) {
+			return database, false, fmt.Errorf("target %s must be an absolute path", path)
+		}
+	}
+	return database, true, nil
+}
+
+// fetchRestoreBackup tries the storages in order and returns the first selected backup
+// retrieved, checked against its manifest and decrypted
+func fetchRestoreBackup(database_name string, storages []string, selection backupSelection, identity_file string) (*bytes.Buffer, setup.Storage, string, error) {
+	var dump *bytes.Buffer
+	var storage setup.Storage
+	var backup_name string
+	err := tryStorages(database_name, storages, func(storage_name string) error {
+		var err error
+		if storage, err = setup.ReadStorageConfig(storage_name); err != nil {
+			return err
+		}
+		dump, backup_name, err = fetchStorageBackup(storage, database_name, selection, identity_file)
+		return err
+	})
+	if err != nil {
+		return nil, setup.Storage{}, "", err
+	}
+	return dump, storage, backup_name, nil
+}
+
+// tryStorages runs fetch on the storages in order until one succeeds. The storages which are
+// unreachable, have no matching backup or whose backup is corrupted are skipped, a canceled
+// backup picker stops the restore instead of moving to the next storage.
+func tryStorages(database_name string, storages []string, fetch func(storage_name string) error) error {
+	var failures []string
+	for _, storage_name := range storages {
+		err := fetch(storage_name)
+		if err == nil {
+			return nil
+		} else if errors.Is(err, interactives.ErrPickCanceled) {
+			return err
+		}
+		utils.LogWarning("Skipping storage %s: %v", "CLI", storage_name, err)
+		failures = append(failures, fmt.Sprintf("%s: %v", storage_name, err))
+	}
+	if len(failures) == 0 {
+		return fmt.Errorf("database %s has no storage", database_name)
+	}
+	return fmt.Errorf("no storage could provide the backup (%s)", strings.Join(failures, "; "))
+}
+
+// fetchStorageBackup retrieves the selected backup of the storage and decrypts it
+func fetchStorageBackup(storage setup.Storage, database_name string, selection backupSelection, identity_file string) (*bytes.Buffer, string, error) {
+	backup_name, err := selection.resolve(storage, database_name)
+	if err != nil {
+		return nil, "", err
+	}
+	if backup_name == "" {
+		if backup_name, err = latestStorageBackup(storage, database_name); err != nil {
+			return nil, "", err
+		}
+	}
+	result, err := pullStorageBackup(storage, database_name, backup_name)
+	if err != nil {
+		return nil, backup_name, err
+	}
+	dump, err := decryptBackup(storage, result.Bytes(), identity_file)
+	if err != nil {
+		return nil, backup_name, fmt.Errorf("decryption of %s failed: %w", backup_name, err)
+	}
+	return dump, backup_name, nil
+}
+
+// backupSelection picks the restored backup in a storage, the latest one when nothing is set
+type backupSelection struct {
+	name      string
+	before    time.Time
+	inclusive bool
+	pick      bool
+}
+
+// restoreSelection reads the selection flags, at most one can be set. The backup is picked
+// on the terminal when none is set and the command runs on a terminal.
+func restoreSelection(cmd *cobra.Command, dump_to_stdout bool) (backupSelection, error) {
+	backup_name, _ := cmd.Flags().GetString("backup-name")
+	at, _ := cmd.Flags().GetString("at")
+	before, _ := cmd.Flags().GetString("before")
+	age, _ := cmd.Flags().GetString("age")
+
+	set := 0
+	for _, value := range []string{backup_name, at, before, age} {
+		if value != "" {
+			set++
+		}
+	}
+	if set > 1 {
+		return backupSelection{}, fmt.Errorf("--backup-name, --at, --before and --age can't be used together")
+	}
+
+	var err error
+	selection := backupSelection{name: backup_name}
+	switch {
+	case at != "":
+		selection.before, err = internalutils.ParseTime(at)
+		selection.inclusive = true
+	case before != "":
+		selection.before, err = internalutils.ParseTime(before)
+	case age != "":
+		var duration time.Duration
+		duration, err = internalutils.ParseDuration(age)
+		selection.before = time.Now().Add(-duration)
+		selection.inclusive = true
+	case backup_name == "":
+		selection.pick = !dump_to_stdout && term.IsTerminal(int(syscall.Stdin)) && term.IsTerminal(int(syscall.Stdout))
+	}
+	return selection, err
+}
+
+// resolve returns the name of the selected backup in the storage, empty for the latest one
+func (selection backupSelection) resolve(storage setup.Storage, database_name string) (string, error) {
+	if selection.name != "" || (selection.before.IsZero() && !selection.pick) {
+		return selection.name, nil
+	}
+
+	names, err := listStorageBackups(storage, database_name)
+	if err != nil {
+		return "", err
+	}
+	backups, _ := backupname.Filter(names, database_name)
+	if len(backups) == 0 {
+		return "", fmt.Errorf("no backup of %s found on %s", database_name, storage.Name)
+	}
+
+	if selection.pick {
+		keys := backupname.Keys(backups)
+		slices.Reverse(keys)
+		return interactives.PickBackup(fmt.Sprintf("Backup of %s to restore from %s", database_name, storage.Name), keys)
+	}
+	backup, found := backupname.Newest(backups, selection.before, selection.inclusive)
+	if !found {
+		return "", fmt.Errorf("no backup of %s on %s before %s", database_name, storage.Name, selection.before.Format(time.RFC3339))
+	}
+	return backup.Key, nil
+}
+
+// restoreTargetName describes where the database is restored
+func restoreTargetName(database setup.Database) string {
+	switch database.Type {
+	case setup.Postgresql:
+		return fmt.Sprintf("%s on %s", database.Postgresql.Name, database.Postgresql.Hostname)
+	case setup.Sqlite3:
+		return database.Sqlite3.Path
+	case setup.LocalFiles:
+		return database.LocalFiles.Path
+	}
+	return database.Name
+}
+
+// writeDumpFile writes the decrypted and decompressed dump to a new file, - for the standard output
+func writeDumpFile(path string, dump *bytes.Buffer) error {
+	if path == "-" {
+		_, err := os.Stdout.Write(dump.Bytes())
+		return err
+	}
+	file, err := os.OpenFile(filepath.Clean(path), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(dump.Bytes()); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+	return file.Close()
+}
+
 func init() {
 	rootCmd.AddCommand(restoreCmd)
 	restoreCmd.Flags().String("name", "", "Database name")
-	restoreCmd.Flags().String("storage-name", "", "You must define a specific storage otherwise it gonna take the first found")
+	restoreCmd.Flags().String("storage-name", "", "Only restore from this storage, otherwise the storages of the database are tried in order")
 	restoreCmd.Flags().String("backup-name", "", "Backup name on your storage solution")
+	restoreCmd.Flags().String("identity-file", "", "Identity file able to decrypt the backups encrypted for recipients")
+	restoreCmd.Flags().String("at", "", "Restore the newest backup taken at or before this time, e.g. \"2026-10-15 03:00\" in local time")
+	restoreCmd.Flags().String("before", "", "Restore the newest backup taken strictly before this time")
+	restoreCmd.Flags().String("age", "", "Restore the newest backup at least this old, e.g. 36h, 2d or 1w")
+	restoreCmd.Flags().String("target-host", "", "Restore a PostgreSQL backup on this host instead of the registered one")
+	restoreCmd.Flags().String("target-db", "", "Restore a PostgreSQL backup into this database instead of the registered one")
+	restoreCmd.Flags().String("target-path", "", "Restore a SQLite backup to this file instead of the registered one")
+	restoreCmd.Flags().String("target-dir", "", "Restore a local files backup to this folder instead of the registered one")
+	restoreCmd.Flags().String("to-file", "", "Write the decrypted and decompressed dump to this new file instead of restoring it, - for the standard output")
 }
@@ -0,0 +1,153 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	localfiles "github.com/martient/bifrost-backups/pkg/local_files"
+	"github.com/martient/bifrost-backups/pkg/postgresql"
+	"github.com/martient/bifrost-backups/pkg/setup"
+	"github.com/martient/bifrost-backups/pkg/setup/interactives"
+	"github.com/martient/bifrost-backups/pkg/sqlite3"
+	"github.com/spf13/cobra"
+)
+
+func TestRestoreTarget(t *testing.T) {
+	databases := map[setup.DatabaseType]setup.Database{
+		setup.Postgresql: {Name: "pg", Type: setup.Postgresql, Postgresql: postgresql.PostgresqlRequirements{Hostname: "db.internal", Name: "app"}},
+		setup.Sqlite3:    {Name: "lite", Type: setup.Sqlite3, Sqlite3: sqlite3.Sqlite3Requirements{Path: "/var/lib/app.db"}},
+		setup.LocalFiles: {Name: "files", Type: setup.LocalFiles, LocalFiles: localfiles.LocalFilesRequirements{Path: "/etc/app"}},
+	}
+
+	tests := []struct {
+		name         string
+		databaseType setup.DatabaseType
+		args         []string
+		wantTarget   bool
+		wantName     string
+		wantErr      bool
+	}{
+		{name: "PostgreSQL without target", databaseType: setup.Postgresql, wantName: "app on db.internal"},
+		{name: "PostgreSQL host", databaseType: setup.Postgresql, args: []string{"--target-host", "replica"}, wantTarget: true, wantName: "app on replica"},
+		{name: "PostgreSQL database", databaseType: setup.Postgresql, args: []string{"--target-db", "app_copy"}, wantTarget: true, wantName: "app_copy on db.internal"},
+		{name: "PostgreSQL path", databaseType: setup.Postgresql, args: []string{"--target-path", "/tmp/app.db"}, wantErr: true},
+		{name: "PostgreSQL folder", databaseType: setup.Postgresql, args: []string{"--target-dir", "/tmp/app"}, wantErr: true},
+		{name: "SQLite path", databaseType: setup.Sqlite3, args: []string{"--target-path", "/tmp/app.db"}, wantTarget: true, wantName: "/tmp/app.db"},
+		{name: "SQLite relative path", databaseType: setup.Sqlite3, args: []string{"--target-path", "app.db"}, wantErr: true},
+		{name: "SQLite host", databaseType: setup.Sqlite3, args: []string{"--target-host", "replica"}, wantErr: true},
+		{name: "SQLite folder", databaseType: setup.Sqlite3, args: []string{"--target-dir", "/tmp/app"}, wantErr: true},
+		{name: "Local files folder", databaseType: setup.LocalFiles, args: []string{"--target-dir", "/tmp/app"}, wantTarget: true, wantName: "/tmp/app"},
+		{name: "Local files relative folder", databaseType: setup.LocalFiles, args: []string{"--target-dir", "app"}, wantErr: true},
+		{name: "Local files path", databaseType: setup.LocalFiles, args: []string{"--target-path", "/tmp/app.db"}, wantErr: true},
+		{name: "Local files database", databaseType: setup.LocalFiles, args: []string{"--target-db", "app"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := &cobra.Command{}
+			for _, flag := range []string{"target-host", "target-db", "target-path", "target-dir"} {
+				cmd.Flags().String(flag, "", "")
+			}
+			if err := cmd.Flags().Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+
+			target, hasTarget, err := restoreTarget(cmd, databases[tt.databaseType])
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("restoreTarget() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if hasTarget != tt.wantTarget {
+				t.Errorf("restoreTarget() has target = %v, want %v", hasTarget, tt.wantTarget)
+			}
+			if name := restoreTargetName(target); name != tt.wantName {
+				t.Errorf("restoreTargetName() = %v, want %v", name, tt.wantName)
+			}
+		})
+	}
+}
+
+func TestWriteDumpFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.sql")
+	if err := writeDumpFile(path, bytes.NewBufferString("dump")); err != nil {
+		t.Fatalf("writeDumpFile() error = %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("dump file mode = %v, want 0600", info.Mode().Perm())
+	}
+
+	// An existing file is never overwritten
+	if err := writeDumpFile(path, bytes.NewBufferString("other dump")); !errors.Is(err, os.ErrExist) {
+		t.Errorf("writeDumpFile() of an existing file error = %v, want %v", err, os.ErrExist)
+	}
+	if content, err := os.ReadFile(path); err != nil || string(content) != "dump" {
+		t.Errorf("dump file = %q, %v, want dump", content, err)
+	}
+}
+
+func TestTryStorages(t *testing.T) {
+	unreachable := errors.New("unreachable")
+	tests := []struct {
+		name      string
+		storages  []string
+		results   map[string]error
+		wantTried []string
+		wantErr   error
+	}{
+		{
+			name:      "First storage",
+			storages:  []string{"primary", "secondary"},
+			wantTried: []string{"primary"},
+		},
+		{
+			name:      "Failing storage skipped",
+			storages:  []string{"primary", "secondary", "archive"},
+			results:   map[string]error{"primary": unreachable},
+			wantTried: []string{"primary", "secondary"},
+		},
+		{
+			name:      "Every storage failing",
+			storages:  []string{"primary", "secondary"},
+			results:   map[string]error{"primary": unreachable, "secondary": unreachable},
+			wantTried: []string{"primary", "secondary"},
+			wantErr:   errors.New("no storage could provide the backup (primary: unreachable; secondary: unreachable)"),
+		},
+		{
+			name:      "Canceled picker",
+			storages:  []string{"primary", "secondary"},
+			results:   map[string]error{"primary": interactives.ErrPickCanceled},
+			wantTried: []string{"primary"},
+			wantErr:   interactives.ErrPickCanceled,
+		},
+		{
+			name:    "No storage",
+			wantErr: errors.New("database app has no storage"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tried []string
+			err := tryStorages("app", tt.storages, func(storage_name string) error {
+				tried = append(tried, storage_name)
+				return tt.results[storage_name]
+			})
+			if (err == nil) != (tt.wantErr == nil) || (err != nil && err.Error() != tt.wantErr.Error()) {
+				t.Errorf("tryStorages() error = %v, want %v", err, tt.wantErr)
+			}
+			if !slices.Equal(tried, tt.wantTried) {
+				t.Errorf("tryStorages() tried %v, want %v", tried, tt.wantTried)
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package cmd
 
 import (
 	localstorage "github.com/martient/bifrost-backups/pkg/local_storage"
+	"github.com/martient/bifrost-backups/pkg/retention"
 	"github.com/martient/bifrost-backups/pkg/s3"
 	"github.com/martient/bifrost-backups/pkg/setup"
 	"github.com/martient/golang-utils/utils"
@@ -17,6 +18,20 @@ var retentionCmd = &cobra.Command{
 		}
 
 		var names []string
+		dry_run, _ := cmd.Flags().GetBool("dry-run")
+		report_path, _ := cmd.Flags().GetString("report")
+		var reports []retention.Report
+		defer func() {
+			if report_path == "" {
+				return
+			}
+			if reports == nil {
+				reports = []retention.Report{}
+			}
+			if err := writeReport(report_path, reports); err != nil {
+				utils.LogError("Failed to write the retention report: %s", "CLI", err)
+			}
+		}()
 
 		name, err := cmd.Flags().GetString("name")
 		if name == "" {
@@ -53,16 +68,23 @@ var retentionCmd = &cobra.Command{
 					utils.LogInfo("Rentention policy of %s as been skipped for %s", "CLI", database.Name, storage.Name)
 					continue
 				}
+				policy := storage.RetentionPolicy()
+				var decisions []retention.Decision
 				switch storage.Type {
 				case setup.LocalStorage:
-					err = localstorage.ExecuteRetentionPolicy(storage.LocalStorage, storage.RetentionDays)
+					decisions, err = localstorage.ExecuteRetentionPolicy(storage.LocalStorage, database.Name, policy, dry_run)
 				case setup.S3:
-					err = s3.ExecuteRetentionPolicy(storage.S3, storage.RetentionDays)
+					decisions, err = s3.ExecuteRetentionPolicy(storage.S3, database.Name, policy, dry_run)
 				}
+				reports = append(reports, retention.NewReport(database.Name, storage.Name, policy, decisions, dry_run))
 				if err != nil {
 					utils.LogError("Something went wrong during the backup(s) cleaning process: %s", "CLI", err)
 					return
 				}
+				if dry_run {
+					utils.LogInfo("Dry run of the retention policy of %s for %s, nothing has been deleted", "CLI", storage.Name, database.Name)
+					continue
+				}
 				utils.LogInfo("Backup(s) of %s as been deleted successfully following the retention policy of %s", "CLI", database.Name, storage.Name)
 			}
 		}
@@ -72,4 +94,6 @@ var retentionCmd = &cobra.Command{
 func init() {
 	rootCmd.AddCommand(retentionCmd)
 	retentionCmd.Flags().String("name", "", "Database name")
+	retentionCmd.Flags().Bool("dry-run", false, "List the backups that would be deleted and why without deleting them")
+	retentionCmd.Flags().String("report", "", "Write a JSON report of the kept and deleted backups to this file, - for the standard output")
 }
@@ -1,6 +1,9 @@
 package cmd
 
 import (
+	"os"
+
+	"github.com/martient/bifrost-backups/pkg/setup"
 	"github.com/martient/bifrost-backups/pkg/updater"
 	"github.com/martient/golang-utils/utils"
 	"github.com/spf13/cobra"
@@ -13,6 +16,7 @@ var (
 	cfgFile       string
 	disableUpdate bool
 	updateChannel string
+	secretSource  string
 )
 
 // rootCmd represents the base command when called without any subcommands
@@ -47,6 +51,7 @@ func init() {
 	rootCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 	rootCmd.PersistentFlags().BoolP("yes", "y", false, "Auto accept manual question y/n")
 	rootCmd.PersistentFlags().BoolVar(&noEncryption, "no-encryption", false, "Disable configuration encryption (not recommended for production)")
+	rootCmd.PersistentFlags().StringVar(&secretSource, "secret-provider", "", "Master key provider of the configuration secrets (keyring, env, file, vault), default BIFROST_SECRET_PROVIDER or keyring")
 
 	// If update check is not disabled, check for updates
 	if !disableUpdate {
@@ -60,6 +65,12 @@ func initConfig() {
 	// }
 	viper.AutomaticEnv() // read in environment variables that match
 
+	setup.SetNoEncryption(noEncryption)
+	if err := setup.SetSecretProvider(secretSource); err != nil {
+		utils.LogError("Invalid secret provider: %s", "CLI", err)
+		os.Exit(1)
+	}
+
 	// If a config file is found, read it in.
 	if err := viper.ReadInConfig(); err == nil {
 		utils.LogInfo("Using config file: %s", "CLI", viper.ConfigFileUsed())
@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/martient/bifrost-backups/pkg/setup"
+	"github.com/martient/golang-utils/utils"
+	"github.com/spf13/cobra"
+)
+
+var rotateKeyCmd = &cobra.Command{
+	Use:   "rotate-key",
+	Short: "Promote a new cipher key for a storage",
+	Long: `Promote a new cipher key used by the next backups of the storage. The previous key is kept
+in the storage keyring to decrypt the older backups, each backup header records the id of its key.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		name, _ := cmd.Flags().GetString("name")
+		if name == "" {
+			utils.LogError("name can't be empty", "CLI", nil)
+			os.Exit(1)
+		}
+		cipher_key, _ := cmd.Flags().GetString("cipher-key")
+		if err := requireUnlock(); err != nil {
+			utils.LogError("Could not unlock the config: %s", "CLI", err)
+			os.Exit(1)
+		}
+
+		id, err := setup.RotateCipherKey(name, cipher_key)
+		if err != nil {
+			utils.LogError("Something went wrong during the key rotation: %s", "CLI", err)
+			os.Exit(1)
+		}
+		utils.LogInfo("Storage %s now encrypts with key %s", "CLI", name, id)
+
+		if reencrypt, _ := cmd.Flags().GetBool("reencrypt"); !reencrypt {
+			return
+		}
+		storage, err := setup.ReadStorageConfig(name)
+		if err != nil {
+			utils.LogError("Something went wrong during the config reading: %s", "CLI", err)
+			os.Exit(1)
+		}
+		if err := reencryptBackups(storage); err != nil {
+			utils.LogError("Something went wrong during the re-encryption process: %s", "CLI", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(rotateKeyCmd)
+	rotateKeyCmd.Flags().String("name", "", "Storage name")
+	rotateKeyCmd.Flags().String("cipher-key", "", "Bring you own cipher key (AES256 32bits) or leave it empty to generate one")
+	rotateKeyCmd.Flags().Bool("reencrypt", false, "Re-encrypt the existing backups with the new key once promoted")
+}
@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/martient/bifrost-backups/pkg/setup"
+	"github.com/martient/golang-utils/utils"
+	"github.com/spf13/cobra"
+)
+
+var splitKeyCmd = &cobra.Command{
+	Use:   "split-key",
+	Short: "Split the storage cipher keys into Shamir shares",
+	Long: `Split the cipher keys of a storage (or of every storage) into shares for disaster recovery,
+any threshold of them rebuild the keys with recover-key. Hand each share to a different
+custodian, a share alone reveals nothing about the keys.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		var names []string
+		if name, _ := cmd.Flags().GetString("name"); name != "" {
+			names = append(names, name)
+		} else if all, _ := cmd.Flags().GetBool("all"); !all {
+			utils.LogError("choose a storage with --name or every storage with --all", "CLI", nil)
+			os.Exit(1)
+		}
+		shares, _ := cmd.Flags().GetInt("shares")
+		threshold, _ := cmd.Flags().GetInt("threshold")
+		if err := requireUnlock(); err != nil {
+			utils.LogError("Could not unlock the config: %s", "CLI", err)
+			os.Exit(1)
+		}
+
+		encoded, err := setup.SplitCipherKeys(names, shares, threshold)
+		if err != nil {
+			utils.LogError("Something went wrong during the key split: %s", "CLI", err)
+			os.Exit(1)
+		}
+		for i, share := range encoded {
+			fmt.Printf("# share %d of %d, %d required\n%s\n\n", i+1, shares, threshold, share)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(splitKeyCmd)
+	splitKeyCmd.Flags().String("name", "", "Storage name")
+	splitKeyCmd.Flags().Bool("all", false, "Split the keys of every storage")
+	splitKeyCmd.Flags().Int("shares", 5, "Number of shares to create")
+	splitKeyCmd.Flags().Int("threshold", 3, "Number of shares required to recover the keys")
+}
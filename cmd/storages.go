@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/martient/bifrost-backups/pkg/hooks"
+	localfiles "github.com/martient/bifrost-backups/pkg/local_files"
+	localstorage "github.com/martient/bifrost-backups/pkg/local_storage"
+	"github.com/martient/bifrost-backups/pkg/manifest"
+	"github.com/martient/bifrost-backups/pkg/postgresql"
+	"github.com/martient/bifrost-backups/pkg/s3"
+	"github.com/martient/bifrost-backups/pkg/setup"
+	"github.com/martient/bifrost-backups/pkg/sqlite3"
+)
+
+// listStorageBackups returns the backups of the database in the storage, oldest first
+func listStorageBackups(storage setup.Storage, database_name string) ([]string, error) {
+	switch storage.Type {
+	case setup.LocalStorage:
+		return localstorage.ListBackups(storage.LocalStorage, database_name)
+	case setup.S3:
+		return s3.ListBackups(storage.S3, database_name)
+	}
+	return nil, fmt.Errorf("unsupported storage type %d", storage.Type)
+}
+
+// latestStorageBackup returns the newest backup of the database in the storage
+func latestStorageBackup(storage setup.Storage, database_name string) (string, error) {
+	switch storage.Type {
+	case setup.LocalStorage:
+		return localstorage.LatestBackup(storage.LocalStorage, database_name)
+	case setup.S3:
+		return s3.LatestBackup(storage.S3, database_name)
+	}
+	return "", fmt.Errorf("unsupported storage type %d", storage.Type)
+}
+
+// pullStorageBackup downloads a backup checked against its manifest, the newest one of the database when the name is empty
+func pullStorageBackup(storage setup.Storage, database_name string, backup_name string) (*bytes.Buffer, error) {
+	switch storage.Type {
+	case setup.LocalStorage:
+		return localstorage.PullBackup(storage.LocalStorage, database_name, backup_name)
+	case setup.S3:
+		return s3.PullBackup(storage.S3, database_name, backup_name)
+	}
+	return nil, fmt.Errorf("unsupported storage type %d", storage.Type)
+}
+
+// readStorageManifest returns the manifest of a backup, nil for the backups written before the manifests
+func readStorageManifest(storage setup.Storage, backup_name string) (*manifest.Manifest, error) {
+	switch storage.Type {
+	case setup.LocalStorage:
+		return localstorage.ReadManifest(storage.LocalStorage, backup_name)
+	case setup.S3:
+		return s3.ReadManifest(storage.S3, backup_name)
+	}
+	return nil, fmt.Errorf("unsupported storage type %d", storage.Type)
+}
+
+// verifyDump runs the validation of the database type on a decrypted backup
+func verifyDump(database setup.Database, dump *bytes.Buffer) error {
+	switch database.Type {
+	case setup.Postgresql:
+		return postgresql.RunVerification(dump)
+	case setup.Sqlite3:
+		return sqlite3.RunVerification(dump)
+	case setup.LocalFiles:
+		_, err := localfiles.RunVerification(dump)
+		return err
+	}
+	return fmt.Errorf("unsupported database type %d", database.Type)
+}
+
+// finishHooks runs the post hooks of the event whatever the outcome of the run, then the on
+// failure hooks when it failed. It returns the error of the hooks set to abort.
+func finishHooks(database_hooks hooks.Hooks, event hooks.Event, run hooks.Run, run_err error) error {
+	run.Status = hooks.StatusSuccess
+	if run_err != nil {
+		run.Status, run.Error = hooks.StatusFailure, run_err.Error()
+	}
+	err := database_hooks.Run(event, run)
+	if run_err != nil {
+		if failure_err := database_hooks.Run(hooks.OnFailure, run); err == nil {
+			err = failure_err
+		}
+	}
+	return err
+}
@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"os"
+	"time"
+
+	"github.com/martient/bifrost-backups/pkg/setup"
+	"github.com/martient/golang-utils/utils"
+	"github.com/spf13/cobra"
+)
+
+var unlockCmd = &cobra.Command{
+	Use:   "unlock",
+	Short: "Keep a protected config unlocked for a while",
+	Long: `Unlock a config protected by the master password and keep it unlocked for the --ttl,
+restore and the key commands then don't ask for the password again. The unlock token is
+encrypted with the host master key and removed by lock.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ttl, _ := cmd.Flags().GetDuration("ttl")
+
+		protected, err := setup.IsProtected()
+		if err != nil {
+			utils.LogError("Something went wrong during the config reading: %s", "CLI", err)
+			os.Exit(1)
+		} else if !protected {
+			utils.LogWarning("The config isn't protected, enable it with init-master-password --protect", "CLI")
+			return
+		}
+		if err := requireUnlock(); err != nil {
+			utils.LogError("Could not unlock the config: %s", "CLI", err)
+			os.Exit(1)
+		}
+		if err := setup.CreateUnlockToken(ttl); err != nil {
+			utils.LogError("Could not create the unlock token: %s", "CLI", err)
+			os.Exit(1)
+		}
+		utils.LogInfo("Config unlocked for %s", "CLI", ttl)
+	},
+}
+
+var lockCmd = &cobra.Command{
+	Use:   "lock",
+	Short: "Lock a protected config before its unlock token expires",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := setup.RemoveUnlockToken(); err != nil {
+			utils.LogError("Could not remove the unlock token: %s", "CLI", err)
+			os.Exit(1)
+		}
+		utils.LogInfo("Config locked", "CLI")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(unlockCmd)
+	rootCmd.AddCommand(lockCmd)
+	unlockCmd.Flags().Duration("ttl", 15*time.Minute, "How long the config stays unlocked")
+}
@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/martient/bifrost-backups/pkg/setup"
+	"github.com/martient/golang-utils/utils"
+	"github.com/spf13/cobra"
+)
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Check the backups can be restored: checksum, decryption, decompression and dump validation",
+	Run: func(cmd *cobra.Command, args []string) {
+		if disableUpdateCheck, _ := rootCmd.Flags().GetBool("disable-update-check"); !disableUpdateCheck {
+			doConfirmAndSelfUpdate()
+		}
+
+		var names []string
+
+		name, _ := cmd.Flags().GetString("name")
+		storage_name, _ := cmd.Flags().GetString("storage-name")
+		backup_name, _ := cmd.Flags().GetString("backup-name")
+		identity_file, _ := cmd.Flags().GetString("identity-file")
+		all, _ := cmd.Flags().GetBool("all")
+		if backup_name != "" && all {
+			utils.LogError("--backup-name and --all can't be used together", "CLI", nil)
+			os.Exit(1)
+		}
+
+		if name == "" {
+			fetched_names, err := setup.GetDatabaseConfigName()
+			if err != nil {
+				utils.LogError("Something went wrong during the config reading: %s", "CLI", err)
+				os.Exit(1)
+			} else if len(fetched_names) == 0 {
+				utils.LogWarning("No backup source found", "CLI")
+				return
+			}
+			names = append(names, fetched_names...)
+		} else {
+			names = append(names, name)
+		}
+
+		if err := requireUnlock(); err != nil {
+			utils.LogError("Could not unlock the config: %s", "CLI", err)
+			os.Exit(1)
+		}
+
+		passed, failed := 0, 0
+		for _, name := range names {
+			database, err := setup.ReadDatabaseConfig(name)
+			if err != nil {
+				utils.LogError("Something went wrong during the config reading: %s", "CLI", err)
+				os.Exit(1)
+			}
+
+			for _, database_storage := range database.Storages {
+				if storage_name != "" && storage_name != database_storage {
+					continue
+				}
+				storage, err := setup.ReadStorageConfig(database_storage)
+				if err != nil {
+					utils.LogError("Something went wrong during the config reading: %s", "CLI", err)
+					os.Exit(1)
+				}
+
+				var backups []string
+				switch {
+				case backup_name != "":
+					backups = []string{backup_name}
+				case all:
+					backups, err = listStorageBackups(storage, database.Name)
+				default:
+					var latest string
+					if latest, err = latestStorageBackup(storage, database.Name); err == nil {
+						backups = []string{latest}
+					}
+				}
+				if err != nil {
+					utils.LogErrorInterface("FAIL %s on %s: %v", "VERIFY", database.Name, storage.Name, err)
+					failed++
+					continue
+				}
+
+				for _, backup := range backups {
+					if err := verifyBackup(database, storage, backup, identity_file); err != nil {
+						utils.LogErrorInterface("FAIL %s on %s: %v", "VERIFY", backup, storage.Name, err)
+						failed++
+						continue
+					}
+					utils.LogInfo("PASS %s on %s", "VERIFY", backup, storage.Name)
+					passed++
+				}
+			}
+		}
+
+		utils.LogInfo("%d backup(s) passed, %d failed", "VERIFY", passed, failed)
+		if failed > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+// verifyBackup checks a backup end to end: manifest checksum, decryption, decompression
+// and the validation of the database type
+func verifyBackup(database setup.Database, storage setup.Storage, backup_name string, identity_file string) error {
+	backup_manifest, err := readStorageManifest(storage, backup_name)
+	if err != nil {
+		return err
+	} else if backup_manifest == nil {
+		utils.LogWarning("Backup %s has no manifest, its checksum can't be verified", "VERIFY", backup_name)
+	}
+
+	// The checksum is verified by the storage before the backup is decompressed
+	result, err := pullStorageBackup(storage, database.Name, backup_name)
+	if err != nil {
+		return err
+	}
+	decipher_result, err := decryptBackup(storage, result.Bytes(), identity_file)
+	if err != nil {
+		return fmt.Errorf("decryption failed: %w", err)
+	}
+	if err := verifyDump(database, decipher_result); err != nil {
+		return fmt.Errorf("invalid dump: %w", err)
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+	verifyCmd.Flags().String("name", "", "Database name, every database when empty")
+	verifyCmd.Flags().String("storage-name", "", "Only verify the backups of this storage")
+	verifyCmd.Flags().String("backup-name", "", "Backup to verify, the latest one when empty")
+	verifyCmd.Flags().Bool("all", false, "Verify every backup of the database instead of the latest one")
+	verifyCmd.Flags().String("identity-file", "", "Identity file able to decrypt the backups encrypted for recipients")
+}
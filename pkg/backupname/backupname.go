@@ -0,0 +1,181 @@
+package backupname
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/martient/golang-utils/utils"
+)
+
+// Layout is the timestamp of a backup name, fixed width so the names of a database sort
+// by time, with dashes instead of colons to be valid on every filesystem
+const Layout = "2006-01-02T15-04-05.000000Z"
+
+// Extension is the extension of the backups written by bifrost, the content is in the
+// bifrost format (compression header over the encrypted dump), not a plain archive
+const Extension = ".bifrost"
+
+// ManifestSuffix is appended to the name of a backup to name its manifest
+const ManifestSuffix = ".manifest"
+
+// The layouts written before the codec, local storages wrote the seconds on 3 digits
+const (
+	legacyLocalLayout = "2006-01-02T15:04:005Z"
+	legacyS3Layout    = time.RFC3339
+)
+
+var (
+	namePattern     = regexp.MustCompile(`^(?:(.+)_)?(\d{4}-\d{2}-\d{2}T\d{2}-\d{2}-\d{2}\.\d{6}Z)((?:\.[A-Za-z0-9]+)*)$`)
+	databasePattern = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+)
+
+// Name is a backup name, <database>_<timestamp><extension>
+type Name struct {
+	Database  string
+	Time      time.Time
+	Extension string
+	// Legacy is set for the names written before the codec, they have no database prefix
+	Legacy bool
+}
+
+// New returns the name of a backup of the database taken at t, the extension may be empty
+func New(database string, t time.Time, extension string) Name {
+	if extension != "" && !strings.HasPrefix(extension, ".") {
+		extension = "." + extension
+	}
+	return Name{Database: SanitizeDatabase(database), Time: t.UTC().Truncate(time.Microsecond), Extension: extension}
+}
+
+// SanitizeDatabase replaces the characters of a database name that aren't safe in a file
+// name or an object key
+func SanitizeDatabase(database string) string {
+	return databasePattern.ReplaceAllString(database, "-")
+}
+
+// String formats the name in the current format. Legacy names are only parsed, nothing
+// renames them, so the storages are addressed with the listed key instead of String()
+func (name Name) String() string {
+	result := name.Time.UTC().Format(Layout) + name.Extension
+	if name.Database != "" {
+		result = name.Database + "_" + result
+	}
+	return result
+}
+
+// Parse reads a backup name, the legacy local and S3 formats are accepted
+func Parse(value string) (Name, error) {
+	if IsManifest(value) {
+		return Name{}, fmt.Errorf("%s is a backup manifest", value)
+	}
+	if match := namePattern.FindStringSubmatch(value); match != nil {
+		backupTime, err := time.Parse(Layout, match[2])
+		if err != nil {
+			return Name{}, fmt.Errorf("invalid backup name %s: %w", value, err)
+		}
+		return Name{Database: match[1], Time: backupTime, Extension: match[3]}, nil
+	}
+	for _, layout := range []string{legacyLocalLayout, legacyS3Layout} {
+		if backupTime, err := time.Parse(layout, value); err == nil {
+			return Name{Time: backupTime.UTC(), Legacy: true}, nil
+		}
+	}
+	return Name{}, fmt.Errorf("%s isn't a backup name", value)
+}
+
+// IsManifest reports whether the name is the one of a backup manifest
+func IsManifest(value string) bool {
+	return strings.HasSuffix(value, ManifestSuffix)
+}
+
+// BelongsTo reports whether the backup is one of the database, names without a database
+// prefix don't belong to a database in particular (see Select)
+func (name Name) BelongsTo(database string) bool {
+	return database == "" || (name.Database != "" && name.Database == SanitizeDatabase(database))
+}
+
+// Backup is a parsed entry of a storage listing
+type Backup struct {
+	Key  string
+	Name Name
+	Size int64
+}
+
+// Filter parses the keys of a storage listing, keeps the backups of the database (every
+// backup when empty) and sorts them oldest first. The keys that aren't backup names are returned
+// apart, except the manifests.
+func Filter(keys []string, database string) (backups []Backup, skipped []string) {
+	for _, key := range keys {
+		if IsManifest(key) {
+			continue
+		}
+		name, err := Parse(key)
+		if err != nil {
+			skipped = append(skipped, key)
+			continue
+		}
+		backups = append(backups, Backup{Key: key, Name: name})
+	}
+	return Select(backups, database), skipped
+}
+
+// Select keeps the backups of the database (every backup when empty) sorted oldest first.
+// The names without a database prefix, written before the codec, are only kept when the
+// storage holds no backup of another database, otherwise they could be of any database
+// and are left out of its retention and restores.
+func Select(backups []Backup, database string) []Backup {
+	var selected, unprefixed []Backup
+	shared := false
+	for _, backup := range backups {
+		switch {
+		case backup.Name.BelongsTo(database):
+			selected = append(selected, backup)
+		case backup.Name.Database == "":
+			unprefixed = append(unprefixed, backup)
+		default:
+			shared = true
+		}
+	}
+	if len(unprefixed) > 0 {
+		if shared {
+			utils.LogWarning("%d backups without a database prefix are ignored for database %s, the storage holds several databases", "BACKUP NAME", len(unprefixed), database)
+		} else {
+			selected = append(selected, unprefixed...)
+		}
+	}
+	Sort(selected)
+	return selected
+}
+
+// Sort sorts the backups oldest first, the key breaks the ties
+func Sort(backups []Backup) {
+	sort.SliceStable(backups, func(i, j int) bool {
+		if !backups[i].Name.Time.Equal(backups[j].Name.Time) {
+			return backups[i].Name.Time.Before(backups[j].Name.Time)
+		}
+		return backups[i].Key < backups[j].Key
+	})
+}
+
+// Keys returns the keys of the backups
+func Keys(backups []Backup) []string {
+	keys := make([]string, len(backups))
+	for i, backup := range backups {
+		keys[i] = backup.Key
+	}
+	return keys
+}
+
+// Newest returns the newest of the backups sorted oldest first taken before t, or at t when
+// inclusive. It returns false when every backup is newer.
+func Newest(backups []Backup, t time.Time, inclusive bool) (Backup, bool) {
+	for i := len(backups) - 1; i >= 0; i-- {
+		backupTime := backups[i].Name.Time
+		if backupTime.Before(t) || (inclusive && backupTime.Equal(t)) {
+			return backups[i], true
+		}
+	}
+	return Backup{}, false
+}
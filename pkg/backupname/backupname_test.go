@@ -0,0 +1,133 @@
+package backupname
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewAndParse(t *testing.T) {
+	backupTime := time.Date(2024, 6, 15, 3, 4, 5, 123456789, time.UTC)
+	name := New("my db/prod", backupTime, "bifrost")
+
+	value := name.String()
+	if value != "my-db-prod_2024-06-15T03-04-05.123456Z.bifrost" {
+		t.Errorf("String() = %s", value)
+	}
+	parsed, err := Parse(value)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if parsed != name {
+		t.Errorf("Parse() = %+v, want %+v", parsed, name)
+	}
+	if !parsed.BelongsTo("my db/prod") || parsed.BelongsTo("other") {
+		t.Error("BelongsTo() should match the sanitized database name only")
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		value    string
+		database string
+		time     time.Time
+		legacy   bool
+		wantErr  bool
+	}{
+		{value: "dev_2024-06-15T03-04-05.000001Z.bifrost", database: "dev", time: time.Date(2024, 6, 15, 3, 4, 5, 1000, time.UTC)},
+		{value: "my_app_db_2024-06-15T03-04-05.000000Z", database: "my_app_db", time: time.Date(2024, 6, 15, 3, 4, 5, 0, time.UTC)},
+		{value: "2024-06-15T03-04-05.000000Z.bifrost", time: time.Date(2024, 6, 15, 3, 4, 5, 0, time.UTC)},
+		// Local storages wrote the seconds on 3 digits
+		{value: "2024-06-15T03:04:005Z", time: time.Date(2024, 6, 15, 3, 4, 5, 0, time.UTC), legacy: true},
+		// S3 storages wrote RFC3339 keys
+		{value: "2024-06-15T03:04:05Z", time: time.Date(2024, 6, 15, 3, 4, 5, 0, time.UTC), legacy: true},
+		{value: "notes.txt", wantErr: true},
+		{value: "dev_2024-06-15T03-04-05.000000Z.bifrost.manifest", wantErr: true},
+		{value: "dev_2024-13-15T03-04-05.000000Z", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			name, err := Parse(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if name.Database != tt.database || !name.Time.Equal(tt.time) || name.Legacy != tt.legacy {
+				t.Errorf("Parse() = %+v", name)
+			}
+		})
+	}
+}
+
+func TestFilter(t *testing.T) {
+	keys := []string{
+		"dev_2024-01-02T00-00-00.000000Z.bifrost",
+		"2024-01-03T00:00:000Z",
+		"other_2024-01-05T00-00-00.000000Z.bifrost",
+		"2024-01-01T10:00:00Z",
+		"notes.txt",
+		"dev_2024-01-02T00-00-00.000000Z.bifrost.manifest",
+	}
+
+	// The storage holds several databases, the names without a prefix could be of any of them
+	backups, skipped := Filter(keys, "dev")
+	if got := strings.Join(Keys(backups), ","); got != "dev_2024-01-02T00-00-00.000000Z.bifrost" {
+		t.Errorf("Filter() = %s, want the prefixed backup of dev only", got)
+	}
+	if len(skipped) != 1 || skipped[0] != "notes.txt" {
+		t.Errorf("Filter() skipped %v, want notes.txt", skipped)
+	}
+	if backups, _ := Filter(keys, ""); len(backups) != 4 {
+		t.Errorf("Filter() of every database returned %d backups, want 4", len(backups))
+	}
+
+	// The storage holds a single database, the names without a prefix are its backups
+	backups, _ = Filter(keys[1:4], "other")
+	want := "2024-01-01T10:00:00Z,2024-01-03T00:00:000Z,other_2024-01-05T00-00-00.000000Z.bifrost"
+	if got := strings.Join(Keys(backups), ","); got != want {
+		t.Errorf("Filter() = %s, want %s", got, want)
+	}
+	if backups, _ := Filter([]string{keys[1], keys[3]}, "dev"); len(backups) != 2 {
+		t.Errorf("Filter() of a storage without prefixed names returned %d backups, want 2", len(backups))
+	}
+
+	// A legacy backup keeps its key, formatting its name gives the current format
+	legacy := backups[0]
+	if legacy.Key != "2024-01-01T10:00:00Z" || !legacy.Name.Legacy {
+		t.Errorf("legacy backup = %+v", legacy)
+	}
+	if formatted := legacy.Name.String(); formatted != "2024-01-01T10-00-00.000000Z" {
+		t.Errorf("legacy name String() = %s", formatted)
+	}
+}
+
+func TestNewest(t *testing.T) {
+	backups, _ := Filter([]string{
+		"dev_2024-01-01T00-00-00.000000Z.bifrost",
+		"dev_2024-01-02T00-00-00.000000Z.bifrost",
+		"dev_2024-01-03T00-00-00.000000Z.bifrost",
+	}, "dev")
+
+	tests := []struct {
+		name      string
+		time      time.Time
+		inclusive bool
+		want      string
+	}{
+		{name: "At a backup", time: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), inclusive: true, want: "dev_2024-01-02T00-00-00.000000Z.bifrost"},
+		{name: "Before a backup", time: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), want: "dev_2024-01-01T00-00-00.000000Z.bifrost"},
+		{name: "Between backups", time: time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC), want: "dev_2024-01-02T00-00-00.000000Z.bifrost"},
+		{name: "After every backup", time: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), want: "dev_2024-01-03T00-00-00.000000Z.bifrost"},
+		{name: "Before every backup", time: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backup, found := Newest(backups, tt.time, tt.inclusive)
+			if found != (tt.want != "") || backup.Key != tt.want {
+				t.Errorf("Newest() = %s, %v, want %s", backup.Key, found, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,250 @@
+package compression
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"runtime"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"github.com/ulikunitz/xz"
+)
+
+type Algorithm string
+
+const (
+	None Algorithm = "none"
+	Zstd Algorithm = "zstd"
+	Gzip Algorithm = "gzip"
+	Xz   Algorithm = "xz"
+	Lz4  Algorithm = "lz4"
+
+	// Magic starts every backup compressed by Compress, followed by the format version and the algorithm
+	Magic = "BFRSTCMP"
+	// FormatVersion is the version of the compression header written by Compress
+	FormatVersion byte = 1
+)
+
+// zstdMagic starts the backups compressed by the previous versions, always zstd without header
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// xzDictCaps maps the xz presets 0 to 9 to their dictionary size
+var xzDictCaps = []int{256 << 10, 1 << 20, 2 << 20, 4 << 20, 4 << 20, 8 << 20, 8 << 20, 16 << 20, 32 << 20, 64 << 20}
+
+var lz4Levels = []lz4.CompressionLevel{lz4.Level1, lz4.Level2, lz4.Level3, lz4.Level4, lz4.Level5, lz4.Level6, lz4.Level7, lz4.Level8, lz4.Level9}
+
+// Options describes how the backups of a storage are compressed, a zero Level
+// uses the default level of the algorithm and zero Threads one per CPU
+type Options struct {
+	Algorithm Algorithm
+	Level     int
+	Threads   int
+}
+
+// ParseAlgorithm returns the algorithm matching the name, an empty name is zstd
+func ParseAlgorithm(name string) (Algorithm, error) {
+	switch algorithm := Algorithm(name); algorithm {
+	case "":
+		return Zstd, nil
+	case None, Zstd, Gzip, Xz, Lz4:
+		return algorithm, nil
+	case "lzma":
+		return Xz, nil
+	default:
+		return "", fmt.Errorf("unsupported compression algorithm %q, expected none, zstd, gzip, xz or lz4", name)
+	}
+}
+
+// Validate checks the level and the threads are supported by the algorithm
+func (options Options) Validate() error {
+	if _, err := ParseAlgorithm(string(options.Algorithm)); err != nil {
+		return err
+	}
+	if options.Threads < 0 {
+		return fmt.Errorf("compression threads can't be negative")
+	}
+
+	var minLevel, maxLevel int
+	switch options.Algorithm {
+	case None:
+		if options.Level != 0 {
+			return fmt.Errorf("no level can be set without compression")
+		}
+		return nil
+	case Zstd, "":
+		minLevel, maxLevel = 1, 22
+	case Gzip, Lz4:
+		minLevel, maxLevel = 1, 9
+	case Xz:
+		minLevel, maxLevel = 0, 9
+	}
+	if options.Level != 0 && (options.Level < minLevel || options.Level > maxLevel) {
+		return fmt.Errorf("%s compression level must be between %d and %d", options.Algorithm, minLevel, maxLevel)
+	}
+	return nil
+}
+
+func (options Options) threads() int {
+	if options.Threads > 0 {
+		return options.Threads
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+// Compress compresses the backup and prefixes it with the header recording the algorithm,
+// backups stored without compression are returned as is
+func Compress(data []byte, options Options) ([]byte, error) {
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+	algorithm, _ := ParseAlgorithm(string(options.Algorithm))
+	if algorithm == None {
+		return data, nil
+	}
+
+	buf := new(bytes.Buffer)
+	buf.WriteString(Magic)
+	buf.WriteByte(FormatVersion)
+	buf.WriteByte(byte(len(algorithm)))
+	buf.WriteString(string(algorithm))
+
+	writer, err := newWriter(buf, algorithm, options)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := writer.Write(data); err != nil {
+		_ = writer.Close()
+		return nil, fmt.Errorf("%s compression failed: %w", algorithm, err)
+	}
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("%s compression failed: %w", algorithm, err)
+	}
+	return buf.Bytes(), nil
+}
+
+func newWriter(w io.Writer, algorithm Algorithm, options Options) (io.WriteCloser, error) {
+	switch algorithm {
+	case Zstd:
+		// The streaming encoder spreads large dumps over the threads, EncodeAll uses only one
+		encoderOptions := []zstd.EOption{zstd.WithEncoderConcurrency(options.threads())}
+		if options.Level != 0 {
+			encoderOptions = append(encoderOptions, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(options.Level)))
+		}
+		return zstd.NewWriter(w, encoderOptions...)
+	case Gzip:
+		level := gzip.DefaultCompression
+		if options.Level != 0 {
+			level = options.Level
+		}
+		return gzip.NewWriterLevel(w, level)
+	case Xz:
+		config := xz.WriterConfig{}
+		if options.Level != 0 {
+			config.DictCap = xzDictCaps[options.Level]
+		}
+		return config.NewWriter(w)
+	case Lz4:
+		writer := lz4.NewWriter(w)
+		writerOptions := []lz4.Option{lz4.ConcurrencyOption(options.threads())}
+		if options.Level != 0 {
+			writerOptions = append(writerOptions, lz4.CompressionLevelOption(lz4Levels[options.Level-1]))
+		}
+		if err := writer.Apply(writerOptions...); err != nil {
+			return nil, err
+		}
+		return writer, nil
+	default:
+		return nil, fmt.Errorf("unsupported compression algorithm %q", algorithm)
+	}
+}
+
+// Detect returns the algorithm of a compressed backup, from its header or the zstd
+// magic of the previous versions, None when the backup isn't compressed
+func Detect(data []byte) (Algorithm, error) {
+	if bytes.HasPrefix(data, zstdMagic) {
+		return Zstd, nil
+	}
+	if !bytes.HasPrefix(data, []byte(Magic)) {
+		return None, nil
+	}
+	algorithm, _, err := parseHeader(data)
+	return algorithm, err
+}
+
+func parseHeader(data []byte) (Algorithm, int, error) {
+	size := len(Magic) + 2
+	if len(data) < size {
+		return "", 0, fmt.Errorf("the compression header is truncated")
+	}
+	if version := data[len(Magic)]; version != FormatVersion {
+		return "", 0, fmt.Errorf("unsupported compression format version %d", version)
+	}
+	size += int(data[len(Magic)+1])
+	if len(data) < size {
+		return "", 0, fmt.Errorf("the compression header is truncated")
+	}
+	algorithm, err := ParseAlgorithm(string(data[len(Magic)+2 : size]))
+	if err != nil {
+		return "", 0, err
+	} else if algorithm == None {
+		return "", 0, fmt.Errorf("invalid compression header")
+	}
+	return algorithm, size, nil
+}
+
+// NewReader detects the compression of the backup and returns a reader of its content,
+// backups which aren't compressed are read as is
+func NewReader(r io.Reader) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(r)
+	prefix, err := buffered.Peek(len(Magic) + 2 + 255)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, err
+	}
+
+	var algorithm Algorithm
+	if bytes.HasPrefix(prefix, zstdMagic) {
+		algorithm = Zstd
+	} else if bytes.HasPrefix(prefix, []byte(Magic)) {
+		var size int
+		if algorithm, size, err = parseHeader(prefix); err != nil {
+			return nil, err
+		}
+		if _, err := buffered.Discard(size); err != nil {
+			return nil, err
+		}
+	} else {
+		return io.NopCloser(buffered), nil
+	}
+
+	switch algorithm {
+	case Zstd:
+		decoder, err := zstd.NewReader(buffered)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	case Gzip:
+		return gzip.NewReader(buffered)
+	case Xz:
+		decoder, err := xz.NewReader(buffered)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(decoder), nil
+	default:
+		return io.NopCloser(lz4.NewReader(buffered)), nil
+	}
+}
+
+// Decompress returns the content of the backup, whatever its compression
+func Decompress(data []byte) ([]byte, error) {
+	reader, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
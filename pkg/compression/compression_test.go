@@ -0,0 +1,100 @@
+package compression
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestCompressRoundTrip(t *testing.T) {
+	data := []byte(strings.Repeat("INSERT INTO backups VALUES (1, 'bifrost');\n", 2000))
+
+	tests := []Options{
+		{Algorithm: None},
+		{Algorithm: Zstd},
+		{Algorithm: Zstd, Level: 19, Threads: 4},
+		{Algorithm: Gzip, Level: 9},
+		{Algorithm: Xz},
+		{Algorithm: Xz, Level: 1},
+		{Algorithm: Lz4},
+		{Algorithm: Lz4, Level: 9, Threads: 2},
+	}
+	for _, options := range tests {
+		compressed, err := Compress(data, options)
+		if err != nil {
+			t.Fatalf("Compress(%+v) error = %v", options, err)
+		}
+		algorithm, err := Detect(compressed)
+		if err != nil || algorithm != options.Algorithm {
+			t.Errorf("Detect(%+v) = %s, %v", options, algorithm, err)
+		}
+		if options.Algorithm != None && len(compressed) >= len(data) {
+			t.Errorf("Compress(%+v) didn't reduce the size", options)
+		}
+
+		decompressed, err := Decompress(compressed)
+		if err != nil {
+			t.Fatalf("Decompress(%+v) error = %v", options, err)
+		}
+		if !bytes.Equal(decompressed, data) {
+			t.Errorf("Decompress(%+v) doesn't match the data", options)
+		}
+	}
+}
+
+func TestDecompressPreviousVersions(t *testing.T) {
+	data := []byte("backup compressed by a previous version")
+	encoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := encoder.EncodeAll(data, nil)
+	_ = encoder.Close()
+
+	reader, err := NewReader(bytes.NewReader(legacy))
+	if err != nil {
+		t.Fatalf("NewReader() error = %v", err)
+	}
+	decompressed, err := io.ReadAll(reader)
+	if err != nil || !bytes.Equal(decompressed, data) {
+		t.Errorf("NewReader() = %q, %v", decompressed, err)
+	}
+}
+
+func TestOptionsValidate(t *testing.T) {
+	invalid := []Options{
+		{Algorithm: "brotli"},
+		{Algorithm: Zstd, Level: 23},
+		{Algorithm: Gzip, Level: 10},
+		{Algorithm: Lz4, Level: -1},
+		{Algorithm: None, Level: 3},
+		{Algorithm: Zstd, Threads: -1},
+	}
+	for _, options := range invalid {
+		if err := options.Validate(); err == nil {
+			t.Errorf("Validate(%+v) should fail", options)
+		}
+	}
+	if _, err := Compress([]byte("data"), Options{Algorithm: Xz, Level: 12}); err == nil {
+		t.Error("Compress() with an invalid level should fail")
+	}
+	if algorithm, err := ParseAlgorithm("lzma"); err != nil || algorithm != Xz {
+		t.Errorf("ParseAlgorithm(lzma) = %s, %v", algorithm, err)
+	}
+}
+
+func TestDetectInvalidHeader(t *testing.T) {
+	if _, err := Decompress([]byte(Magic + "\x02\x04zstd")); err == nil {
+		t.Error("Decompress() of an unknown format version should fail")
+	}
+	if _, err := Decompress([]byte(Magic + "\x01\x06brotli")); err == nil {
+		t.Error("Decompress() of an unknown algorithm should fail")
+	}
+	plain := []byte("not compressed")
+	if decompressed, err := Decompress(plain); err != nil || !bytes.Equal(decompressed, plain) {
+		t.Errorf("Decompress() of an uncompressed backup = %q, %v", decompressed, err)
+	}
+}
@@ -2,28 +2,25 @@ package crypto
 
 import (
 	"bytes"
-	"crypto/aes"
-	"crypto/cipher"
-	"crypto/rand"
-	"io"
 )
 
+// Cipher encrypts the plaintext in the streaming format, the header records the key id
 func Cipher(key []byte, plaintext []byte) (*bytes.Buffer, error) {
-	block, err := aes.NewCipher(key)
+	return CipherWithHeader(key, plaintext, Header{KeyID: KeyID(key)})
+}
+
+// CipherWithHeader encrypts the plaintext in the streaming format with the given header options
+func CipherWithHeader(key []byte, plaintext []byte, header Header) (*bytes.Buffer, error) {
+	cipher_text := new(bytes.Buffer)
+	writer, err := NewWriter(cipher_text, key, header)
 	if err != nil {
 		return nil, err
 	}
-
-	aesGCM, err := cipher.NewGCM(block)
-	if err != nil {
+	if _, err := writer.Write(plaintext); err != nil {
 		return nil, err
 	}
-
-	nonce := make([]byte, aesGCM.NonceSize())
-	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+	if err := writer.Close(); err != nil {
 		return nil, err
 	}
-
-	cipher_text := aesGCM.Seal(nonce, nonce, plaintext, nil)
-	return bytes.NewBuffer(cipher_text), nil
+	return cipher_text, nil
 }
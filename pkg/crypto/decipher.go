@@ -5,9 +5,28 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"fmt"
+	"io"
 )
 
+// Decipher decrypts a backup in the streaming format or in the legacy single-shot format
 func Decipher(key []byte, cipher_text []byte) (*bytes.Buffer, error) {
+	if !IsStreamFormat(cipher_text) {
+		return decipherLegacy(key, cipher_text)
+	}
+
+	reader, err := NewReader(bytes.NewReader(cipher_text), key)
+	if err != nil {
+		return nil, err
+	}
+	plaintext := new(bytes.Buffer)
+	if _, err := io.Copy(plaintext, reader); err != nil {
+		return nil, err
+	}
+	return plaintext, nil
+}
+
+// decipherLegacy decrypts the backups sealed in a single AES-GCM call, nonce followed by the cipher text
+func decipherLegacy(key []byte, cipher_text []byte) (*bytes.Buffer, error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,54 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// KeyID returns the identifier of a backup key recorded in the backup header,
+// it's derived from the key so it never has to be stored next to it
+func KeyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+// BackupKeyID returns the key id recorded in the backup header, empty for
+// legacy backups and backups encrypted for recipients
+func BackupKeyID(cipher_text []byte) string {
+	if !IsStreamFormat(cipher_text) {
+		return ""
+	}
+	header, _, err := ReadHeader(bytes.NewReader(cipher_text))
+	if err != nil || len(header.Recipients) > 0 {
+		return ""
+	}
+	return header.KeyID
+}
+
+// DecipherWithKeys decrypts a backup with the key of the keyring matching its
+// header key id, backups without key id are tried against every key
+func DecipherWithKeys(keys [][]byte, cipher_text []byte) (*bytes.Buffer, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("at least one key is required")
+	}
+
+	if id := BackupKeyID(cipher_text); id != "" {
+		for _, key := range keys {
+			if KeyID(key) == id {
+				return Decipher(key, cipher_text)
+			}
+		}
+		return nil, fmt.Errorf("the backup key %s is not in the keyring", id)
+	}
+
+	var err error
+	for _, key := range keys {
+		var plaintext *bytes.Buffer
+		if plaintext, err = Decipher(key, cipher_text); err == nil {
+			return plaintext, nil
+		}
+	}
+	return nil, err
+}
@@ -0,0 +1,52 @@
+package crypto
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDecipherWithKeys(t *testing.T) {
+	oldKey := newTestKey(t)
+	newKey := newTestKey(t)
+	otherKey := newTestKey(t)
+	plaintext := []byte("rotated backup")
+
+	encrypted, err := Cipher(oldKey, plaintext)
+	if err != nil {
+		t.Fatalf("Cipher() error = %v", err)
+	}
+	if got := BackupKeyID(encrypted.Bytes()); got != KeyID(oldKey) {
+		t.Errorf("BackupKeyID() = %s, want %s", got, KeyID(oldKey))
+	}
+
+	decrypted, err := DecipherWithKeys([][]byte{newKey, oldKey}, encrypted.Bytes())
+	if err != nil {
+		t.Fatalf("DecipherWithKeys() error = %v", err)
+	}
+	if !bytes.Equal(decrypted.Bytes(), plaintext) {
+		t.Error("DecipherWithKeys() plaintext mismatch")
+	}
+
+	if _, err := DecipherWithKeys([][]byte{newKey, otherKey}, encrypted.Bytes()); err == nil {
+		t.Error("DecipherWithKeys() without the backup key should fail")
+	}
+}
+
+func TestDecipherWithKeysLegacy(t *testing.T) {
+	oldKey := newTestKey(t)
+	newKey := newTestKey(t)
+	plaintext := []byte("legacy backup")
+
+	legacy := sealLegacy(t, oldKey, plaintext)
+	if got := BackupKeyID(legacy); got != "" {
+		t.Errorf("BackupKeyID() of a legacy backup = %s, want empty", got)
+	}
+
+	decrypted, err := DecipherWithKeys([][]byte{newKey, oldKey}, legacy)
+	if err != nil {
+		t.Fatalf("DecipherWithKeys() error = %v", err)
+	}
+	if !bytes.Equal(decrypted.Bytes(), plaintext) {
+		t.Error("DecipherWithKeys() plaintext mismatch")
+	}
+}
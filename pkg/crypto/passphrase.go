@@ -0,0 +1,127 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	KDFArgon2id = "argon2id"
+
+	// Defaults follow the second recommended option of RFC 9106, 64 MiB of memory
+	defaultArgon2Time    = 3
+	defaultArgon2Memory  = 64 * 1024
+	defaultArgon2Threads = 4
+	argon2SaltSize       = 16
+	argon2KeySize        = 32
+
+	// Upper bounds accepted from a backup header, a crafted header can't exhaust the host,
+	// 1 GiB of memory is 16 times the default
+	maxArgon2Time   = 64
+	maxArgon2Memory = 1024 * 1024
+)
+
+// KDFParams describe how the backup key has been derived from a passphrase,
+// they're stored in the header so the passphrase alone restores the backup
+type KDFParams struct {
+	Algorithm string `json:"alg"`
+	Salt      []byte `json:"salt"`
+	Time      uint32 `json:"t"`
+	Memory    uint32 `json:"m"`
+	Threads   uint8  `json:"p"`
+}
+
+// NewKDFParams returns Argon2id parameters with a fresh random salt
+func NewKDFParams() (KDFParams, error) {
+	salt := make([]byte, argon2SaltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return KDFParams{}, err
+	}
+	return KDFParams{
+		Algorithm: KDFArgon2id,
+		Salt:      salt,
+		Time:      defaultArgon2Time,
+		Memory:    defaultArgon2Memory,
+		Threads:   defaultArgon2Threads,
+	}, nil
+}
+
+func (params KDFParams) validate() error {
+	if params.Algorithm != KDFArgon2id {
+		return fmt.Errorf("unsupported key derivation %s", params.Algorithm)
+	}
+	if len(params.Salt) < argon2SaltSize {
+		return fmt.Errorf("the key derivation salt is too short")
+	}
+	if params.Time == 0 || params.Time > maxArgon2Time {
+		return fmt.Errorf("invalid key derivation time %d", params.Time)
+	}
+	if params.Memory < 8*uint32(params.Threads) || params.Memory > maxArgon2Memory {
+		return fmt.Errorf("invalid key derivation memory %d", params.Memory)
+	}
+	if params.Threads == 0 {
+		return fmt.Errorf("invalid key derivation threads %d", params.Threads)
+	}
+	return nil
+}
+
+// DerivePassphraseKey derives the backup key from the passphrase with Argon2id
+func DerivePassphraseKey(passphrase string, params KDFParams) ([]byte, error) {
+	if passphrase == "" {
+		return nil, fmt.Errorf("passphrase can't be empty")
+	}
+	if err := params.validate(); err != nil {
+		return nil, err
+	}
+	return argon2.IDKey([]byte(passphrase), params.Salt, params.Time, params.Memory, params.Threads, argon2KeySize), nil
+}
+
+// CipherWithPassphrase encrypts the plaintext with a key derived from the
+// passphrase and a salt unique to this backup
+func CipherWithPassphrase(passphrase string, plaintext []byte) (*bytes.Buffer, error) {
+	params, err := NewKDFParams()
+	if err != nil {
+		return nil, err
+	}
+	key, err := DerivePassphraseKey(passphrase, params)
+	if err != nil {
+		return nil, err
+	}
+	return CipherWithHeader(key, plaintext, Header{KDF: &params})
+}
+
+// DecipherWithPassphrase decrypts a backup written by CipherWithPassphrase
+func DecipherWithPassphrase(passphrase string, cipher_text []byte) (*bytes.Buffer, error) {
+	if !IsStreamFormat(cipher_text) {
+		return nil, fmt.Errorf("the backup is not protected by a passphrase")
+	}
+	header, _, err := ReadHeader(bytes.NewReader(cipher_text))
+	if err != nil {
+		return nil, err
+	}
+	if header.KDF == nil {
+		return nil, fmt.Errorf("the backup is not protected by a passphrase")
+	}
+	key, err := DerivePassphraseKey(passphrase, *header.KDF)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := Decipher(key, cipher_text)
+	if err != nil {
+		return nil, fmt.Errorf("wrong passphrase or corrupted backup: %w", err)
+	}
+	return plaintext, nil
+}
+
+// HasPassphrase reports whether the backup key is derived from a passphrase
+func HasPassphrase(cipher_text []byte) bool {
+	if !IsStreamFormat(cipher_text) {
+		return false
+	}
+	header, _, err := ReadHeader(bytes.NewReader(cipher_text))
+	return err == nil && header.KDF != nil
+}
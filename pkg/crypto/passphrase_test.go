@@ -0,0 +1,97 @@
+package crypto
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestPassphraseRoundTrip(t *testing.T) {
+	plaintext := []byte("restored with a memorised passphrase")
+
+	first, err := CipherWithPassphrase("correct horse battery staple", plaintext)
+	if err != nil {
+		t.Fatalf("CipherWithPassphrase() error = %v", err)
+	}
+	second, err := CipherWithPassphrase("correct horse battery staple", plaintext)
+	if err != nil {
+		t.Fatalf("CipherWithPassphrase() error = %v", err)
+	}
+	if !HasPassphrase(first.Bytes()) {
+		t.Fatal("HasPassphrase() = false, want true")
+	}
+
+	firstHeader, _, err := ReadHeader(bytes.NewReader(first.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	secondHeader, _, err := ReadHeader(bytes.NewReader(second.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(firstHeader.KDF.Salt, secondHeader.KDF.Salt) {
+		t.Error("CipherWithPassphrase() reused the salt across backups")
+	}
+
+	decrypted, err := DecipherWithPassphrase("correct horse battery staple", first.Bytes())
+	if err != nil {
+		t.Fatalf("DecipherWithPassphrase() error = %v", err)
+	}
+	if !bytes.Equal(decrypted.Bytes(), plaintext) {
+		t.Error("DecipherWithPassphrase() plaintext mismatch")
+	}
+
+	if _, err := DecipherWithPassphrase("wrong passphrase", first.Bytes()); err == nil {
+		t.Error("DecipherWithPassphrase() with a wrong passphrase should fail")
+	}
+}
+
+func TestDerivePassphraseKeyRejectsUnsafeParams(t *testing.T) {
+	params, err := NewKDFParams()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		mutate func(*KDFParams)
+	}{
+		{"unknown algorithm", func(p *KDFParams) { p.Algorithm = "scrypt" }},
+		{"short salt", func(p *KDFParams) { p.Salt = p.Salt[:4] }},
+		{"zero time", func(p *KDFParams) { p.Time = 0 }},
+		{"huge memory", func(p *KDFParams) { p.Memory = maxArgon2Memory + 1 }},
+		{"zero threads", func(p *KDFParams) { p.Threads = 0 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mutated := params
+			tt.mutate(&mutated)
+			if _, err := DerivePassphraseKey("passphrase", mutated); err == nil {
+				t.Error("DerivePassphraseKey() should fail")
+			}
+		})
+	}
+
+	if _, err := DerivePassphraseKey("", params); err == nil {
+		t.Error("DerivePassphraseKey() with an empty passphrase should fail")
+	}
+}
+
+func TestDecipherWithPassphraseRejectsCraftedHeader(t *testing.T) {
+	params, err := NewKDFParams()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 2 GiB, a header asking for more memory than the cap is refused before deriving the key
+	params.Memory = 2 * 1024 * 1024
+	key := bytes.Repeat([]byte{1}, argon2KeySize)
+	cipher_text, err := CipherWithHeader(key, []byte("backup"), Header{KDF: &params})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = DecipherWithPassphrase("passphrase", cipher_text.Bytes())
+	if err == nil || !strings.Contains(err.Error(), "memory") {
+		t.Errorf("DecipherWithPassphrase() error = %v, want the memory to be refused", err)
+	}
+}
@@ -0,0 +1,267 @@
+package crypto
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// RecipientPrefix starts the public keys backups can be encrypted to
+	RecipientPrefix = "bifrost-x25519:"
+	// IdentityPrefix starts the private keys able to decrypt the backups of their recipient
+	IdentityPrefix = "BIFROST-X25519-SECRET:"
+
+	RecipientTypeX25519 = "X25519"
+
+	fileKeySize = 32
+)
+
+// Recipient is a file key wrapped for one X25519 public key
+type Recipient struct {
+	Type       string `json:"type"`
+	Ephemeral  []byte `json:"epk"`
+	WrappedKey []byte `json:"key"`
+}
+
+// GenerateIdentity returns a new X25519 identity and its public recipient
+func GenerateIdentity() (string, string, error) {
+	private := make([]byte, curve25519.ScalarSize)
+	if _, err := io.ReadFull(rand.Reader, private); err != nil {
+		return "", "", err
+	}
+	public, err := curve25519.X25519(private, curve25519.Basepoint)
+	if err != nil {
+		return "", "", err
+	}
+	return IdentityPrefix + base64.RawURLEncoding.EncodeToString(private),
+		RecipientPrefix + base64.RawURLEncoding.EncodeToString(public), nil
+}
+
+// ParseRecipient decodes a public recipient
+func ParseRecipient(recipient string) ([]byte, error) {
+	recipient = strings.TrimSpace(recipient)
+	if !strings.HasPrefix(recipient, RecipientPrefix) {
+		return nil, fmt.Errorf("invalid recipient, expected the %s prefix", RecipientPrefix)
+	}
+	public, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(recipient, RecipientPrefix))
+	if err != nil || len(public) != curve25519.PointSize {
+		return nil, fmt.Errorf("invalid recipient public key")
+	}
+	return public, nil
+}
+
+// ParseIdentity decodes a private identity
+func ParseIdentity(identity string) ([]byte, error) {
+	identity = strings.TrimSpace(identity)
+	if !strings.HasPrefix(identity, IdentityPrefix) {
+		return nil, fmt.Errorf("invalid identity, expected the %s prefix", IdentityPrefix)
+	}
+	private, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(identity, IdentityPrefix))
+	if err != nil || len(private) != curve25519.ScalarSize {
+		return nil, fmt.Errorf("invalid identity private key")
+	}
+	return private, nil
+}
+
+// ParseIdentities reads an identity file, one identity per line, blank lines and # comments are ignored
+func ParseIdentities(data []byte) ([][]byte, error) {
+	var identities [][]byte
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		identity, err := ParseIdentity(line)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, identity)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(identities) == 0 {
+		return nil, fmt.Errorf("no identity found")
+	}
+	return identities, nil
+}
+
+// IdentityToRecipient returns the public recipient of an identity
+func IdentityToRecipient(identity string) (string, error) {
+	private, err := ParseIdentity(identity)
+	if err != nil {
+		return "", err
+	}
+	public, err := curve25519.X25519(private, curve25519.Basepoint)
+	if err != nil {
+		return "", err
+	}
+	return RecipientPrefix + base64.RawURLEncoding.EncodeToString(public), nil
+}
+
+func wrapKey(shared []byte, ephemeral []byte, public []byte) ([]byte, error) {
+	salt := append(append([]byte{}, ephemeral...), public...)
+	key := make([]byte, chacha20poly1305.KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared, salt, []byte("bifrost-backups x25519 v1")), key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// wrapFileKey encrypts the file key for the recipient with an ephemeral key exchange
+func wrapFileKey(fileKey []byte, public []byte) (Recipient, error) {
+	ephemeralPrivate := make([]byte, curve25519.ScalarSize)
+	if _, err := io.ReadFull(rand.Reader, ephemeralPrivate); err != nil {
+		return Recipient{}, err
+	}
+	ephemeral, err := curve25519.X25519(ephemeralPrivate, curve25519.Basepoint)
+	if err != nil {
+		return Recipient{}, err
+	}
+	shared, err := curve25519.X25519(ephemeralPrivate, public)
+	if err != nil {
+		return Recipient{}, err
+	}
+
+	key, err := wrapKey(shared, ephemeral, public)
+	if err != nil {
+		return Recipient{}, err
+	}
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return Recipient{}, err
+	}
+	// The wrapping key is single use, a zero nonce is safe
+	wrapped := aead.Seal(nil, make([]byte, aead.NonceSize()), fileKey, nil)
+
+	return Recipient{Type: RecipientTypeX25519, Ephemeral: ephemeral, WrappedKey: wrapped}, nil
+}
+
+// unwrapFileKey returns the file key when the recipient has been wrapped for the identity
+func unwrapFileKey(recipient Recipient, private []byte) ([]byte, error) {
+	if recipient.Type != RecipientTypeX25519 {
+		return nil, fmt.Errorf("unsupported recipient type %s", recipient.Type)
+	}
+	public, err := curve25519.X25519(private, curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := curve25519.X25519(private, recipient.Ephemeral)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := wrapKey(shared, recipient.Ephemeral, public)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Open(nil, make([]byte, aead.NonceSize()), recipient.WrappedKey, nil)
+}
+
+// NewRecipientWriter returns a writer encrypting into w with a random file key
+// wrapped for every recipient, any of their identities can decrypt the backup
+func NewRecipientWriter(w io.Writer, recipients []string, header Header) (io.WriteCloser, error) {
+	if len(recipients) == 0 {
+		return nil, fmt.Errorf("at least one recipient is required")
+	}
+
+	fileKey := make([]byte, fileKeySize)
+	if _, err := io.ReadFull(rand.Reader, fileKey); err != nil {
+		return nil, err
+	}
+
+	header.Recipients = nil
+	for _, recipient := range recipients {
+		public, err := ParseRecipient(recipient)
+		if err != nil {
+			return nil, err
+		}
+		wrapped, err := wrapFileKey(fileKey, public)
+		if err != nil {
+			return nil, err
+		}
+		header.Recipients = append(header.Recipients, wrapped)
+	}
+
+	return NewWriter(w, fileKey, header)
+}
+
+// NewIdentityReader returns a reader decrypting a backup written by NewRecipientWriter
+func NewIdentityReader(r io.Reader, identities [][]byte) (io.Reader, error) {
+	header, raw, err := ReadHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	fileKey, err := findFileKey(header, identities)
+	if err != nil {
+		return nil, err
+	}
+	return openStream(r, header, raw, fileKey)
+}
+
+func findFileKey(header Header, identities [][]byte) ([]byte, error) {
+	if len(header.Recipients) == 0 {
+		return nil, fmt.Errorf("the backup is not encrypted for recipients")
+	}
+	for _, recipient := range header.Recipients {
+		for _, identity := range identities {
+			if fileKey, err := unwrapFileKey(recipient, identity); err == nil {
+				return fileKey, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("none of the identities can decrypt the backup")
+}
+
+// CipherForRecipients encrypts the plaintext for the recipients
+func CipherForRecipients(recipients []string, plaintext []byte) (*bytes.Buffer, error) {
+	cipher_text := new(bytes.Buffer)
+	writer, err := NewRecipientWriter(cipher_text, recipients, Header{})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := writer.Write(plaintext); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return cipher_text, nil
+}
+
+// DecipherWithIdentities decrypts a backup encrypted for recipients
+func DecipherWithIdentities(identities [][]byte, cipher_text []byte) (*bytes.Buffer, error) {
+	reader, err := NewIdentityReader(bytes.NewReader(cipher_text), identities)
+	if err != nil {
+		return nil, err
+	}
+	plaintext := new(bytes.Buffer)
+	if _, err := io.Copy(plaintext, reader); err != nil {
+		return nil, err
+	}
+	return plaintext, nil
+}
+
+// HasRecipients reports whether the backup has been encrypted for recipients
+func HasRecipients(cipher_text []byte) bool {
+	if !IsStreamFormat(cipher_text) {
+		return false
+	}
+	header, _, err := ReadHeader(bytes.NewReader(cipher_text))
+	return err == nil && len(header.Recipients) > 0
+}
@@ -0,0 +1,132 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+)
+
+// Shamir secret sharing over GF(2^8), every byte of the secret is the constant
+// term of its own random polynomial. A share holds one evaluation per secret
+// byte followed by its x coordinate.
+
+var gfExp [510]byte
+var gfLog [256]byte
+
+func init() {
+	x := byte(1)
+	for i := 0; i < 255; i++ {
+		gfExp[i] = x
+		gfLog[x] = byte(i)
+		x = gfMulNoTable(x, 3)
+	}
+	for i := 255; i < len(gfExp); i++ {
+		gfExp[i] = gfExp[i-255]
+	}
+}
+
+// gfMulNoTable multiplies in GF(2^8) with the AES polynomial, only used to build the tables
+func gfMulNoTable(a, b byte) byte {
+	var product byte
+	for b > 0 {
+		if b&1 == 1 {
+			product ^= a
+		}
+		carry := a & 0x80
+		a <<= 1
+		if carry != 0 {
+			a ^= 0x1b
+		}
+		b >>= 1
+	}
+	return product
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+func gfDiv(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+255-int(gfLog[b])]
+}
+
+// SplitSecret splits the secret into shares, any threshold of them rebuild it
+func SplitSecret(secret []byte, shares int, threshold int) ([][]byte, error) {
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("secret can't be empty")
+	}
+	if threshold < 2 || threshold > shares || shares > 255 {
+		return nil, fmt.Errorf("invalid sharing %d-of-%d, expected 2 <= threshold <= shares <= 255", threshold, shares)
+	}
+
+	result := make([][]byte, shares)
+	for i := range result {
+		result[i] = make([]byte, len(secret)+1)
+		result[i][len(secret)] = byte(i + 1)
+	}
+
+	coefficients := make([]byte, threshold)
+	for position, value := range secret {
+		coefficients[0] = value
+		if _, err := io.ReadFull(rand.Reader, coefficients[1:]); err != nil {
+			return nil, err
+		}
+		for i := range result {
+			x := byte(i + 1)
+			// Horner evaluation of the polynomial at x
+			var y byte
+			for degree := threshold - 1; degree >= 0; degree-- {
+				y = gfMul(y, x) ^ coefficients[degree]
+			}
+			result[i][position] = y
+		}
+	}
+	return result, nil
+}
+
+// CombineShares rebuilds the secret with Lagrange interpolation at zero, the
+// caller must provide at least the threshold, fewer shares give a wrong secret
+func CombineShares(shares [][]byte) ([]byte, error) {
+	if len(shares) < 2 {
+		return nil, fmt.Errorf("at least two shares are required")
+	}
+	size := len(shares[0])
+	if size < 2 {
+		return nil, fmt.Errorf("invalid share length")
+	}
+	seen := make(map[byte]bool, len(shares))
+	for _, share := range shares {
+		if len(share) != size {
+			return nil, fmt.Errorf("the shares don't have the same length")
+		}
+		x := share[size-1]
+		if x == 0 || seen[x] {
+			return nil, fmt.Errorf("invalid or duplicated share %d", x)
+		}
+		seen[x] = true
+	}
+
+	secret := make([]byte, size-1)
+	for i, share := range shares {
+		xi := share[size-1]
+		// Lagrange basis of the share evaluated at zero
+		basis := byte(1)
+		for j, other := range shares {
+			if i == j {
+				continue
+			}
+			xj := other[size-1]
+			basis = gfMul(basis, gfDiv(xj, xj^xi))
+		}
+		for position := range secret {
+			secret[position] ^= gfMul(share[position], basis)
+		}
+	}
+	return secret, nil
+}
@@ -0,0 +1,75 @@
+package crypto
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSplitAndCombineShares(t *testing.T) {
+	secret := newTestKey(t)
+
+	shares, err := SplitSecret(secret, 5, 3)
+	if err != nil {
+		t.Fatalf("SplitSecret() error = %v", err)
+	}
+	if len(shares) != 5 {
+		t.Fatalf("SplitSecret() returned %d shares, want 5", len(shares))
+	}
+
+	subsets := [][]int{{0, 1, 2}, {4, 2, 0}, {1, 3, 4}, {0, 1, 2, 3, 4}}
+	for _, subset := range subsets {
+		var selected [][]byte
+		for _, index := range subset {
+			selected = append(selected, shares[index])
+		}
+		combined, err := CombineShares(selected)
+		if err != nil {
+			t.Fatalf("CombineShares(%v) error = %v", subset, err)
+		}
+		if !bytes.Equal(combined, secret) {
+			t.Errorf("CombineShares(%v) didn't rebuild the secret", subset)
+		}
+	}
+
+	combined, err := CombineShares([][]byte{shares[0], shares[1]})
+	if err != nil {
+		t.Fatalf("CombineShares() error = %v", err)
+	}
+	if bytes.Equal(combined, secret) {
+		t.Error("CombineShares() rebuilt the secret below the threshold")
+	}
+}
+
+func TestSplitSecretInvalid(t *testing.T) {
+	tests := []struct {
+		name      string
+		secret    []byte
+		shares    int
+		threshold int
+	}{
+		{"empty secret", nil, 3, 2},
+		{"threshold of one", []byte("key"), 3, 1},
+		{"threshold above shares", []byte("key"), 2, 3},
+		{"too many shares", []byte("key"), 256, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := SplitSecret(tt.secret, tt.shares, tt.threshold); err == nil {
+				t.Error("SplitSecret() should fail")
+			}
+		})
+	}
+}
+
+func TestCombineSharesInvalid(t *testing.T) {
+	shares, err := SplitSecret([]byte("key"), 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CombineShares([][]byte{shares[0], shares[0]}); err == nil {
+		t.Error("CombineShares() with duplicated shares should fail")
+	}
+	if _, err := CombineShares([][]byte{shares[0], shares[1][1:]}); err == nil {
+		t.Error("CombineShares() with shares of different length should fail")
+	}
+}
@@ -0,0 +1,300 @@
+package crypto
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// Magic starts every backup written in the streaming format
+	Magic = "BFRSTENC"
+	// FormatVersion is the version of the streaming format written by NewWriter
+	FormatVersion byte = 1
+
+	AlgorithmAESGCMStream = "AES-256-GCM-STREAM"
+
+	DefaultChunkSize = 64 * 1024
+	maxChunkSize     = 16 * 1024 * 1024
+	maxHeaderSize    = 64 * 1024
+	saltSize         = 32
+	lastChunkFlag    = 1
+)
+
+// Header describes how a backup stream has been encrypted, it is stored in clear
+// at the beginning of the backup and authenticated with every chunk
+type Header struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid,omitempty"`
+	ChunkSize int    `json:"chunk_size"`
+	Salt      []byte `json:"salt"`
+
+	// Recipients hold the file key wrapped for public keys, the backup key is not used then
+	Recipients []Recipient `json:"recipients,omitempty"`
+	// KDF holds the parameters deriving the backup key from a passphrase
+	KDF *KDFParams `json:"kdf,omitempty"`
+}
+
+// IsStreamFormat reports whether the data starts with the streaming format magic
+func IsStreamFormat(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(Magic))
+}
+
+func validateKey(key []byte) error {
+	switch len(key) {
+	case 16, 24, 32:
+		return nil
+	default:
+		return fmt.Errorf("invalid key size %d, expected 16, 24 or 32 bytes", len(key))
+	}
+}
+
+// newChunkAEAD derives the stream key from the backup key and the header salt
+func newChunkAEAD(key []byte, salt []byte) (cipher.AEAD, error) {
+	if err := validateKey(key); err != nil {
+		return nil, err
+	}
+	streamKey := make([]byte, len(key))
+	if _, err := io.ReadFull(hkdf.New(sha256.New, key, salt, []byte("bifrost-backups stream v1")), streamKey); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(streamKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// chunkNonce builds the STREAM nonce, a big endian chunk counter followed by the last chunk flag
+func chunkNonce(size int, counter uint64, last bool) []byte {
+	nonce := make([]byte, size)
+	binary.BigEndian.PutUint64(nonce[size-9:size-1], counter)
+	if last {
+		nonce[size-1] = lastChunkFlag
+	}
+	return nonce
+}
+
+func encodeHeader(header Header) ([]byte, error) {
+	body, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+	encoded := make([]byte, 0, len(Magic)+5+len(body))
+	encoded = append(encoded, Magic...)
+	encoded = append(encoded, FormatVersion)
+	encoded = binary.BigEndian.AppendUint32(encoded, uint32(len(body))) //#nosec
+	return append(encoded, body...), nil
+}
+
+// ReadHeader reads the stream header, it returns the parsed header and its raw bytes
+func ReadHeader(r io.Reader) (Header, []byte, error) {
+	prefix := make([]byte, len(Magic)+5)
+	if _, err := io.ReadFull(r, prefix); err != nil {
+		return Header{}, nil, fmt.Errorf("failed to read the backup header: %w", err)
+	}
+	if !IsStreamFormat(prefix) {
+		return Header{}, nil, fmt.Errorf("the backup is not in the streaming format")
+	}
+	if version := prefix[len(Magic)]; version != FormatVersion {
+		return Header{}, nil, fmt.Errorf("unsupported backup format version %d", version)
+	}
+	size := binary.BigEndian.Uint32(prefix[len(Magic)+1:])
+	if size > maxHeaderSize {
+		return Header{}, nil, fmt.Errorf("the backup header is too large")
+	}
+
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return Header{}, nil, fmt.Errorf("failed to read the backup header: %w", err)
+	}
+	var header Header
+	if err := json.Unmarshal(body, &header); err != nil {
+		return Header{}, nil, fmt.Errorf("invalid backup header: %w", err)
+	}
+	if header.Algorithm != AlgorithmAESGCMStream {
+		return Header{}, nil, fmt.Errorf("unsupported backup algorithm %s", header.Algorithm)
+	}
+	if header.ChunkSize <= 0 || header.ChunkSize > maxChunkSize {
+		return Header{}, nil, fmt.Errorf("invalid backup chunk size %d", header.ChunkSize)
+	}
+
+	return header, append(prefix, body...), nil
+}
+
+type streamWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	aad     []byte
+	buf     []byte
+	counter uint64
+	closed  bool
+}
+
+// NewWriter returns a writer encrypting everything written to it into w, the
+// caller must Close it to write the final chunk. KeyID and ChunkSize of the
+// header are optional.
+func NewWriter(w io.Writer, key []byte, header Header) (io.WriteCloser, error) {
+	header.Algorithm = AlgorithmAESGCMStream
+	if header.ChunkSize == 0 {
+		header.ChunkSize = DefaultChunkSize
+	} else if header.ChunkSize < 0 || header.ChunkSize > maxChunkSize {
+		return nil, fmt.Errorf("invalid chunk size %d", header.ChunkSize)
+	}
+	header.Salt = make([]byte, saltSize)
+	if _, err := io.ReadFull(rand.Reader, header.Salt); err != nil {
+		return nil, err
+	}
+
+	aead, err := newChunkAEAD(key, header.Salt)
+	if err != nil {
+		return nil, err
+	}
+
+	encoded, err := encodeHeader(header)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(encoded); err != nil {
+		return nil, err
+	}
+
+	return &streamWriter{
+		w:    w,
+		aead: aead,
+		aad:  encoded,
+		buf:  make([]byte, 0, header.ChunkSize),
+	}, nil
+}
+
+func (s *streamWriter) Write(p []byte) (int, error) {
+	if s.closed {
+		return 0, errors.New("write on a closed encryption stream")
+	}
+
+	written := 0
+	for len(p) > 0 {
+		// A full chunk is only sealed once more data follows, the last chunk is sealed by Close
+		if len(s.buf) == cap(s.buf) {
+			if err := s.flush(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(s.buf[len(s.buf):cap(s.buf)], p)
+		s.buf = s.buf[:len(s.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (s *streamWriter) flush(last bool) error {
+	nonce := chunkNonce(s.aead.NonceSize(), s.counter, last)
+	sealed := s.aead.Seal(nil, nonce, s.buf, s.aad)
+	if _, err := s.w.Write(sealed); err != nil {
+		return err
+	}
+	s.counter++
+	s.buf = s.buf[:0]
+	return nil
+}
+
+func (s *streamWriter) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	return s.flush(true)
+}
+
+type streamReader struct {
+	r       *bufio.Reader
+	aead    cipher.AEAD
+	aad     []byte
+	chunk   []byte
+	plain   []byte
+	counter uint64
+	done    bool
+}
+
+// NewReader returns a reader decrypting a backup written by NewWriter
+func NewReader(r io.Reader, key []byte) (io.Reader, error) {
+	header, raw, err := ReadHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(header.Recipients) > 0 {
+		return nil, fmt.Errorf("the backup is encrypted for recipients, an identity is required")
+	}
+	return openStream(r, header, raw, key)
+}
+
+// openStream returns the reader of the chunks following the header
+func openStream(r io.Reader, header Header, raw []byte, key []byte) (io.Reader, error) {
+	aead, err := newChunkAEAD(key, header.Salt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &streamReader{
+		r:     bufio.NewReader(r),
+		aead:  aead,
+		aad:   raw,
+		chunk: make([]byte, header.ChunkSize+aead.Overhead()),
+	}, nil
+}
+
+func (s *streamReader) Read(p []byte) (int, error) {
+	for len(s.plain) == 0 {
+		if s.done {
+			return 0, io.EOF
+		}
+		if err := s.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, s.plain)
+	s.plain = s.plain[n:]
+	return n, nil
+}
+
+func (s *streamReader) next() error {
+	n, err := io.ReadFull(s.r, s.chunk)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return err
+	}
+
+	// The chunk is the last one when nothing follows it
+	last := err != nil
+	if !last {
+		if _, peekErr := s.r.Peek(1); peekErr == io.EOF {
+			last = true
+		} else if peekErr != nil {
+			return peekErr
+		}
+	}
+
+	nonce := chunkNonce(s.aead.NonceSize(), s.counter, last)
+	plain, openErr := s.aead.Open(nil, nonce, s.chunk[:n], s.aad) //#nosec
+	if openErr != nil {
+		if last {
+			return fmt.Errorf("failed to decrypt the last chunk, the backup may be truncated or altered: %w", openErr)
+		}
+		return fmt.Errorf("failed to decrypt chunk %d: %w", s.counter, openErr)
+	}
+
+	s.plain = plain
+	s.counter++
+	s.done = last
+	return nil
+}
@@ -0,0 +1,136 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"testing"
+)
+
+func newTestKey(t *testing.T) []byte {
+	cipher_key, err := GenerateCipherKey(32)
+	if err != nil {
+		t.Fatalf("GenerateCipherKey failed: %v", err)
+	}
+	key, err := base64.StdEncoding.DecodeString(cipher_key)
+	if err != nil {
+		t.Fatalf("GenerateCipherKey failed: %v", err)
+	}
+	return key
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	key := newTestKey(t)
+	chunkSize := 1024
+
+	sizes := []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 5*chunkSize + 17}
+	for _, size := range sizes {
+		plaintext := make([]byte, size)
+		if _, err := rand.Read(plaintext); err != nil {
+			t.Fatal(err)
+		}
+
+		encrypted := new(bytes.Buffer)
+		writer, err := NewWriter(encrypted, key, Header{KeyID: "k1", ChunkSize: chunkSize})
+		if err != nil {
+			t.Fatalf("NewWriter() error = %v", err)
+		}
+		// Write in uneven pieces to cross the chunk boundaries
+		for offset := 0; offset < size; offset += 333 {
+			end := offset + 333
+			if end > size {
+				end = size
+			}
+			if _, err := writer.Write(plaintext[offset:end]); err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+		}
+		if err := writer.Close(); err != nil {
+			t.Fatalf("Close() error = %v", err)
+		}
+
+		header, _, err := ReadHeader(bytes.NewReader(encrypted.Bytes()))
+		if err != nil {
+			t.Fatalf("ReadHeader() error = %v", err)
+		}
+		if header.KeyID != "k1" || header.ChunkSize != chunkSize || header.Algorithm != AlgorithmAESGCMStream {
+			t.Errorf("ReadHeader() got unexpected header: %+v", header)
+		}
+
+		reader, err := NewReader(bytes.NewReader(encrypted.Bytes()), key)
+		if err != nil {
+			t.Fatalf("NewReader() error = %v", err)
+		}
+		decrypted, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatalf("size %d: ReadAll() error = %v", size, err)
+		}
+		if !bytes.Equal(decrypted, plaintext) {
+			t.Errorf("size %d: decrypted data doesn't match the plaintext", size)
+		}
+	}
+}
+
+func TestStreamDetectsTruncationAndTampering(t *testing.T) {
+	key := newTestKey(t)
+	chunkSize := 64
+	plaintext := bytes.Repeat([]byte("bifrost"), 100)
+
+	encrypted, err := CipherWithHeader(key, plaintext, Header{ChunkSize: chunkSize})
+	if err != nil {
+		t.Fatalf("CipherWithHeader() error = %v", err)
+	}
+	data := encrypted.Bytes()
+
+	// Drop the last chunk, the stream now ends on a non final chunk
+	lastChunk := len(plaintext) % chunkSize
+	truncated := data[:len(data)-(lastChunk+16)]
+	if _, err := Decipher(key, truncated); err == nil {
+		t.Error("Decipher should fail on a truncated stream")
+	}
+
+	tampered := append([]byte{}, data...)
+	tampered[len(tampered)-1] ^= 0x01
+	if _, err := Decipher(key, tampered); err == nil {
+		t.Error("Decipher should fail on an altered stream")
+	}
+
+	otherKey := newTestKey(t)
+	if _, err := Decipher(otherKey, data); err == nil {
+		t.Error("Decipher should fail with another key")
+	}
+}
+
+func TestDecipherLegacyFormat(t *testing.T) {
+	key := newTestKey(t)
+	plaintext := []byte("backup sealed by a previous version")
+	legacy := sealLegacy(t, key, plaintext)
+
+	decrypted, err := Decipher(key, legacy)
+	if err != nil {
+		t.Fatalf("Decipher() error = %v", err)
+	}
+	if !bytes.Equal(decrypted.Bytes(), plaintext) {
+		t.Errorf("Decipher() got %s, want %s", decrypted.String(), string(plaintext))
+	}
+}
+
+// sealLegacy encrypts like the previous versions, nonce followed by the cipher text
+func sealLegacy(t *testing.T, key []byte, plaintext []byte) []byte {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aesGCM, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := make([]byte, aesGCM.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		t.Fatal(err)
+	}
+	return aesGCM.Seal(nonce, nonce, plaintext, nil)
+}
@@ -0,0 +1,174 @@
+package drill
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	internalutils "github.com/martient/bifrost-backups/pkg/utils"
+)
+
+// Drill restores the latest backup of a database into a throwaway target and runs
+// assertions on it, it proves the backups can actually be restored
+type Drill struct {
+	// ScratchDatabase is the PostgreSQL database the backup is restored into, a name
+	// derived from the database is used when empty. It is dropped once the drill ends.
+	ScratchDatabase string      `yaml:"scratch_database,omitempty" json:"scratch_database,omitempty"`
+	Assertions      []Assertion `yaml:"assertions,omitempty" json:"assertions,omitempty"`
+}
+
+// Assertion is a SQL query run on the restored database, its first value must match every
+// check set. An assertion without any check only requires the query to succeed.
+type Assertion struct {
+	Name   string   `yaml:"name" json:"name"`
+	Query  string   `yaml:"query" json:"query"`
+	Min    *float64 `yaml:"min,omitempty" json:"min,omitempty"` // e.g. the minimum row count
+	Max    *float64 `yaml:"max,omitempty" json:"max,omitempty"`
+	Equals string   `yaml:"equals,omitempty" json:"equals,omitempty"`
+	// MaxAge is the maximum age of the timestamp returned by the query, e.g. 36h or 2d
+	// for a max(updated_at) freshness check
+	MaxAge string `yaml:"max_age,omitempty" json:"max_age,omitempty"`
+}
+
+// AssertionResult is the outcome of an assertion on the restored database
+type AssertionResult struct {
+	Name   string `json:"name"`
+	Query  string `json:"query"`
+	Value  string `json:"value"`
+	Passed bool   `json:"passed"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Report is the audit of a drill run
+type Report struct {
+	Database   string            `json:"database"`
+	Storage    string            `json:"storage"`
+	Backup     string            `json:"backup"`
+	Target     string            `json:"target"`
+	StartedAt  time.Time         `json:"started_at"`
+	Duration   string            `json:"duration"`
+	Files      int               `json:"files,omitempty"` // Restored files of a local files database
+	Passed     bool              `json:"passed"`
+	Error      string            `json:"error,omitempty"`
+	Assertions []AssertionResult `json:"assertions"`
+}
+
+// timestampLayouts are the layouts a freshness query may return, PostgreSQL and SQLite ones included
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999-07",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02",
+}
+
+// Validate checks the assertions of the drill
+func (d Drill) Validate() error {
+	names := make(map[string]bool)
+	for i, assertion := range d.Assertions {
+		if err := assertion.Validate(); err != nil {
+			return fmt.Errorf("assertion %d: %w", i+1, err)
+		}
+		if names[assertion.Name] {
+			return fmt.Errorf("assertion %s is defined twice", assertion.Name)
+		}
+		names[assertion.Name] = true
+	}
+	return nil
+}
+
+// Validate checks the assertion has a name, a query and consistent checks
+func (a Assertion) Validate() error {
+	if strings.TrimSpace(a.Name) == "" {
+		return fmt.Errorf("name cannot be empty")
+	} else if strings.TrimSpace(a.Query) == "" {
+		return fmt.Errorf("query of %s cannot be empty", a.Name)
+	} else if a.Min != nil && a.Max != nil && *a.Min > *a.Max {
+		return fmt.Errorf("min of %s is greater than its max", a.Name)
+	}
+	if a.MaxAge != "" {
+		if _, err := internalutils.ParseDuration(a.MaxAge); err != nil {
+			return fmt.Errorf("max age of %s: %w", a.Name, err)
+		}
+	}
+	return nil
+}
+
+// Check compares the value returned by the query to the checks of the assertion
+func (a Assertion) Check(value string, now time.Time) error {
+	value = strings.TrimSpace(value)
+	if a.Equals != "" && value != a.Equals {
+		return fmt.Errorf("got %q, want %q", value, a.Equals)
+	}
+	if a.Min != nil || a.Max != nil {
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("got %q, want a number", value)
+		}
+		if a.Min != nil && number < *a.Min {
+			return fmt.Errorf("got %v, want at least %v", number, *a.Min)
+		}
+		if a.Max != nil && number > *a.Max {
+			return fmt.Errorf("got %v, want at most %v", number, *a.Max)
+		}
+	}
+	if a.MaxAge != "" {
+		maxAge, err := internalutils.ParseDuration(a.MaxAge)
+		if err != nil {
+			return err
+		}
+		timestamp, err := ParseTimestamp(value)
+		if err != nil {
+			return err
+		}
+		if age := now.Sub(timestamp); age > maxAge {
+			return fmt.Errorf("got %s, %s old, want at most %s", value, age.Round(time.Second), a.MaxAge)
+		}
+	}
+	return nil
+}
+
+// ParseTimestamp parses a timestamp returned by a query, unix seconds are accepted
+func ParseTimestamp(value string) (time.Time, error) {
+	for _, layout := range timestampLayouts {
+		if timestamp, err := time.Parse(layout, value); err == nil {
+			return timestamp, nil
+		}
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0).UTC(), nil
+	}
+	return time.Time{}, fmt.Errorf("got %q, want a timestamp", value)
+}
+
+// Run runs the assertions with the query function of the restored database, it returns
+// whether every assertion passed
+func Run(assertions []Assertion, query func(query string) (string, error), now time.Time) ([]AssertionResult, bool) {
+	results := make([]AssertionResult, 0, len(assertions))
+	passed := true
+	for _, assertion := range assertions {
+		result := AssertionResult{Name: assertion.Name, Query: assertion.Query}
+		value, err := query(assertion.Query)
+		if err == nil {
+			result.Value = firstValue(value)
+			err = assertion.Check(result.Value, now)
+		}
+		if err != nil {
+			result.Error = err.Error()
+			passed = false
+		} else {
+			result.Passed = true
+		}
+		results = append(results, result)
+	}
+	return results, passed
+}
+
+// firstValue returns the first column of the first row of a query output
+func firstValue(output string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(output), "\n")
+	value, _, _ := strings.Cut(line, "|")
+	return strings.TrimSpace(value)
+}
@@ -0,0 +1,95 @@
+package drill
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func float(value float64) *float64 {
+	return &value
+}
+
+func TestAssertionValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		assertion Assertion
+		wantErr   bool
+	}{
+		{name: "Valid", assertion: Assertion{Name: "users", Query: "SELECT count(*) FROM users", Min: float(1)}},
+		{name: "Missing name", assertion: Assertion{Query: "SELECT 1"}, wantErr: true},
+		{name: "Missing query", assertion: Assertion{Name: "users"}, wantErr: true},
+		{name: "Min greater than max", assertion: Assertion{Name: "users", Query: "SELECT 1", Min: float(2), Max: float(1)}, wantErr: true},
+		{name: "Invalid max age", assertion: Assertion{Name: "fresh", Query: "SELECT 1", MaxAge: "soon"}, wantErr: true},
+		{name: "Max age in days", assertion: Assertion{Name: "fresh", Query: "SELECT 1", MaxAge: "2d"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.assertion.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	duplicated := Drill{Assertions: []Assertion{{Name: "a", Query: "SELECT 1"}, {Name: "a", Query: "SELECT 2"}}}
+	if err := duplicated.Validate(); err == nil {
+		t.Error("Validate() of a drill with duplicated assertions should fail")
+	}
+}
+
+func TestAssertionCheck(t *testing.T) {
+	now := time.Date(2024, 6, 15, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		assertion Assertion
+		value     string
+		wantErr   bool
+	}{
+		{name: "Row count above min", assertion: Assertion{Min: float(10)}, value: "42"},
+		{name: "Row count below min", assertion: Assertion{Min: float(10)}, value: "3", wantErr: true},
+		{name: "Row count above max", assertion: Assertion{Max: float(10)}, value: "11", wantErr: true},
+		{name: "Not a number", assertion: Assertion{Min: float(1)}, value: "abc", wantErr: true},
+		{name: "Equals", assertion: Assertion{Equals: "ok"}, value: " ok\n"},
+		{name: "Not equal", assertion: Assertion{Equals: "ok"}, value: "ko", wantErr: true},
+		{name: "Fresh PostgreSQL timestamp", assertion: Assertion{MaxAge: "1d"}, value: "2024-06-15 03:04:05.123+00"},
+		{name: "Stale SQLite timestamp", assertion: Assertion{MaxAge: "36h"}, value: "2024-06-13 03:04:05", wantErr: true},
+		{name: "Fresh unix timestamp", assertion: Assertion{MaxAge: "1h"}, value: fmt.Sprint(now.Add(-time.Minute).Unix())},
+		{name: "Empty timestamp", assertion: Assertion{MaxAge: "1h"}, value: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.assertion.Check(tt.value, now); (err != nil) != tt.wantErr {
+				t.Errorf("Check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	assertions := []Assertion{
+		{Name: "users", Query: "SELECT count(*) FROM users", Min: float(2)},
+		{Name: "orders", Query: "SELECT count(*) FROM orders", Min: float(1)},
+		{Name: "broken", Query: "SELECT * FROM missing"},
+	}
+	outputs := map[string]string{
+		"SELECT count(*) FROM users":  "3\n",
+		"SELECT count(*) FROM orders": "0|ignored\n5",
+	}
+	query := func(query string) (string, error) {
+		if output, ok := outputs[query]; ok {
+			return output, nil
+		}
+		return "", fmt.Errorf("no such table")
+	}
+
+	results, passed := Run(assertions, query, time.Now())
+	if passed {
+		t.Error("Run() passed with failing assertions")
+	}
+	if len(results) != 3 || !results[0].Passed || results[1].Passed || results[1].Value != "0" || results[2].Error == "" {
+		t.Errorf("Run() = %+v", results)
+	}
+	if _, passed := Run(assertions[:1], query, time.Now()); !passed {
+		t.Error("Run() failed with passing assertions")
+	}
+}
@@ -0,0 +1,276 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	internalutils "github.com/martient/bifrost-backups/pkg/utils"
+	"github.com/martient/golang-utils/utils"
+)
+
+// Event is the step of a backup or a restore a hook runs at
+type Event string
+
+const (
+	PreBackup   Event = "pre_backup"
+	PostBackup  Event = "post_backup"
+	OnFailure   Event = "on_failure"
+	PreRestore  Event = "pre_restore"
+	PostRestore Event = "post_restore"
+)
+
+// What a failing hook does to the run
+const (
+	Abort = "abort"
+	Warn  = "warn"
+)
+
+// Statuses of a run given to the hooks
+const (
+	StatusStarted = "started"
+	StatusSuccess = "success"
+	StatusFailure = "failure"
+)
+
+// DefaultTimeout is the timeout of the hooks without one
+const DefaultTimeout = 30 * time.Second
+
+// maxOutput is the number of bytes of a hook output kept for the logs
+const maxOutput = 4096
+
+// Hooks are the hooks of a database by event. The post hooks run whatever the outcome of
+// the backup or the restore, e.g. to resume an application paused by a pre hook, and the
+// on failure hooks run on top of them when it failed.
+type Hooks struct {
+	PreBackup   []Hook `yaml:"pre_backup,omitempty" json:"pre_backup,omitempty"`
+	PostBackup  []Hook `yaml:"post_backup,omitempty" json:"post_backup,omitempty"`
+	OnFailure   []Hook `yaml:"on_failure,omitempty" json:"on_failure,omitempty"`
+	PreRestore  []Hook `yaml:"pre_restore,omitempty" json:"pre_restore,omitempty"`
+	PostRestore []Hook `yaml:"post_restore,omitempty" json:"post_restore,omitempty"`
+}
+
+// Hook is a shell command or an HTTP call, exactly one of Command and URL is set
+type Hook struct {
+	Name    string `yaml:"name,omitempty" json:"name,omitempty"`
+	Command string `yaml:"command,omitempty" json:"command,omitempty"` // Run with sh -c
+	URL     string `yaml:"url,omitempty" json:"url,omitempty"`
+	// Method of the HTTP call, POST by default. The body of a POST or a PUT is the run as JSON.
+	Method  string            `yaml:"method,omitempty" json:"method,omitempty"`
+	Headers map[string]string `yaml:"headers,omitempty" json:"headers,omitempty"`
+	Timeout string            `yaml:"timeout,omitempty" json:"timeout,omitempty"` // e.g. 30s or 5m
+	// OnError is abort or warn, the pre hooks abort the run by default and the other ones warn
+	OnError string `yaml:"on_error,omitempty" json:"on_error,omitempty"`
+}
+
+// Run describes the backup or the restore to the hooks, as BIFROST_* environment variables
+// for the commands and as the JSON body of the HTTP calls
+type Run struct {
+	Event    Event  `json:"event"`
+	Database string `json:"database"`
+	Storage  string `json:"storage,omitempty"`
+	Backup   string `json:"backup,omitempty"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Env returns the environment variables describing the run
+func (run Run) Env() []string {
+	return []string{
+		"BIFROST_HOOK_EVENT=" + string(run.Event),
+		"BIFROST_DATABASE=" + run.Database,
+		"BIFROST_STORAGE=" + run.Storage,
+		"BIFROST_BACKUP=" + run.Backup,
+		"BIFROST_STATUS=" + run.Status,
+		"BIFROST_ERROR=" + run.Error,
+	}
+}
+
+// IsZero tells whether no hook is set
+func (h Hooks) IsZero() bool {
+	return len(h.PreBackup) == 0 && len(h.PostBackup) == 0 && len(h.OnFailure) == 0 && len(h.PreRestore) == 0 && len(h.PostRestore) == 0
+}
+
+// Of returns the hooks of the event
+func (h Hooks) Of(event Event) []Hook {
+	switch event {
+	case PreBackup:
+		return h.PreBackup
+	case PostBackup:
+		return h.PostBackup
+	case OnFailure:
+		return h.OnFailure
+	case PreRestore:
+		return h.PreRestore
+	case PostRestore:
+		return h.PostRestore
+	}
+	return nil
+}
+
+// Validate checks every hook
+func (h Hooks) Validate() error {
+	for _, event := range []Event{PreBackup, PostBackup, OnFailure, PreRestore, PostRestore} {
+		for i, hook := range h.Of(event) {
+			if err := hook.Validate(); err != nil {
+				return fmt.Errorf("%s hook %d: %w", event, i+1, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Validate checks the hook has a command or a URL, a valid timeout and failure mode
+func (hook Hook) Validate() error {
+	if (hook.Command == "") == (hook.URL == "") {
+		return fmt.Errorf("exactly one of command and url must be set")
+	}
+	if hook.URL != "" && !strings.HasPrefix(hook.URL, "http://") && !strings.HasPrefix(hook.URL, "https://") {
+		return fmt.Errorf("url must be an http or https URL")
+	}
+	if hook.Timeout != "" {
+		if timeout, err := internalutils.ParseDuration(hook.Timeout); err != nil {
+			return err
+		} else if timeout <= 0 {
+			return fmt.Errorf("timeout must be positive")
+		}
+	}
+	if hook.OnError != "" && hook.OnError != Abort && hook.OnError != Warn {
+		return fmt.Errorf("on_error must be %s or %s", Abort, Warn)
+	}
+	return nil
+}
+
+// String names the hook in the logs
+func (hook Hook) String() string {
+	if hook.Name != "" {
+		return hook.Name
+	} else if hook.URL != "" {
+		return hook.URL
+	}
+	return hook.Command
+}
+
+func (hook Hook) timeout() time.Duration {
+	if timeout, err := internalutils.ParseDuration(hook.Timeout); err == nil && timeout > 0 {
+		return timeout
+	}
+	return DefaultTimeout
+}
+
+// aborts tells whether a failure of the hook aborts the run
+func (hook Hook) aborts(event Event) bool {
+	if hook.OnError != "" {
+		return hook.OnError == Abort
+	}
+	return event == PreBackup || event == PreRestore
+}
+
+// Run runs the hooks of the event in order, a failing hook set to abort stops there and
+// returns its error while the other failures are only logged
+func (h Hooks) Run(event Event, run Run) error {
+	run.Event = event
+	for _, hook := range h.Of(event) {
+		utils.LogInfo("Running %s hook %s", "HOOKS", event, hook)
+		err := hook.Run(run)
+		if err == nil {
+			continue
+		}
+		if hook.aborts(event) {
+			return fmt.Errorf("%s hook %s failed: %w", event, hook, err)
+		}
+		utils.LogWarning("%s hook %s failed: %v", "HOOKS", event, hook, err)
+	}
+	return nil
+}
+
+// Run runs the hook within its timeout
+func (hook Hook) Run(run Run) error {
+	ctx, cancel := context.WithTimeout(context.Background(), hook.timeout())
+	defer cancel()
+
+	var err error
+	if hook.URL != "" {
+		err = hook.call(ctx, run)
+	} else {
+		err = hook.execute(ctx, run)
+	}
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out after %s: %w", hook.timeout(), err)
+	}
+	return err
+}
+
+// execute runs the command with the environment of the run
+func (hook Hook) execute(ctx context.Context, run Run) error {
+	cmd := exec.CommandContext(ctx, "sh", "-c", hook.Command) //#nosec
+	cmd.Env = append(os.Environ(), run.Env()...)
+	// The output pipes of the commands left behind by the shell aren't awaited forever
+	cmd.WaitDelay = time.Second
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Run(); err != nil {
+		if output.Len() == 0 {
+			return err
+		}
+		return fmt.Errorf("%w: %s", err, truncate(output.String()))
+	}
+	if output.Len() > 0 {
+		utils.LogDebug("Hook %s output: %s", "HOOKS", hook, truncate(output.String()))
+	}
+	return nil
+}
+
+// call sends the run to the URL, any status but 2xx is a failure
+func (hook Hook) call(ctx context.Context, run Run) error {
+	method := strings.ToUpper(hook.Method)
+	if method == "" {
+		method = http.MethodPost
+	}
+	var body io.Reader
+	if method == http.MethodPost || method == http.MethodPut {
+		data, err := json.Marshal(run)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, hook.URL, body)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	for key, value := range hook.Headers {
+		request.Header.Set(key, value)
+	}
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		data, _ := io.ReadAll(io.LimitReader(response.Body, maxOutput))
+		return fmt.Errorf("unexpected status %s: %s", response.Status, truncate(string(data)))
+	}
+	return nil
+}
+
+func truncate(output string) string {
+	output = strings.TrimSpace(output)
+	if len(output) > maxOutput {
+		return output[:maxOutput] + "..."
+	}
+	return output
+}
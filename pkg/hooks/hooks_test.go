@@ -0,0 +1,118 @@
+package hooks
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHookValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		hook    Hook
+		wantErr bool
+	}{
+		{name: "Command", hook: Hook{Command: "true"}},
+		{name: "URL", hook: Hook{URL: "https://example.com/hook", Timeout: "5s", OnError: Warn}},
+		{name: "Nothing", hook: Hook{}, wantErr: true},
+		{name: "Command and URL", hook: Hook{Command: "true", URL: "https://example.com"}, wantErr: true},
+		{name: "Not an HTTP URL", hook: Hook{URL: "ftp://example.com"}, wantErr: true},
+		{name: "Invalid timeout", hook: Hook{Command: "true", Timeout: "soon"}, wantErr: true},
+		{name: "Invalid failure mode", hook: Hook{Command: "true", OnError: "ignore"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.hook.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRunCommand(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "env")
+	hooks := Hooks{PostBackup: []Hook{{Command: `echo "$BIFROST_HOOK_EVENT $BIFROST_DATABASE $BIFROST_STORAGE $BIFROST_BACKUP $BIFROST_STATUS" > ` + output}}}
+
+	run := Run{Database: "dev", Storage: "local", Backup: "dev_2024-06-15T03-04-05.000000Z.bifrost", Status: StatusSuccess}
+	if err := hooks.Run(PostBackup, run); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(string(data)); got != "post_backup dev local dev_2024-06-15T03-04-05.000000Z.bifrost success" {
+		t.Errorf("hook environment = %s", got)
+	}
+}
+
+func TestRunFailureModes(t *testing.T) {
+	failing := Hook{Name: "failing", Command: "echo broken >&2; exit 3"}
+
+	// The pre hooks abort by default, the other ones warn
+	if err := (Hooks{PreBackup: []Hook{failing}}).Run(PreBackup, Run{}); err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Errorf("Run() of a failing pre hook error = %v, want the hook output", err)
+	}
+	if err := (Hooks{PostBackup: []Hook{failing}}).Run(PostBackup, Run{}); err != nil {
+		t.Errorf("Run() of a failing post hook error = %v, want a warning only", err)
+	}
+
+	warning := failing
+	warning.OnError = Warn
+	if err := (Hooks{PreRestore: []Hook{warning}}).Run(PreRestore, Run{}); err != nil {
+		t.Errorf("Run() of a failing pre hook set to warn error = %v", err)
+	}
+	aborting := failing
+	aborting.OnError = Abort
+	if err := (Hooks{OnFailure: []Hook{aborting}}).Run(OnFailure, Run{}); err == nil {
+		t.Error("Run() of a failing hook set to abort should fail")
+	}
+
+	// An aborting hook stops the following ones
+	marker := filepath.Join(t.TempDir(), "marker")
+	hooks := Hooks{PreBackup: []Hook{failing, {Command: "touch " + marker}}}
+	if err := hooks.Run(PreBackup, Run{}); err == nil {
+		t.Fatal("Run() should fail")
+	}
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Errorf("the hook after an aborting one ran: %v", err)
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	hook := Hook{Command: "sleep 5", Timeout: "100ms"}
+	if err := hook.Run(Run{}); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("Run() error = %v, want a timeout", err)
+	}
+}
+
+func TestRunHTTP(t *testing.T) {
+	var received Run
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	hook := Hook{URL: server.URL, Headers: map[string]string{"Authorization": "Bearer token"}}
+	if err := (Hooks{PreRestore: []Hook{hook}}).Run(PreRestore, Run{Database: "dev", Status: StatusStarted}); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if received.Event != PreRestore || received.Database != "dev" || received.Status != StatusStarted {
+		t.Errorf("received run = %+v", received)
+	}
+
+	hook.Headers = nil
+	if err := hook.Run(Run{}); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("Run() error = %v, want the unexpected status", err)
+	}
+}
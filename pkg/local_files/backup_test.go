@@ -181,3 +181,34 @@ func TestBackupAndRestore(t *testing.T) {
 		})
 	}
 }
+
+func TestRestorePath(t *testing.T) {
+	tests := []struct {
+		name       string
+		backupData string
+		want       string
+	}{
+		{
+			name:       "Single file backup",
+			backupData: "FILE:/etc/app/app.ini\nport=8080\n\n---END---\n",
+			want:       "/restore/app.ini",
+		},
+		{
+			name:       "Folder backup",
+			backupData: "DIR:/etc/app\nFILE:/etc/app/app.ini\nport=8080\n\n---END---\n",
+			want:       "/restore",
+		},
+		{
+			name: "Empty backup",
+			want: "/restore",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RestorePath("/restore", []byte(tt.backupData)); got != tt.want {
+				t.Errorf("RestorePath() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -11,6 +11,16 @@ import (
 	"github.com/martient/golang-utils/utils"
 )
 
+// RestorePath returns the path to restore the backup into the folder dir: the folder
+// itself for a folder backup, the file of the archived name inside it for a single file
+func RestorePath(dir string, backupData []byte) string {
+	firstLine, _, _ := bytes.Cut(backupData, []byte("\n"))
+	if filePath, found := strings.CutPrefix(string(firstLine), "FILE:"); found {
+		return filepath.Join(dir, filepath.Base(filePath))
+	}
+	return dir
+}
+
 func RunRestore(config LocalFilesRequirements, backupData *bytes.Buffer) error {
 	if err := validateRequirements(config); err != nil {
 		return err
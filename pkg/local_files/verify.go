@@ -0,0 +1,71 @@
+package localfiles
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// RunVerification walks the archive and checks every file is complete, it returns the
+// number of files of the archive
+func RunVerification(backupData *bytes.Buffer) (int, error) {
+	if backupData == nil || backupData.Len() <= 0 {
+		return 0, fmt.Errorf("backup can't be empty for the verification process")
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(backupData.Bytes()))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(backupData.Bytes())+1)
+	var currentFile string
+	var basePath string
+	files := 0
+
+	checkPath := func(path string) error {
+		if !filepath.IsAbs(path) {
+			return fmt.Errorf("archive path must be absolute: %s", path)
+		}
+		if basePath != "" {
+			rel, err := filepath.Rel(basePath, path)
+			if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				return fmt.Errorf("archive path %s is outside of %s", path, basePath)
+			}
+		}
+		return nil
+	}
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "DIR:") && currentFile == "":
+			dirPath := strings.TrimPrefix(line, "DIR:")
+			if err := checkPath(dirPath); err != nil {
+				return files, err
+			}
+			if basePath == "" {
+				basePath = dirPath
+			}
+		case strings.HasPrefix(line, "FILE:") && currentFile == "":
+			filePath := strings.TrimPrefix(line, "FILE:")
+			if err := checkPath(filePath); err != nil {
+				return files, err
+			}
+			currentFile = filePath
+		case line == "---END---":
+			if currentFile == "" {
+				return files, fmt.Errorf("end marker without a file")
+			}
+			currentFile = ""
+			files++
+		case currentFile == "":
+			return files, fmt.Errorf("unexpected content outside of a file: %.40q", line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return files, fmt.Errorf("failed to read the archive: %w", err)
+	}
+	if currentFile != "" {
+		return files, fmt.Errorf("the archive is truncated, %s has no end marker", currentFile)
+	}
+	return files, nil
+}
@@ -0,0 +1,41 @@
+package localfiles
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRunVerification(t *testing.T) {
+	sourceDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(sourceDir, "nested"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.txt", filepath.Join("nested", "b.txt")} {
+		if err := os.WriteFile(filepath.Join(sourceDir, name), []byte("content of "+name), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	backup, err := RunBackup(LocalFilesRequirements{Path: sourceDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := RunVerification(bytes.NewBuffer(backup.Bytes()))
+	if err != nil || files != 2 {
+		t.Errorf("RunVerification() = %d, %v, want 2 files", files, err)
+	}
+
+	truncated := backup.Bytes()[:backup.Len()-len("---END---\n")]
+	if _, err := RunVerification(bytes.NewBuffer(truncated)); err == nil {
+		t.Error("RunVerification() of a truncated archive should fail")
+	}
+	escaping := []byte("DIR:/backup\nFILE:/etc/passwd\nroot\n\n---END---\n")
+	if _, err := RunVerification(bytes.NewBuffer(escaping)); err == nil {
+		t.Error("RunVerification() of a file outside of the archive folder should fail")
+	}
+	if _, err := RunVerification(bytes.NewBufferString("garbage")); err == nil {
+		t.Error("RunVerification() of garbage should fail")
+	}
+}
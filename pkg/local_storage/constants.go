@@ -1,5 +1,14 @@
 package localstorage
 
+import "time"
+
+const (
+	// tempFilePrefix marks the backups being written, they are renamed once complete
+	tempFilePrefix = ".bifrost-tmp-"
+	// orphanedTempFileAge is the inactivity after which a temp file is considered left by a crashed run
+	orphanedTempFileAge = time.Hour
+)
+
 type LocalStorageRequirements struct {
 	FolderPath string `json:"folder_path"`
 }
@@ -23,13 +23,13 @@ func TestStoreAndFetchBackup(t *testing.T) {
 		buffer := bytes.NewBufferString(originalData)
 
 		// Store the backup
-		err = StoreBackup(storage, buffer, true)
+		_, err = StoreBackup(storage, "test", buffer, zstdCompression)
 		if err != nil {
 			t.Fatalf("Failed to store backup: %v", err)
 		}
 
 		// Fetch the backup
-		fetchedBuffer, err := PullBackup(storage, "", true)
+		fetchedBuffer, err := PullBackup(storage, "", "")
 		if err != nil {
 			t.Fatalf("Failed to fetch backup: %v", err)
 		}
@@ -56,13 +56,13 @@ func TestStoreAndFetchBackup(t *testing.T) {
 		buffer := bytes.NewBufferString(originalData)
 
 		// Store the backup
-		err = StoreBackup(storage, buffer, false)
+		_, err = StoreBackup(storage, "test", buffer, noCompression)
 		if err != nil {
 			t.Fatalf("Failed to store backup: %v", err)
 		}
 
 		// Fetch the backup
-		fetchedBuffer, err := PullBackup(storage, "", false)
+		fetchedBuffer, err := PullBackup(storage, "", "")
 		if err != nil {
 			t.Fatalf("Failed to fetch backup: %v", err)
 		}
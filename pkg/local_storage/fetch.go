@@ -3,49 +3,84 @@ package localstorage
 import (
 	"bytes"
 	"fmt"
-	"io"
-	"log"
 	"os"
 	"path/filepath"
-	"sort"
 	"strings"
 
-	"github.com/klauspost/compress/zstd"
+	"github.com/martient/bifrost-backups/pkg/backupname"
+	"github.com/martient/bifrost-backups/pkg/compression"
+	"github.com/martient/bifrost-backups/pkg/manifest"
+	internalutils "github.com/martient/bifrost-backups/pkg/utils"
 	"github.com/martient/golang-utils/utils"
 )
 
-func getBackupPath(path string) (string, error) {
+// getBackupPath returns the newest backup of the database in the folder, every database when empty
+func getBackupPath(path string, database_name string) (string, error) {
 	if len(path) <= 0 {
 		return "", fmt.Errorf("the backup folder path can't be empty")
 	}
-	entries, err := os.ReadDir(path)
+	files, err := getBackupFiles(path)
 	if err != nil {
 		return "", fmt.Errorf("failed to read directory: %w", err)
 	}
 
-	if len(entries) == 0 {
+	backups, _ := backupname.Filter(files, database_name)
+	if len(backups) == 0 {
 		return "", fmt.Errorf("no backups found in folder %s", path)
 	}
 
-	// Sort entries by modification time
-	var files []string
-	for _, entry := range entries {
-		if !entry.IsDir() {
-			files = append(files, entry.Name())
-		}
+	latest := backups[len(backups)-1].Key
+	utils.LogDebug("Latest file: %s", "LOCAL-STORAGE", latest)
+	return latest, nil
+}
+
+// ListBackups returns the backup names of the database in the storage (every backup when empty), oldest first
+func ListBackups(storage LocalStorageRequirements, database_name string) ([]string, error) {
+	if storage == (LocalStorageRequirements{}) {
+		return nil, fmt.Errorf("storage can't be empty")
+	}
+	files, err := getBackupFiles(storage.FolderPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory: %w", err)
 	}
+	backups, _ := backupname.Filter(files, database_name)
+	return backupname.Keys(backups), nil
+}
 
-	if len(files) == 0 {
-		return "", fmt.Errorf("no backup files found in %s", path)
+// LatestBackup returns the name of the newest backup of the database
+func LatestBackup(storage LocalStorageRequirements, database_name string) (string, error) {
+	if storage == (LocalStorageRequirements{}) {
+		return "", fmt.Errorf("storage can't be empty")
 	}
+	return getBackupPath(storage.FolderPath, database_name)
+}
 
-	// Sort files by name (which includes timestamp)
-	sort.Strings(files)
-	utils.LogDebug("Latest file: %s", "LOCAL-STORAGE", files[len(files)-1])
-	return files[len(files)-1], nil
+// ReadManifest returns the manifest of a backup, nil when the backup has none
+// (written before the manifests)
+func ReadManifest(storage LocalStorageRequirements, backup_name string) (*manifest.Manifest, error) {
+	if storage == (LocalStorageRequirements{}) {
+		return nil, fmt.Errorf("storage can't be empty")
+	}
+	manifestPath := filepath.Join(storage.FolderPath, manifest.Name(backup_name))
+	if err := internalutils.ValidatePath(manifestPath, []string{storage.FolderPath}); err != nil {
+		return nil, fmt.Errorf("invalid manifest path: %w", err)
+	}
+	data, err := os.ReadFile(manifestPath) //#nosec
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read the manifest of %s: %w", backup_name, err)
+	}
+	backupManifest, err := manifest.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the manifest of %s: %w", backup_name, err)
+	}
+	return &backupManifest, nil
 }
 
-func PullBackup(storage LocalStorageRequirements, backup_name string, useCompression bool) (*bytes.Buffer, error) {
+// PullBackup reads a backup of the storage, the newest one of the database when the name is empty.
+// The backup is checked against its manifest and its compression is detected from the backup header.
+func PullBackup(storage LocalStorageRequirements, database_name string, backup_name string) (*bytes.Buffer, error) {
 	if storage == (LocalStorageRequirements{}) {
 		return nil, fmt.Errorf("storage can't be empty")
 	}
@@ -53,40 +88,37 @@ func PullBackup(storage LocalStorageRequirements, backup_name string, useCompres
 
 	latestBackupKey := backup_name
 	if latestBackupKey == "" {
-		latestBackupKey, err = getBackupPath(storage.FolderPath)
+		latestBackupKey, err = getBackupPath(storage.FolderPath, database_name)
 		if err != nil {
 			return nil, err
 		}
 	}
 
 	filePath := strings.TrimSuffix(filepath.Join(storage.FolderPath, latestBackupKey), "\n")
+	if err := internalutils.ValidatePath(filePath, []string{storage.FolderPath}); err != nil {
+		return nil, fmt.Errorf("invalid backup path: %w", err)
+	}
 
-	file, err := os.OpenFile(filePath, os.O_RDONLY, 0600) //#nosec
+	data, err := os.ReadFile(filePath) //#nosec
 	if err != nil {
-		return nil, fmt.Errorf("error opening config file: %v", err)
+		return nil, fmt.Errorf("error opening backup file: %v", err)
 	}
-	defer func() {
-		if err := file.Close(); err != nil {
-			log.Printf("failed to close config file: %v", err)
-		}
-	}()
-
-	var reader io.Reader = file
 
-	if useCompression {
-		zReader, err := zstd.NewReader(file)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create new reader for file %s from folder %s: %v", latestBackupKey, storage.FolderPath, err)
+	backupManifest, err := ReadManifest(storage, latestBackupKey)
+	if err != nil {
+		return nil, err
+	} else if backupManifest != nil {
+		if err := backupManifest.Verify(data); err != nil {
+			return nil, err
 		}
-		defer zReader.Close()
-		reader = zReader
+	} else {
+		utils.LogDebug("Backup %s has no manifest, its checksum isn't verified", "LOCAL-STORAGE", latestBackupKey)
 	}
-	buf := new(bytes.Buffer)
 
-	_, err = io.Copy(buf, reader)
+	decompressed, err := compression.Decompress(data)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read file %s from folder %s: %v", latestBackupKey, storage.FolderPath, err)
+		return nil, fmt.Errorf("failed to decompress file %s from folder %s: %v", latestBackupKey, storage.FolderPath, err)
 	}
 
-	return buf, nil
+	return bytes.NewBuffer(decompressed), nil
 }
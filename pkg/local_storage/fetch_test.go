@@ -1,18 +1,22 @@
 package localstorage
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/klauspost/compress/zstd"
+	"github.com/martient/bifrost-backups/pkg/manifest"
 )
 
 func TestGetBackupPath(t *testing.T) {
 	t.Run("empty path", func(t *testing.T) {
-		_, err := getBackupPath("")
+		_, err := getBackupPath("", "")
 		if err == nil {
 			t.Error("Expected error for empty path, got nil")
 		}
@@ -31,7 +35,7 @@ func TestGetBackupPath(t *testing.T) {
 			}
 		}()
 
-		_, err = getBackupPath(tempDir)
+		_, err = getBackupPath(tempDir, "")
 		if err == nil {
 			t.Error("Expected error for no backups found, got nil")
 		}
@@ -52,25 +56,92 @@ func TestGetBackupPath(t *testing.T) {
 		}()
 
 		// Create a test backup file
-		backupFile := filepath.Join(tempDir, "test_backup.json")
+		backupFile := filepath.Join(tempDir, "test_2024-01-01T00-00-00.000000Z.bifrost")
 		err = os.WriteFile(backupFile, []byte("test backup data"), 0644)
 		if err != nil {
 			t.Fatal(err)
 		}
 
-		backupPath, err := getBackupPath(tempDir)
+		backupPath, err := getBackupPath(tempDir, "")
 		if err != nil {
 			t.Errorf("Unexpected error: %v", err)
 		}
-		if backupPath != "test_backup.json" {
-			t.Errorf("Expected backup path 'test_backup.json', got '%s'", backupPath)
+		if backupPath != "test_2024-01-01T00-00-00.000000Z.bifrost" {
+			t.Errorf("Expected backup path 'test_2024-01-01T00-00-00.000000Z.bifrost', got '%s'", backupPath)
 		}
 	})
 }
 
+func TestGetBackupPathIgnoresTempFiles(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "bifrost-backups")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tempDir); err != nil {
+			t.Errorf("Failed to remove temp directory: %v", err)
+		}
+	}()
+
+	err = os.WriteFile(filepath.Join(tempDir, "2024-01-01T00:00:000Z"), []byte("complete backup"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A truncated backup left by a crash sorts after the complete one
+	err = os.WriteFile(filepath.Join(tempDir, tempFilePrefix+"2024-01-02"), []byte("trunc"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backupPath, err := getBackupPath(tempDir, "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if backupPath != "2024-01-01T00:00:000Z" {
+		t.Errorf("Expected backup path '2024-01-01T00:00:000Z', got '%s'", backupPath)
+	}
+}
+
+func TestGetBackupPathMixedFormats(t *testing.T) {
+	tempDir := t.TempDir()
+	// The legacy local and S3 names are older than the codec names but sort after them
+	for _, name := range []string{
+		"dev_2024-01-02T00-00-00.000000Z.bifrost",
+		"2024-01-03T00:00:000Z",
+		"other_2024-01-05T00-00-00.000000Z.bifrost",
+		"2024-01-01T10:00:00Z",
+		"dev_2024-01-04T00-00-00.123456Z.bifrost",
+		"notes.txt",
+	} {
+		if err := os.WriteFile(filepath.Join(tempDir, name), []byte("backup"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	backupPath, err := getBackupPath(tempDir, "dev")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if backupPath != "dev_2024-01-04T00-00-00.123456Z.bifrost" {
+		t.Errorf("getBackupPath() = %s, want the newest backup of dev", backupPath)
+	}
+	if backupPath, _ := getBackupPath(tempDir, ""); backupPath != "other_2024-01-05T00-00-00.000000Z.bifrost" {
+		t.Errorf("getBackupPath() = %s, want the newest backup of the folder", backupPath)
+	}
+
+	names, err := ListBackups(LocalStorageRequirements{FolderPath: tempDir}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"2024-01-01T10:00:00Z", "dev_2024-01-02T00-00-00.000000Z.bifrost", "2024-01-03T00:00:000Z", "dev_2024-01-04T00-00-00.123456Z.bifrost", "other_2024-01-05T00-00-00.000000Z.bifrost"}
+	if strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Errorf("ListBackups() = %v, want %v", names, want)
+	}
+}
+
 func TestPullBackup(t *testing.T) {
 	t.Run("empty storage", func(t *testing.T) {
-		_, err := PullBackup(LocalStorageRequirements{}, "", false)
+		_, err := PullBackup(LocalStorageRequirements{}, "", "")
 		if err == nil {
 			t.Error("Expected error for empty storage, got nil")
 		}
@@ -91,14 +162,14 @@ func TestPullBackup(t *testing.T) {
 		}()
 
 		// Create a test backup file
-		backupFile := filepath.Join(tempDir, "test_backup.json")
+		backupFile := filepath.Join(tempDir, "test_2024-01-01T00-00-00.000000Z.bifrost")
 		err = os.WriteFile(backupFile, []byte("test backup data"), 0644)
 		if err != nil {
 			t.Fatal(err)
 		}
 
 		storage := LocalStorageRequirements{FolderPath: tempDir}
-		buf, err := PullBackup(storage, "", false)
+		buf, err := PullBackup(storage, "test", "")
 		if err != nil {
 			t.Errorf("Unexpected error: %v", err)
 		}
@@ -126,7 +197,7 @@ func TestPullBackup(t *testing.T) {
 		}
 
 		storage := LocalStorageRequirements{FolderPath: tempDir}
-		buf, err := PullBackup(storage, "test_backup.json", false)
+		buf, err := PullBackup(storage, "", "test_backup.json")
 		if err != nil {
 			t.Errorf("Unexpected error: %v", err)
 		}
@@ -168,7 +239,7 @@ func TestPullBackup(t *testing.T) {
 		}
 
 		storage := LocalStorageRequirements{FolderPath: tempDir}
-		buf, err := PullBackup(storage, "test_backup.json.zst", true)
+		buf, err := PullBackup(storage, "", "test_backup.json.zst")
 		if err != nil {
 			t.Errorf("Unexpected error: %v", err)
 		}
@@ -177,3 +248,42 @@ func TestPullBackup(t *testing.T) {
 		}
 	})
 }
+
+func TestPullBackupChecksManifest(t *testing.T) {
+	tempDir := t.TempDir()
+	storage := LocalStorageRequirements{FolderPath: tempDir}
+
+	backupName, err := StoreBackup(storage, "dev", bytes.NewBufferString("test backup data"), zstdCompression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backupManifest, err := ReadManifest(storage, backupName)
+	if err != nil || backupManifest == nil || backupManifest.Backup != backupName || backupManifest.Database != "dev" {
+		t.Fatalf("ReadManifest() = %+v, %v", backupManifest, err)
+	}
+	if latest, err := LatestBackup(storage, "dev"); err != nil || latest != backupName {
+		t.Errorf("LatestBackup() = %s, %v, want %s", latest, err, backupName)
+	}
+
+	// Flip a byte of the stored backup
+	backupPath := filepath.Join(tempDir, backupName)
+	data, err := os.ReadFile(backupPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 0xff
+	if err := os.WriteFile(backupPath, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := PullBackup(storage, "dev", ""); !errors.Is(err, manifest.ErrChecksumMismatch) {
+		t.Errorf("PullBackup() of an altered backup error = %v, want ErrChecksumMismatch", err)
+	}
+
+	// Backups written before the manifests are still pulled
+	if err := os.Remove(filepath.Join(tempDir, manifest.Name(backupName))); err != nil {
+		t.Fatal(err)
+	}
+	if backupManifest, err := ReadManifest(storage, backupName); err != nil || backupManifest != nil {
+		t.Errorf("ReadManifest() without manifest = %+v, %v, want nil", backupManifest, err)
+	}
+}
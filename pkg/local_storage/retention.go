@@ -4,9 +4,12 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
-	"sort"
+	"strings"
 	"time"
 
+	"github.com/martient/bifrost-backups/pkg/backupname"
+	"github.com/martient/bifrost-backups/pkg/manifest"
+	"github.com/martient/bifrost-backups/pkg/retention"
 	"github.com/martient/golang-utils/utils"
 )
 
@@ -18,7 +21,7 @@ func getBackupFiles(folderPath string) ([]string, error) {
 
 	var backupFiles []string
 	for _, file := range files {
-		if !file.IsDir() {
+		if !file.IsDir() && !isTempFile(file.Name()) {
 			backupFiles = append(backupFiles, file.Name())
 		}
 	}
@@ -26,40 +29,100 @@ func getBackupFiles(folderPath string) ([]string, error) {
 	return backupFiles, nil
 }
 
-func deleteOldBackups(folderPath string, retentionDays int) error {
-	backupFiles, err := getBackupFiles(folderPath)
+// cleanupTempFiles removes the temp files left by interrupted backups
+func cleanupTempFiles(folderPath string) error {
+	files, err := os.ReadDir(folderPath)
 	if err != nil {
 		return err
 	}
 
-	sort.Strings(backupFiles)
-
-	cutoffTime := time.Now().AddDate(0, 0, -retentionDays)
-
-	for _, fileName := range backupFiles {
-		backupTime, err := time.Parse("2006-01-02T15:04:005Z", fileName)
+	cutoffTime := time.Now().Add(-orphanedTempFileAge)
+	for _, file := range files {
+		if file.IsDir() || !isTempFile(file.Name()) {
+			continue
+		}
+		info, err := file.Info()
 		if err != nil {
-			// Skip files that don't match the expected date format
-			utils.LogError("Failed to parse backup file %s: %v", fileName, err)
 			continue
 		}
-
-		if backupTime.Before(cutoffTime) {
-			filePath := filepath.Join(folderPath, fileName)
-			err = os.Remove(filePath)
-			if err != nil {
-				return fmt.Errorf("failed to delete backup file %s: %v", filePath, err)
-			}
+		// Recent temp files may belong to a backup still running
+		if info.ModTime().After(cutoffTime) {
+			continue
+		}
+		filePath := filepath.Join(folderPath, file.Name())
+		if err := os.Remove(filePath); err != nil {
+			return fmt.Errorf("failed to delete orphaned temp file %s: %v", filePath, err)
 		}
+		utils.LogInfo("Deleted orphaned temp file %s", "Local storage", filePath)
 	}
 
 	return nil
 }
 
-func ExecuteRetentionPolicy(storage LocalStorageRequirements, retention_days int) error {
+// listBackupTimes returns the backups of the database in the folder with the time parsed from their name
+func listBackupTimes(folderPath string, database_name string) ([]retention.Backup, error) {
+	backupFiles, err := getBackupFiles(folderPath)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, skipped := backupname.Filter(backupFiles, database_name)
+	for _, fileName := range skipped {
+		utils.LogWarning("Skipping backup file %s, its name isn't a backup name", "Local storage", fileName)
+	}
+	var backups []retention.Backup
+	for _, backup := range parsed {
+		entry := retention.Backup{Name: backup.Key, Time: backup.Name.Time}
+		if info, err := os.Stat(filepath.Join(folderPath, backup.Key)); err == nil {
+			entry.Size = info.Size()
+		}
+		backups = append(backups, entry)
+	}
+	return backups, nil
+}
+
+func deleteOldBackups(folderPath string, database_name string, policy retention.Policy, dryRun bool) ([]retention.Decision, error) {
+	if !dryRun {
+		if err := cleanupTempFiles(folderPath); err != nil {
+			return nil, err
+		}
+	}
+
+	backups, err := listBackupTimes(folderPath, database_name)
+	if err != nil {
+		return nil, err
+	}
+
+	decisions := policy.Evaluate(backups, time.Now())
+	for i, decision := range decisions {
+		if decision.Keep {
+			continue
+		}
+		filePath := filepath.Join(folderPath, decision.Name)
+		if dryRun {
+			utils.LogInfo("Would delete backup file %s: %s", "Local storage", filePath, strings.Join(decision.Reasons, ", "))
+			continue
+		}
+		if err := os.Remove(filePath); err != nil {
+			decisions[i].Error = err.Error()
+			return decisions, fmt.Errorf("failed to delete backup file %s: %v", filePath, err)
+		}
+		decisions[i].Deleted = true
+		if err := os.Remove(filepath.Join(folderPath, manifest.Name(decision.Name))); err != nil && !os.IsNotExist(err) {
+			utils.LogError("Failed to delete the backup manifest: %s", "Local storage", err)
+		}
+		utils.LogInfo("Deleted backup file %s: %s", "Local storage", filePath, strings.Join(decision.Reasons, ", "))
+	}
+
+	return decisions, nil
+}
+
+// ExecuteRetentionPolicy deletes the backups of the database the policy doesn't keep and
+// returns the decision made for each backup, nothing is deleted on a dry run
+func ExecuteRetentionPolicy(storage LocalStorageRequirements, database_name string, policy retention.Policy, dryRun bool) ([]retention.Decision, error) {
 	if storage == (LocalStorageRequirements{}) {
-		return fmt.Errorf("storage can't be empty")
+		return nil, fmt.Errorf("storage can't be empty")
 	}
 
-	return deleteOldBackups(storage.FolderPath, retention_days)
+	return deleteOldBackups(storage.FolderPath, database_name, policy, dryRun)
 }
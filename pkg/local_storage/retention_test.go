@@ -0,0 +1,182 @@
+package localstorage
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/martient/bifrost-backups/pkg/backupname"
+	"github.com/martient/bifrost-backups/pkg/retention"
+)
+
+func TestCleanupTempFiles(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "bifrost-backups")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tempDir); err != nil {
+			t.Errorf("Failed to remove temp directory: %v", err)
+		}
+	}()
+
+	orphaned := filepath.Join(tempDir, tempFilePrefix+"orphaned")
+	inProgress := filepath.Join(tempDir, tempFilePrefix+"in-progress")
+	for _, path := range []string{orphaned, inProgress} {
+		if err := os.WriteFile(path, []byte("partial"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Now().Add(-2 * orphanedTempFileAge)
+	if err := os.Chtimes(orphaned, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cleanupTempFiles(tempDir); err != nil {
+		t.Fatalf("cleanupTempFiles() error = %v", err)
+	}
+
+	if _, err := os.Stat(orphaned); !os.IsNotExist(err) {
+		t.Errorf("Expected orphaned temp file to be removed, got %v", err)
+	}
+	if _, err := os.Stat(inProgress); err != nil {
+		t.Errorf("Expected recent temp file to be kept, got %v", err)
+	}
+}
+
+func TestStoreBackupLeavesNoTempFile(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "bifrost-backups")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tempDir); err != nil {
+			t.Errorf("Failed to remove temp directory: %v", err)
+		}
+	}()
+
+	storage := LocalStorageRequirements{FolderPath: tempDir}
+	if _, err := StoreBackup(storage, "test", bytes.NewBufferString("test backup data"), noCompression); err != nil {
+		t.Fatalf("Failed to store backup: %v", err)
+	}
+
+	files, err := os.ReadDir(tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The backup and its manifest
+	if len(files) != 2 || isTempFile(files[0].Name()) || isTempFile(files[1].Name()) {
+		t.Errorf("Expected a single complete backup file and its manifest, got %v", files)
+	}
+}
+
+func TestExecuteRetentionPolicyKeepsOneBackupPerDay(t *testing.T) {
+	tempDir := t.TempDir()
+	now := time.Now().UTC()
+	for i := 0; i < 40; i++ {
+		// Two backups a day, the policy only keeps the newest one
+		for _, hours := range []int{0, 6} {
+			backupTime := now.AddDate(0, 0, -i).Add(-time.Duration(hours) * time.Hour)
+			if err := os.WriteFile(filepath.Join(tempDir, backupTime.Format("2006-01-02T15:04:005Z")), []byte("backup"), 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := os.WriteFile(filepath.Join(tempDir, "notes.txt"), []byte("not a backup"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	storage := LocalStorageRequirements{FolderPath: tempDir}
+	if _, err := ExecuteRetentionPolicy(storage, "", retention.Policy{KeepLast: 1, KeepDaily: 5}, false); err != nil {
+		t.Fatalf("ExecuteRetentionPolicy() error = %v", err)
+	}
+
+	backups, err := ListBackups(storage, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The 5 days window spans 5 or 6 calendar days depending on the hour
+	if len(backups) < 5 || len(backups) > 6 {
+		t.Errorf("ExecuteRetentionPolicy() kept %v, want about one backup per day for 5 days", backups)
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "notes.txt")); err != nil {
+		t.Errorf("ExecuteRetentionPolicy() deleted a file that isn't a backup: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, now.Format("2006-01-02T15:04:005Z"))); err != nil {
+		t.Errorf("ExecuteRetentionPolicy() deleted the newest backup: %v", err)
+	}
+}
+
+func TestExecuteRetentionPolicyDryRun(t *testing.T) {
+	tempDir := t.TempDir()
+	now := time.Now().UTC()
+	for i := 0; i < 3; i++ {
+		backupTime := now.AddDate(0, 0, -i*10)
+		if err := os.WriteFile(filepath.Join(tempDir, backupTime.Format("2006-01-02T15:04:005Z")), []byte("backup"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	storage := LocalStorageRequirements{FolderPath: tempDir}
+	decisions, err := ExecuteRetentionPolicy(storage, "", retention.Policy{KeepWithinDays: 5}, true)
+	if err != nil {
+		t.Fatalf("ExecuteRetentionPolicy() error = %v", err)
+	}
+	if len(decisions) != 3 || !decisions[0].Keep || decisions[1].Keep || decisions[1].Deleted {
+		t.Errorf("ExecuteRetentionPolicy() decisions = %+v, want the newest kept and nothing deleted", decisions)
+	}
+	if backups, _ := ListBackups(storage, ""); len(backups) != 3 {
+		t.Errorf("ExecuteRetentionPolicy() dry run deleted backups, %d left", len(backups))
+	}
+
+	decisions, err = ExecuteRetentionPolicy(storage, "", retention.Policy{KeepWithinDays: 5}, false)
+	if err != nil {
+		t.Fatalf("ExecuteRetentionPolicy() error = %v", err)
+	}
+	if !decisions[1].Deleted || !decisions[2].Deleted {
+		t.Errorf("ExecuteRetentionPolicy() decisions = %+v, want the old backups deleted", decisions)
+	}
+	if backups, _ := ListBackups(storage, ""); len(backups) != 1 {
+		t.Errorf("ExecuteRetentionPolicy() left %d backups, want 1", len(backups))
+	}
+}
+
+func TestExecuteRetentionPolicyLegacyNames(t *testing.T) {
+	tests := []struct {
+		name       string
+		databases  []string
+		wantLegacy bool
+	}{
+		// The legacy backups are of the only database, its retention deletes them
+		{name: "Single database", databases: []string{"dev"}, wantLegacy: false},
+		// The legacy backups could be of any database, they are left alone
+		{name: "Several databases", databases: []string{"dev", "other"}, wantLegacy: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tempDir := t.TempDir()
+			now := time.Now().UTC()
+			legacy := now.AddDate(0, 0, -30).Format("2006-01-02T15:04:005Z")
+			names := []string{legacy}
+			for _, database := range tt.databases {
+				names = append(names, backupname.New(database, now, backupname.Extension).String())
+			}
+			for _, name := range names {
+				if err := os.WriteFile(filepath.Join(tempDir, name), []byte("backup"), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			storage := LocalStorageRequirements{FolderPath: tempDir}
+			if _, err := ExecuteRetentionPolicy(storage, "dev", retention.Policy{KeepLast: 1}, false); err != nil {
+				t.Fatalf("ExecuteRetentionPolicy() error = %v", err)
+			}
+			if _, err := os.Stat(filepath.Join(tempDir, legacy)); (err == nil) != tt.wantLegacy {
+				t.Errorf("legacy backup kept = %v, want %v", err == nil, tt.wantLegacy)
+			}
+		})
+	}
+}
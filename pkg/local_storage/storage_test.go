@@ -6,6 +6,14 @@ import (
 	"path/filepath"
 	"testing"
 	"time"
+
+	"github.com/martient/bifrost-backups/pkg/compression"
+	"github.com/martient/bifrost-backups/pkg/retention"
+)
+
+var (
+	noCompression   = compression.Options{Algorithm: compression.None}
+	zstdCompression = compression.Options{Algorithm: compression.Zstd}
 )
 
 func TestLocalStorageOperations(t *testing.T) {
@@ -22,51 +30,51 @@ func TestLocalStorageOperations(t *testing.T) {
 
 	t.Run("Store operations", func(t *testing.T) {
 		tests := []struct {
-			name           string
-			storage        LocalStorageRequirements
-			buffer         *bytes.Buffer
-			useCompression bool
-			wantErr        bool
+			name        string
+			storage     LocalStorageRequirements
+			buffer      *bytes.Buffer
+			compression compression.Options
+			wantErr     bool
 		}{
 			{
 				name: "Store uncompressed backup",
 				storage: LocalStorageRequirements{
 					FolderPath: tmpDir,
 				},
-				buffer:         bytes.NewBufferString("test backup content"),
-				useCompression: false,
-				wantErr:        false,
+				buffer:      bytes.NewBufferString("test backup content"),
+				compression: noCompression,
+				wantErr:     false,
 			},
 			{
 				name: "Store compressed backup",
 				storage: LocalStorageRequirements{
 					FolderPath: tmpDir,
 				},
-				buffer:         bytes.NewBufferString("test backup content"),
-				useCompression: true,
-				wantErr:        false,
+				buffer:      bytes.NewBufferString("test backup content"),
+				compression: zstdCompression,
+				wantErr:     false,
 			},
 			{
-				name:           "Empty storage requirements",
-				storage:        LocalStorageRequirements{},
-				buffer:         bytes.NewBufferString("test backup content"),
-				useCompression: false,
-				wantErr:        true,
+				name:        "Empty storage requirements",
+				storage:     LocalStorageRequirements{},
+				buffer:      bytes.NewBufferString("test backup content"),
+				compression: noCompression,
+				wantErr:     true,
 			},
 			{
 				name: "Empty buffer",
 				storage: LocalStorageRequirements{
 					FolderPath: tmpDir,
 				},
-				buffer:         nil,
-				useCompression: false,
-				wantErr:        true,
+				buffer:      nil,
+				compression: noCompression,
+				wantErr:     true,
 			},
 		}
 
 		for _, tt := range tests {
 			t.Run(tt.name, func(t *testing.T) {
-				err := StoreBackup(tt.storage, tt.buffer, tt.useCompression)
+				_, err := StoreBackup(tt.storage, "test", tt.buffer, tt.compression)
 				if (err != nil) != tt.wantErr {
 					t.Errorf("StoreBackup() error = %v, wantErr %v", err, tt.wantErr)
 				}
@@ -98,44 +106,40 @@ func TestLocalStorageOperations(t *testing.T) {
 		}
 
 		tests := []struct {
-			name           string
-			storage        LocalStorageRequirements
-			backupName     string
-			useCompression bool
-			wantErr        bool
-			wantContent    string
+			name        string
+			storage     LocalStorageRequirements
+			backupName  string
+			wantErr     bool
+			wantContent string
 		}{
 			{
 				name: "Pull existing backup",
 				storage: LocalStorageRequirements{
 					FolderPath: tmpDir,
 				},
-				backupName:     backupName,
-				useCompression: false,
-				wantErr:        false,
-				wantContent:    testContent,
+				backupName:  backupName,
+				wantErr:     false,
+				wantContent: testContent,
 			},
 			{
 				name: "Pull non-existent backup",
 				storage: LocalStorageRequirements{
 					FolderPath: tmpDir,
 				},
-				backupName:     "nonexistent.bak",
-				useCompression: false,
-				wantErr:        true,
+				backupName: "nonexistent.bak",
+				wantErr:    true,
 			},
 			{
-				name:           "Empty storage requirements",
-				storage:        LocalStorageRequirements{},
-				backupName:     backupName,
-				useCompression: false,
-				wantErr:        true,
+				name:       "Empty storage requirements",
+				storage:    LocalStorageRequirements{},
+				backupName: backupName,
+				wantErr:    true,
 			},
 		}
 
 		for _, tt := range tests {
 			t.Run(tt.name, func(t *testing.T) {
-				buffer, err := PullBackup(tt.storage, tt.backupName, tt.useCompression)
+				buffer, err := PullBackup(tt.storage, "", tt.backupName)
 				if (err != nil) != tt.wantErr {
 					t.Errorf("PullBackup() error = %v, wantErr %v", err, tt.wantErr)
 					return
@@ -157,6 +161,8 @@ func TestLocalStorageOperations(t *testing.T) {
 	})
 
 	t.Run("Retention operations", func(t *testing.T) {
+		// The backups stored above are named after the current time, they would be counted as well
+		retentionDir := t.TempDir()
 		tests := []struct {
 			name                   string
 			storage                LocalStorageRequirements
@@ -168,7 +174,7 @@ func TestLocalStorageOperations(t *testing.T) {
 			{
 				name: "Retain 23 days",
 				storage: LocalStorageRequirements{
-					FolderPath: tmpDir,
+					FolderPath: retentionDir,
 				},
 				retentionDays:          23,
 				executeRetentionPolicy: true,
@@ -178,7 +184,7 @@ func TestLocalStorageOperations(t *testing.T) {
 			{
 				name: "Retain 15 days",
 				storage: LocalStorageRequirements{
-					FolderPath: tmpDir,
+					FolderPath: retentionDir,
 				},
 				retentionDays:          15,
 				executeRetentionPolicy: true,
@@ -188,7 +194,7 @@ func TestLocalStorageOperations(t *testing.T) {
 			{
 				name: "Retain all backups",
 				storage: LocalStorageRequirements{
-					FolderPath: tmpDir,
+					FolderPath: retentionDir,
 				},
 				executeRetentionPolicy: false,
 				wantErr:                false,
@@ -216,7 +222,7 @@ func TestLocalStorageOperations(t *testing.T) {
 
 				for _, date := range backupDates {
 					backupName := date.Format("2006-01-02T15:04:005Z")
-					backupPath := filepath.Join(tmpDir, backupName)
+					backupPath := filepath.Join(retentionDir, backupName)
 					err = os.WriteFile(backupPath, []byte("test backup"), 0644)
 					if err != nil {
 						t.Fatalf("Failed to create test backup file: %v", err)
@@ -224,7 +230,7 @@ func TestLocalStorageOperations(t *testing.T) {
 				}
 
 				if tt.executeRetentionPolicy {
-					err := ExecuteRetentionPolicy(tt.storage, tt.retentionDays)
+					_, err := ExecuteRetentionPolicy(tt.storage, "", retention.Policy{KeepWithinDays: tt.retentionDays}, false)
 					if err != nil {
 						t.Errorf("ExecuteRetentionPolicy() error = %v", err)
 						return
@@ -232,7 +238,7 @@ func TestLocalStorageOperations(t *testing.T) {
 				}
 
 				if !tt.wantErr {
-					files, err := os.ReadDir(tmpDir)
+					files, err := os.ReadDir(retentionDir)
 					if err != nil {
 						t.Errorf("Failed to read temp directory: %v", err)
 						return
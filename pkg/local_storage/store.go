@@ -5,77 +5,162 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
 	"time"
 
-	"github.com/klauspost/compress/zstd"
+	"github.com/martient/bifrost-backups/pkg/backupname"
+	"github.com/martient/bifrost-backups/pkg/compression"
+	"github.com/martient/bifrost-backups/pkg/manifest"
 	internalutils "github.com/martient/bifrost-backups/pkg/utils"
 	"github.com/martient/golang-utils/utils"
 )
 
-func StoreBackup(storage LocalStorageRequirements, buffer *bytes.Buffer, useCompression bool) error {
+// StoreBackup writes a new backup of the database and returns its name
+func StoreBackup(storage LocalStorageRequirements, database_name string, buffer *bytes.Buffer, options compression.Options) (string, error) {
 	if buffer == nil {
-		return fmt.Errorf("buffer can't be empty")
+		return "", fmt.Errorf("buffer can't be empty")
 	} else if storage == (LocalStorageRequirements{}) {
-		return fmt.Errorf("storage can't be empty")
+		return "", fmt.Errorf("storage can't be empty")
 	}
 
 	if _, err := os.Stat(storage.FolderPath); os.IsNotExist(err) {
 		err = os.MkdirAll(storage.FolderPath, 0750)
 		if err != nil {
 			utils.LogError("Folder creation went wrong", "Local storage", err)
-			return err
+			return "", err
 		}
 	}
-	currentTime := time.Now().UTC()
+	backupName := backupname.New(database_name, time.Now(), backupname.Extension).String()
 
-	backupPath := filepath.Join(storage.FolderPath, internalutils.FormatBackupTimestamp(currentTime)) // Use the imported function
+	backupPath := filepath.Join(storage.FolderPath, backupName)
 
 	// Validate backup path
 	allowedPaths := []string{storage.FolderPath}
 	if err := internalutils.ValidatePath(backupPath, allowedPaths); err != nil {
+		return "", fmt.Errorf("invalid backup path: %w", err)
+	}
+
+	dataToWrite, err := compressBackup(buffer.Bytes(), options)
+	if err != nil {
+		return "", err
+	}
+
+	if err := writeFileAtomic(storage.FolderPath, backupPath, dataToWrite); err != nil {
+		return "", err
+	}
+	return backupName, writeManifest(storage.FolderPath, manifest.New(backupName, database_name, dataToWrite))
+}
+
+// ReplaceBackup overwrites an existing backup, e.g. once re-encrypted with a new key
+func ReplaceBackup(storage LocalStorageRequirements, backup_name string, buffer *bytes.Buffer, options compression.Options) error {
+	if buffer == nil {
+		return fmt.Errorf("buffer can't be empty")
+	} else if storage == (LocalStorageRequirements{}) {
+		return fmt.Errorf("storage can't be empty")
+	} else if backup_name == "" {
+		return fmt.Errorf("backup name can't be empty")
+	}
+
+	backupPath := filepath.Join(storage.FolderPath, backup_name)
+	if err := internalutils.ValidatePath(backupPath, []string{storage.FolderPath}); err != nil {
 		return fmt.Errorf("invalid backup path: %w", err)
 	}
+	if _, err := os.Stat(backupPath); err != nil {
+		return fmt.Errorf("backup %s not found: %w", backup_name, err)
+	}
 
-	file, err := os.OpenFile(backupPath, os.O_CREATE|os.O_WRONLY, 0600) //#nosec
+	dataToWrite, err := compressBackup(buffer.Bytes(), options)
 	if err != nil {
 		return err
 	}
-	defer func() {
-		if err := file.Close(); err != nil {
-			utils.LogError("Failed to close file", "Local storage", err)
-		}
-	}()
+	if err := writeFileAtomic(storage.FolderPath, backupPath, dataToWrite); err != nil {
+		return err
+	}
+	database_name := ""
+	if name, err := backupname.Parse(backup_name); err == nil {
+		database_name = name.Database
+	}
+	return writeManifest(storage.FolderPath, manifest.New(backup_name, database_name, dataToWrite))
+}
 
-	var dataToWrite []byte
+// writeManifest writes the manifest next to its backup
+func writeManifest(folderPath string, backupManifest manifest.Manifest) error {
+	data, err := backupManifest.Marshal()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(folderPath, filepath.Join(folderPath, manifest.Name(backupManifest.Backup)), data)
+}
 
-	if useCompression {
-		encoder, err := zstd.NewWriter(nil)
-		if err != nil {
-			utils.LogError("Compression failed", "Local storage", err)
-			return err
+func compressBackup(data []byte, options compression.Options) ([]byte, error) {
+	compressed, err := compression.Compress(data, options)
+	if err != nil {
+		utils.LogError("Compression failed: %s", "Local storage", err)
+		return nil, err
+	}
+	return compressed, nil
+}
+
+// writeFileAtomic writes the data to a temp file of the folder, syncs it and renames it to path,
+// so a crash never leaves a truncated backup behind the final name
+func writeFileAtomic(folderPath string, path string, data []byte) error {
+	file, err := os.CreateTemp(folderPath, tempFilePrefix+"*")
+	if err != nil {
+		return err
+	}
+	tempPath := file.Name()
+	cleanup := func() {
+		if err := os.Remove(tempPath); err != nil && !os.IsNotExist(err) {
+			utils.LogError("Failed to remove temp file: %s", "Local storage", err)
 		}
-		defer func() {
-			if err := encoder.Close(); err != nil {
-				utils.LogError("Failed to close encoder", "Local storage", err)
-			}
-		}()
+	}
 
-		// Compress the input string
-		compressed := encoder.EncodeAll([]byte(buffer.Bytes()), nil)
-		dataToWrite = compressed
-	} else {
-		dataToWrite = buffer.Bytes()
+	if err := file.Chmod(0600); err != nil {
+		_ = file.Close()
+		cleanup()
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		_ = file.Close()
+		cleanup()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		cleanup()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		cleanup()
+		return err
 	}
 
-	_, err = file.Write(dataToWrite)
-	if err != nil {
+	if err := os.Rename(tempPath, path); err != nil {
+		cleanup()
 		return err
 	}
 
-	// _, err = buffer.WriteTo(file)
-	// if err != nil {
-	// 	return err
-	// }
+	return syncDir(folderPath)
+}
 
-	return nil
+func isTempFile(name string) bool {
+	return strings.HasPrefix(name, tempFilePrefix)
+}
+
+// syncDir persists the directory entries, directories can't be synced on windows
+func syncDir(path string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	dir, err := os.Open(filepath.Clean(path))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := dir.Close(); err != nil {
+			utils.LogError("Failed to close folder", "Local storage", err)
+		}
+	}()
+	return dir.Sync()
 }
@@ -2,14 +2,13 @@ package localstorage
 
 import (
 	"bytes"
-	"fmt"
-	"log"
 	"os"
 	"path/filepath"
 	"testing"
 	"time"
 
-	"github.com/klauspost/compress/zstd"
+	"github.com/martient/bifrost-backups/pkg/backupname"
+	"github.com/martient/bifrost-backups/pkg/compression"
 )
 
 func TestStoreBackup(t *testing.T) {
@@ -26,20 +25,17 @@ func TestStoreBackup(t *testing.T) {
 
 		storage := LocalStorageRequirements{FolderPath: tempDir}
 		buffer := bytes.NewBufferString("test data")
-		expectedFilename := fmt.Sprintf("%d-%02d-%02dT%02d:%02d:%03dZ",
-			time.Now().UTC().Year(),
-			time.Now().UTC().Month(),
-			time.Now().UTC().Day(),
-			time.Now().UTC().Hour(),
-			time.Now().UTC().Minute(),
-			time.Now().UTC().Second(),
-		)
-		expectedFilePath := filepath.Join(tempDir, expectedFilename)
-
-		err = StoreBackup(storage, buffer, false)
+		before := time.Now().UTC().Truncate(time.Microsecond)
+
+		backupName, err := StoreBackup(storage, "test", buffer, noCompression)
 		if err != nil {
 			t.Fatal(err)
 		}
+		name, err := backupname.Parse(backupName)
+		if err != nil || name.Database != "test" || name.Extension != backupname.Extension || name.Time.Before(before) {
+			t.Errorf("Expected a backup name of test taken after %s, got %s (%v)", before, backupName, err)
+		}
+		expectedFilePath := filepath.Join(tempDir, backupName)
 
 		_, err = os.Stat(expectedFilePath)
 		if err != nil {
@@ -68,20 +64,17 @@ func TestStoreBackup(t *testing.T) {
 
 		storage := LocalStorageRequirements{FolderPath: tempDir}
 		buffer := bytes.NewBufferString("test data")
-		expectedFilename := fmt.Sprintf("%d-%02d-%02dT%02d:%02d:%03dZ",
-			time.Now().UTC().Year(),
-			time.Now().UTC().Month(),
-			time.Now().UTC().Day(),
-			time.Now().UTC().Hour(),
-			time.Now().UTC().Minute(),
-			time.Now().UTC().Second(),
-		)
-		expectedFilePath := filepath.Join(tempDir, expectedFilename)
-
-		err = StoreBackup(storage, buffer, true)
+		before := time.Now().UTC().Truncate(time.Microsecond)
+
+		backupName, err := StoreBackup(storage, "test", buffer, zstdCompression)
 		if err != nil {
 			t.Fatal(err)
 		}
+		name, err := backupname.Parse(backupName)
+		if err != nil || name.Database != "test" || name.Extension != backupname.Extension || name.Time.Before(before) {
+			t.Errorf("Expected a backup name of test taken after %s, got %s (%v)", before, backupName, err)
+		}
+		expectedFilePath := filepath.Join(tempDir, backupName)
 
 		_, err = os.Stat(expectedFilePath)
 		if err != nil {
@@ -92,19 +85,11 @@ func TestStoreBackup(t *testing.T) {
 		if err != nil {
 			t.Errorf("Error reading backup file: %v", err)
 		}
-		// Decompress the data
-		decoder, err := zstd.NewReader(nil)
-		if err != nil {
-			log.Fatal(err)
-		}
-		defer decoder.Close()
-
-		// Decompress the data
-		decompressed, err := decoder.DecodeAll(data, nil)
-		if err != nil {
-			t.Fatal(err)
+		// The backup header records the algorithm
+		if algorithm, err := compression.Detect(data); err != nil || algorithm != compression.Zstd {
+			t.Errorf("Expected a zstd backup, got %s, %v", algorithm, err)
 		}
-
+		decompressed, err := compression.Decompress(data)
 		if err != nil {
 			t.Errorf("Error decompressing backup file: %v", err)
 		}
@@ -127,7 +112,7 @@ func TestStoreBackup(t *testing.T) {
 		storage := LocalStorageRequirements{FolderPath: tempDir}
 		var buffer *bytes.Buffer
 
-		err = StoreBackup(storage, buffer, false)
+		_, err = StoreBackup(storage, "test", buffer, noCompression)
 
 		if err == nil {
 			t.Error("Expected error for empty buffer, got nil")
@@ -141,7 +126,7 @@ func TestStoreBackup(t *testing.T) {
 		buffer := bytes.NewBufferString("test data")
 		var storage LocalStorageRequirements
 
-		err := StoreBackup(storage, buffer, false)
+		_, err := StoreBackup(storage, "test", buffer, noCompression)
 
 		if err == nil {
 			t.Error("Expected error for empty storage, got nil")
@@ -155,10 +140,41 @@ func TestStoreBackup(t *testing.T) {
 		storage := LocalStorageRequirements{FolderPath: "/tmp/non-existent-folder"}
 		buffer := bytes.NewBufferString("test data")
 
-		err := StoreBackup(storage, buffer, false)
+		_, err := StoreBackup(storage, "test", buffer, noCompression)
 
 		if err != nil && !os.IsNotExist(err) {
 			t.Errorf("Expected error to be 'os.IsNotExist', got '%s'", err.Error())
 		}
 	})
 }
+
+func TestReplaceBackup(t *testing.T) {
+	tempDir := t.TempDir()
+	storage := LocalStorageRequirements{FolderPath: tempDir}
+
+	if _, err := StoreBackup(storage, "test", bytes.NewBufferString("first key"), zstdCompression); err != nil {
+		t.Fatalf("Failed to store backup: %v", err)
+	}
+	names, err := ListBackups(storage, "")
+	if err != nil || len(names) != 1 {
+		t.Fatalf("ListBackups() = %v, %v, want one backup", names, err)
+	}
+
+	if err := ReplaceBackup(storage, names[0], bytes.NewBufferString("second key"), compression.Options{Algorithm: compression.Gzip, Level: 9}); err != nil {
+		t.Fatalf("ReplaceBackup() error = %v", err)
+	}
+	if err := ReplaceBackup(storage, "missing", bytes.NewBufferString("data"), zstdCompression); err == nil {
+		t.Error("ReplaceBackup() of a missing backup should fail")
+	}
+
+	replaced, err := PullBackup(storage, "", names[0])
+	if err != nil {
+		t.Fatalf("PullBackup() error = %v", err)
+	}
+	if replaced.String() != "second key" {
+		t.Errorf("PullBackup() = %q, want %q", replaced.String(), "second key")
+	}
+	if names, _ := ListBackups(storage, ""); len(names) != 1 {
+		t.Errorf("ReplaceBackup() left %d backups, want 1", len(names))
+	}
+}
@@ -0,0 +1,89 @@
+package manifest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/martient/bifrost-backups/pkg/backupname"
+	"github.com/martient/bifrost-backups/pkg/compression"
+)
+
+// FormatVersion is the version of the manifest written next to the backups
+const FormatVersion = 1
+
+// ErrChecksumMismatch is returned when a backup doesn't match its manifest
+var ErrChecksumMismatch = errors.New("backup checksum doesn't match its manifest")
+
+// Manifest describes a stored backup, the checksum covers the stored bytes (compression
+// header over the encrypted dump) so a backup is checked before being decrypted
+type Manifest struct {
+	Version     int       `json:"version"`
+	Backup      string    `json:"backup"`
+	Database    string    `json:"database,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	Size        int64     `json:"size"`
+	SHA256      string    `json:"sha256"`
+	Compression string    `json:"compression"`
+}
+
+// New returns the manifest of the stored bytes of a backup
+func New(backup string, database string, data []byte) Manifest {
+	algorithm, err := compression.Detect(data)
+	if err != nil {
+		algorithm = compression.None
+	}
+	return Manifest{
+		Version:     FormatVersion,
+		Backup:      backup,
+		Database:    database,
+		CreatedAt:   time.Now().UTC(),
+		Size:        int64(len(data)),
+		SHA256:      Checksum(data),
+		Compression: string(algorithm),
+	}
+}
+
+// Name returns the name of the manifest of a backup
+func Name(backup string) string {
+	return backup + backupname.ManifestSuffix
+}
+
+// Checksum returns the hex encoded SHA-256 of the data
+func Checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Marshal encodes the manifest as JSON
+func (manifest Manifest) Marshal() ([]byte, error) {
+	return json.MarshalIndent(manifest, "", "  ")
+}
+
+// Parse decodes a manifest
+func Parse(data []byte) (Manifest, error) {
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return Manifest{}, fmt.Errorf("invalid manifest: %w", err)
+	}
+	if manifest.Version > FormatVersion {
+		return Manifest{}, fmt.Errorf("unsupported manifest version %d", manifest.Version)
+	} else if manifest.SHA256 == "" {
+		return Manifest{}, fmt.Errorf("invalid manifest: the checksum is missing")
+	}
+	return manifest, nil
+}
+
+// Verify checks the stored bytes of the backup match the manifest
+func (manifest Manifest) Verify(data []byte) error {
+	if int64(len(data)) != manifest.Size {
+		return fmt.Errorf("%w: %s is %d bytes, %d expected", ErrChecksumMismatch, manifest.Backup, len(data), manifest.Size)
+	}
+	if Checksum(data) != manifest.SHA256 {
+		return fmt.Errorf("%w: %s", ErrChecksumMismatch, manifest.Backup)
+	}
+	return nil
+}
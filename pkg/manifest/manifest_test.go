@@ -0,0 +1,49 @@
+package manifest
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/martient/bifrost-backups/pkg/compression"
+)
+
+func TestManifest(t *testing.T) {
+	data, err := compression.Compress([]byte("encrypted dump"), compression.Options{Algorithm: compression.Gzip})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifest := New("dev_2024-06-15T03-04-05.000000Z.bifrost", "dev", data)
+	if manifest.Compression != string(compression.Gzip) || manifest.Size != int64(len(data)) {
+		t.Errorf("New() = %+v", manifest)
+	}
+	encoded, err := manifest.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := Parse(encoded)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if err := parsed.Verify(data); err != nil {
+		t.Errorf("Verify() error = %v", err)
+	}
+
+	altered := append([]byte{}, data...)
+	altered[len(altered)-1] ^= 0xff
+	if err := parsed.Verify(altered); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("Verify() of an altered backup error = %v, want ErrChecksumMismatch", err)
+	}
+	if err := parsed.Verify(data[:len(data)-1]); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("Verify() of a truncated backup error = %v, want ErrChecksumMismatch", err)
+	}
+}
+
+func TestParse(t *testing.T) {
+	invalid := []string{"", "{", `{"version": 1}`, `{"version": 99, "sha256": "00"}`}
+	for _, data := range invalid {
+		if _, err := Parse([]byte(data)); err == nil {
+			t.Errorf("Parse(%q) should fail", data)
+		}
+	}
+}
@@ -372,3 +372,34 @@ func TestBuildCommandArgsRestore(t *testing.T) {
 		})
 	}
 }
+
+func TestRunVerification(t *testing.T) {
+	tests := []struct {
+		name    string
+		backup  *bytes.Buffer
+		wantErr bool
+	}{
+		{name: "Empty backup", backup: bytes.NewBuffer(nil), wantErr: true},
+		{name: "Complete plain dump", backup: bytes.NewBufferString("--\n-- PostgreSQL database dump\n--\nCREATE TABLE t (a int);\n--\n-- PostgreSQL database dump complete\n--\n"), wantErr: false},
+		{name: "Truncated plain dump", backup: bytes.NewBufferString("--\n-- PostgreSQL database dump\n--\nCREATE TABLE t ("), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := RunVerification(tt.backup); (err != nil) != tt.wantErr {
+				t.Errorf("RunVerification() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBuildCommandArgsRestorePlain(t *testing.T) {
+	input := PostgresqlRequirements{Name: "testdb_drill", User: "testuser", Hostname: "localhost", Port: "5432"}
+	expected := []string{"-h", "localhost", "-p", "5432", "-U", "testuser", "-d", "testdb_drill", "-X", "-v", "ON_ERROR_STOP=1", "-f", "/tmp/test.sql"}
+	if result := buildCommandArgsRestorePlain(input, "/tmp/test.sql"); !reflect.DeepEqual(result, expected) {
+		t.Errorf("buildCommandArgsRestorePlain() = %v, want %v", result, expected)
+	}
+	if quoted := quoteIdentifier(`my"db`); quoted != `"my""db"` {
+		t.Errorf("quoteIdentifier() = %s", quoted)
+	}
+}
@@ -0,0 +1,95 @@
+package postgresql
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	internalutils "github.com/martient/bifrost-backups/pkg/utils"
+)
+
+// maintenanceDatabase is the database psql connects to when creating or dropping a database
+const maintenanceDatabase = "postgres"
+
+// commandEnv returns the environment of the PostgreSQL commands, a clean one with only
+// the necessary variables when the password is set
+func commandEnv(database PostgresqlRequirements) []string {
+	if database.Password == "" {
+		return nil
+	}
+	return []string{
+		"PGPASSWORD=" + database.Password,
+		"PATH=" + os.Getenv("PATH"), // Needed for pg_restore and psql
+		"HOME=" + os.Getenv("HOME"), // Needed for .pgpass
+	}
+}
+
+func connectionArgs(database PostgresqlRequirements) []string {
+	var args []string
+	if database.Hostname != "" {
+		args = append(args, "-h", database.Hostname)
+	}
+	if database.Port != "" {
+		args = append(args, "-p", database.Port)
+	}
+	if database.User != "" {
+		args = append(args, "-U", database.User)
+	}
+	if database.Name != "" {
+		args = append(args, "-d", database.Name)
+	}
+	return args
+}
+
+// quoteIdentifier quotes a database name for a SQL statement
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// RunQuery runs a query on the database with psql and returns its unaligned output
+func RunQuery(database PostgresqlRequirements, query string) (string, error) {
+	if err := validateRequirements(database); err != nil {
+		return "", err
+	}
+	if err := internalutils.ValidateQuery(query); err != nil {
+		return "", err
+	}
+	psqlPath, err := exec.LookPath(psqlCommand)
+	if err != nil {
+		return "", fmt.Errorf("psql command not found: %w", err)
+	}
+
+	args := connectionArgs(database)
+	args = append(args, "-X", "-A", "-t", "-v", "ON_ERROR_STOP=1", "-c", query)
+	if err := internalutils.ValidateCommand(psqlPath, args, allowedPgCommands); err != nil {
+		return "", fmt.Errorf("invalid command arguments: %w", err)
+	}
+
+	cmd := exec.Command(psqlPath, args...) //#nosec
+	cmd.Env = commandEnv(database)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("query failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// CreateDatabase creates the database of the requirements, e.g. a scratch database
+func CreateDatabase(database PostgresqlRequirements) error {
+	maintenance := database
+	maintenance.Name = maintenanceDatabase
+	_, err := RunQuery(maintenance, "CREATE DATABASE "+quoteIdentifier(database.Name))
+	return err
+}
+
+// DropDatabase drops the database of the requirements if it exists
+func DropDatabase(database PostgresqlRequirements) error {
+	maintenance := database
+	maintenance.Name = maintenanceDatabase
+	_, err := RunQuery(maintenance, "DROP DATABASE IF EXISTS "+quoteIdentifier(database.Name))
+	return err
+}
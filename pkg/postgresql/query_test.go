@@ -0,0 +1,24 @@
+package postgresql
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRunQueryRejectsMetaCommands(t *testing.T) {
+	database := PostgresqlRequirements{Hostname: "127.0.0.1", Port: "5432", User: "postgres", Name: "app"}
+
+	for _, query := range []string{
+		`\! touch /tmp/bifrost-marker`,
+		`  \setenv PAGER id`,
+		"\n\\o |id",
+		".shell id",
+	} {
+		t.Run(query, func(t *testing.T) {
+			_, err := RunQuery(database, query)
+			if err == nil || !strings.Contains(err.Error(), "meta-commands aren't allowed") {
+				t.Errorf("RunQuery() error = %v, want the meta-command rejected", err)
+			}
+		})
+	}
+}
@@ -11,7 +11,10 @@ import (
 	"github.com/martient/golang-utils/utils"
 )
 
-const pgRestoreCommand = "pg_restore"
+const (
+	pgRestoreCommand = "pg_restore"
+	psqlCommand      = "psql"
+)
 
 var allowedPgCommands = map[string][]string{
 	"pg_restore": {
@@ -23,6 +26,19 @@ var allowedPgCommands = map[string][]string{
 		"--no-privileges",
 		"--clean",
 		"--if-exists",
+		"-v",
+	},
+	"psql": {
+		"-X",
+		"-h",
+		"-U",
+		"-d",
+		"-p",
+		"-v",
+		"-f",
+		"-c",
+		"-A",
+		"-t",
 	},
 }
 
@@ -33,9 +49,15 @@ func RunRestoration(database PostgresqlRequirements, backup *bytes.Buffer) error
 		return err
 	}
 
-	pgRestorePath, err := exec.LookPath(pgRestoreCommand)
+	// pg_dump writes plain SQL dumps by default, pg_restore only reads the archives
+	plain := !bytes.HasPrefix(backup.Bytes(), []byte(pgArchiveMagic))
+	command := pgRestoreCommand
+	if plain {
+		command = psqlCommand
+	}
+	pgRestorePath, err := exec.LookPath(command)
 	if err != nil {
-		return fmt.Errorf("pg_dump command not found: %w", err)
+		return fmt.Errorf("%s command not found: %w", command, err)
 	}
 
 	tempFile, err := os.CreateTemp("", "pg_restore_*")
@@ -64,19 +86,15 @@ func RunRestoration(database PostgresqlRequirements, backup *bytes.Buffer) error
 	}
 
 	args := buildCommandArgsRestore(database, tempFile.Name())
+	if plain {
+		args = buildCommandArgsRestorePlain(database, tempFile.Name())
+	}
 	if err := internalutils.ValidateCommand(pgRestorePath, args, allowedPgCommands); err != nil {
 		return fmt.Errorf("invalid command arguments: %w", err)
 	}
 
 	cmd := exec.Command(pgRestorePath, args...) //#nosec
-	if database.Password != "" {
-		// Use a clean environment with only necessary variables
-		cmd.Env = []string{
-			"PGPASSWORD=" + database.Password,
-			"PATH=" + os.Getenv("PATH"), // Needed for pg_restore
-			"HOME=" + os.Getenv("HOME"), // Needed for .pgpass
-		}
-	}
+	cmd.Env = commandEnv(database)
 
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
@@ -90,6 +108,13 @@ func RunRestoration(database PostgresqlRequirements, backup *bytes.Buffer) error
 	return nil
 }
 
+// buildCommandArgsRestorePlain replays a plain dump with psql, stopping at the first error
+func buildCommandArgsRestorePlain(database PostgresqlRequirements, tempFile string) []string {
+	args := connectionArgs(database)
+	args = append(args, "-X", "-v", "ON_ERROR_STOP=1", "-f", tempFile)
+	return args
+}
+
 func buildCommandArgsRestore(database PostgresqlRequirements, tempFile string) []string {
 	var args []string
 
@@ -0,0 +1,59 @@
+package postgresql
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+)
+
+// pgArchiveMagic starts the pg_dump custom archives, plain dumps are SQL scripts
+const pgArchiveMagic = "PGDMP"
+
+// plainDumpTrailer is written by pg_dump once a plain dump is complete
+const plainDumpTrailer = "PostgreSQL database dump complete"
+
+// RunVerification checks a backup is a readable dump, the archives are listed with
+// pg_restore --list and the plain dumps must be complete
+func RunVerification(backup *bytes.Buffer) error {
+	if backup == nil || backup.Len() <= 0 {
+		return fmt.Errorf("backup can't be empty for the verification process")
+	}
+	if !bytes.HasPrefix(backup.Bytes(), []byte(pgArchiveMagic)) {
+		if !bytes.Contains(backup.Bytes(), []byte(plainDumpTrailer)) {
+			return fmt.Errorf("the plain dump is truncated, the pg_dump trailer is missing")
+		}
+		return nil
+	}
+
+	pgRestorePath, err := exec.LookPath(pgRestoreCommand)
+	if err != nil {
+		return fmt.Errorf("pg_restore command not found: %w", err)
+	}
+
+	tempFile, err := os.CreateTemp("", "pg_verify_*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer func() {
+		if err := os.Remove(tempFile.Name()); err != nil {
+			log.Printf("failed to remove temporary file: %v", err)
+		}
+	}()
+	if _, err := tempFile.Write(backup.Bytes()); err != nil {
+		_ = tempFile.Close()
+		return fmt.Errorf("failed to write backup to temporary file: %w", err)
+	}
+	if err := tempFile.Close(); err != nil {
+		return fmt.Errorf("failed to close temporary file: %w", err)
+	}
+
+	cmd := exec.Command(pgRestorePath, "--list", tempFile.Name()) //#nosec
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("pg_restore --list failed: %w: %s", err, stderr.String())
+	}
+	return nil
+}
@@ -0,0 +1,237 @@
+package retention
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Policy selects the backups kept by the retention, a backup is kept as soon as one
+// rule keeps it. The hourly, daily, weekly, monthly and yearly rules keep the newest
+// backup of each period over their window, e.g. KeepDaily 7 keeps one backup per day
+// for a week. A policy without any rule keeps every backup.
+type Policy struct {
+	KeepWithinDays int `yaml:"keep_within_days,omitempty" json:"keep_within_days,omitempty"` // Every backup of the last days
+	KeepLast       int `yaml:"keep_last,omitempty" json:"keep_last,omitempty"`
+	KeepHourly     int `yaml:"keep_hourly,omitempty" json:"keep_hourly,omitempty"` // Hours
+	KeepDaily      int `yaml:"keep_daily,omitempty" json:"keep_daily,omitempty"`   // Days
+	KeepWeekly     int `yaml:"keep_weekly,omitempty" json:"keep_weekly,omitempty"` // Weeks
+	KeepMonthly    int `yaml:"keep_monthly,omitempty" json:"keep_monthly,omitempty"`
+	KeepYearly     int `yaml:"keep_yearly,omitempty" json:"keep_yearly,omitempty"`
+	// MinKeep is the number of valid backups never deleted whatever the rules, e.g. when
+	// the clock jumps ahead. The newest valid backup is always kept even when it is 0.
+	MinKeep int `yaml:"min_keep,omitempty" json:"min_keep,omitempty"`
+}
+
+// Backup is a backup of a storage listing, empty backups aren't valid and don't count
+// for the minimum kept
+type Backup struct {
+	Name string    `json:"name"`
+	Time time.Time `json:"time"`
+	Size int64     `json:"size"`
+}
+
+// Decision tells whether a backup is kept and why, Deleted and Error are
+// filled by the storage once the decision is applied
+type Decision struct {
+	Backup
+	Keep    bool     `json:"keep"`
+	Reasons []string `json:"reasons,omitempty"`
+	Deleted bool     `json:"deleted"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// Report is the audit of a retention run on a storage
+type Report struct {
+	Database  string     `json:"database"`
+	Storage   string     `json:"storage"`
+	DryRun    bool       `json:"dry_run"`
+	Policy    Policy     `json:"policy"`
+	Time      time.Time  `json:"time"`
+	Kept      int        `json:"kept"`
+	Deleted   int        `json:"deleted"`
+	Decisions []Decision `json:"decisions"`
+}
+
+type periodRule struct {
+	name   string
+	cutoff func(now time.Time, count int) time.Time
+	period func(t time.Time) string
+}
+
+var periodRules = []periodRule{
+	{
+		name:   "hourly",
+		cutoff: func(now time.Time, count int) time.Time { return now.Add(-time.Duration(count) * time.Hour) },
+		period: func(t time.Time) string { return t.Format("2006-01-02T15") },
+	},
+	{
+		name:   "daily",
+		cutoff: func(now time.Time, count int) time.Time { return now.AddDate(0, 0, -count) },
+		period: func(t time.Time) string { return t.Format("2006-01-02") },
+	},
+	{
+		name:   "weekly",
+		cutoff: func(now time.Time, count int) time.Time { return now.AddDate(0, 0, -7*count) },
+		period: func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		},
+	},
+	{
+		name:   "monthly",
+		cutoff: func(now time.Time, count int) time.Time { return now.AddDate(0, -count, 0) },
+		period: func(t time.Time) string { return t.Format("2006-01") },
+	},
+	{
+		name:   "yearly",
+		cutoff: func(now time.Time, count int) time.Time { return now.AddDate(-count, 0, 0) },
+		period: func(t time.Time) string { return t.Format("2006") },
+	},
+}
+
+// HasRules reports whether the policy has a rule selecting the backups, the minimum
+// kept alone isn't a rule
+func (policy Policy) HasRules() bool {
+	policy.MinKeep = 0
+	return policy != Policy{}
+}
+
+// Validate checks the rules aren't negative
+func (policy Policy) Validate() error {
+	for _, rule := range periodRules {
+		if policy.count(rule.name) < 0 {
+			return fmt.Errorf("retention %s can't be negative", rule.name)
+		}
+	}
+	if policy.KeepWithinDays < 0 {
+		return fmt.Errorf("retention days can't be negative")
+	}
+	if policy.KeepLast < 0 {
+		return fmt.Errorf("retention keep last can't be negative")
+	}
+	if policy.MinKeep < 0 {
+		return fmt.Errorf("retention minimum kept can't be negative")
+	}
+	return nil
+}
+
+func (policy Policy) count(rule string) int {
+	switch rule {
+	case "hourly":
+		return policy.KeepHourly
+	case "daily":
+		return policy.KeepDaily
+	case "weekly":
+		return policy.KeepWeekly
+	case "monthly":
+		return policy.KeepMonthly
+	case "yearly":
+		return policy.KeepYearly
+	}
+	return 0
+}
+
+// Evaluate decides which backups the policy keeps, the decisions are sorted newest first.
+// The periods are computed in UTC so every host evaluates the same listing the same way.
+func (policy Policy) Evaluate(backups []Backup, now time.Time) []Decision {
+	decisions := make([]Decision, len(backups))
+	for i, backup := range backups {
+		decisions[i] = Decision{Backup: backup}
+	}
+	sort.SliceStable(decisions, func(i, j int) bool {
+		return decisions[i].Time.After(decisions[j].Time)
+	})
+	if !policy.HasRules() {
+		for i := range decisions {
+			decisions[i].Keep = true
+			decisions[i].Reasons = []string{"no retention rule"}
+		}
+		return decisions
+	}
+	now = now.UTC()
+
+	keep := func(decision *Decision, reason string) {
+		decision.Keep = true
+		decision.Reasons = append(decision.Reasons, reason)
+	}
+	superseded := make([]string, len(decisions))
+
+	for i := range decisions {
+		if i < policy.KeepLast {
+			keep(&decisions[i], "last")
+		}
+		if policy.KeepWithinDays > 0 && !decisions[i].Time.Before(now.AddDate(0, 0, -policy.KeepWithinDays)) {
+			keep(&decisions[i], "within")
+		}
+	}
+
+	for _, rule := range periodRules {
+		count := policy.count(rule.name)
+		if count <= 0 {
+			continue
+		}
+		cutoff := rule.cutoff(now, count)
+		seen := map[string]bool{}
+		for i := range decisions {
+			backupTime := decisions[i].Time.UTC()
+			if backupTime.Before(cutoff) {
+				break
+			}
+			period := rule.period(backupTime)
+			if seen[period] {
+				if superseded[i] == "" {
+					superseded[i] = fmt.Sprintf("a newer backup is kept for %s %s", rule.name, period)
+				}
+				continue
+			}
+			seen[period] = true
+			keep(&decisions[i], rule.name)
+		}
+	}
+
+	// The floor protects the newest valid backups from a wrong clock or a wrong policy
+	floor := max(policy.MinKeep, 1)
+	valid := 0
+	for i := range decisions {
+		if decisions[i].Keep && decisions[i].Size > 0 {
+			valid++
+		}
+	}
+	for i := range decisions {
+		if valid >= floor {
+			break
+		}
+		if !decisions[i].Keep && decisions[i].Size > 0 {
+			keep(&decisions[i], "minimum")
+			valid++
+		}
+	}
+
+	for i := range decisions {
+		if decisions[i].Keep {
+			continue
+		} else if superseded[i] != "" {
+			decisions[i].Reasons = []string{superseded[i]}
+		} else {
+			decisions[i].Reasons = []string{"outside of every retention rule"}
+		}
+	}
+	return decisions
+}
+
+// NewReport summarizes the decisions applied on the backups of a database in a storage
+func NewReport(database string, storage string, policy Policy, decisions []Decision, dryRun bool) Report {
+	report := Report{Database: database, Storage: storage, DryRun: dryRun, Policy: policy, Time: time.Now().UTC(), Decisions: decisions}
+	for _, decision := range decisions {
+		if decision.Keep {
+			report.Kept++
+		} else if decision.Deleted {
+			report.Deleted++
+		}
+	}
+	if report.Decisions == nil {
+		report.Decisions = []Decision{}
+	}
+	return report
+}
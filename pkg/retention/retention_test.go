@@ -0,0 +1,171 @@
+package retention
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// hourlyBackups returns a backup every hour over the days before now, newest first
+func hourlyBackups(now time.Time, days int) []Backup {
+	var backups []Backup
+	for i := 0; i < days*24; i++ {
+		backupTime := now.Add(-time.Duration(i) * time.Hour)
+		backups = append(backups, Backup{Name: backupTime.Format(time.RFC3339), Time: backupTime, Size: 1024})
+	}
+	return backups
+}
+
+func kept(decisions []Decision) []Decision {
+	var result []Decision
+	for _, decision := range decisions {
+		if decision.Keep {
+			result = append(result, decision)
+		}
+	}
+	return result
+}
+
+func TestEvaluate(t *testing.T) {
+	now := time.Date(2024, 6, 15, 12, 30, 0, 0, time.UTC)
+	backups := hourlyBackups(now, 800)
+
+	tests := []struct {
+		name   string
+		policy Policy
+		want   int
+	}{
+		{name: "No rule keeps everything", policy: Policy{}, want: len(backups)},
+		{name: "Keep last", policy: Policy{KeepLast: 5}, want: 5},
+		{name: "Keep within days", policy: Policy{KeepWithinDays: 2}, want: 49},
+		{name: "Hourly", policy: Policy{KeepHourly: 6}, want: 7},
+		{name: "Daily", policy: Policy{KeepDaily: 7}, want: 8},
+		{name: "Weekly", policy: Policy{KeepWeekly: 4}, want: 5},
+		{name: "Monthly", policy: Policy{KeepMonthly: 6}, want: 7},
+		{name: "Yearly", policy: Policy{KeepYearly: 3}, want: 3},
+		// 3 last, 7 more days, 3 more weeks and 12 more months, the newest backup of
+		// a day is also the newest of its week or month and is only counted once
+		{name: "Grandfather-father-son", policy: Policy{KeepLast: 3, KeepDaily: 7, KeepWeekly: 4, KeepMonthly: 12}, want: 3 + 7 + 3 + 12},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decisions := tt.policy.Evaluate(backups, now)
+			if len(decisions) != len(backups) {
+				t.Fatalf("Evaluate() returned %d decisions, want %d", len(decisions), len(backups))
+			}
+			if got := len(kept(decisions)); got != tt.want {
+				t.Errorf("Evaluate() kept %d backups, want %d", got, tt.want)
+			}
+			if !decisions[0].Keep {
+				t.Error("Evaluate() should keep the newest backup")
+			}
+		})
+	}
+}
+
+func TestEvaluateKeepsNewestOfEachPeriod(t *testing.T) {
+	now := time.Date(2024, 6, 15, 23, 0, 0, 0, time.UTC)
+	backups := []Backup{
+		{Name: "morning", Time: time.Date(2024, 6, 14, 8, 0, 0, 0, time.UTC)},
+		{Name: "evening", Time: time.Date(2024, 6, 14, 20, 0, 0, 0, time.UTC)},
+		{Name: "today", Time: time.Date(2024, 6, 15, 9, 0, 0, 0, time.UTC)},
+		{Name: "old", Time: time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)},
+	}
+
+	decisions := Policy{KeepDaily: 3}.Evaluate(backups, now)
+	result := map[string]Decision{}
+	for _, decision := range decisions {
+		result[decision.Name] = decision
+	}
+	if decisions[0].Name != "today" {
+		t.Errorf("Evaluate() should sort the decisions newest first, got %s", decisions[0].Name)
+	}
+	if !result["today"].Keep || !result["evening"].Keep {
+		t.Error("Evaluate() should keep the newest backup of each day")
+	}
+	if result["morning"].Keep || result["old"].Keep {
+		t.Error("Evaluate() should drop the older backups of a day and the ones out of the window")
+	}
+	if reasons := result["evening"].Reasons; len(reasons) != 1 || reasons[0] != "daily" {
+		t.Errorf("Evaluate() reasons = %v, want [daily]", reasons)
+	}
+}
+
+func TestEvaluateMinimumKept(t *testing.T) {
+	// The clock jumped years ahead, every backup is out of the rules window
+	now := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	backups := []Backup{
+		{Name: "empty", Time: time.Date(2024, 6, 15, 9, 0, 0, 0, time.UTC)},
+		{Name: "newest", Time: time.Date(2024, 6, 14, 9, 0, 0, 0, time.UTC), Size: 10},
+		{Name: "older", Time: time.Date(2024, 6, 13, 9, 0, 0, 0, time.UTC), Size: 10},
+		{Name: "oldest", Time: time.Date(2024, 6, 12, 9, 0, 0, 0, time.UTC), Size: 10},
+	}
+
+	tests := []struct {
+		name   string
+		policy Policy
+		want   []string
+	}{
+		{name: "The only valid backup is kept", policy: Policy{KeepDaily: 7}, want: []string{"newest"}},
+		{name: "Minimum kept", policy: Policy{KeepDaily: 7, MinKeep: 2}, want: []string{"newest", "older"}},
+		{name: "Minimum above the backups", policy: Policy{KeepDaily: 7, MinKeep: 10}, want: []string{"newest", "older", "oldest"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, decision := range kept(tt.policy.Evaluate(backups, now)) {
+				got = append(got, decision.Name)
+				if reasons := decision.Reasons; len(reasons) != 1 || reasons[0] != "minimum" {
+					t.Errorf("Evaluate() reasons of %s = %v, want [minimum]", decision.Name, reasons)
+				}
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("Evaluate() kept %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvaluateDeletionReasons(t *testing.T) {
+	now := time.Date(2024, 6, 15, 23, 0, 0, 0, time.UTC)
+	backups := []Backup{
+		{Name: "today", Time: time.Date(2024, 6, 15, 9, 0, 0, 0, time.UTC), Size: 10},
+		{Name: "morning", Time: time.Date(2024, 6, 15, 8, 0, 0, 0, time.UTC), Size: 10},
+		{Name: "old", Time: time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC), Size: 10},
+	}
+
+	decisions := Policy{KeepDaily: 3}.Evaluate(backups, now)
+	if decisions[1].Keep || !strings.Contains(decisions[1].Reasons[0], "daily 2024-06-15") {
+		t.Errorf("Evaluate() reasons of morning = %v, want superseded by the daily backup", decisions[1].Reasons)
+	}
+	if decisions[2].Keep || decisions[2].Reasons[0] != "outside of every retention rule" {
+		t.Errorf("Evaluate() reasons of old = %v, want outside of the rules", decisions[2].Reasons)
+	}
+
+	decisions[2].Deleted = true
+	report := NewReport("dev", "local", Policy{KeepDaily: 3}, decisions, false)
+	if report.Kept != 1 || report.Deleted != 1 {
+		t.Errorf("NewReport() kept %d and deleted %d, want 1 and 1", report.Kept, report.Deleted)
+	}
+}
+
+func TestHasRules(t *testing.T) {
+	if (Policy{MinKeep: 3}).HasRules() {
+		t.Error("HasRules() of a minimum kept alone should be false")
+	}
+	if !(Policy{KeepLast: 1}).HasRules() {
+		t.Error("HasRules() of keep last should be true")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	invalid := []Policy{{KeepLast: -1}, {KeepWithinDays: -1}, {KeepHourly: -1}, {KeepYearly: -2}, {MinKeep: -1}}
+	for _, policy := range invalid {
+		if err := policy.Validate(); err == nil {
+			t.Errorf("Validate(%+v) should fail", policy)
+		}
+	}
+	if err := (Policy{KeepLast: 3, KeepDaily: 7}).Validate(); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+}
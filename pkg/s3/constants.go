@@ -1,9 +1,80 @@
 package s3
 
+const (
+	defaultPartSizeMiB          = 100
+	minPartSizeMiB              = 5
+	maxUploadParts              = 10000
+	defaultConcurrency          = 4
+	defaultUploadRetries        = 3
+	defaultAbandonedUploadHours = 24
+)
+
+// Credentials modes supported by getS3Client
+const (
+	CredentialsStatic      = "static"
+	CredentialsDefault     = "default"
+	CredentialsProfile     = "profile"
+	CredentialsAssumeRole  = "assume_role"
+	CredentialsWebIdentity = "web_identity"
+)
+
+// Server side encryption modes supported on upload
+const (
+	EncryptionNone     = ""
+	EncryptionS3       = "AES256"
+	EncryptionKMS      = "aws:kms"
+	EncryptionCustomer = "SSE-C"
+)
+
+// Object lock retention modes
+const (
+	ObjectLockNone       = ""
+	ObjectLockGovernance = "GOVERNANCE"
+	ObjectLockCompliance = "COMPLIANCE"
+)
+
 type S3Requirements struct {
 	BucketName      string `json:"bucket_name"`
 	AccessKeyId     string `json:"access_key_id"`
 	AccessKeySecret string `json:"access_key_secret"`
 	Region          string `json:"region"`
 	Endpoint        string `json:"endpoint"`
+
+	// Credentials selection, an empty mode falls back to static keys when
+	// they are set and to the default AWS credentials chain otherwise
+	CredentialsMode      string `json:"credentials_mode,omitempty"`
+	SessionToken         string `json:"session_token,omitempty"`
+	Profile              string `json:"profile,omitempty"`
+	RoleArn              string `json:"role_arn,omitempty"`
+	ExternalId           string `json:"external_id,omitempty"`
+	RoleSessionName      string `json:"role_session_name,omitempty"`
+	WebIdentityTokenFile string `json:"web_identity_token_file,omitempty"`
+
+	// Object options applied on every upload
+	ServerSideEncryption string            `json:"server_side_encryption,omitempty"`
+	SSEKMSKeyId          string            `json:"sse_kms_key_id,omitempty"`
+	SSECustomerKey       string            `json:"sse_customer_key,omitempty"` // base64 encoded 256 bits key
+	StorageClass         string            `json:"storage_class,omitempty"`
+	Tags                 map[string]string `json:"tags,omitempty"`
+	ChecksumAlgorithm    string            `json:"checksum_algorithm,omitempty"`
+
+	// Immutable backups, the retention period follows the storage retention days
+	ObjectLockMode string `json:"object_lock_mode,omitempty"`
+	LegalHold      bool   `json:"legal_hold,omitempty"`
+
+	// Connection options for self-hosted endpoints (MinIO, Ceph, ...)
+	UsePathStyle       bool   `json:"use_path_style,omitempty"`
+	CABundle           string `json:"ca_bundle,omitempty"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"`
+	Proxy              string `json:"proxy,omitempty"`
+	TimeoutSeconds     int    `json:"timeout_seconds,omitempty"`
+	MaxAttempts        int    `json:"max_attempts,omitempty"`
+	MaxBackoffSeconds  int    `json:"max_backoff_seconds,omitempty"`
+
+	// Upload tuning, zero values fall back to the defaults
+	PartSizeMiB          int `json:"part_size_mib,omitempty"`
+	Concurrency          int `json:"concurrency,omitempty"`
+	UploadRetries        int `json:"upload_retries,omitempty"`
+	BandwidthLimitKiB    int `json:"bandwidth_limit_kib,omitempty"` // KiB per second, 0 for unlimited
+	AbandonedUploadHours int `json:"abandoned_upload_hours,omitempty"`
 }
@@ -0,0 +1,126 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+const defaultRoleSessionName = "bifrost-backups"
+
+// GetCredentialsMode returns the credentials mode in use for the storage
+func GetCredentialsMode(storage S3Requirements) string {
+	if storage.CredentialsMode != "" {
+		return storage.CredentialsMode
+	}
+	if storage.AccessKeyId != "" && storage.AccessKeySecret != "" {
+		return CredentialsStatic
+	}
+	return CredentialsDefault
+}
+
+// ValidateCredentials checks that the fields required by the credentials mode are set
+func ValidateCredentials(storage S3Requirements) error {
+	switch GetCredentialsMode(storage) {
+	case CredentialsStatic:
+		if storage.AccessKeyId == "" || storage.AccessKeySecret == "" {
+			return fmt.Errorf("access_key_id and access_key_secret can't be empty with static credentials")
+		}
+	case CredentialsDefault:
+	case CredentialsProfile:
+		if storage.Profile == "" {
+			return fmt.Errorf("profile can't be empty with profile credentials")
+		}
+	case CredentialsAssumeRole:
+		if storage.RoleArn == "" {
+			return fmt.Errorf("role_arn can't be empty with assume_role credentials")
+		}
+	case CredentialsWebIdentity:
+		if storage.RoleArn == "" || storage.WebIdentityTokenFile == "" {
+			return fmt.Errorf("role_arn and web_identity_token_file can't be empty with web_identity credentials")
+		}
+	default:
+		return fmt.Errorf("unsupported credentials mode: %s", storage.CredentialsMode)
+	}
+	return nil
+}
+
+// credentialsLoadOptions returns the base credentials options used to load the aws config
+func credentialsLoadOptions(storage S3Requirements) []func(*config.LoadOptions) error {
+	var options []func(*config.LoadOptions) error
+
+	// Static keys are also used as the source identity of an assumed role when present
+	if storage.AccessKeyId != "" && storage.AccessKeySecret != "" {
+		switch GetCredentialsMode(storage) {
+		case CredentialsStatic, CredentialsAssumeRole:
+			options = append(options, config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(
+				storage.AccessKeyId,
+				storage.AccessKeySecret,
+				storage.SessionToken,
+			)))
+		}
+	}
+
+	if storage.Profile != "" {
+		options = append(options, config.WithSharedConfigProfile(storage.Profile))
+	}
+
+	return options
+}
+
+// applyRoleCredentials wraps the loaded config credentials with the sts providers when a role is requested
+func applyRoleCredentials(cfg *aws.Config, storage S3Requirements) {
+	sessionName := storage.RoleSessionName
+	if sessionName == "" {
+		sessionName = defaultRoleSessionName
+	}
+
+	switch GetCredentialsMode(storage) {
+	case CredentialsAssumeRole:
+		provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(*cfg), storage.RoleArn, func(o *stscreds.AssumeRoleOptions) {
+			o.RoleSessionName = sessionName
+			if storage.ExternalId != "" {
+				o.ExternalID = aws.String(storage.ExternalId)
+			}
+		})
+		cfg.Credentials = aws.NewCredentialsCache(provider)
+	case CredentialsWebIdentity:
+		provider := stscreds.NewWebIdentityRoleProvider(
+			sts.NewFromConfig(*cfg),
+			storage.RoleArn,
+			stscreds.IdentityTokenFile(storage.WebIdentityTokenFile),
+			func(o *stscreds.WebIdentityRoleOptions) {
+				o.RoleSessionName = sessionName
+			},
+		)
+		cfg.Credentials = aws.NewCredentialsCache(provider)
+	}
+}
+
+func loadConfig(storage S3Requirements) (aws.Config, error) {
+	if err := ValidateCredentials(storage); err != nil {
+		return aws.Config{}, err
+	}
+
+	transportOptions, err := transportLoadOptions(storage)
+	if err != nil {
+		return aws.Config{}, err
+	}
+
+	options := credentialsLoadOptions(storage)
+	options = append(options, transportOptions...)
+	options = append(options, config.WithRegion(storage.Region))
+
+	cfg, err := config.LoadDefaultConfig(context.TODO(), options...)
+	if err != nil {
+		return aws.Config{}, err
+	}
+
+	applyRoleCredentials(&cfg, storage)
+	return cfg, nil
+}
@@ -0,0 +1,73 @@
+package s3
+
+import "testing"
+
+func TestGetCredentialsMode(t *testing.T) {
+	tests := []struct {
+		name    string
+		storage S3Requirements
+		want    string
+	}{
+		{name: "Static keys", storage: S3Requirements{AccessKeyId: "id", AccessKeySecret: "secret"}, want: CredentialsStatic},
+		{name: "Access key id only", storage: S3Requirements{AccessKeyId: "id"}, want: CredentialsDefault},
+		{name: "No keys", storage: S3Requirements{}, want: CredentialsDefault},
+		{name: "Explicit mode", storage: S3Requirements{CredentialsMode: CredentialsProfile, AccessKeyId: "id", AccessKeySecret: "secret"}, want: CredentialsProfile},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := GetCredentialsMode(tt.storage); got != tt.want {
+				t.Errorf("GetCredentialsMode() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateCredentials(t *testing.T) {
+	tests := []struct {
+		name    string
+		storage S3Requirements
+		wantErr bool
+	}{
+		{name: "Static keys", storage: S3Requirements{AccessKeyId: "id", AccessKeySecret: "secret"}},
+		{name: "Static without secret", storage: S3Requirements{CredentialsMode: CredentialsStatic, AccessKeyId: "id"}, wantErr: true},
+		{name: "Default chain", storage: S3Requirements{}},
+		{name: "Profile", storage: S3Requirements{CredentialsMode: CredentialsProfile, Profile: "backups"}},
+		{name: "Profile without name", storage: S3Requirements{CredentialsMode: CredentialsProfile}, wantErr: true},
+		{name: "Assume role", storage: S3Requirements{CredentialsMode: CredentialsAssumeRole, RoleArn: "arn:aws:iam::123456789012:role/backups"}},
+		{name: "Assume role without arn", storage: S3Requirements{CredentialsMode: CredentialsAssumeRole}, wantErr: true},
+		{name: "Web identity", storage: S3Requirements{CredentialsMode: CredentialsWebIdentity, RoleArn: "arn:aws:iam::123456789012:role/backups", WebIdentityTokenFile: "/var/run/secrets/token"}},
+		{name: "Web identity without token file", storage: S3Requirements{CredentialsMode: CredentialsWebIdentity, RoleArn: "arn:aws:iam::123456789012:role/backups"}, wantErr: true},
+		{name: "Web identity without arn", storage: S3Requirements{CredentialsMode: CredentialsWebIdentity, WebIdentityTokenFile: "/var/run/secrets/token"}, wantErr: true},
+		{name: "Unknown mode", storage: S3Requirements{CredentialsMode: "instance"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateCredentials(tt.storage); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateCredentials() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCredentialsLoadOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		storage S3Requirements
+		want    int
+	}{
+		{name: "Static keys", storage: S3Requirements{AccessKeyId: "id", AccessKeySecret: "secret"}, want: 1},
+		{name: "Assume role from static keys", storage: S3Requirements{CredentialsMode: CredentialsAssumeRole, RoleArn: "arn", AccessKeyId: "id", AccessKeySecret: "secret"}, want: 1},
+		{name: "Profile ignores the keys", storage: S3Requirements{CredentialsMode: CredentialsProfile, Profile: "backups", AccessKeyId: "id", AccessKeySecret: "secret"}, want: 1},
+		{name: "Default chain", storage: S3Requirements{}, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := len(credentialsLoadOptions(tt.storage)); got != tt.want {
+				t.Errorf("credentialsLoadOptions() = %d options, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -3,110 +3,179 @@ package s3
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
-	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/klauspost/compress/zstd"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/martient/bifrost-backups/pkg/backupname"
+	"github.com/martient/bifrost-backups/pkg/compression"
+	"github.com/martient/bifrost-backups/pkg/manifest"
+	"github.com/martient/golang-utils/utils"
 )
 
-func getBackupKey(client *s3.Client, bucket_name string) (string, error) {
-	if client == nil {
-		return "", fmt.Errorf("s3 client can't be null for the list operation")
-	} else if len(bucket_name) <= 0 {
-		return "", fmt.Errorf("the bucket need a name, can't be null at the list operation")
-	}
-
-	listObjectsInput := &s3.ListObjectsV2Input{
+// listObjects returns the backups of the database in the bucket (every backup when empty), oldest first
+func listObjects(client s3.ListObjectsV2APIClient, bucket_name string, database_name string) ([]backupname.Backup, error) {
+	p := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{
 		Bucket: aws.String(bucket_name),
-	}
-
-	p := s3.NewListObjectsV2Paginator(client, listObjectsInput)
-
-	var latestBackupKey string
-	var latestBackupTime time.Time
+	})
 
+	var backups []backupname.Backup
 	for p.HasMorePages() {
 		page, err := p.NextPage(context.TODO())
 		if err != nil {
-			return "", err
+			return nil, fmt.Errorf("failed to list objects in bucket %s: %v", bucket_name, err)
 		}
-
 		for _, obj := range page.Contents {
 			key := aws.ToString(obj.Key)
-			backupTime, err := time.Parse(time.RFC3339, key)
+			name, err := backupname.Parse(key)
 			if err != nil {
-				// Skip keys that don't match the expected date format
+				// Skip keys that aren't backup names
 				continue
 			}
-
-			if backupTime.After(latestBackupTime) {
-				latestBackupKey = key
-				latestBackupTime = backupTime
-			}
+			backups = append(backups, backupname.Backup{Key: key, Name: name, Size: aws.ToInt64(obj.Size)})
 		}
 	}
+	return backupname.Select(backups, database_name), nil
+}
 
-	if latestBackupKey == "" {
+func getBackupKey(client *s3.Client, bucket_name string, database_name string) (string, error) {
+	if client == nil {
+		return "", fmt.Errorf("s3 client can't be null for the list operation")
+	} else if len(bucket_name) <= 0 {
+		return "", fmt.Errorf("the bucket need a name, can't be null at the list operation")
+	}
+
+	backups, err := listObjects(client, bucket_name, database_name)
+	if err != nil {
+		return "", err
+	}
+	if len(backups) == 0 {
 		return "", fmt.Errorf("no backups found in bucket %s", bucket_name)
 	}
 
-	return latestBackupKey, nil
+	return backups[len(backups)-1].Key, nil
 }
 
-func PullBackup(storage S3Requirements, backup_name string, useCompression bool) (*bytes.Buffer, error) {
-	if storage == (S3Requirements{}) {
+// ListBackups returns the backup keys of the database in the bucket (every backup when empty), oldest first
+func ListBackups(storage S3Requirements, database_name string) ([]string, error) {
+	if storage.BucketName == "" {
 		return nil, fmt.Errorf("storage can't be empty")
 	}
-
 	client, err := getS3Client(storage)
 	if err != nil {
 		return nil, err
 	}
 
-	latestBackupKey := backup_name
-	if latestBackupKey == "" {
-		latestBackupKey, err = getBackupKey(client, storage.BucketName)
-		if err != nil {
-			return nil, err
-		}
+	backups, err := listObjects(client, storage.BucketName, database_name)
+	if err != nil {
+		return nil, err
+	}
+	return backupname.Keys(backups), nil
+}
+
+// LatestBackup returns the key of the newest backup of the database
+func LatestBackup(storage S3Requirements, database_name string) (string, error) {
+	if storage.BucketName == "" {
+		return "", fmt.Errorf("storage can't be empty")
 	}
+	client, err := getS3Client(storage)
+	if err != nil {
+		return "", err
+	}
+	return getBackupKey(client, storage.BucketName, database_name)
+}
 
-	obj, err := client.GetObject(context.TODO(), &s3.GetObjectInput{
+// getObject downloads an object of the bucket
+func getObject(client *s3.Client, storage S3Requirements, key string) ([]byte, error) {
+	input := &s3.GetObjectInput{
 		Bucket: aws.String(storage.BucketName),
-		Key:    aws.String(latestBackupKey),
-	})
+		Key:    aws.String(key),
+	}
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = sseCustomerParams(storage)
+	obj, err := client.GetObject(context.TODO(), input)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get object %s from bucket %s: %v", latestBackupKey, storage.BucketName, err)
+		return nil, err
 	}
 	defer func() {
 		if err := obj.Body.Close(); err != nil {
 			log.Printf("failed to close object body: %v", err)
 		}
 	}()
+	return io.ReadAll(obj.Body)
+}
 
-	buf := new(bytes.Buffer)
-	var reader io.Reader = obj.Body
+func readManifest(client *s3.Client, storage S3Requirements, backup_name string) (*manifest.Manifest, error) {
+	data, err := getObject(client, storage, manifest.Name(backup_name))
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get the manifest of %s from bucket %s: %v", backup_name, storage.BucketName, err)
+	}
+	backupManifest, err := manifest.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the manifest of %s: %w", backup_name, err)
+	}
+	return &backupManifest, nil
+}
 
-	if useCompression {
-		// Create a new Zstandard decompression reader
-		zReader, err := zstd.NewReader(obj.Body)
+// ReadManifest returns the manifest of a backup, nil when the backup has none
+// (written before the manifests)
+func ReadManifest(storage S3Requirements, backup_name string) (*manifest.Manifest, error) {
+	if storage.BucketName == "" {
+		return nil, fmt.Errorf("storage can't be empty")
+	}
+	client, err := getS3Client(storage)
+	if err != nil {
+		return nil, err
+	}
+	return readManifest(client, storage, backup_name)
+}
+
+// PullBackup downloads a backup of the storage, the newest one of the database when the name is empty.
+// The backup is checked against its manifest and its compression is detected from the backup header.
+func PullBackup(storage S3Requirements, database_name string, backup_name string) (*bytes.Buffer, error) {
+	if storage.BucketName == "" {
+		return nil, fmt.Errorf("storage can't be empty")
+	}
+
+	client, err := getS3Client(storage)
+	if err != nil {
+		return nil, err
+	}
+
+	latestBackupKey := backup_name
+	if latestBackupKey == "" {
+		latestBackupKey, err = getBackupKey(client, storage.BucketName, database_name)
 		if err != nil {
-			return nil, fmt.Errorf("failed to create new reader object %s from bucket %s: %v", latestBackupKey, storage.BucketName, err)
+			return nil, err
 		}
-		defer zReader.Close()
+	}
+
+	data, err := getObject(client, storage, latestBackupKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get object %s from bucket %s: %v", latestBackupKey, storage.BucketName, err)
+	}
 
-		// Use the zstd reader for decompression
-		reader = zReader
+	backupManifest, err := readManifest(client, storage, latestBackupKey)
+	if err != nil {
+		return nil, err
+	} else if backupManifest != nil {
+		if err := backupManifest.Verify(data); err != nil {
+			return nil, err
+		}
+	} else {
+		utils.LogDebug("Backup %s has no manifest, its checksum isn't verified", "S3", latestBackupKey)
 	}
 
-	_, err = io.Copy(buf, reader)
+	decompressed, err := compression.Decompress(data)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read object %s from bucket %s: %v", latestBackupKey, storage.BucketName, err)
+		return nil, fmt.Errorf("failed to decompress object %s from bucket %s: %v", latestBackupKey, storage.BucketName, err)
 	}
 
-	return buf, nil
+	return bytes.NewBuffer(decompressed), nil
 }
@@ -0,0 +1,480 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5" //#nosec
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/martient/golang-utils/utils"
+)
+
+// multipartClient is the part of the s3 client used by the uploads
+type multipartClient interface {
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+	CreateMultipartUpload(ctx context.Context, params *s3.CreateMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error)
+	UploadPart(ctx context.Context, params *s3.UploadPartInput, optFns ...func(*s3.Options)) (*s3.UploadPartOutput, error)
+	CompleteMultipartUpload(ctx context.Context, params *s3.CompleteMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error)
+	AbortMultipartUpload(ctx context.Context, params *s3.AbortMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error)
+	s3.ListPartsAPIClient
+	s3.ListMultipartUploadsAPIClient
+}
+
+// retryBackoff is the wait before the first retry of an upload operation, it doubles on each attempt
+var retryBackoff = time.Second
+
+// uploadState tracks an in-progress multipart upload so that it can be resumed by a later run
+type uploadState struct {
+	Endpoint  string    `json:"endpoint"`
+	Bucket    string    `json:"bucket"`
+	Key       string    `json:"key"`
+	UploadId  string    `json:"upload_id"`
+	PartSize  int64     `json:"part_size"`
+	Size      int64     `json:"size"`
+	SpoolPath string    `json:"spool_path"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// getUploadStateDir returns the folder holding the pending uploads, BIFROST_UPLOAD_STATE_DIR overrides it
+func getUploadStateDir() (string, error) {
+	if dir := os.Getenv("BIFROST_UPLOAD_STATE_DIR"); dir != "" {
+		return dir, nil
+	}
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get cache directory: %w", err)
+	}
+	return filepath.Join(cacheDir, "bifrost-backups", "uploads"), nil
+}
+
+func uploadStateName(endpoint string, bucket string, key string) string {
+	sum := sha256.Sum256([]byte(endpoint + "\x00" + bucket + "\x00" + key))
+	return hex.EncodeToString(sum[:16])
+}
+
+func (state *uploadState) statePath(dir string) string {
+	return filepath.Join(dir, uploadStateName(state.Endpoint, state.Bucket, state.Key)+".json")
+}
+
+func (state *uploadState) save(dir string) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(state.statePath(dir), data, 0600)
+}
+
+func (state *uploadState) remove(dir string) {
+	if err := os.Remove(state.SpoolPath); err != nil && !os.IsNotExist(err) {
+		utils.LogError("Failed to remove upload spool file: %s", "S3", err)
+	}
+	if err := os.Remove(state.statePath(dir)); err != nil && !os.IsNotExist(err) {
+		utils.LogError("Failed to remove upload state file: %s", "S3", err)
+	}
+}
+
+// loadUploadStates returns the pending uploads of the storage bucket
+func loadUploadStates(storage S3Requirements) ([]uploadState, string, error) {
+	dir, err := getUploadStateDir()
+	if err != nil {
+		return nil, "", err
+	}
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, dir, nil
+	} else if err != nil {
+		return nil, dir, err
+	}
+
+	var states []uploadState
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name())) //#nosec
+		if err != nil {
+			return nil, dir, err
+		}
+		var state uploadState
+		if err := json.Unmarshal(data, &state); err != nil {
+			utils.LogWarning("Ignoring unreadable upload state %s", "S3", entry.Name())
+			continue
+		}
+		if state.Endpoint == storage.Endpoint && state.Bucket == storage.BucketName {
+			states = append(states, state)
+		}
+	}
+	return states, dir, nil
+}
+
+func getPartSize(storage S3Requirements, size int64) int64 {
+	partSizeMiB := storage.PartSizeMiB
+	if partSizeMiB <= 0 {
+		partSizeMiB = defaultPartSizeMiB
+	}
+	partSize := int64(partSizeMiB) * 1024 * 1024
+
+	// Grow the parts to stay under the s3 parts limit
+	for size/partSize >= maxUploadParts {
+		partSize *= 2
+	}
+	return partSize
+}
+
+// withRetries runs the operation until it succeeds or the storage upload retries are exhausted
+func withRetries(storage S3Requirements, operation string, fn func() error) error {
+	retries := storage.UploadRetries
+	if retries <= 0 {
+		retries = defaultUploadRetries
+	}
+
+	backoff := retryBackoff
+	var err error
+	for attempt := 0; attempt <= retries; attempt++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+		if attempt < retries {
+			utils.LogWarning("%s failed (attempt %d/%d), retrying in %s: %v", "S3", operation, attempt+1, retries+1, backoff, err)
+			time.Sleep(backoff)
+			backoff *= 2
+			if backoff > 30*time.Second {
+				backoff = 30 * time.Second
+			}
+		}
+	}
+	return err
+}
+
+// putObject uploads the backup in a single request
+func putObject(client multipartClient, storage S3Requirements, input *s3.PutObjectInput, body []byte) error {
+	return withRetries(storage, fmt.Sprintf("Upload of %s", aws.ToString(input.Key)), func() error {
+		input.Body = bytes.NewReader(body)
+		_, err := client.PutObject(context.TODO(), input)
+		return err
+	})
+}
+
+// multipartUpload spools the backup on disk and uploads it in parts, the spool is kept on failure to resume the upload on the next run
+func multipartUpload(client multipartClient, storage S3Requirements, input *s3.PutObjectInput, body []byte) error {
+	dir, err := getUploadStateDir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create upload state directory: %w", err)
+	}
+
+	state := &uploadState{
+		Endpoint:  storage.Endpoint,
+		Bucket:    storage.BucketName,
+		Key:       aws.ToString(input.Key),
+		PartSize:  getPartSize(storage, int64(len(body))),
+		Size:      int64(len(body)),
+		CreatedAt: time.Now().UTC(),
+	}
+	state.SpoolPath = filepath.Join(dir, uploadStateName(state.Endpoint, state.Bucket, state.Key)+".spool")
+	if err := os.WriteFile(state.SpoolPath, body, 0600); err != nil {
+		return fmt.Errorf("failed to write upload spool file: %w", err)
+	}
+
+	var created *s3.CreateMultipartUploadOutput
+	err = withRetries(storage, fmt.Sprintf("Creation of the multipart upload %s", state.Key), func() error {
+		var err error
+		created, err = client.CreateMultipartUpload(context.TODO(), createMultipartInput(input))
+		return err
+	})
+	if err != nil {
+		state.remove(dir)
+		return fmt.Errorf("failed to create multipart upload %s: %w", state.Key, err)
+	}
+	state.UploadId = aws.ToString(created.UploadId)
+	if err := state.save(dir); err != nil {
+		// Without its state the upload can't be resumed
+		abortUpload(client, state.Bucket, state.Key, state.UploadId)
+		state.remove(dir)
+		return fmt.Errorf("failed to save upload state: %w", err)
+	}
+
+	if err := completeUpload(client, storage, state, body, nil); err != nil {
+		utils.LogWarning("Upload of %s interrupted, it will be resumed on the next run", "S3", state.Key)
+		return err
+	}
+	state.remove(dir)
+	return nil
+}
+
+// createMultipartInput copies the object options of the upload input
+func createMultipartInput(input *s3.PutObjectInput) *s3.CreateMultipartUploadInput {
+	return &s3.CreateMultipartUploadInput{
+		Bucket:                    input.Bucket,
+		Key:                       input.Key,
+		ChecksumAlgorithm:         input.ChecksumAlgorithm,
+		ObjectLockLegalHoldStatus: input.ObjectLockLegalHoldStatus,
+		ObjectLockMode:            input.ObjectLockMode,
+		ObjectLockRetainUntilDate: input.ObjectLockRetainUntilDate,
+		SSECustomerAlgorithm:      input.SSECustomerAlgorithm,
+		SSECustomerKey:            input.SSECustomerKey,
+		SSECustomerKeyMD5:         input.SSECustomerKeyMD5,
+		SSEKMSKeyId:               input.SSEKMSKeyId,
+		ServerSideEncryption:      input.ServerSideEncryption,
+		StorageClass:              input.StorageClass,
+		Tagging:                   input.Tagging,
+	}
+}
+
+// completeUpload uploads the parts missing from done and completes the multipart upload
+func completeUpload(client multipartClient, storage S3Requirements, state *uploadState, body []byte, done []types.CompletedPart) error {
+	partCount := int32((state.Size + state.PartSize - 1) / state.PartSize)
+	uploaded := map[int32]bool{}
+	for _, part := range done {
+		uploaded[aws.ToInt32(part.PartNumber)] = true
+	}
+
+	concurrency := storage.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultConcurrency
+	}
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		firstErr error
+		parts    = append([]types.CompletedPart{}, done...)
+		queue    = make(chan int32)
+	)
+
+	for worker := 0; worker < concurrency; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for number := range queue {
+				start := int64(number-1) * state.PartSize
+				end := start + state.PartSize
+				if end > state.Size {
+					end = state.Size
+				}
+				part, err := uploadPart(client, storage, state, number, body[start:end])
+				mu.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = err
+				} else if err == nil {
+					parts = append(parts, part)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for number := int32(1); number <= partCount; number++ {
+		mu.Lock()
+		failed := firstErr != nil
+		mu.Unlock()
+		if failed {
+			break
+		}
+		if !uploaded[number] {
+			queue <- number
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+
+	sort.Slice(parts, func(i, j int) bool {
+		return aws.ToInt32(parts[i].PartNumber) < aws.ToInt32(parts[j].PartNumber)
+	})
+
+	input := &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(state.Bucket),
+		Key:             aws.String(state.Key),
+		UploadId:        aws.String(state.UploadId),
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	}
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = sseCustomerParams(storage)
+	return withRetries(storage, fmt.Sprintf("Completion of %s", state.Key), func() error {
+		_, err := client.CompleteMultipartUpload(context.TODO(), input)
+		return err
+	})
+}
+
+func uploadPart(client multipartClient, storage S3Requirements, state *uploadState, number int32, body []byte) (types.CompletedPart, error) {
+	input := &s3.UploadPartInput{
+		Bucket:        aws.String(state.Bucket),
+		Key:           aws.String(state.Key),
+		UploadId:      aws.String(state.UploadId),
+		PartNumber:    aws.Int32(number),
+		ContentLength: aws.Int64(int64(len(body))),
+	}
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = sseCustomerParams(storage)
+	switch storage.ChecksumAlgorithm {
+	case "":
+	case checksumMD5:
+		digest := md5.Sum(body) //#nosec
+		input.ContentMD5 = aws.String(base64.StdEncoding.EncodeToString(digest[:]))
+	default:
+		input.ChecksumAlgorithm = types.ChecksumAlgorithm(storage.ChecksumAlgorithm)
+	}
+	if usesObjectLock(storage) && input.ContentMD5 == nil && input.ChecksumAlgorithm == "" {
+		input.ChecksumAlgorithm = types.ChecksumAlgorithmCrc32
+	}
+
+	var output *s3.UploadPartOutput
+	err := withRetries(storage, fmt.Sprintf("Upload of part %d of %s", number, state.Key), func() error {
+		input.Body = bytes.NewReader(body)
+		var err error
+		output, err = client.UploadPart(context.TODO(), input)
+		return err
+	})
+	if err != nil {
+		return types.CompletedPart{}, err
+	}
+
+	return types.CompletedPart{
+		PartNumber:     aws.Int32(number),
+		ETag:           output.ETag,
+		ChecksumCRC32:  output.ChecksumCRC32,
+		ChecksumCRC32C: output.ChecksumCRC32C,
+		ChecksumSHA1:   output.ChecksumSHA1,
+		ChecksumSHA256: output.ChecksumSHA256,
+	}, nil
+}
+
+// listUploadedParts returns the parts already stored for the multipart upload
+func listUploadedParts(client multipartClient, storage S3Requirements, state *uploadState) ([]types.CompletedPart, error) {
+	input := &s3.ListPartsInput{
+		Bucket:   aws.String(state.Bucket),
+		Key:      aws.String(state.Key),
+		UploadId: aws.String(state.UploadId),
+	}
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = sseCustomerParams(storage)
+
+	var parts []types.CompletedPart
+	p := s3.NewListPartsPaginator(client, input)
+	for p.HasMorePages() {
+		page, err := p.NextPage(context.TODO())
+		if err != nil {
+			return nil, err
+		}
+		for _, part := range page.Parts {
+			// Parts of a different size than expected can't be reused
+			expected := state.PartSize
+			if last := int64(aws.ToInt32(part.PartNumber)) * state.PartSize; last > state.Size {
+				expected = state.Size - (last - state.PartSize)
+			}
+			if aws.ToInt64(part.Size) != expected {
+				continue
+			}
+			parts = append(parts, types.CompletedPart{
+				PartNumber:     part.PartNumber,
+				ETag:           part.ETag,
+				ChecksumCRC32:  part.ChecksumCRC32,
+				ChecksumCRC32C: part.ChecksumCRC32C,
+				ChecksumSHA1:   part.ChecksumSHA1,
+				ChecksumSHA256: part.ChecksumSHA256,
+			})
+		}
+	}
+	return parts, nil
+}
+
+// resumePendingUploads finishes the multipart uploads interrupted by a previous run
+func resumePendingUploads(client multipartClient, storage S3Requirements) error {
+	states, dir, err := loadUploadStates(storage)
+	if err != nil {
+		return err
+	}
+
+	for i := range states {
+		state := &states[i]
+		body, err := os.ReadFile(state.SpoolPath)
+		if err != nil {
+			utils.LogErrorInterface("Spool of the pending upload %s is unreadable, dropping it: %v", "S3", state.Key, err)
+			abortUpload(client, state.Bucket, state.Key, state.UploadId)
+			state.remove(dir)
+			continue
+		}
+
+		done, err := listUploadedParts(client, storage, state)
+		if err != nil {
+			var noSuchUpload *types.NoSuchUpload
+			if errors.As(err, &noSuchUpload) {
+				utils.LogWarning("Pending upload %s no longer exists, dropping it", "S3", state.Key)
+				state.remove(dir)
+				continue
+			}
+			return fmt.Errorf("failed to list the parts of the pending upload %s: %w", state.Key, err)
+		}
+
+		utils.LogInfo("Resuming upload of %s (%d parts already stored)", "S3", state.Key, len(done))
+		if err := completeUpload(client, storage, state, body, done); err != nil {
+			return fmt.Errorf("failed to resume the upload of %s: %w", state.Key, err)
+		}
+		state.remove(dir)
+		utils.LogInfo("Upload of %s resumed and completed", "S3", state.Key)
+	}
+	return nil
+}
+
+func abortUpload(client multipartClient, bucket string, key string, uploadId string) {
+	_, err := client.AbortMultipartUpload(context.TODO(), &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadId),
+	})
+	if err != nil {
+		utils.LogErrorInterface("Failed to abort multipart upload %s of %s: %v", "S3", uploadId, key, err)
+	}
+}
+
+// cleanupAbandonedUploads aborts the multipart uploads older than the storage abandoned upload hours that no run can resume
+func cleanupAbandonedUploads(client multipartClient, storage S3Requirements) error {
+	hours := storage.AbandonedUploadHours
+	if hours <= 0 {
+		hours = defaultAbandonedUploadHours
+	}
+	cutoff := time.Now().Add(-time.Duration(hours) * time.Hour)
+
+	states, _, err := loadUploadStates(storage)
+	if err != nil {
+		return err
+	}
+	resumable := map[string]bool{}
+	for _, state := range states {
+		resumable[state.UploadId] = true
+	}
+
+	p := s3.NewListMultipartUploadsPaginator(client, &s3.ListMultipartUploadsInput{
+		Bucket: aws.String(storage.BucketName),
+	})
+	for p.HasMorePages() {
+		page, err := p.NextPage(context.TODO())
+		if err != nil {
+			return fmt.Errorf("failed to list multipart uploads of bucket %s: %w", storage.BucketName, err)
+		}
+		for _, upload := range page.Uploads {
+			if resumable[aws.ToString(upload.UploadId)] || upload.Initiated == nil || upload.Initiated.After(cutoff) {
+				continue
+			}
+			abortUpload(client, storage.BucketName, aws.ToString(upload.Key), aws.ToString(upload.UploadId))
+			utils.LogInfo("Aborted abandoned multipart upload of %s", "S3", aws.ToString(upload.Key))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,424 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// fakeClient keeps the uploads in memory, the operations listed in failures fail that many times
+type fakeClient struct {
+	mu          sync.Mutex
+	failures    map[string]int
+	partFails   map[int32]int
+	partCalls   map[int32]int
+	calls       map[string]int
+	uploads     map[string]map[int32][]byte
+	initiated   map[string]time.Time
+	uploadKeys  map[string]string
+	objects     map[string][]byte
+	aborted     []string
+	nextID      int
+	maxPartSize int64
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{
+		failures:   map[string]int{},
+		partFails:  map[int32]int{},
+		partCalls:  map[int32]int{},
+		calls:      map[string]int{},
+		uploads:    map[string]map[int32][]byte{},
+		initiated:  map[string]time.Time{},
+		uploadKeys: map[string]string{},
+		objects:    map[string][]byte{},
+	}
+}
+
+func (c *fakeClient) call(operation string) error {
+	c.calls[operation]++
+	if c.failures[operation] > 0 {
+		c.failures[operation]--
+		return fmt.Errorf("%s: transient failure", operation)
+	}
+	return nil
+}
+
+func (c *fakeClient) PutObject(_ context.Context, params *s3.PutObjectInput, _ ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.call("PutObject"); err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(params.Body)
+	if err != nil {
+		return nil, err
+	}
+	c.objects[aws.ToString(params.Key)] = body
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (c *fakeClient) CreateMultipartUpload(_ context.Context, params *s3.CreateMultipartUploadInput, _ ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.call("CreateMultipartUpload"); err != nil {
+		return nil, err
+	}
+	c.nextID++
+	id := fmt.Sprintf("upload-%d", c.nextID)
+	c.uploads[id] = map[int32][]byte{}
+	c.initiated[id] = time.Now()
+	c.uploadKeys[id] = aws.ToString(params.Key)
+	return &s3.CreateMultipartUploadOutput{UploadId: aws.String(id)}, nil
+}
+
+func (c *fakeClient) UploadPart(_ context.Context, params *s3.UploadPartInput, _ ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	number := aws.ToInt32(params.PartNumber)
+	c.partCalls[number]++
+	if err := c.call("UploadPart"); err != nil {
+		return nil, err
+	}
+	if c.partFails[number] > 0 {
+		c.partFails[number]--
+		return nil, fmt.Errorf("part %d: transient failure", number)
+	}
+	parts, ok := c.uploads[aws.ToString(params.UploadId)]
+	if !ok {
+		return nil, &types.NoSuchUpload{}
+	}
+	body, err := io.ReadAll(params.Body)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > c.maxPartSize {
+		c.maxPartSize = int64(len(body))
+	}
+	parts[number] = body
+	return &s3.UploadPartOutput{ETag: aws.String(fmt.Sprintf("etag-%d", number))}, nil
+}
+
+func (c *fakeClient) CompleteMultipartUpload(_ context.Context, params *s3.CompleteMultipartUploadInput, _ ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.call("CompleteMultipartUpload"); err != nil {
+		return nil, err
+	}
+	id := aws.ToString(params.UploadId)
+	parts, ok := c.uploads[id]
+	if !ok {
+		return nil, &types.NoSuchUpload{}
+	}
+	var object []byte
+	for i, part := range params.MultipartUpload.Parts {
+		number := aws.ToInt32(part.PartNumber)
+		if number != int32(i+1) {
+			return nil, fmt.Errorf("part %d listed at position %d", number, i+1)
+		}
+		body, ok := parts[number]
+		if !ok {
+			return nil, fmt.Errorf("part %d not uploaded", number)
+		}
+		object = append(object, body...)
+	}
+	c.objects[aws.ToString(params.Key)] = object
+	delete(c.uploads, id)
+	return &s3.CompleteMultipartUploadOutput{}, nil
+}
+
+func (c *fakeClient) AbortMultipartUpload(_ context.Context, params *s3.AbortMultipartUploadInput, _ ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id := aws.ToString(params.UploadId)
+	c.aborted = append(c.aborted, id)
+	delete(c.uploads, id)
+	return &s3.AbortMultipartUploadOutput{}, nil
+}
+
+func (c *fakeClient) ListParts(_ context.Context, params *s3.ListPartsInput, _ ...func(*s3.Options)) (*s3.ListPartsOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	parts, ok := c.uploads[aws.ToString(params.UploadId)]
+	if !ok {
+		return nil, &types.NoSuchUpload{}
+	}
+	output := &s3.ListPartsOutput{}
+	for number, body := range parts {
+		output.Parts = append(output.Parts, types.Part{
+			PartNumber: aws.Int32(number),
+			Size:       aws.Int64(int64(len(body))),
+			ETag:       aws.String(fmt.Sprintf("etag-%d", number)),
+		})
+	}
+	return output, nil
+}
+
+func (c *fakeClient) ListMultipartUploads(_ context.Context, _ *s3.ListMultipartUploadsInput, _ ...func(*s3.Options)) (*s3.ListMultipartUploadsOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	output := &s3.ListMultipartUploadsOutput{}
+	for id := range c.uploads {
+		output.Uploads = append(output.Uploads, types.MultipartUpload{
+			UploadId:  aws.String(id),
+			Key:       aws.String(c.uploadKeys[id]),
+			Initiated: aws.Time(c.initiated[id]),
+		})
+	}
+	return output, nil
+}
+
+func newFakeStorage(t *testing.T) S3Requirements {
+	t.Helper()
+	t.Setenv("BIFROST_UPLOAD_STATE_DIR", t.TempDir())
+	originalBackoff := retryBackoff
+	retryBackoff = time.Millisecond
+	t.Cleanup(func() { retryBackoff = originalBackoff })
+	return S3Requirements{BucketName: "backups", Endpoint: "https://s3.example.com", PartSizeMiB: minPartSizeMiB, UploadRetries: 2}
+}
+
+func randomBody(t *testing.T, size int) []byte {
+	t.Helper()
+	body := make([]byte, size)
+	if _, err := rand.Read(body); err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func uploadStateEntries(t *testing.T) []string {
+	t.Helper()
+	entries, err := os.ReadDir(os.Getenv("BIFROST_UPLOAD_STATE_DIR"))
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+func TestGetPartSize(t *testing.T) {
+	const mib = 1024 * 1024
+	tests := []struct {
+		name        string
+		partSizeMiB int
+		size        int64
+		want        int64
+	}{
+		{name: "Default part size", size: 10 * mib, want: defaultPartSizeMiB * mib},
+		{name: "Storage part size", partSizeMiB: 8, size: 100 * mib, want: 8 * mib},
+		{name: "Last part under the limit", partSizeMiB: 5, size: 5 * mib * (maxUploadParts - 1), want: 5 * mib},
+		{name: "Grown to the parts limit", partSizeMiB: 5, size: 5 * mib * maxUploadParts, want: 10 * mib},
+		{name: "Grown for a large backup", partSizeMiB: 5, size: 200 * 1024 * mib, want: 40 * mib},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := getPartSize(S3Requirements{PartSizeMiB: tt.partSizeMiB}, tt.size)
+			if got != tt.want {
+				t.Errorf("getPartSize() = %d, want %d", got, tt.want)
+			}
+			if parts := (tt.size + got - 1) / got; parts > maxUploadParts {
+				t.Errorf("getPartSize() needs %d parts, over the %d limit", parts, maxUploadParts)
+			}
+		})
+	}
+}
+
+func TestWithRetries(t *testing.T) {
+	storage := newFakeStorage(t)
+
+	tests := []struct {
+		name      string
+		retries   int
+		failures  int
+		wantCalls int
+		wantErr   bool
+	}{
+		{name: "First attempt", retries: 2, failures: 0, wantCalls: 1},
+		{name: "Succeeds on the last retry", retries: 2, failures: 2, wantCalls: 3},
+		{name: "Retries exhausted", retries: 2, failures: 3, wantCalls: 3, wantErr: true},
+		{name: "Default retries", retries: 0, failures: 10, wantCalls: defaultUploadRetries + 1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage.UploadRetries = tt.retries
+			calls := 0
+			err := withRetries(storage, "Operation", func() error {
+				calls++
+				if calls <= tt.failures {
+					return errors.New("transient failure")
+				}
+				return nil
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("withRetries() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("withRetries() calls = %d, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestMultipartUploadRetries(t *testing.T) {
+	storage := newFakeStorage(t)
+	client := newFakeClient()
+	client.failures["CreateMultipartUpload"] = 2
+	client.failures["UploadPart"] = 2
+	client.failures["CompleteMultipartUpload"] = 1
+
+	body := randomBody(t, 11*1024*1024)
+	input := &s3.PutObjectInput{Bucket: aws.String(storage.BucketName), Key: aws.String("app.bifrost")}
+	if err := multipartUpload(client, storage, input, body); err != nil {
+		t.Fatalf("multipartUpload() error = %v", err)
+	}
+	if !bytes.Equal(client.objects["app.bifrost"], body) {
+		t.Error("the uploaded object doesn't match the backup")
+	}
+	if client.calls["CreateMultipartUpload"] != 3 {
+		t.Errorf("CreateMultipartUpload calls = %d, want 3", client.calls["CreateMultipartUpload"])
+	}
+	if client.maxPartSize != int64(minPartSizeMiB)*1024*1024 {
+		t.Errorf("largest part = %d bytes, want %d", client.maxPartSize, minPartSizeMiB*1024*1024)
+	}
+	if entries := uploadStateEntries(t); len(entries) != 0 {
+		t.Errorf("upload state left behind: %v", entries)
+	}
+}
+
+func TestMultipartUploadCreationFailure(t *testing.T) {
+	storage := newFakeStorage(t)
+	client := newFakeClient()
+	client.failures["CreateMultipartUpload"] = storage.UploadRetries + 1
+
+	input := &s3.PutObjectInput{Bucket: aws.String(storage.BucketName), Key: aws.String("app.bifrost")}
+	if err := multipartUpload(client, storage, input, randomBody(t, 6*1024*1024)); err == nil {
+		t.Fatal("multipartUpload() should fail once the retries are exhausted")
+	}
+	if entries := uploadStateEntries(t); len(entries) != 0 {
+		t.Errorf("upload state left behind: %v", entries)
+	}
+}
+
+func TestMultipartUploadStateSaveFailure(t *testing.T) {
+	storage := newFakeStorage(t)
+	client := newFakeClient()
+
+	// A folder in place of the state file makes the state unsavable
+	input := &s3.PutObjectInput{Bucket: aws.String(storage.BucketName), Key: aws.String("app.bifrost")}
+	state := &uploadState{Endpoint: storage.Endpoint, Bucket: storage.BucketName, Key: "app.bifrost"}
+	if err := os.MkdirAll(state.statePath(os.Getenv("BIFROST_UPLOAD_STATE_DIR")), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := multipartUpload(client, storage, input, randomBody(t, 6*1024*1024)); err == nil {
+		t.Fatal("multipartUpload() should fail when the upload state can't be saved")
+	}
+	if len(client.aborted) != 1 || len(client.uploads) != 0 {
+		t.Errorf("aborted uploads = %v, pending = %d, want the created upload aborted", client.aborted, len(client.uploads))
+	}
+	if client.calls["UploadPart"] != 0 {
+		t.Errorf("UploadPart calls = %d, want 0", client.calls["UploadPart"])
+	}
+	if entries := uploadStateEntries(t); len(entries) != 0 {
+		t.Errorf("upload spool left behind: %v", entries)
+	}
+}
+
+func TestMultipartUploadResume(t *testing.T) {
+	storage := newFakeStorage(t)
+	storage.Concurrency = 1
+	client := newFakeClient()
+	client.partFails[2] = storage.UploadRetries + 1
+
+	body := randomBody(t, 12*1024*1024)
+	input := &s3.PutObjectInput{Bucket: aws.String(storage.BucketName), Key: aws.String("app.bifrost")}
+	if err := multipartUpload(client, storage, input, body); err == nil {
+		t.Fatal("multipartUpload() should fail once the part retries are exhausted")
+	}
+	if len(uploadStateEntries(t)) != 2 {
+		t.Fatalf("upload state = %v, want the state and the spool kept", uploadStateEntries(t))
+	}
+	if _, ok := client.objects["app.bifrost"]; ok {
+		t.Fatal("the interrupted upload shouldn't be completed")
+	}
+
+	client.partCalls = map[int32]int{}
+	if err := resumePendingUploads(client, storage); err != nil {
+		t.Fatalf("resumePendingUploads() error = %v", err)
+	}
+	if !bytes.Equal(client.objects["app.bifrost"], body) {
+		t.Error("the resumed object doesn't match the backup")
+	}
+	if client.partCalls[1] != 0 {
+		t.Errorf("part 1 uploaded %d times on resume, want it reused", client.partCalls[1])
+	}
+	if client.partCalls[2] != 1 {
+		t.Errorf("part 2 uploaded %d times on resume, want 1", client.partCalls[2])
+	}
+	if entries := uploadStateEntries(t); len(entries) != 0 {
+		t.Errorf("upload state left behind: %v", entries)
+	}
+}
+
+func TestResumeDropsUnknownUploads(t *testing.T) {
+	storage := newFakeStorage(t)
+	client := newFakeClient()
+
+	dir := os.Getenv("BIFROST_UPLOAD_STATE_DIR")
+	state := &uploadState{Endpoint: storage.Endpoint, Bucket: storage.BucketName, Key: "app.bifrost", UploadId: "expired", PartSize: 5 * 1024 * 1024, Size: 6 * 1024 * 1024}
+	state.SpoolPath = filepath.Join(dir, "app.spool")
+	if err := os.WriteFile(state.SpoolPath, randomBody(t, int(state.Size)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.save(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := resumePendingUploads(client, storage); err != nil {
+		t.Fatalf("resumePendingUploads() error = %v", err)
+	}
+	if entries := uploadStateEntries(t); len(entries) != 0 {
+		t.Errorf("upload state left behind: %v", entries)
+	}
+}
+
+func TestCleanupAbandonedUploads(t *testing.T) {
+	storage := newFakeStorage(t)
+	storage.AbandonedUploadHours = 1
+	client := newFakeClient()
+
+	for _, id := range []string{"abandoned", "recent", "resumable"} {
+		client.uploads[id] = map[int32][]byte{}
+		client.uploadKeys[id] = id + ".bifrost"
+		client.initiated[id] = time.Now().Add(-2 * time.Hour)
+	}
+	client.initiated["recent"] = time.Now()
+	state := &uploadState{Endpoint: storage.Endpoint, Bucket: storage.BucketName, Key: "resumable.bifrost", UploadId: "resumable"}
+	if err := state.save(os.Getenv("BIFROST_UPLOAD_STATE_DIR")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cleanupAbandonedUploads(client, storage); err != nil {
+		t.Fatalf("cleanupAbandonedUploads() error = %v", err)
+	}
+	if len(client.aborted) != 1 || client.aborted[0] != "abandoned" {
+		t.Errorf("aborted uploads = %v, want [abandoned]", client.aborted)
+	}
+}
@@ -0,0 +1,122 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// ValidateObjectLock checks the object lock options of the storage
+func ValidateObjectLock(storage S3Requirements) error {
+	switch storage.ObjectLockMode {
+	case ObjectLockNone, ObjectLockGovernance, ObjectLockCompliance:
+		return nil
+	default:
+		return fmt.Errorf("unsupported object lock mode: %s", storage.ObjectLockMode)
+	}
+}
+
+func usesObjectLock(storage S3Requirements) bool {
+	return storage.ObjectLockMode != ObjectLockNone || storage.LegalHold
+}
+
+// objectLockConfigurationClient is the part of the s3 client reading the bucket object lock
+type objectLockConfigurationClient interface {
+	GetObjectLockConfiguration(ctx context.Context, params *s3.GetObjectLockConfigurationInput, optFns ...func(*s3.Options)) (*s3.GetObjectLockConfigurationOutput, error)
+}
+
+// bucketObjectLockEnabled reports whether object lock is enabled on the bucket
+func bucketObjectLockEnabled(client objectLockConfigurationClient, bucket_name string) (bool, error) {
+	lock, err := client.GetObjectLockConfiguration(context.TODO(), &s3.GetObjectLockConfigurationInput{
+		Bucket: aws.String(bucket_name),
+	})
+	if err != nil {
+		return false, err
+	}
+	return lock.ObjectLockConfiguration != nil && lock.ObjectLockConfiguration.ObjectLockEnabled == types.ObjectLockEnabledEnabled, nil
+}
+
+// mayLockObjects reports whether the objects of the storage can be locked, either by the
+// storage options or by object lock enabled on the bucket outside of bifrost. A bucket whose
+// configuration can't be read is considered unlocked, deleting a locked object just fails.
+func mayLockObjects(client objectLockConfigurationClient, storage S3Requirements) bool {
+	if usesObjectLock(storage) {
+		return true
+	}
+	enabled, err := bucketObjectLockEnabled(client, storage.BucketName)
+	return err == nil && enabled
+}
+
+// applyObjectLock sets the retention and legal hold of the uploaded object
+func applyObjectLock(input *s3.PutObjectInput, storage S3Requirements, retentionDays int) {
+	if storage.ObjectLockMode != ObjectLockNone && retentionDays > 0 {
+		input.ObjectLockMode = types.ObjectLockMode(storage.ObjectLockMode)
+		input.ObjectLockRetainUntilDate = aws.Time(time.Now().UTC().AddDate(0, 0, retentionDays))
+	}
+	if storage.LegalHold {
+		input.ObjectLockLegalHoldStatus = types.ObjectLockLegalHoldStatusOn
+	}
+
+	// Locked uploads must carry an integrity checksum
+	if usesObjectLock(storage) && input.ContentMD5 == nil && input.ChecksumAlgorithm == "" {
+		input.ChecksumAlgorithm = types.ChecksumAlgorithmCrc32
+	}
+}
+
+// isObjectLocked reports whether the object is still under retention or legal hold
+func isObjectLocked(client s3.HeadObjectAPIClient, storage S3Requirements, key string) (bool, error) {
+	input := &s3.HeadObjectInput{
+		Bucket: aws.String(storage.BucketName),
+		Key:    aws.String(key),
+	}
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = sseCustomerParams(storage)
+
+	head, err := client.HeadObject(context.TODO(), input)
+	if err != nil {
+		return false, err
+	}
+
+	if head.ObjectLockLegalHoldStatus == types.ObjectLockLegalHoldStatusOn {
+		return true, nil
+	}
+	if head.ObjectLockRetainUntilDate != nil && head.ObjectLockRetainUntilDate.After(time.Now()) {
+		return true, nil
+	}
+	return false, nil
+}
+
+// CheckBucketProtection verifies the bucket has versioning and object lock enabled
+func CheckBucketProtection(storage S3Requirements) error {
+	if storage.BucketName == "" {
+		return fmt.Errorf("storage can't be empty")
+	}
+
+	client, err := getS3Client(storage)
+	if err != nil {
+		return err
+	}
+
+	versioning, err := client.GetBucketVersioning(context.TODO(), &s3.GetBucketVersioningInput{
+		Bucket: aws.String(storage.BucketName),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get versioning of bucket %s: %v", storage.BucketName, err)
+	}
+	if versioning.Status != types.BucketVersioningStatusEnabled {
+		return fmt.Errorf("versioning is not enabled on bucket %s", storage.BucketName)
+	}
+
+	enabled, err := bucketObjectLockEnabled(client, storage.BucketName)
+	if err != nil {
+		return fmt.Errorf("failed to get object lock configuration of bucket %s: %v", storage.BucketName, err)
+	}
+	if !enabled {
+		return fmt.Errorf("object lock is not enabled on bucket %s", storage.BucketName)
+	}
+
+	return nil
+}
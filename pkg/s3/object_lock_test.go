@@ -0,0 +1,295 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/martient/bifrost-backups/pkg/backupname"
+	"github.com/martient/bifrost-backups/pkg/compression"
+	"github.com/martient/bifrost-backups/pkg/manifest"
+	"github.com/martient/bifrost-backups/pkg/retention"
+)
+
+// fakeBucket holds objects with their lock status, locked objects can't be deleted
+type fakeBucket struct {
+	objects     map[string]*s3.HeadObjectOutput
+	lockEnabled bool
+	deleted     []string
+	deleteCalls int
+	headCalls   int
+}
+
+func newFakeBucket() *fakeBucket {
+	return &fakeBucket{objects: map[string]*s3.HeadObjectOutput{}}
+}
+
+func (b *fakeBucket) locked(key string) bool {
+	head := b.objects[key]
+	return head.ObjectLockLegalHoldStatus == types.ObjectLockLegalHoldStatusOn ||
+		(head.ObjectLockRetainUntilDate != nil && head.ObjectLockRetainUntilDate.After(time.Now()))
+}
+
+func (b *fakeBucket) ListObjectsV2(_ context.Context, _ *s3.ListObjectsV2Input, _ ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	output := &s3.ListObjectsV2Output{}
+	for key := range b.objects {
+		output.Contents = append(output.Contents, types.Object{Key: aws.String(key), Size: aws.Int64(10)})
+	}
+	sort.Slice(output.Contents, func(i, j int) bool {
+		return aws.ToString(output.Contents[i].Key) < aws.ToString(output.Contents[j].Key)
+	})
+	return output, nil
+}
+
+func (b *fakeBucket) HeadObject(_ context.Context, params *s3.HeadObjectInput, _ ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	b.headCalls++
+	head, ok := b.objects[aws.ToString(params.Key)]
+	if !ok {
+		return nil, &types.NotFound{}
+	}
+	return head, nil
+}
+
+func (b *fakeBucket) GetObjectLockConfiguration(_ context.Context, _ *s3.GetObjectLockConfigurationInput, _ ...func(*s3.Options)) (*s3.GetObjectLockConfigurationOutput, error) {
+	if !b.lockEnabled {
+		return nil, fmt.Errorf("ObjectLockConfigurationNotFoundError")
+	}
+	return &s3.GetObjectLockConfigurationOutput{
+		ObjectLockConfiguration: &types.ObjectLockConfiguration{ObjectLockEnabled: types.ObjectLockEnabledEnabled},
+	}, nil
+}
+
+func (b *fakeBucket) DeleteObject(_ context.Context, params *s3.DeleteObjectInput, _ ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	b.deleteCalls++
+	key := aws.ToString(params.Key)
+	if _, ok := b.objects[key]; ok && b.locked(key) {
+		return nil, fmt.Errorf("object %s is WORM protected", key)
+	}
+	delete(b.objects, key)
+	b.deleted = append(b.deleted, key)
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func TestValidateObjectLock(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    string
+		wantErr bool
+	}{
+		{name: "No lock", mode: ObjectLockNone},
+		{name: "Governance", mode: ObjectLockGovernance},
+		{name: "Compliance", mode: ObjectLockCompliance},
+		{name: "Lower case", mode: "governance", wantErr: true},
+		{name: "Unknown mode", mode: "LEGAL", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateObjectLock(S3Requirements{ObjectLockMode: tt.mode}); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateObjectLock() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestApplyObjectLock(t *testing.T) {
+	tests := []struct {
+		name          string
+		storage       S3Requirements
+		retentionDays int
+		contentMD5    bool
+		wantMode      types.ObjectLockMode
+		wantLegalHold types.ObjectLockLegalHoldStatus
+		wantChecksum  types.ChecksumAlgorithm
+	}{
+		{name: "No lock", storage: S3Requirements{}, retentionDays: 7},
+		{name: "Governance", storage: S3Requirements{ObjectLockMode: ObjectLockGovernance}, retentionDays: 7, wantMode: types.ObjectLockModeGovernance, wantChecksum: types.ChecksumAlgorithmCrc32},
+		{name: "Compliance", storage: S3Requirements{ObjectLockMode: ObjectLockCompliance}, retentionDays: 30, wantMode: types.ObjectLockModeCompliance, wantChecksum: types.ChecksumAlgorithmCrc32},
+		{name: "Mode without retention days", storage: S3Requirements{ObjectLockMode: ObjectLockGovernance}, retentionDays: 0, wantChecksum: types.ChecksumAlgorithmCrc32},
+		{name: "Legal hold", storage: S3Requirements{LegalHold: true}, wantLegalHold: types.ObjectLockLegalHoldStatusOn, wantChecksum: types.ChecksumAlgorithmCrc32},
+		{name: "Content MD5 kept", storage: S3Requirements{ObjectLockMode: ObjectLockGovernance}, retentionDays: 7, contentMD5: true, wantMode: types.ObjectLockModeGovernance},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := &s3.PutObjectInput{}
+			if tt.contentMD5 {
+				input.ContentMD5 = aws.String("QCBR9L4Mw6rTO886w9ZTKw==")
+			}
+			applyObjectLock(input, tt.storage, tt.retentionDays)
+
+			if input.ObjectLockMode != tt.wantMode {
+				t.Errorf("ObjectLockMode = %v, want %v", input.ObjectLockMode, tt.wantMode)
+			}
+			if tt.wantMode == "" && input.ObjectLockRetainUntilDate != nil {
+				t.Errorf("ObjectLockRetainUntilDate = %v, want none", input.ObjectLockRetainUntilDate)
+			} else if tt.wantMode != "" {
+				want := time.Now().UTC().AddDate(0, 0, tt.retentionDays)
+				if until := aws.ToTime(input.ObjectLockRetainUntilDate); until.Sub(want).Abs() > time.Minute {
+					t.Errorf("ObjectLockRetainUntilDate = %v, want %v", until, want)
+				}
+			}
+			if input.ObjectLockLegalHoldStatus != tt.wantLegalHold {
+				t.Errorf("ObjectLockLegalHoldStatus = %v, want %v", input.ObjectLockLegalHoldStatus, tt.wantLegalHold)
+			}
+			if input.ChecksumAlgorithm != tt.wantChecksum {
+				t.Errorf("ChecksumAlgorithm = %v, want %v", input.ChecksumAlgorithm, tt.wantChecksum)
+			}
+		})
+	}
+}
+
+func TestIsObjectLocked(t *testing.T) {
+	bucket := newFakeBucket()
+	bucket.objects["retained"] = &s3.HeadObjectOutput{ObjectLockRetainUntilDate: aws.Time(time.Now().Add(time.Hour))}
+	bucket.objects["expired"] = &s3.HeadObjectOutput{ObjectLockRetainUntilDate: aws.Time(time.Now().Add(-time.Hour))}
+	bucket.objects["held"] = &s3.HeadObjectOutput{ObjectLockLegalHoldStatus: types.ObjectLockLegalHoldStatusOn}
+	bucket.objects["released"] = &s3.HeadObjectOutput{ObjectLockLegalHoldStatus: types.ObjectLockLegalHoldStatusOff}
+	bucket.objects["unlocked"] = &s3.HeadObjectOutput{}
+
+	tests := []struct {
+		key     string
+		want    bool
+		wantErr bool
+	}{
+		{key: "retained", want: true},
+		{key: "expired", want: false},
+		{key: "held", want: true},
+		{key: "released", want: false},
+		{key: "unlocked", want: false},
+		{key: "missing", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			got, err := isObjectLocked(bucket, S3Requirements{BucketName: "backups"}, tt.key)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("isObjectLocked() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("isObjectLocked() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeleteOldBackupsSkipsLockedObjects(t *testing.T) {
+	tests := []struct {
+		name          string
+		storage       S3Requirements
+		lockEnabled   bool
+		dryRun        bool
+		wantHeads     int
+		wantDeletions int
+	}{
+		// The expired objects are checked, only the one whose retention ended is deleted with its manifest
+		{name: "Storage lock mode", storage: S3Requirements{BucketName: "backups", ObjectLockMode: ObjectLockGovernance}, wantHeads: 3, wantDeletions: 2},
+		{name: "Storage legal hold", storage: S3Requirements{BucketName: "backups", LegalHold: true}, wantHeads: 3, wantDeletions: 2},
+		{name: "Bucket lock enabled", storage: S3Requirements{BucketName: "backups"}, lockEnabled: true, wantHeads: 3, wantDeletions: 2},
+		{name: "Dry run", storage: S3Requirements{BucketName: "backups", ObjectLockMode: ObjectLockGovernance}, dryRun: true, wantHeads: 3, wantDeletions: 0},
+		// Without object lock no HeadObject is sent, the deletion of the locked objects fails
+		{name: "No object lock", storage: S3Requirements{BucketName: "backups"}, wantHeads: 0, wantDeletions: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Now()
+			bucket := newFakeBucket()
+			bucket.lockEnabled = tt.lockEnabled
+			add := func(days int, head *s3.HeadObjectOutput) string {
+				key := backupname.New("app", now.AddDate(0, 0, -days), backupname.Extension).String()
+				bucket.objects[key] = head
+				return key
+			}
+			add(1, &s3.HeadObjectOutput{})
+			retained := add(10, &s3.HeadObjectOutput{ObjectLockRetainUntilDate: aws.Time(now.Add(24 * time.Hour))})
+			held := add(11, &s3.HeadObjectOutput{ObjectLockLegalHoldStatus: types.ObjectLockLegalHoldStatusOn})
+			expired := add(12, &s3.HeadObjectOutput{ObjectLockRetainUntilDate: aws.Time(now.Add(-24 * time.Hour))})
+
+			decisions, err := deleteOldBackups(bucket, tt.storage, "app", retention.Policy{KeepLast: 1}, tt.dryRun)
+			if err != nil {
+				t.Fatalf("deleteOldBackups() error = %v", err)
+			}
+			wantDeleted := []string{expired, manifest.Name(expired)}
+			if tt.dryRun {
+				wantDeleted = nil
+			}
+			if fmt.Sprint(bucket.deleted) != fmt.Sprint(wantDeleted) {
+				t.Errorf("deleted %v, want %v", bucket.deleted, wantDeleted)
+			}
+			if bucket.headCalls != tt.wantHeads || bucket.deleteCalls != tt.wantDeletions {
+				t.Errorf("%d HeadObject and %d DeleteObject calls, want %d and %d", bucket.headCalls, bucket.deleteCalls, tt.wantHeads, tt.wantDeletions)
+			}
+			if tt.wantHeads == 0 {
+				return
+			}
+			for _, decision := range decisions {
+				if (decision.Name == retained || decision.Name == held) && (!decision.Keep || fmt.Sprint(decision.Reasons) != "[locked]") {
+					t.Errorf("decision %s = %+v, want kept as locked", decision.Name, decision)
+				}
+			}
+		})
+	}
+}
+
+// TestObjectLockServer runs against an S3 compatible server supporting object lock, e.g. MinIO:
+// BIFROST_TEST_S3_ENDPOINT=http://127.0.0.1:9000 BIFROST_TEST_S3_ACCESS_KEY=... BIFROST_TEST_S3_SECRET_KEY=...
+func TestObjectLockServer(t *testing.T) {
+	endpoint := os.Getenv("BIFROST_TEST_S3_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("BIFROST_TEST_S3_ENDPOINT not set, skipping object lock integration test")
+	}
+	t.Setenv("BIFROST_UPLOAD_STATE_DIR", t.TempDir())
+	storage := S3Requirements{
+		BucketName:      fmt.Sprintf("bifrost-lock-%d", time.Now().UnixNano()),
+		AccessKeyId:     os.Getenv("BIFROST_TEST_S3_ACCESS_KEY"),
+		AccessKeySecret: os.Getenv("BIFROST_TEST_S3_SECRET_KEY"),
+		Region:          "us-east-1",
+		Endpoint:        endpoint,
+		UsePathStyle:    true,
+		ObjectLockMode:  ObjectLockGovernance,
+	}
+	options := compression.Options{Algorithm: compression.None}
+
+	// The bucket is created with object lock on the first backup
+	first, err := StoreBackup(storage, "app", bytes.NewBufferString("first"), options, 1)
+	if err != nil {
+		t.Fatalf("StoreBackup() error = %v", err)
+	}
+	if err := CheckBucketProtection(storage); err != nil {
+		t.Fatalf("CheckBucketProtection() error = %v", err)
+	}
+	time.Sleep(time.Second)
+	if _, err := StoreBackup(storage, "app", bytes.NewBufferString("second"), options, 1); err != nil {
+		t.Fatalf("StoreBackup() error = %v", err)
+	}
+
+	client, err := getS3Client(storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if locked, err := isObjectLocked(client, storage, first); err != nil || !locked {
+		t.Fatalf("isObjectLocked() = %v, %v, want the backup locked", locked, err)
+	}
+
+	// The first backup isn't kept by the policy but is still locked
+	decisions, err := ExecuteRetentionPolicy(storage, "app", retention.Policy{KeepLast: 1}, false)
+	if err != nil {
+		t.Fatalf("ExecuteRetentionPolicy() error = %v", err)
+	}
+	for _, decision := range decisions {
+		if decision.Name == first && (!decision.Keep || decision.Deleted || decision.Error != "") {
+			t.Errorf("decision %s = %+v, want the locked backup skipped", first, decision)
+		}
+	}
+	if backups, err := ListBackups(storage, "app"); err != nil || len(backups) != 2 {
+		t.Errorf("ListBackups() = %v, %v, want both backups kept", backups, err)
+	}
+}
@@ -0,0 +1,176 @@
+package s3
+
+import (
+	"crypto/md5" //#nosec
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const checksumMD5 = "MD5"
+
+// databaseTag is the object tag set on each upload with the name of the backed up database
+const databaseTag = "database"
+
+// ValidateObjectOptions checks the encryption, storage class, tags and checksum options of the storage
+func ValidateObjectOptions(storage S3Requirements) error {
+	switch storage.ServerSideEncryption {
+	case EncryptionNone, EncryptionS3, EncryptionKMS:
+	case EncryptionCustomer:
+		key, err := base64.StdEncoding.DecodeString(storage.SSECustomerKey)
+		if err != nil {
+			return fmt.Errorf("sse_customer_key must be base64 encoded: %w", err)
+		} else if len(key) != 32 {
+			return fmt.Errorf("sse_customer_key must be a 256 bits key, got %d bits", len(key)*8)
+		}
+	default:
+		return fmt.Errorf("unsupported server side encryption: %s", storage.ServerSideEncryption)
+	}
+
+	if storage.SSEKMSKeyId != "" && storage.ServerSideEncryption != EncryptionKMS {
+		return fmt.Errorf("sse_kms_key_id can only be used with %s server side encryption", EncryptionKMS)
+	}
+
+	if storage.StorageClass != "" && !isKnownStorageClass(storage.StorageClass) {
+		return fmt.Errorf("unsupported storage class: %s", storage.StorageClass)
+	}
+
+	for key, value := range storage.Tags {
+		if key == "" {
+			return fmt.Errorf("tag keys can't be empty")
+		} else if len(key) > 128 || len(value) > 256 {
+			return fmt.Errorf("tag %s exceeds the s3 tag size limits", key)
+		}
+	}
+	// One of the 10 tags allowed by s3 is kept for the database tag
+	userTags := len(storage.Tags)
+	if _, found := storage.Tags[databaseTag]; found {
+		userTags--
+	}
+	if userTags > 9 {
+		return fmt.Errorf("s3 objects can't have more than 9 tags besides the %s one, got %d", databaseTag, userTags)
+	}
+
+	if storage.ChecksumAlgorithm != "" && storage.ChecksumAlgorithm != checksumMD5 && !isKnownChecksumAlgorithm(storage.ChecksumAlgorithm) {
+		return fmt.Errorf("unsupported checksum algorithm: %s", storage.ChecksumAlgorithm)
+	}
+
+	return nil
+}
+
+// ParseTags parses a "key=value,key2=value2" list of object tags
+func ParseTags(raw string) (map[string]string, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+
+	tags := map[string]string{}
+	for _, pair := range strings.Split(raw, ",") {
+		key, value, found := strings.Cut(strings.TrimSpace(pair), "=")
+		if !found || key == "" {
+			return nil, fmt.Errorf("invalid tag %q, expected key=value", pair)
+		}
+		tags[key] = value
+	}
+	return tags, nil
+}
+
+func isKnownStorageClass(class string) bool {
+	for _, known := range types.StorageClass("").Values() {
+		if string(known) == class {
+			return true
+		}
+	}
+	return false
+}
+
+func isKnownChecksumAlgorithm(algorithm string) bool {
+	for _, known := range types.ChecksumAlgorithm("").Values() {
+		if string(known) == algorithm {
+			return true
+		}
+	}
+	return false
+}
+
+// withDatabaseTag returns the storage with the database tag added to a copy of its tags,
+// a static database tag of the storage is replaced
+func withDatabaseTag(storage S3Requirements, database_name string) S3Requirements {
+	if database_name == "" {
+		return storage
+	}
+	tags := make(map[string]string, len(storage.Tags)+1)
+	for key, value := range storage.Tags {
+		tags[key] = value
+	}
+	tags[databaseTag] = database_name
+	storage.Tags = tags
+	return storage
+}
+
+// encodeTags encodes the tags as the url query expected by the Tagging header
+func encodeTags(tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	values := url.Values{}
+	for _, key := range keys {
+		values.Set(key, tags[key])
+	}
+	return values.Encode()
+}
+
+// sseCustomerParams returns the algorithm, key and key digest used with SSE-C
+func sseCustomerParams(storage S3Requirements) (*string, *string, *string) {
+	if storage.ServerSideEncryption != EncryptionCustomer {
+		return nil, nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(storage.SSECustomerKey)
+	if err != nil {
+		return nil, nil, nil
+	}
+	digest := md5.Sum(key) //#nosec
+	return aws.String("AES256"), aws.String(storage.SSECustomerKey), aws.String(base64.StdEncoding.EncodeToString(digest[:]))
+}
+
+// applyObjectOptions sets the encryption, storage class, tags and checksum options on the upload input
+func applyObjectOptions(input *s3.PutObjectInput, storage S3Requirements, body []byte) {
+	switch storage.ServerSideEncryption {
+	case EncryptionS3:
+		input.ServerSideEncryption = types.ServerSideEncryptionAes256
+	case EncryptionKMS:
+		input.ServerSideEncryption = types.ServerSideEncryptionAwsKms
+		if storage.SSEKMSKeyId != "" {
+			input.SSEKMSKeyId = aws.String(storage.SSEKMSKeyId)
+		}
+	case EncryptionCustomer:
+		input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = sseCustomerParams(storage)
+	}
+
+	if storage.StorageClass != "" {
+		input.StorageClass = types.StorageClass(storage.StorageClass)
+	}
+
+	if len(storage.Tags) > 0 {
+		input.Tagging = aws.String(encodeTags(storage.Tags))
+	}
+
+	switch storage.ChecksumAlgorithm {
+	case "":
+	case checksumMD5:
+		// Content-MD5 is ignored by the uploader on multipart uploads
+		digest := md5.Sum(body) //#nosec
+		input.ContentMD5 = aws.String(base64.StdEncoding.EncodeToString(digest[:]))
+	default:
+		input.ChecksumAlgorithm = types.ChecksumAlgorithm(storage.ChecksumAlgorithm)
+	}
+}
@@ -0,0 +1,208 @@
+package s3
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// customerKey is a base64 encoded 256 bits SSE-C key
+const customerKey = "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="
+
+func TestValidateObjectOptions(t *testing.T) {
+	tooManyTags := map[string]string{}
+	for i := 0; i < 11; i++ {
+		tooManyTags[fmt.Sprintf("tag%d", i)] = "value"
+	}
+
+	tests := []struct {
+		name    string
+		storage S3Requirements
+		wantErr bool
+	}{
+		{name: "No options", storage: S3Requirements{}},
+		{name: "SSE-S3", storage: S3Requirements{ServerSideEncryption: EncryptionS3}},
+		{name: "SSE-KMS with a key", storage: S3Requirements{ServerSideEncryption: EncryptionKMS, SSEKMSKeyId: "alias/backups"}},
+		{name: "KMS key without SSE-KMS", storage: S3Requirements{ServerSideEncryption: EncryptionS3, SSEKMSKeyId: "alias/backups"}, wantErr: true},
+		{name: "SSE-C", storage: S3Requirements{ServerSideEncryption: EncryptionCustomer, SSECustomerKey: customerKey}},
+		{name: "SSE-C key not base64", storage: S3Requirements{ServerSideEncryption: EncryptionCustomer, SSECustomerKey: "not base64!"}, wantErr: true},
+		{name: "SSE-C key too short", storage: S3Requirements{ServerSideEncryption: EncryptionCustomer, SSECustomerKey: "c2hvcnQ="}, wantErr: true},
+		{name: "Unknown encryption", storage: S3Requirements{ServerSideEncryption: "DES"}, wantErr: true},
+		{name: "Storage class", storage: S3Requirements{StorageClass: "GLACIER_IR"}},
+		{name: "Unknown storage class", storage: S3Requirements{StorageClass: "COLD"}, wantErr: true},
+		{name: "Tags", storage: S3Requirements{Tags: map[string]string{"env": "prod", "team": ""}}},
+		{name: "Empty tag key", storage: S3Requirements{Tags: map[string]string{"": "prod"}}, wantErr: true},
+		{name: "Tag key too long", storage: S3Requirements{Tags: map[string]string{strings.Repeat("k", 129): "prod"}}, wantErr: true},
+		{name: "Tag value too long", storage: S3Requirements{Tags: map[string]string{"env": strings.Repeat("v", 257)}}, wantErr: true},
+		{name: "Too many tags", storage: S3Requirements{Tags: tooManyTags}, wantErr: true},
+		{name: "No room for the database tag", storage: S3Requirements{Tags: withoutKey(tooManyTags, "tag0")}, wantErr: true},
+		{name: "Static database tag", storage: S3Requirements{Tags: withDatabaseTag(S3Requirements{Tags: withoutKey(withoutKey(tooManyTags, "tag0"), "tag1")}, "app").Tags}},
+		{name: "MD5 checksum", storage: S3Requirements{ChecksumAlgorithm: checksumMD5}},
+		{name: "SHA256 checksum", storage: S3Requirements{ChecksumAlgorithm: "SHA256"}},
+		{name: "Unknown checksum", storage: S3Requirements{ChecksumAlgorithm: "XXH3"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateObjectOptions(tt.storage); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateObjectOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseTags(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    map[string]string
+		wantErr bool
+	}{
+		{name: "Empty", raw: "  ", want: nil},
+		{name: "Single tag", raw: "env=prod", want: map[string]string{"env": "prod"}},
+		{name: "Several tags with spaces", raw: "env=prod, team=data", want: map[string]string{"env": "prod", "team": "data"}},
+		{name: "Empty value", raw: "env=", want: map[string]string{"env": ""}},
+		{name: "Value with an equal sign", raw: "query=a=b", want: map[string]string{"query": "a=b"}},
+		{name: "Missing value", raw: "env", wantErr: true},
+		{name: "Missing key", raw: "=prod", wantErr: true},
+		{name: "Trailing comma", raw: "env=prod,", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseTags(tt.raw)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseTags() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseTags() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncodeTags(t *testing.T) {
+	tests := []struct {
+		name string
+		tags map[string]string
+		want string
+	}{
+		{name: "No tags", tags: nil, want: ""},
+		{name: "Sorted keys", tags: map[string]string{"team": "data", "env": "prod"}, want: "env=prod&team=data"},
+		{name: "Escaped values", tags: map[string]string{"owner": "data team", "path": "a/b&c"}, want: "owner=data+team&path=a%2Fb%26c"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := encodeTags(tt.tags); got != tt.want {
+				t.Errorf("encodeTags() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithDatabaseTag(t *testing.T) {
+	tests := []struct {
+		name          string
+		tags          map[string]string
+		database_name string
+		want          map[string]string
+	}{
+		{name: "No tags", tags: nil, database_name: "app", want: map[string]string{"database": "app"}},
+		{name: "Storage tags", tags: map[string]string{"env": "prod"}, database_name: "app", want: map[string]string{"env": "prod", "database": "app"}},
+		{name: "Static database tag", tags: map[string]string{"database": "prod"}, database_name: "app", want: map[string]string{"database": "app"}},
+		{name: "No database", tags: map[string]string{"env": "prod"}, database_name: "", want: map[string]string{"env": "prod"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := S3Requirements{Tags: tt.tags}
+			original := fmt.Sprint(tt.tags)
+			got := withDatabaseTag(storage, tt.database_name)
+			if !reflect.DeepEqual(got.Tags, tt.want) {
+				t.Errorf("withDatabaseTag() tags = %v, want %v", got.Tags, tt.want)
+			}
+			if fmt.Sprint(storage.Tags) != original {
+				t.Errorf("withDatabaseTag() changed the storage tags to %v", storage.Tags)
+			}
+		})
+	}
+}
+
+func withoutKey(tags map[string]string, key string) map[string]string {
+	copied := map[string]string{}
+	for k, v := range tags {
+		if k != key {
+			copied[k] = v
+		}
+	}
+	return copied
+}
+
+func TestApplyObjectOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		storage S3Requirements
+		check   func(t *testing.T, input *s3.PutObjectInput)
+	}{
+		{
+			name:    "SSE-KMS",
+			storage: S3Requirements{ServerSideEncryption: EncryptionKMS, SSEKMSKeyId: "alias/backups"},
+			check: func(t *testing.T, input *s3.PutObjectInput) {
+				if input.ServerSideEncryption != types.ServerSideEncryptionAwsKms || aws.ToString(input.SSEKMSKeyId) != "alias/backups" {
+					t.Errorf("encryption = %v %v", input.ServerSideEncryption, aws.ToString(input.SSEKMSKeyId))
+				}
+			},
+		},
+		{
+			name:    "SSE-C",
+			storage: S3Requirements{ServerSideEncryption: EncryptionCustomer, SSECustomerKey: customerKey},
+			check: func(t *testing.T, input *s3.PutObjectInput) {
+				if aws.ToString(input.SSECustomerAlgorithm) != "AES256" || aws.ToString(input.SSECustomerKey) != customerKey || input.SSECustomerKeyMD5 == nil {
+					t.Errorf("SSE-C = %v %v %v", aws.ToString(input.SSECustomerAlgorithm), aws.ToString(input.SSECustomerKey), input.SSECustomerKeyMD5)
+				}
+			},
+		},
+		{
+			name:    "Storage class and tags",
+			storage: S3Requirements{StorageClass: "STANDARD_IA", Tags: map[string]string{"env": "prod"}},
+			check: func(t *testing.T, input *s3.PutObjectInput) {
+				if input.StorageClass != types.StorageClassStandardIa || aws.ToString(input.Tagging) != "env=prod" {
+					t.Errorf("storage class = %v, tagging = %v", input.StorageClass, aws.ToString(input.Tagging))
+				}
+			},
+		},
+		{
+			name:    "MD5 checksum",
+			storage: S3Requirements{ChecksumAlgorithm: checksumMD5},
+			check: func(t *testing.T, input *s3.PutObjectInput) {
+				// md5("backup")
+				if aws.ToString(input.ContentMD5) != "QCBR9L4Mw6rTO886w9ZTKw==" || input.ChecksumAlgorithm != "" {
+					t.Errorf("checksum = %v %v", aws.ToString(input.ContentMD5), input.ChecksumAlgorithm)
+				}
+			},
+		},
+		{
+			name:    "No options",
+			storage: S3Requirements{},
+			check: func(t *testing.T, input *s3.PutObjectInput) {
+				if !reflect.DeepEqual(*input, s3.PutObjectInput{}) {
+					t.Errorf("input = %+v, want no option set", *input)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := &s3.PutObjectInput{}
+			applyObjectOptions(input, tt.storage, []byte("backup"))
+			tt.check(t, input)
+		})
+	}
+}
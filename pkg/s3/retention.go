@@ -3,56 +3,119 @@ package s3
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/martient/bifrost-backups/pkg/manifest"
+	"github.com/martient/bifrost-backups/pkg/retention"
 	"github.com/martient/golang-utils/utils"
 )
 
-// Make me a retention policies for s3 to delete old backups with a date > 21 days
-func deleteOldBackups(client *s3.Client, bucket_name string, retentionDays int) error {
+// retentionClient is the part of the s3 client used by the retention
+type retentionClient interface {
+	s3.ListObjectsV2APIClient
+	s3.HeadObjectAPIClient
+	objectLockConfigurationClient
+	DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
+}
+
+// listBackupTimes returns the backups of the database in the bucket with the time parsed from their key
+func listBackupTimes(client s3.ListObjectsV2APIClient, bucket_name string, database_name string) ([]retention.Backup, error) {
+	objects, err := listObjects(client, bucket_name, database_name)
+	if err != nil {
+		return nil, err
+	}
+	backups := make([]retention.Backup, len(objects))
+	for i, object := range objects {
+		backups[i] = retention.Backup{Name: object.Key, Time: object.Name.Time, Size: object.Size}
+	}
+	return backups, nil
+}
+
+// deleteOldBackups deletes the backups of the bucket the policy doesn't keep, locked ones are kept
+func deleteOldBackups(client retentionClient, storage S3Requirements, database_name string, policy retention.Policy, dryRun bool) ([]retention.Decision, error) {
+	bucket_name := storage.BucketName
 	if client == nil {
-		return fmt.Errorf("s3 client can't be null for the list operation")
+		return nil, fmt.Errorf("s3 client can't be null for the list operation")
 	} else if len(bucket_name) <= 0 {
-		return fmt.Errorf("the bucket need a name, can't be null at the list operation")
+		return nil, fmt.Errorf("the bucket need a name, can't be null at the list operation")
 	}
 
-	cutoffDate := time.Now().AddDate(0, 0, -retentionDays)
-
-	listObjectsOutput, err := client.ListObjectsV2(context.TODO(), &s3.ListObjectsV2Input{
-		Bucket: &bucket_name,
-	})
+	backups, err := listBackupTimes(client, bucket_name, database_name)
 	if err != nil {
-		return fmt.Errorf("failed to list objects in bucket %s: %v", bucket_name, err)
+		return nil, err
 	}
 
-	// Iterate over the objects and delete those older than the cutoff date
-	for _, obj := range listObjectsOutput.Contents {
-		if obj.LastModified.Before(cutoffDate) {
-			_, err := client.DeleteObject(context.TODO(), &s3.DeleteObjectInput{
-				Bucket: &bucket_name,
-				Key:    obj.Key,
-			})
+	// The lock status needs a HeadObject per object, which requires the GetObject permission,
+	// so it's only read when the objects can be locked
+	checkLock := mayLockObjects(client, storage)
+
+	decisions := policy.Evaluate(backups, time.Now())
+	for i, decision := range decisions {
+		if decision.Keep {
+			continue
+		}
+		key := decision.Name
+		reasons := strings.Join(decision.Reasons, ", ")
+		if checkLock {
+			locked, err := isObjectLocked(client, storage, key)
 			if err != nil {
-				utils.LogErrorInterface("Failed to delete object %s from bucket %s: %v", "S3", *obj.Key, "", bucket_name, err)
-			} else {
-				utils.LogInfo("Deleted object %s from bucket %s", "S3", *obj.Key, bucket_name)
+				decisions[i].Error = err.Error()
+				utils.LogErrorInterface("Failed to get the lock status of object %s from bucket %s: %v", "S3", key, bucket_name, err)
+				continue
+			} else if locked {
+				decisions[i].Keep = true
+				decisions[i].Reasons = []string{"locked"}
+				utils.LogInfo("Object %s from bucket %s is still locked, skipped", "S3", key, bucket_name)
+				continue
 			}
 		}
+		if dryRun {
+			utils.LogInfo("Would delete object %s from bucket %s: %s", "S3", key, bucket_name, reasons)
+			continue
+		}
+		_, err = client.DeleteObject(context.TODO(), &s3.DeleteObjectInput{
+			Bucket: &bucket_name,
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			decisions[i].Error = err.Error()
+			utils.LogErrorInterface("Failed to delete object %s from bucket %s: %v", "S3", key, bucket_name, err)
+		} else {
+			decisions[i].Deleted = true
+			// The manifest is deleted with its backup, a missing one isn't an error on S3
+			if _, err := client.DeleteObject(context.TODO(), &s3.DeleteObjectInput{
+				Bucket: &bucket_name,
+				Key:    aws.String(manifest.Name(key)),
+			}); err != nil {
+				utils.LogErrorInterface("Failed to delete the manifest of object %s from bucket %s: %v", "S3", key, bucket_name, err)
+			}
+			utils.LogInfo("Deleted object %s from bucket %s: %s", "S3", key, bucket_name, reasons)
+		}
 	}
 
-	return nil
+	return decisions, nil
 }
 
-func ExecuteRetentionPolicy(storage S3Requirements, retention_days int) error {
-	if storage == (S3Requirements{}) {
-		return fmt.Errorf("storage can't be empty")
+// ExecuteRetentionPolicy deletes the backups of the database the policy doesn't keep and
+// returns the decision made for each backup, nothing is deleted on a dry run
+func ExecuteRetentionPolicy(storage S3Requirements, database_name string, policy retention.Policy, dryRun bool) ([]retention.Decision, error) {
+	if storage.BucketName == "" {
+		return nil, fmt.Errorf("storage can't be empty")
 	}
 
 	client, err := getS3Client(storage)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	if !dryRun {
+		if err := cleanupAbandonedUploads(client, storage); err != nil {
+			utils.LogError("Failed to clean the abandoned uploads: %s", "S3", err)
+		}
 	}
 
-	return deleteOldBackups(client, storage.BucketName, retention_days)
+	return deleteOldBackups(client, storage, database_name, policy, dryRun)
 }
@@ -9,29 +9,18 @@ import (
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/credentials"
-	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/aws/smithy-go"
-	"github.com/klauspost/compress/zstd"
+	"github.com/martient/bifrost-backups/pkg/backupname"
+	"github.com/martient/bifrost-backups/pkg/compression"
+	"github.com/martient/bifrost-backups/pkg/manifest"
 	"github.com/martient/golang-utils/utils"
 )
 
 func getS3Client(storage S3Requirements) (*s3.Client, error) {
-	options := []func(*config.LoadOptions) error{
-		// Add credentials provider
-		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(
-			storage.AccessKeyId,
-			storage.AccessKeySecret,
-			"",
-		)),
-		config.WithRegion(storage.Region),
-	}
-
-	// Load the configuration
-	cfg, err := config.LoadDefaultConfig(context.TODO(), options...)
+	// Load the configuration with the credentials mode of the storage
+	cfg, err := loadConfig(storage)
 	if err != nil {
 		return nil, err
 	}
@@ -46,22 +35,34 @@ func getS3Client(storage S3Requirements) (*s3.Client, error) {
 		})
 	}
 
+	// Self-hosted endpoints often lack the virtual-host DNS records
+	if storage.UsePathStyle {
+		s3Options = append(s3Options, func(o *s3.Options) {
+			o.UsePathStyle = true
+		})
+	}
+
 	// Create and return the S3 client with custom options
 	return s3.NewFromConfig(cfg, s3Options...), nil
 }
 
-func createBucket(client *s3.Client, name string, region string) error {
+func createBucket(client *s3.Client, name string, region string, objectLock bool) error {
 	if client == nil {
 		return fmt.Errorf("s3 client can't be null for the bucket creation")
 	} else if len(name) <= 0 || len(region) <= 0 {
 		return fmt.Errorf("the bucket need a name and a region, none of them can be null")
 	}
-	_, err := client.CreateBucket(context.TODO(), &s3.CreateBucketInput{
+	input := &s3.CreateBucketInput{
 		Bucket: aws.String(name),
 		CreateBucketConfiguration: &types.CreateBucketConfiguration{
 			LocationConstraint: types.BucketLocationConstraint(region),
 		},
-	})
+	}
+	if objectLock {
+		// Object lock can only be enabled at the bucket creation, it also turns on versioning
+		input.ObjectLockEnabledForBucket = aws.Bool(true)
+	}
+	_, err := client.CreateBucket(context.TODO(), input)
 	if err != nil {
 		log.Printf("Couldn't create bucket %v in Region %v. Here's why: %v\n",
 			name, region, err)
@@ -69,56 +70,50 @@ func createBucket(client *s3.Client, name string, region string) error {
 	return err
 }
 
-func upload(client *s3.Client, bucket_name string, buffer []byte) error {
+func upload(client *s3.Client, storage S3Requirements, key string, buffer []byte, retentionDays int) error {
 	if client == nil {
 		return fmt.Errorf("s3 client can't be null for the upload operation")
-	} else if len(bucket_name) <= 0 {
+	} else if len(storage.BucketName) <= 0 {
 		return fmt.Errorf("the bucket need a name, can't be null at the upload")
 	} else if len(buffer) <= 0 {
 		return fmt.Errorf("the buffer can't be nil or empty at the bucket upload")
 	}
-	currentTime := time.Now().UTC()
 
-	largeBuffer := bytes.NewReader(buffer)
-	var partMiBs int64 = 100
-	uploader := manager.NewUploader(client, func(u *manager.Uploader) {
-		u.PartSize = partMiBs * 1024 * 1024
-	})
-	_, err := uploader.Upload(context.TODO(), &s3.PutObjectInput{
-		Bucket: aws.String(bucket_name),
-		Key: aws.String(fmt.Sprintf("%04d-%02d-%02dT%02d:%02d:%02dZ",
-			currentTime.Year(),
-			currentTime.Month(),
-			currentTime.Day(),
-			currentTime.Hour(),
-			currentTime.Minute(),
-			currentTime.Second())),
-
-		Body: largeBuffer,
-	})
+	return uploadObject(client, storage, key, buffer, retentionDays)
+}
+
+func uploadObject(client *s3.Client, storage S3Requirements, key string, buffer []byte, retentionDays int) error {
+	input := &s3.PutObjectInput{
+		Bucket: aws.String(storage.BucketName),
+		Key:    aws.String(key),
+	}
+	applyObjectOptions(input, storage, buffer)
+	applyObjectLock(input, storage, retentionDays)
+
+	var err error
+	if int64(len(buffer)) <= getPartSize(storage, int64(len(buffer))) {
+		err = putObject(client, storage, input, buffer)
+	} else {
+		err = multipartUpload(client, storage, input, buffer)
+	}
 	if err != nil {
 		log.Printf("Couldn't upload large object to %v:%v. Here's why: %v\n",
-			bucket_name, fmt.Sprintf("%04d-%02d-%02dT%02d:%02d:%02dZ",
-				currentTime.Year(),
-				currentTime.Month(),
-				currentTime.Day(),
-				currentTime.Hour(),
-				currentTime.Minute(),
-				currentTime.Second()), err)
+			storage.BucketName, key, err)
 		return err
 	}
 	return nil
 }
 
-func StoreBackup(storage S3Requirements, buffer *bytes.Buffer, useCompression bool) error {
+// StoreBackup uploads a new backup of the database and returns its key
+func StoreBackup(storage S3Requirements, database_name string, buffer *bytes.Buffer, options compression.Options, retentionDays int) (string, error) {
 	if buffer == nil {
-		return fmt.Errorf("buffer can't be empty")
-	} else if storage == (S3Requirements{}) {
-		return fmt.Errorf("storage can't be empty")
+		return "", fmt.Errorf("buffer can't be empty")
+	} else if storage.BucketName == "" {
+		return "", fmt.Errorf("storage can't be empty")
 	}
 	client, err := getS3Client(storage)
 	if err != nil {
-		return err
+		return "", err
 	}
 	hb, err := client.HeadBucket(context.TODO(), &s3.HeadBucketInput{
 		Bucket: &storage.BucketName,
@@ -130,44 +125,85 @@ func StoreBackup(storage S3Requirements, buffer *bytes.Buffer, useCompression bo
 			case *types.NotFound:
 				utils.LogWarning("The bucket %s does not exist, it gonna be created", "S3", storage.BucketName)
 			default:
-				return err
+				return "", err
 			}
 		}
 	}
 
-	var dataToWrite []byte
+	dataToWrite, err := compressBackup(buffer.Bytes(), options)
+	if err != nil {
+		return "", err
+	}
 
-	if useCompression {
-		// compressed, err := zstd.Compress(nil, buffer.Bytes())
-		// if err != nil {
-		// 	utils.LogError("Compression failed", "Local storage", err)
-		// 	return err
-		// }
-		encoder, err := zstd.NewWriter(nil)
+	if hb == nil {
+		err = createBucket(client, storage.BucketName, storage.Region, usesObjectLock(storage))
 		if err != nil {
-			utils.LogError("Compression failed", "S3", err)
-			return err
+			return "", err
 		}
-		defer func() {
-			if err := encoder.Close(); err != nil {
-				utils.LogError("Failed to close encoder", "S3", err)
-			}
-		}()
+	} else if err := resumePendingUploads(client, storage); err != nil {
+		utils.LogError("Failed to resume the pending uploads: %s", "S3", err)
+	}
 
-		// Compress the input string
-		compressed := encoder.EncodeAll([]byte(buffer.Bytes()), nil)
-		dataToWrite = compressed
-	} else {
-		dataToWrite = buffer.Bytes()
+	storage = withDatabaseTag(storage, database_name)
+	key := backupname.New(database_name, time.Now(), backupname.Extension).String()
+	err = upload(client, storage, key, dataToWrite, retentionDays)
+	if err != nil {
+		return "", err
+	}
+	if err := uploadManifest(client, storage, manifest.New(key, database_name, dataToWrite), retentionDays); err != nil {
+		return "", err
 	}
 
-	if hb != nil {
-		return upload(client, storage.BucketName, dataToWrite)
-	} else {
-		err = createBucket(client, storage.BucketName, storage.Region)
-		if err != nil {
-			return err
-		}
-		return upload(client, storage.BucketName, dataToWrite)
+	if err := cleanupAbandonedUploads(client, storage); err != nil {
+		utils.LogError("Failed to clean the abandoned uploads: %s", "S3", err)
+	}
+	return key, nil
+}
+
+// ReplaceBackup overwrites an existing backup, e.g. once re-encrypted with a new key.
+// On a versioned bucket the previous version is kept until it expires.
+func ReplaceBackup(storage S3Requirements, backup_name string, buffer *bytes.Buffer, options compression.Options, retentionDays int) error {
+	if buffer == nil {
+		return fmt.Errorf("buffer can't be empty")
+	} else if storage.BucketName == "" {
+		return fmt.Errorf("storage can't be empty")
+	} else if backup_name == "" {
+		return fmt.Errorf("backup name can't be empty")
+	}
+	client, err := getS3Client(storage)
+	if err != nil {
+		return err
+	}
+
+	dataToWrite, err := compressBackup(buffer.Bytes(), options)
+	if err != nil {
+		return err
+	}
+	database_name := ""
+	if name, err := backupname.Parse(backup_name); err == nil {
+		database_name = name.Database
+	}
+	storage = withDatabaseTag(storage, database_name)
+	if err := uploadObject(client, storage, backup_name, dataToWrite, retentionDays); err != nil {
+		return err
+	}
+	return uploadManifest(client, storage, manifest.New(backup_name, database_name, dataToWrite), retentionDays)
+}
+
+// uploadManifest uploads the manifest next to its backup, with the same object options
+func uploadManifest(client *s3.Client, storage S3Requirements, backupManifest manifest.Manifest, retentionDays int) error {
+	data, err := backupManifest.Marshal()
+	if err != nil {
+		return err
+	}
+	return uploadObject(client, storage, manifest.Name(backupManifest.Backup), data, retentionDays)
+}
+
+func compressBackup(data []byte, options compression.Options) ([]byte, error) {
+	compressed, err := compression.Compress(data, options)
+	if err != nil {
+		utils.LogError("Compression failed: %s", "S3", err)
+		return nil, err
 	}
+	return compressed, nil
 }
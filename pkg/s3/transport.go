@@ -0,0 +1,189 @@
+package s3
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/config"
+	internalutils "github.com/martient/bifrost-backups/pkg/utils"
+	"github.com/martient/golang-utils/utils"
+)
+
+const throttleChunkSize = 16 * 1024
+
+// throttledConn caps the upload bandwidth of a connection
+type throttledConn struct {
+	net.Conn
+	limiter *internalutils.RateLimiter
+}
+
+func (c *throttledConn) Write(b []byte) (int, error) {
+	written := 0
+	for written < len(b) {
+		end := written + throttleChunkSize
+		if end > len(b) {
+			end = len(b)
+		}
+		c.limiter.Wait(end - written)
+		n, err := c.Conn.Write(b[written:end])
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// ValidateTransport checks the connection options of the storage
+func ValidateTransport(storage S3Requirements) error {
+	if storage.CABundle != "" {
+		if _, err := loadCABundle(storage.CABundle); err != nil {
+			return err
+		}
+	}
+	if storage.Proxy != "" {
+		if _, err := parseProxy(storage.Proxy); err != nil {
+			return err
+		}
+	}
+	if storage.TimeoutSeconds < 0 || storage.MaxAttempts < 0 || storage.MaxBackoffSeconds < 0 {
+		return fmt.Errorf("timeout, max attempts and max backoff can't be negative")
+	}
+	if storage.PartSizeMiB != 0 && storage.PartSizeMiB < minPartSizeMiB {
+		return fmt.Errorf("part size can't be lower than %d MiB", minPartSizeMiB)
+	}
+	if storage.Concurrency < 0 || storage.UploadRetries < 0 || storage.BandwidthLimitKiB < 0 || storage.AbandonedUploadHours < 0 {
+		return fmt.Errorf("concurrency, upload retries, bandwidth limit and abandoned upload hours can't be negative")
+	}
+	return nil
+}
+
+func loadCABundle(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ca bundle %s: %w", path, err)
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificate found in ca bundle %s", path)
+	}
+	return pool, nil
+}
+
+func parseProxy(raw string) (*url.URL, error) {
+	proxy, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy url %s: %w", raw, err)
+	} else if proxy.Scheme == "" || proxy.Host == "" {
+		return nil, fmt.Errorf("invalid proxy url %s, expected scheme://host:port", raw)
+	}
+	return proxy, nil
+}
+
+// transportLoadOptions returns the http client and retry options used to load the aws config
+func transportLoadOptions(storage S3Requirements) ([]func(*config.LoadOptions) error, error) {
+	var options []func(*config.LoadOptions) error
+
+	client, err := newHTTPClient(storage)
+	if err != nil {
+		return nil, err
+	}
+	options = append(options, config.WithHTTPClient(client))
+
+	if storage.MaxAttempts > 0 || storage.MaxBackoffSeconds > 0 {
+		options = append(options, config.WithRetryer(func() aws.Retryer {
+			return retry.NewStandard(func(o *retry.StandardOptions) {
+				if storage.MaxAttempts > 0 {
+					o.MaxAttempts = storage.MaxAttempts
+				}
+				if storage.MaxBackoffSeconds > 0 {
+					o.MaxBackoff = time.Duration(storage.MaxBackoffSeconds) * time.Second
+				}
+			})
+		}))
+	}
+
+	return options, nil
+}
+
+// newHTTPClient returns the http client of the storage. The timeout applies to the connection
+// and to the wait for the response headers, not to the whole request so large uploads and
+// downloads over a slow link aren't cut.
+func newHTTPClient(storage S3Requirements) (*awshttp.BuildableClient, error) {
+	var pool *x509.CertPool
+	if storage.CABundle != "" {
+		var err error
+		pool, err = loadCABundle(storage.CABundle)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var proxy *url.URL
+	if storage.Proxy != "" {
+		var err error
+		proxy, err = parseProxy(storage.Proxy)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if storage.InsecureSkipVerify {
+		utils.LogWarning("TLS verification is disabled for bucket %s, do not use it in production", "S3", storage.BucketName)
+	}
+
+	limiter := internalutils.NewRateLimiter(int64(storage.BandwidthLimitKiB) * 1024)
+	timeout := time.Duration(storage.TimeoutSeconds) * time.Second
+
+	client := awshttp.NewBuildableClient()
+	if timeout > 0 {
+		client = client.WithDialerOptions(func(dialer *net.Dialer) {
+			dialer.Timeout = timeout
+		})
+	}
+	return client.WithTransportOptions(func(tr *http.Transport) {
+		if tr.TLSClientConfig == nil {
+			tr.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		}
+		if pool != nil {
+			tr.TLSClientConfig.RootCAs = pool
+		}
+		if storage.InsecureSkipVerify {
+			tr.TLSClientConfig.InsecureSkipVerify = true //#nosec
+		}
+		if proxy != nil {
+			tr.Proxy = http.ProxyURL(proxy)
+		}
+		if timeout > 0 {
+			tr.ResponseHeaderTimeout = timeout
+		}
+		if limiter != nil {
+			dial := tr.DialContext
+			if dial == nil {
+				dial = (&net.Dialer{}).DialContext
+			}
+			tr.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+				conn, err := dial(ctx, network, address)
+				if err != nil {
+					return nil, err
+				}
+				return &throttledConn{Conn: conn, limiter: limiter}, nil
+			}
+		}
+	}), nil
+}
@@ -14,7 +14,7 @@ func ReadConfigUnciphered() (Config, error) {
 	defer configMutex.Unlock()
 
 	// Read current config
-	config, err := readConfig()
+	config, err := loadConfig()
 	if err != nil {
 		return Config{}, fmt.Errorf("failed to read config: %w", err)
 	}
@@ -0,0 +1,94 @@
+package interactives
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// pickerHeight is the number of backups shown at once
+const pickerHeight = 15
+
+// ErrPickCanceled is returned when the user leaves the picker without picking a backup
+var ErrPickCanceled = errors.New("no backup picked")
+
+type pickerModel struct {
+	title    string
+	items    []string
+	cursor   int
+	picked   bool
+	canceled bool
+}
+
+func (m pickerModel) Init() tea.Cmd {
+	return nil
+}
+
+func (m pickerModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if msg, ok := msg.(tea.KeyMsg); ok {
+		switch msg.String() {
+		case "ctrl+c", "esc", "q":
+			m.canceled = true
+			return m, tea.Quit
+		case "enter":
+			m.picked = true
+			return m, tea.Quit
+		case "up", "k", "shift+tab":
+			if m.cursor > 0 {
+				m.cursor--
+			}
+		case "down", "j", "tab":
+			if m.cursor < len(m.items)-1 {
+				m.cursor++
+			}
+		case "home":
+			m.cursor = 0
+		case "end":
+			m.cursor = len(m.items) - 1
+		}
+	}
+	return m, nil
+}
+
+func (m pickerModel) View() string {
+	if m.picked || m.canceled {
+		return ""
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n\n", m.title)
+
+	start := 0
+	if m.cursor >= pickerHeight {
+		start = m.cursor - pickerHeight + 1
+	}
+	for i := start; i < len(m.items) && i < start+pickerHeight; i++ {
+		if i == m.cursor {
+			b.WriteString(focusedStyle.Render("> " + m.items[i]))
+		} else {
+			b.WriteString(noStyle.Render("  " + m.items[i]))
+		}
+		b.WriteRune('\n')
+	}
+
+	b.WriteString(helpStyle.Render(fmt.Sprintf("\n%d/%d, enter to restore, esc to cancel", m.cursor+1, len(m.items))))
+	b.WriteRune('\n')
+	return b.String()
+}
+
+// PickBackup lets the user pick one of the backups on the terminal, the backups are listed
+// in the given order and the first one is selected
+func PickBackup(title string, backups []string) (string, error) {
+	if len(backups) == 0 {
+		return "", fmt.Errorf("no backup to pick from")
+	}
+	result, err := tea.NewProgram(pickerModel{title: title, items: backups}).Run()
+	if err != nil {
+		return "", err
+	}
+	if m := result.(pickerModel); m.picked {
+		return m.items[m.cursor], nil
+	}
+	return "", ErrPickCanceled
+}
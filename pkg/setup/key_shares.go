@@ -0,0 +1,208 @@
+package setup
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"slices"
+	"strings"
+
+	"github.com/martient/bifrost-backups/pkg/crypto"
+	"github.com/martient/golang-utils/utils"
+)
+
+const (
+	// SharePrefix starts every printed share, the rest is upper case base32 so the
+	// share fits the QR code alphanumeric mode
+	SharePrefix = "BIFROST-SHARE-"
+
+	shareVersion  = 1
+	shareIDLength = 4
+)
+
+var shareEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// StorageKeys are the cipher keys of a storage saved in the shares
+type StorageKeys struct {
+	Name               string           `json:"name"`
+	CipherKey          string           `json:"cypher_key"`
+	PreviousCipherKeys []CipherKeyEntry `json:"previous_cypher_keys,omitempty"`
+}
+
+type keyShare struct {
+	id        []byte
+	threshold int
+	total     int
+	share     []byte
+}
+
+func encodeShare(share keyShare) string {
+	payload := []byte{shareVersion, byte(share.threshold), byte(share.total)}
+	payload = append(payload, share.id...)
+	payload = append(payload, share.share...)
+	payload = binary.BigEndian.AppendUint32(payload, crc32.ChecksumIEEE(payload))
+	return SharePrefix + shareEncoding.EncodeToString(payload)
+}
+
+func decodeShare(text string) (keyShare, error) {
+	text = strings.ToUpper(strings.Join(strings.Fields(text), ""))
+	if !strings.HasPrefix(text, SharePrefix) {
+		return keyShare{}, fmt.Errorf("invalid share, expected the %s prefix", SharePrefix)
+	}
+	payload, err := shareEncoding.DecodeString(strings.TrimPrefix(text, SharePrefix))
+	if err != nil || len(payload) < 3+shareIDLength+2+4 {
+		return keyShare{}, fmt.Errorf("invalid share encoding")
+	}
+	body, checksum := payload[:len(payload)-4], payload[len(payload)-4:]
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(checksum) {
+		return keyShare{}, fmt.Errorf("invalid share checksum, the share has been mistyped")
+	}
+	if body[0] != shareVersion {
+		return keyShare{}, fmt.Errorf("unsupported share version %d", body[0])
+	}
+	return keyShare{
+		threshold: int(body[1]),
+		total:     int(body[2]),
+		id:        body[3 : 3+shareIDLength],
+		share:     body[3+shareIDLength:],
+	}, nil
+}
+
+// SplitCipherKeys splits the cipher keys of the storages (every storage when names
+// is empty) into printable shares, any threshold of them recover the keys
+func SplitCipherKeys(names []string, shares int, threshold int) ([]string, error) {
+	configMutex.Lock()
+	config, err := readConfig()
+	configMutex.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	var keys []StorageKeys
+	for _, storage := range config.Storages {
+		if len(names) > 0 && !slices.Contains(names, storage.Name) {
+			continue
+		}
+		if err := resolveStorageSecrets(&storage); err != nil {
+			return nil, err
+		}
+		if _, err := storage.CipherKeys(); errors.Is(err, ErrConfigLocked) {
+			return nil, err
+		}
+		keys = append(keys, StorageKeys{
+			Name:               storage.Name,
+			CipherKey:          storage.CipherKey,
+			PreviousCipherKeys: storage.PreviousCipherKeys,
+		})
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no storage found")
+	}
+	for _, name := range names {
+		if !slices.ContainsFunc(keys, func(k StorageKeys) bool { return k.Name == name }) {
+			return nil, fmt.Errorf("storage %s not found", name)
+		}
+	}
+
+	secret, err := json.Marshal(keys)
+	if err != nil {
+		return nil, err
+	}
+	split, err := crypto.SplitSecret(secret, shares, threshold)
+	if err != nil {
+		return nil, err
+	}
+
+	id := make([]byte, shareIDLength)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	var encoded []string
+	for _, share := range split {
+		encoded = append(encoded, encodeShare(keyShare{id: id, threshold: threshold, total: shares, share: share}))
+	}
+	return encoded, nil
+}
+
+// RecoverCipherKeys rebuilds the storage keys from the shares
+func RecoverCipherKeys(texts []string) ([]StorageKeys, error) {
+	var shares [][]byte
+	var first keyShare
+	for i, text := range texts {
+		share, err := decodeShare(text)
+		if err != nil {
+			return nil, fmt.Errorf("share %d: %w", i+1, err)
+		}
+		if i == 0 {
+			first = share
+		} else if !bytes.Equal(share.id, first.id) {
+			return nil, fmt.Errorf("share %d doesn't belong to the same split", i+1)
+		}
+		shares = append(shares, share.share)
+	}
+	if len(shares) == 0 || len(shares) < first.threshold {
+		return nil, fmt.Errorf("%d share(s) provided, %d required", len(shares), first.threshold)
+	}
+
+	secret, err := crypto.CombineShares(shares)
+	if err != nil {
+		return nil, err
+	}
+	var keys []StorageKeys
+	if err := json.Unmarshal(secret, &keys); err != nil {
+		return nil, fmt.Errorf("the shares don't rebuild valid keys: %w", err)
+	}
+	return keys, nil
+}
+
+// ImportCipherKeys writes the recovered keys into the registered storages of the
+// same name, the key they hold is retired. It returns the storages not registered.
+func ImportCipherKeys(keys []StorageKeys) ([]string, error) {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+
+	currentConfig, err := loadConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	var missing []string
+	for _, recovered := range keys {
+		found := false
+		for i := range currentConfig.Storages {
+			storage := &currentConfig.Storages[i]
+			if storage.Name != recovered.Name {
+				continue
+			}
+			found = true
+			if storage.CipherKey != "" && storage.CipherKey != recovered.CipherKey {
+				storage.PreviousCipherKeys = retireCipherKey(storage.PreviousCipherKeys, storage.CipherKey)
+			}
+			storage.CipherKey = recovered.CipherKey
+			for _, previous := range recovered.PreviousCipherKeys {
+				if !containsCipherKey(storage.PreviousCipherKeys, previous.Key) && previous.Key != storage.CipherKey {
+					storage.PreviousCipherKeys = append(storage.PreviousCipherKeys, previous)
+				}
+			}
+			utils.LogInfo("Storage %s cipher keys recovered", "REGISTER STORAGE", storage.Name)
+			break
+		}
+		if !found {
+			missing = append(missing, recovered.Name)
+		}
+	}
+
+	if err := writeConfig(currentConfig); err != nil {
+		return nil, fmt.Errorf("failed to write config: %w", err)
+	}
+	return missing, nil
+}
+
+func containsCipherKey(entries []CipherKeyEntry, key string) bool {
+	return slices.ContainsFunc(entries, func(entry CipherKeyEntry) bool { return entry.Key == key })
+}
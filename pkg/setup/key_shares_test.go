@@ -0,0 +1,99 @@
+package setup
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	localstorage "github.com/martient/bifrost-backups/pkg/local_storage"
+)
+
+func TestSplitAndRecoverCipherKeys(t *testing.T) {
+	originalConfigPath := configFilePath
+	defer func() { configFilePath = originalConfigPath }()
+	configFilePath = filepath.Join(t.TempDir(), "config.yaml")
+
+	if err := writeConfig(Config{Version: "1.0"}); err != nil {
+		t.Fatalf("Failed to write initial config: %v", err)
+	}
+	requirements := &localstorage.LocalStorageRequirements{FolderPath: "/tmp/backup"}
+	for _, name := range []string{"primary", "offsite"} {
+		if err := RegisterStorage(LocalStorage, name, 7, "", true, requirements); err != nil {
+			t.Fatalf("RegisterStorage() error = %v", err)
+		}
+	}
+	if _, err := RotateCipherKey("primary", ""); err != nil {
+		t.Fatalf("RotateCipherKey() error = %v", err)
+	}
+	primary, err := ReadStorageConfig("primary")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := SplitCipherKeys([]string{"missing"}, 3, 2); err == nil {
+		t.Error("SplitCipherKeys() of an unknown storage should fail")
+	}
+	shares, err := SplitCipherKeys(nil, 5, 3)
+	if err != nil {
+		t.Fatalf("SplitCipherKeys() error = %v", err)
+	}
+	for _, share := range shares {
+		if !strings.HasPrefix(share, SharePrefix) || strings.ToUpper(share) != share {
+			t.Errorf("SplitCipherKeys() share %q isn't QR alphanumeric friendly", share)
+		}
+	}
+
+	if _, err := RecoverCipherKeys(shares[:2]); err == nil {
+		t.Error("RecoverCipherKeys() below the threshold should fail")
+	}
+	position := len(SharePrefix) + 10
+	replacement := "A"
+	if shares[0][position] == 'A' {
+		replacement = "B"
+	}
+	mistyped := shares[0][:position] + replacement + shares[0][position+1:]
+	if _, err := RecoverCipherKeys([]string{mistyped, shares[1], shares[2]}); err == nil {
+		t.Error("RecoverCipherKeys() with a mistyped share should fail")
+	}
+	others, err := SplitCipherKeys([]string{"offsite"}, 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RecoverCipherKeys([]string{shares[0], others[1], shares[2]}); err == nil {
+		t.Error("RecoverCipherKeys() mixing two splits should fail")
+	}
+
+	keys, err := RecoverCipherKeys([]string{shares[4], " " + strings.ToLower(shares[1]) + "\n", shares[2]})
+	if err != nil {
+		t.Fatalf("RecoverCipherKeys() error = %v", err)
+	}
+	if len(keys) != 2 {
+		t.Fatalf("RecoverCipherKeys() returned %d storages, want 2", len(keys))
+	}
+
+	// A fresh config where only the primary storage has been registered again
+	if err := writeConfig(Config{Version: "1.0"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterStorage(LocalStorage, "primary", 7, "", true, requirements); err != nil {
+		t.Fatal(err)
+	}
+	missing, err := ImportCipherKeys(keys)
+	if err != nil {
+		t.Fatalf("ImportCipherKeys() error = %v", err)
+	}
+	if len(missing) != 1 || missing[0] != "offsite" {
+		t.Errorf("ImportCipherKeys() missing = %v, want [offsite]", missing)
+	}
+
+	recovered, err := ReadStorageConfig("primary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recovered.CipherKey != primary.CipherKey {
+		t.Error("ImportCipherKeys() didn't restore the active key")
+	}
+	if !containsCipherKey(recovered.PreviousCipherKeys, primary.PreviousCipherKeys[0].Key) {
+		t.Error("ImportCipherKeys() didn't restore the retired keys")
+	}
+}
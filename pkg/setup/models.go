@@ -1,9 +1,14 @@
 package setup
 
 import (
+	"time"
+
+	"github.com/martient/bifrost-backups/pkg/drill"
+	"github.com/martient/bifrost-backups/pkg/hooks"
 	"github.com/martient/bifrost-backups/pkg/local_files"
 	localstorage "github.com/martient/bifrost-backups/pkg/local_storage"
 	"github.com/martient/bifrost-backups/pkg/postgresql"
+	"github.com/martient/bifrost-backups/pkg/retention"
 	"github.com/martient/bifrost-backups/pkg/s3"
 	"github.com/martient/bifrost-backups/pkg/sqlite3"
 )
@@ -27,11 +32,26 @@ type Storage struct {
 	Type                   StorageType                           `yaml:"storage_type"`
 	Name                   string                                `yaml:"name"`
 	CipherKey              string                                `yaml:"cypher_key"`
+	PreviousCipherKeys     []CipherKeyEntry                      `yaml:"previous_cypher_keys,omitempty"` // Retired keys, only used to decrypt older backups
 	RetentionDays          int                                   `yaml:"retention_days" default:"21"`
+	Retention              retention.Policy                      `yaml:"retention,omitempty"` // GFS rules, RetentionDays is used when empty
 	ExecuteRetentionPolicy bool                                  `yaml:"execute_retention_policy" default:"true"`
 	Compression            bool                                  `yaml:"compression" default:"true"`
-	LocalStorage           localstorage.LocalStorageRequirements `yaml:"local_storage,omitempty"` // Make local_storage optional
-	S3                     s3.S3Requirements                     `yaml:"s3,omitempty"`            // Make s3 optional
+	CompressionAlgorithm   string                                `yaml:"compression_algorithm,omitempty"` // zstd when empty
+	CompressionLevel       int                                   `yaml:"compression_level,omitempty"`     // Default level of the algorithm when 0
+	CompressionThreads     int                                   `yaml:"compression_threads,omitempty"`   // One per CPU when 0
+	Recipients             []string                              `yaml:"recipients,omitempty"`            // Public keys, when set the host can't decrypt the backups
+	Passphrase             string                                `yaml:"passphrase,omitempty"`            // Backup keys derived from it with Argon2id, restorable without the config
+	ProtectionRecipient    string                                `yaml:"-"`                               // Set at runtime when the config is protected by the master password
+	LocalStorage           localstorage.LocalStorageRequirements `yaml:"local_storage,omitempty"`         // Make local_storage optional
+	S3                     s3.S3Requirements                     `yaml:"s3,omitempty"`                    // Make s3 optional
+}
+
+// CipherKeyEntry is a retired storage cipher key
+type CipherKeyEntry struct {
+	ID        string    `yaml:"id" json:"id"`
+	Key       string    `yaml:"key" json:"key"`
+	RetiredAt time.Time `yaml:"retired_at" json:"retired_at"`
 }
 
 type Database struct {
@@ -42,11 +62,14 @@ type Database struct {
 	LocalFiles localfiles.LocalFilesRequirements `yaml:"local_files,omitempty"`
 	Storages   []string                          `yaml:"storages"`
 	Cron       string                            `yaml:"cron"`
+	Drill      drill.Drill                       `yaml:"drill,omitempty"`
+	Hooks      hooks.Hooks                       `yaml:"hooks,omitempty"`
 }
 
 type Config struct {
-	Version    string     `yaml:"version"`
-	MasterHash string     `yaml:"master_hash,omitempty"`
-	Databases  []Database `yaml:"databases,omitempty"`
-	Storages   []Storage  `yaml:"storages,omitempty"`
+	Version    string      `yaml:"version"`
+	MasterHash string      `yaml:"master_hash,omitempty"`
+	Protection *Protection `yaml:"protection,omitempty"`
+	Databases  []Database  `yaml:"databases,omitempty"`
+	Storages   []Storage   `yaml:"storages,omitempty"`
 }
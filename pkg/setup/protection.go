@@ -0,0 +1,290 @@
+package setup
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/martient/bifrost-backups/pkg/crypto"
+)
+
+// ErrConfigLocked is returned when a restore secret is needed while the config is protected and locked
+var ErrConfigLocked = errors.New("the config is protected by the master password, unlock it first")
+
+// unlockedIdentity opens the restore secrets once the config has been unlocked
+var unlockedIdentity []byte
+
+// Protection splits the config secrets in two: the credentials backups need stay under
+// the host master key, the restore secrets (cipher keys, passphrases) are encrypted for
+// Recipient whose identity is wrapped by the master password. Backups write for the
+// recipient, only an unlocked config can read them back.
+type Protection struct {
+	Recipient string `yaml:"recipient"`
+	Identity  string `yaml:"identity"` // Wrapped with a key derived from the master password
+}
+
+type unlockToken struct {
+	Identity  string    `json:"identity"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// EnableProtection protects the restore secrets of the config with the master password,
+// the caller writes the config back with UpdateConfig. Storages with a passphrase are
+// refused, their backups would be written for the recipient the passphrase can't open.
+func EnableProtection(config *Config, password string) error {
+	if config == nil {
+		return fmt.Errorf("config cannot be nil")
+	} else if noEncryption {
+		return fmt.Errorf("the protection requires the config encryption")
+	} else if config.Protection != nil {
+		return fmt.Errorf("the config is already protected")
+	}
+	for _, storage := range config.Storages {
+		if storage.Passphrase != "" {
+			return fmt.Errorf("storage %s uses a passphrase, remove it before enabling the protection", storage.Name)
+		}
+	}
+	if err := ValidateMasterPassword(config, password); err != nil {
+		return err
+	}
+
+	identity, recipient, err := crypto.GenerateIdentity()
+	if err != nil {
+		return fmt.Errorf("failed to generate the protection identity: %w", err)
+	}
+	wrapped, err := crypto.CipherWithPassphrase(password, []byte(identity))
+	if err != nil {
+		return fmt.Errorf("failed to wrap the protection identity: %w", err)
+	}
+	config.Protection = &Protection{
+		Recipient: recipient,
+		Identity:  base64.StdEncoding.EncodeToString(wrapped.Bytes()),
+	}
+	return setUnlockedIdentity(identity)
+}
+
+// RewrapProtection wraps the protection identity with a new master password
+func RewrapProtection(config *Config, oldPassword string, newPassword string) error {
+	if config == nil || config.Protection == nil {
+		return nil
+	}
+	identity, err := unwrapIdentity(config.Protection, oldPassword)
+	if err != nil {
+		return err
+	}
+	wrapped, err := crypto.CipherWithPassphrase(newPassword, []byte(identity))
+	if err != nil {
+		return fmt.Errorf("failed to wrap the protection identity: %w", err)
+	}
+	config.Protection.Identity = base64.StdEncoding.EncodeToString(wrapped.Bytes())
+	return nil
+}
+
+func unwrapIdentity(protection *Protection, password string) (string, error) {
+	wrapped, err := base64.StdEncoding.DecodeString(protection.Identity)
+	if err != nil {
+		return "", fmt.Errorf("invalid protection identity: %w", err)
+	}
+	identity, err := crypto.DecipherWithPassphrase(password, wrapped)
+	if err != nil {
+		return "", fmt.Errorf("invalid master password")
+	}
+	return identity.String(), nil
+}
+
+func setUnlockedIdentity(identity string) error {
+	private, err := crypto.ParseIdentity(identity)
+	if err != nil {
+		return err
+	}
+	unlockedIdentity = private
+	return nil
+}
+
+// IsProtected reports whether the restore secrets of the config are protected
+func IsProtected() (bool, error) {
+	configMutex.Lock()
+	config, err := loadConfig()
+	configMutex.Unlock()
+	if err != nil {
+		return false, err
+	}
+	return config.Protection != nil, nil
+}
+
+// IsUnlocked reports whether the restore secrets can be read
+func IsUnlocked() bool {
+	return unlockedIdentity != nil
+}
+
+// Lock forgets the protection identity of the running process
+func Lock() {
+	unlockedIdentity = nil
+}
+
+// DecipherProtected decrypts a backup encrypted for the protection recipient
+func DecipherProtected(cipher_text []byte) (*bytes.Buffer, error) {
+	if unlockedIdentity == nil {
+		return nil, ErrConfigLocked
+	}
+	return crypto.DecipherWithIdentities([][]byte{unlockedIdentity}, cipher_text)
+}
+
+// Unlock opens the restore secrets of a protected config with the master password
+func Unlock(password string) error {
+	configMutex.Lock()
+	config, err := loadConfig()
+	configMutex.Unlock()
+	if err != nil {
+		return err
+	}
+	if config.Protection == nil {
+		return nil
+	}
+	if err := ValidateMasterPassword(&config, password); err != nil {
+		return err
+	}
+	identity, err := unwrapIdentity(config.Protection, password)
+	if err != nil {
+		return err
+	}
+	return setUnlockedIdentity(identity)
+}
+
+func getUnlockTokenPath() (string, error) {
+	if path := os.Getenv("BIFROST_UNLOCK_TOKEN_FILE"); path != "" {
+		return path, nil
+	}
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get the user cache folder: %w", err)
+	}
+	return filepath.Join(cacheDir, "bifrost-backups", "unlock"), nil
+}
+
+// CreateUnlockToken keeps the config unlocked for the ttl, the token is encrypted
+// with the host master key so it can't be used on another host
+func CreateUnlockToken(ttl time.Duration) error {
+	if unlockedIdentity == nil {
+		return ErrConfigLocked
+	} else if ttl <= 0 {
+		return fmt.Errorf("the unlock ttl must be positive")
+	}
+	sm, err := NewSecureManager(false)
+	if err != nil {
+		return fmt.Errorf("failed to initialize secure manager: %w", err)
+	}
+
+	identity := crypto.IdentityPrefix + base64.RawURLEncoding.EncodeToString(unlockedIdentity)
+	token, err := json.Marshal(unlockToken{Identity: identity, ExpiresAt: time.Now().Add(ttl).UTC()})
+	if err != nil {
+		return err
+	}
+	encrypted, err := sm.encrypt(string(token))
+	if err != nil {
+		return fmt.Errorf("failed to encrypt the unlock token: %w", err)
+	}
+
+	path, err := getUnlockTokenPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create the unlock token folder: %w", err)
+	}
+	return os.WriteFile(path, []byte(encrypted), 0600)
+}
+
+// UnlockWithToken opens the restore secrets with a valid unlock token
+func UnlockWithToken() error {
+	path, err := getUnlockTokenPath()
+	if err != nil {
+		return err
+	}
+	content, err := os.ReadFile(path) //#nosec G304
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ErrConfigLocked
+		}
+		return fmt.Errorf("failed to read the unlock token: %w", err)
+	}
+	sm, err := NewSecureManager(false)
+	if err != nil {
+		return fmt.Errorf("failed to initialize secure manager: %w", err)
+	}
+	decrypted, err := sm.decrypt("ENC[AES256," + strings.TrimSpace(string(content)) + "]")
+	if err != nil {
+		return fmt.Errorf("failed to decrypt the unlock token: %w", err)
+	}
+
+	var token unlockToken
+	if err := json.Unmarshal([]byte(decrypted), &token); err != nil {
+		return ErrConfigLocked
+	}
+	if time.Now().After(token.ExpiresAt) {
+		_ = RemoveUnlockToken()
+		return ErrConfigLocked
+	}
+	return setUnlockedIdentity(token.Identity)
+}
+
+// RemoveUnlockToken locks the config again before the unlock token expires
+func RemoveUnlockToken() error {
+	path, err := getUnlockTokenPath()
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// sealRestoreSecret encrypts a restore secret, for the protection recipient when
+// the config is protected, otherwise with the host master key
+func (sm *SecureManager) sealRestoreSecret(config *Config, value string) (string, error) {
+	if config.Protection == nil {
+		encrypted, err := sm.encrypt(value)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("ENC[AES256,%s]", encrypted), nil
+	}
+
+	encrypted, err := crypto.CipherForRecipients([]string{config.Protection.Recipient}, []byte(value))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("ENC[X25519,%s]", base64.StdEncoding.EncodeToString(encrypted.Bytes())), nil
+}
+
+// openRestoreSecret decrypts a restore secret, protected secrets stay sealed while locked
+func (sm *SecureManager) openRestoreSecret(value string) (string, error) {
+	if !strings.HasPrefix(value, "ENC[X25519,") {
+		return sm.decrypt(value)
+	}
+	if sm.identity == nil {
+		return value, nil
+	}
+
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSuffix(strings.TrimPrefix(value, "ENC[X25519,"), "]"))
+	if err != nil {
+		return "", fmt.Errorf("invalid protected secret: %w", err)
+	}
+	plaintext, err := crypto.DecipherWithIdentities([][]byte{sm.identity}, data)
+	if err != nil {
+		return "", err
+	}
+	return plaintext.String(), nil
+}
+
+// isSealed reports whether a restore secret is still sealed by the protection
+func isSealed(value string) bool {
+	return strings.HasPrefix(value, "ENC[X25519,")
+}
@@ -0,0 +1,257 @@
+package setup
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/martient/bifrost-backups/pkg/crypto"
+	localstorage "github.com/martient/bifrost-backups/pkg/local_storage"
+)
+
+func newProtectedConfig(t *testing.T, password string) Storage {
+	t.Helper()
+	originalConfigPath := configFilePath
+	t.Cleanup(func() {
+		configFilePath = originalConfigPath
+		Lock()
+	})
+	configFilePath = filepath.Join(t.TempDir(), "config.yaml")
+
+	if err := writeConfig(Config{Version: "1.0"}); err != nil {
+		t.Fatalf("Failed to write initial config: %v", err)
+	}
+	requirements := &localstorage.LocalStorageRequirements{FolderPath: "/tmp/backup"}
+	if err := RegisterStorage(LocalStorage, "primary", 7, "", true, requirements); err != nil {
+		t.Fatalf("RegisterStorage() error = %v", err)
+	}
+	storage, err := ReadStorageConfig("primary")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := EnableProtection(&config, password); err == nil {
+		t.Error("EnableProtection() without a master password should fail")
+	}
+	if err := SetMasterPassword(&config, password); err != nil {
+		t.Fatal(err)
+	}
+	if err := EnableProtection(&config, password); err != nil {
+		t.Fatalf("EnableProtection() error = %v", err)
+	}
+	if err := EnableProtection(&config, password); err == nil {
+		t.Error("EnableProtection() of a protected config should fail")
+	}
+	if err := UpdateConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	Lock()
+	return storage
+}
+
+func TestProtection(t *testing.T) {
+	storage := newProtectedConfig(t, "correct horse")
+
+	content, err := os.ReadFile(configFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), "ENC[X25519,") || strings.Contains(string(content), storage.CipherKey) {
+		t.Error("the cipher key should be sealed for the protection recipient")
+	}
+
+	locked, err := ReadStorageConfig("primary")
+	if err != nil {
+		t.Fatalf("ReadStorageConfig() while locked error = %v", err)
+	}
+	if locked.ProtectionRecipient == "" {
+		t.Error("ReadStorageConfig() should set the protection recipient")
+	}
+	if _, err := locked.CipherKeys(); !errors.Is(err, ErrConfigLocked) {
+		t.Errorf("CipherKeys() while locked error = %v, want %v", err, ErrConfigLocked)
+	}
+	if _, err := SplitCipherKeys(nil, 3, 2); !errors.Is(err, ErrConfigLocked) {
+		t.Errorf("SplitCipherKeys() while locked error = %v, want %v", err, ErrConfigLocked)
+	}
+
+	// Backups are written for the recipient while locked
+	backup, err := crypto.CipherForRecipients([]string{locked.ProtectionRecipient}, []byte("dump"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DecipherProtected(backup.Bytes()); !errors.Is(err, ErrConfigLocked) {
+		t.Errorf("DecipherProtected() while locked error = %v, want %v", err, ErrConfigLocked)
+	}
+
+	if err := Unlock("wrong password"); err == nil {
+		t.Error("Unlock() with a wrong password should fail")
+	}
+	if err := Unlock("correct horse"); err != nil {
+		t.Fatalf("Unlock() error = %v", err)
+	}
+	unlocked, err := ReadStorageConfig("primary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unlocked.CipherKey != storage.CipherKey {
+		t.Error("the unlocked cipher key doesn't match the registered one")
+	}
+	plaintext, err := DecipherProtected(backup.Bytes())
+	if err != nil || plaintext.String() != "dump" {
+		t.Errorf("DecipherProtected() = %v, %v", plaintext, err)
+	}
+
+	// Writing the config while unlocked keeps the secrets sealed
+	if _, err := RotateCipherKey("primary", ""); err != nil {
+		t.Fatalf("RotateCipherKey() error = %v", err)
+	}
+	Lock()
+	content, err = os.ReadFile(configFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(content), storage.CipherKey) || strings.Count(string(content), "ENC[X25519,") != 2 {
+		t.Error("the rotated keys should be sealed for the protection recipient")
+	}
+}
+
+func TestRewrapProtection(t *testing.T) {
+	storage := newProtectedConfig(t, "old password")
+
+	config, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := RewrapProtection(&config, "wrong password", "new password"); err == nil {
+		t.Error("RewrapProtection() with a wrong password should fail")
+	}
+	if err := RewrapProtection(&config, "old password", "new password"); err != nil {
+		t.Fatalf("RewrapProtection() error = %v", err)
+	}
+	if err := SetMasterPassword(&config, "new password"); err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateConfig(config); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Unlock("old password"); err == nil {
+		t.Error("Unlock() with the previous password should fail")
+	}
+	if err := Unlock("new password"); err != nil {
+		t.Fatalf("Unlock() error = %v", err)
+	}
+	unlocked, err := ReadStorageConfig("primary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unlocked.CipherKey != storage.CipherKey {
+		t.Error("the cipher key should survive the password change")
+	}
+}
+
+func TestUnlockToken(t *testing.T) {
+	newProtectedConfig(t, "correct horse")
+	t.Setenv("BIFROST_UNLOCK_TOKEN_FILE", filepath.Join(t.TempDir(), "unlock"))
+
+	if err := CreateUnlockToken(time.Minute); !errors.Is(err, ErrConfigLocked) {
+		t.Errorf("CreateUnlockToken() while locked error = %v, want %v", err, ErrConfigLocked)
+	}
+	if err := UnlockWithToken(); !errors.Is(err, ErrConfigLocked) {
+		t.Errorf("UnlockWithToken() without a token error = %v, want %v", err, ErrConfigLocked)
+	}
+
+	if err := Unlock("correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	if err := CreateUnlockToken(time.Minute); err != nil {
+		t.Fatalf("CreateUnlockToken() error = %v", err)
+	}
+	Lock()
+	if err := UnlockWithToken(); err != nil {
+		t.Fatalf("UnlockWithToken() error = %v", err)
+	}
+	if !IsUnlocked() {
+		t.Error("UnlockWithToken() should unlock the config")
+	}
+
+	if err := RemoveUnlockToken(); err != nil {
+		t.Fatalf("RemoveUnlockToken() error = %v", err)
+	}
+	Lock()
+	if err := UnlockWithToken(); !errors.Is(err, ErrConfigLocked) {
+		t.Errorf("UnlockWithToken() after lock error = %v, want %v", err, ErrConfigLocked)
+	}
+
+	if err := Unlock("correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	if err := CreateUnlockToken(time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	Lock()
+	time.Sleep(5 * time.Millisecond)
+	if err := UnlockWithToken(); !errors.Is(err, ErrConfigLocked) {
+		t.Errorf("UnlockWithToken() with an expired token error = %v, want %v", err, ErrConfigLocked)
+	}
+}
+
+func TestProtectionRefusesPassphraseStorages(t *testing.T) {
+	originalConfigPath := configFilePath
+	t.Cleanup(func() {
+		configFilePath = originalConfigPath
+		Lock()
+	})
+	configFilePath = filepath.Join(t.TempDir(), "config.yaml")
+
+	if err := writeConfig(Config{Version: "1.0"}); err != nil {
+		t.Fatal(err)
+	}
+	requirements := &localstorage.LocalStorageRequirements{FolderPath: "/tmp/backup"}
+	if err := RegisterStorage(LocalStorage, "primary", 7, "", true, requirements); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetStoragePassphrase("primary", "backup passphrase"); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SetMasterPassword(&config, "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	if err := EnableProtection(&config, "correct horse"); err == nil || !strings.Contains(err.Error(), "primary") {
+		t.Errorf("EnableProtection() with a passphrase storage error = %v, want the storage named", err)
+	}
+	if config.Protection != nil {
+		t.Error("EnableProtection() should leave the config unprotected")
+	}
+
+	if err := SetStoragePassphrase("primary", ""); err != nil {
+		t.Fatal(err)
+	}
+	if config, err = loadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetMasterPassword(&config, "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	if err := EnableProtection(&config, "correct horse"); err != nil {
+		t.Fatalf("EnableProtection() error = %v", err)
+	}
+	if err := UpdateConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetStoragePassphrase("primary", "backup passphrase"); err == nil {
+		t.Error("SetStoragePassphrase() on a protected config should fail")
+	}
+}
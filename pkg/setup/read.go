@@ -33,7 +33,24 @@ func init() {
 	configFilePath = filepath.Join(homeDir, ".config", "bifrost_backups.yaml")
 }
 
+// readConfig returns the config with its secrets decrypted, the secret references are
+// resolved by the readers of a single database or storage
 func readConfig() (Config, error) {
+	config, err := loadConfig()
+	if err != nil {
+		return Config{}, err
+	}
+	if config.Protection != nil {
+		for i := range config.Storages {
+			config.Storages[i].ProtectionRecipient = config.Protection.Recipient
+		}
+	}
+	return config, nil
+}
+
+// loadConfig returns the config with its secrets decrypted, the secret references are
+// kept as is so the config can be written back without leaking the resolved values
+func loadConfig() (Config, error) {
 	file, err := os.OpenFile(configFilePath, os.O_RDONLY, 0600) //#nosec
 	if err != nil {
 		return Config{}, fmt.Errorf("error opening config file: %v", err)
@@ -217,7 +234,11 @@ func ReadDatabaseConfig(name string) (Database, error) {
 	}
 	for i := 0; i < len(config.Databases); i++ {
 		if config.Databases[i].Name == name {
-			return config.Databases[i], nil
+			database := config.Databases[i]
+			if err := resolveDatabaseSecrets(&database); err != nil {
+				return Database{}, err
+			}
+			return database, nil
 		}
 	}
 	return Database{}, fmt.Errorf("database with name %q not found", name)
@@ -238,6 +259,21 @@ func GetStorageConfigName() ([]string, error) {
 	return names, nil
 }
 
+// LookupStorage returns the storage as registered in the config, its secret references
+// unresolved, and whether a storage of that name is registered
+func LookupStorage(name string) (Storage, bool, error) {
+	config, err := loadConfig()
+	if err != nil {
+		return Storage{}, false, err
+	}
+	for i := range config.Storages {
+		if config.Storages[i].Name == name {
+			return config.Storages[i], true, nil
+		}
+	}
+	return Storage{}, false, nil
+}
+
 func ReadStorageConfig(name string) (Storage, error) {
 	config, err := readConfig()
 
@@ -246,10 +282,14 @@ func ReadStorageConfig(name string) (Storage, error) {
 	}
 	for i := 0; i < len(config.Storages); i++ {
 		if config.Storages[i].Name == name {
-			if config.Storages[i].RetentionDays == 0 {
-				config.Storages[i].RetentionDays = 21 // Default retention period is 21 days
+			storage := config.Storages[i]
+			if storage.RetentionDays == 0 {
+				storage.RetentionDays = 21 // Default retention period is 21 days
+			}
+			if err := resolveStorageSecrets(&storage); err != nil {
+				return Storage{}, err
 			}
-			return config.Storages[i], nil
+			return storage, nil
 		}
 	}
 	return Storage{}, fmt.Errorf("storage with name %q not found", name)
@@ -5,6 +5,8 @@ import (
 	"os"
 
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/martient/bifrost-backups/pkg/drill"
+	"github.com/martient/bifrost-backups/pkg/hooks"
 	localfiles "github.com/martient/bifrost-backups/pkg/local_files"
 	"github.com/martient/bifrost-backups/pkg/postgresql"
 	"github.com/martient/bifrost-backups/pkg/setup/interactives"
@@ -74,7 +76,7 @@ func RegisterDatabase(databaseType DatabaseType, name string, cronExpr string, s
 	defer configMutex.Unlock()
 
 	// Read the current config
-	currentConfig, err := readConfig()
+	currentConfig, err := loadConfig()
 	if err != nil {
 		return fmt.Errorf("failed to read config: %w", err)
 	}
@@ -110,6 +112,9 @@ func RegisterDatabase(databaseType DatabaseType, name string, cronExpr string, s
 	found := false
 	for i := range currentConfig.Databases {
 		if currentConfig.Databases[i].Name == name {
+			// The drill and the hooks are set apart from the registration
+			newDatabase.Drill = currentConfig.Databases[i].Drill
+			newDatabase.Hooks = currentConfig.Databases[i].Hooks
 			currentConfig.Databases[i] = *newDatabase
 			found = true
 			utils.LogInfo("Database %s configuration updated", "REGISTER DATABASE", name)
@@ -129,3 +134,65 @@ func RegisterDatabase(databaseType DatabaseType, name string, cronExpr string, s
 
 	return nil
 }
+
+// SetDatabaseDrill sets the restore drill of the database, an empty drill only checks the
+// latest backup restores
+func SetDatabaseDrill(name string, databaseDrill drill.Drill) error {
+	if err := databaseDrill.Validate(); err != nil {
+		return err
+	}
+
+	configMutex.Lock()
+	defer configMutex.Unlock()
+
+	currentConfig, err := loadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to read config: %w", err)
+	}
+
+	for i := range currentConfig.Databases {
+		database := &currentConfig.Databases[i]
+		if database.Name != name {
+			continue
+		}
+		if database.Type == LocalFiles && len(databaseDrill.Assertions) > 0 {
+			return fmt.Errorf("local files database %s can't run SQL assertions", name)
+		} else if database.Type == Postgresql && databaseDrill.ScratchDatabase == database.Postgresql.Name {
+			return fmt.Errorf("scratch database of %s can't be the database itself", name)
+		}
+		database.Drill = databaseDrill
+		if err := writeConfig(currentConfig); err != nil {
+			return fmt.Errorf("failed to write config: %w", err)
+		}
+		utils.LogInfo("Database %s drill updated with %d assertion(s)", "REGISTER DATABASE", name, len(databaseDrill.Assertions))
+		return nil
+	}
+	return fmt.Errorf("database %s not found", name)
+}
+
+// SetDatabaseHooks sets the hooks run around the backups and the restores of the database
+func SetDatabaseHooks(name string, databaseHooks hooks.Hooks) error {
+	if err := databaseHooks.Validate(); err != nil {
+		return err
+	}
+
+	configMutex.Lock()
+	defer configMutex.Unlock()
+
+	currentConfig, err := loadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to read config: %w", err)
+	}
+
+	for i := range currentConfig.Databases {
+		if currentConfig.Databases[i].Name == name {
+			currentConfig.Databases[i].Hooks = databaseHooks
+			if err := writeConfig(currentConfig); err != nil {
+				return fmt.Errorf("failed to write config: %w", err)
+			}
+			utils.LogInfo("Database %s hooks updated", "REGISTER DATABASE", name)
+			return nil
+		}
+	}
+	return fmt.Errorf("database %s not found", name)
+}
@@ -1,12 +1,16 @@
 package setup
 
 import (
+	"encoding/base64"
 	"fmt"
 	"os"
+	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/martient/bifrost-backups/pkg/compression"
 	"github.com/martient/bifrost-backups/pkg/crypto"
 	localstorage "github.com/martient/bifrost-backups/pkg/local_storage"
+	"github.com/martient/bifrost-backups/pkg/retention"
 	"github.com/martient/bifrost-backups/pkg/s3"
 	"github.com/martient/bifrost-backups/pkg/setup/interactives"
 	"github.com/martient/golang-utils/utils"
@@ -30,18 +34,29 @@ func RegisterLocalStorage(path string) (*localstorage.LocalStorageRequirements,
 	return requirements, nil
 }
 
-func RegisterS3Storage(bucket_name string, access_key_id string, access_key_secret string, endpoint string, region string) (*s3.S3Requirements, error) {
-	requirements := &s3.S3Requirements{}
-	if len(bucket_name) <= 0 || len(access_key_id) <= 0 || len(access_key_secret) <= 0 || len(region) <= 0 {
-		utils.LogError("bucket_name, access_key_id, access_key_secret, region can't be empty", "Register s3 database", nil)
-		return nil, fmt.Errorf("bucket_name, account_id, access_key_id, access_key_secret, endpoint, region can't be empty")
-	}
-	requirements.BucketName = bucket_name
-	requirements.AccessKeyId = access_key_id
-	requirements.AccessKeySecret = access_key_secret
-	requirements.Endpoint = endpoint
-	requirements.Region = region
-	return requirements, nil
+func RegisterS3Storage(requirements s3.S3Requirements) (*s3.S3Requirements, error) {
+	if len(requirements.BucketName) <= 0 || len(requirements.Region) <= 0 {
+		utils.LogError("bucket_name, region can't be empty", "Register s3 database", nil)
+		return nil, fmt.Errorf("bucket_name, region can't be empty")
+	}
+	if err := s3.ValidateCredentials(requirements); err != nil {
+		utils.LogError("Invalid credentials configuration: %s", "Register s3 database", err)
+		return nil, err
+	}
+	if err := s3.ValidateObjectOptions(requirements); err != nil {
+		utils.LogError("Invalid object options: %s", "Register s3 database", err)
+		return nil, err
+	}
+	if err := s3.ValidateObjectLock(requirements); err != nil {
+		utils.LogError("Invalid object lock options: %s", "Register s3 database", err)
+		return nil, err
+	}
+	if err := s3.ValidateTransport(requirements); err != nil {
+		utils.LogError("Invalid connection options: %s", "Register s3 database", err)
+		return nil, err
+	}
+	requirements.CredentialsMode = s3.GetCredentialsMode(requirements)
+	return &requirements, nil
 }
 
 func RegisterStorage(storageType StorageType, name string, retention int, cipher_key string, compression bool, storage interface{}) error {
@@ -62,12 +77,23 @@ func RegisterStorage(storageType StorageType, name string, retention int, cipher
 	defer configMutex.Unlock()
 
 	// Read current config
-	currentConfig, err := readConfig()
+	currentConfig, err := loadConfig()
 	if err != nil {
 		return fmt.Errorf("failed to read config: %w", err)
 	}
 
-	// Generate cipher key if not provided
+	var existing *Storage
+	for i := range currentConfig.Storages {
+		if currentConfig.Storages[i].Name == name {
+			existing = &currentConfig.Storages[i]
+			break
+		}
+	}
+
+	// Keep the key of an updated storage, otherwise generate one if not provided
+	if cipher_key == "" && existing != nil {
+		cipher_key = existing.CipherKey
+	}
 	if cipher_key == "" {
 		cipher_key, err = crypto.GenerateCipherKey(32)
 		if err != nil {
@@ -82,6 +108,20 @@ func RegisterStorage(storageType StorageType, name string, retention int, cipher
 		CipherKey:     cipher_key,
 		Compression:   compression,
 	}
+	if existing != nil {
+		// The encryption and compression set up by the dedicated commands are kept
+		newStorage.Recipients = existing.Recipients
+		newStorage.Passphrase = existing.Passphrase
+		newStorage.CompressionAlgorithm = existing.CompressionAlgorithm
+		newStorage.CompressionLevel = existing.CompressionLevel
+		newStorage.CompressionThreads = existing.CompressionThreads
+		newStorage.Retention = existing.Retention
+		// A replaced key is retired instead of dropped, the older backups stay readable
+		newStorage.PreviousCipherKeys = existing.PreviousCipherKeys
+		if existing.CipherKey != "" && existing.CipherKey != cipher_key {
+			newStorage.PreviousCipherKeys = retireCipherKey(newStorage.PreviousCipherKeys, existing.CipherKey)
+		}
+	}
 
 	switch req := storage.(type) {
 	case *localstorage.LocalStorageRequirements:
@@ -121,3 +161,235 @@ func RegisterStorage(storageType StorageType, name string, retention int, cipher
 
 	return nil
 }
+
+// SetStoragePassphrase derives the keys of the next backups of the storage from
+// the passphrase, an empty passphrase goes back to the cipher key
+func SetStoragePassphrase(name string, passphrase string) error {
+	if len(passphrase) > maxPasswordLength {
+		return fmt.Errorf("passphrase exceeds maximum length of %d bytes", maxPasswordLength)
+	}
+
+	configMutex.Lock()
+	defer configMutex.Unlock()
+
+	currentConfig, err := loadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to read config: %w", err)
+	}
+	if passphrase != "" && currentConfig.Protection != nil {
+		return fmt.Errorf("a protected config can't use a passphrase, backups are encrypted for the protection recipient")
+	}
+
+	for i := range currentConfig.Storages {
+		if currentConfig.Storages[i].Name == name {
+			currentConfig.Storages[i].Passphrase = passphrase
+			if err := writeConfig(currentConfig); err != nil {
+				return fmt.Errorf("failed to write config: %w", err)
+			}
+			utils.LogInfo("Storage %s passphrase updated", "REGISTER STORAGE", name)
+			return nil
+		}
+	}
+	return fmt.Errorf("storage %s not found", name)
+}
+
+// SetStorageCompression selects how the next backups of the storage are compressed, the
+// older ones stay readable since each backup header records its algorithm
+func SetStorageCompression(name string, options compression.Options) error {
+	if err := options.Validate(); err != nil {
+		return err
+	}
+	algorithm, _ := compression.ParseAlgorithm(string(options.Algorithm))
+
+	configMutex.Lock()
+	defer configMutex.Unlock()
+
+	currentConfig, err := loadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to read config: %w", err)
+	}
+
+	for i := range currentConfig.Storages {
+		storage := &currentConfig.Storages[i]
+		if storage.Name != name {
+			continue
+		}
+		storage.Compression = algorithm != compression.None
+		storage.CompressionAlgorithm, storage.CompressionLevel, storage.CompressionThreads = "", 0, 0
+		if storage.Compression {
+			storage.CompressionAlgorithm = string(algorithm)
+			storage.CompressionLevel = options.Level
+			storage.CompressionThreads = options.Threads
+		}
+		if err := writeConfig(currentConfig); err != nil {
+			return fmt.Errorf("failed to write config: %w", err)
+		}
+		utils.LogInfo("Storage %s compresses with %s", "REGISTER STORAGE", name, algorithm)
+		return nil
+	}
+	return fmt.Errorf("storage %s not found", name)
+}
+
+// SetStorageRetention sets the GFS retention rules of the storage, an empty policy
+// goes back to deleting the backups older than the retention days
+func SetStorageRetention(name string, policy retention.Policy) error {
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+
+	configMutex.Lock()
+	defer configMutex.Unlock()
+
+	currentConfig, err := loadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to read config: %w", err)
+	}
+
+	for i := range currentConfig.Storages {
+		if currentConfig.Storages[i].Name == name {
+			currentConfig.Storages[i].Retention = policy
+			if err := writeConfig(currentConfig); err != nil {
+				return fmt.Errorf("failed to write config: %w", err)
+			}
+			utils.LogInfo("Storage %s retention policy updated", "REGISTER STORAGE", name)
+			return nil
+		}
+	}
+	return fmt.Errorf("storage %s not found", name)
+}
+
+// SetStorageRecipients encrypts the next backups of the storage for the public
+// recipients, the host then can't decrypt them, an empty list goes back to the cipher key
+func SetStorageRecipients(name string, recipients []string) error {
+	for _, recipient := range recipients {
+		if _, err := crypto.ParseRecipient(recipient); err != nil {
+			return err
+		}
+	}
+
+	configMutex.Lock()
+	defer configMutex.Unlock()
+
+	currentConfig, err := loadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to read config: %w", err)
+	}
+
+	for i := range currentConfig.Storages {
+		if currentConfig.Storages[i].Name == name {
+			currentConfig.Storages[i].Recipients = recipients
+			if err := writeConfig(currentConfig); err != nil {
+				return fmt.Errorf("failed to write config: %w", err)
+			}
+			utils.LogInfo("Storage %s encrypts for %d recipient(s)", "REGISTER STORAGE", name, len(recipients))
+			return nil
+		}
+	}
+	return fmt.Errorf("storage %s not found", name)
+}
+
+func retireCipherKey(previous []CipherKeyEntry, cipher_key string) []CipherKeyEntry {
+	if containsCipherKey(previous, cipher_key) {
+		return previous
+	}
+	var id string
+	if key, err := base64.StdEncoding.DecodeString(cipher_key); err == nil {
+		id = crypto.KeyID(key)
+	}
+	return append(previous, CipherKeyEntry{ID: id, Key: cipher_key, RetiredAt: time.Now().UTC()})
+}
+
+// RotateCipherKey promotes a new cipher key for the next backups of the storage,
+// the current key is kept to decrypt the older ones. It returns the new key id.
+func RotateCipherKey(name string, cipher_key string) (string, error) {
+	var err error
+	if cipher_key == "" {
+		cipher_key, err = crypto.GenerateCipherKey(32)
+		if err != nil {
+			return "", errors.Wrap(err, "could not generate cipher key")
+		}
+	}
+	key, err := base64.StdEncoding.DecodeString(cipher_key)
+	if err != nil {
+		return "", fmt.Errorf("the cipher key must be base64 encoded: %w", err)
+	} else if len(key) != 16 && len(key) != 24 && len(key) != 32 {
+		return "", fmt.Errorf("the cipher key must be 16, 24 or 32 bytes long")
+	}
+
+	configMutex.Lock()
+	defer configMutex.Unlock()
+
+	currentConfig, err := loadConfig()
+	if err != nil {
+		return "", fmt.Errorf("failed to read config: %w", err)
+	}
+
+	for i := range currentConfig.Storages {
+		storage := &currentConfig.Storages[i]
+		if storage.Name != name {
+			continue
+		}
+		if storage.CipherKey == cipher_key {
+			return "", fmt.Errorf("the cipher key is already the active key of storage %s", name)
+		}
+		if storage.CipherKey != "" {
+			storage.PreviousCipherKeys = retireCipherKey(storage.PreviousCipherKeys, storage.CipherKey)
+		}
+		storage.CipherKey = cipher_key
+
+		if err := writeConfig(currentConfig); err != nil {
+			return "", fmt.Errorf("failed to write config: %w", err)
+		}
+		utils.LogInfo("Storage %s cipher key rotated", "REGISTER STORAGE", name)
+		return crypto.KeyID(key), nil
+	}
+	return "", fmt.Errorf("storage %s not found", name)
+}
+
+// RetentionPolicy returns the retention rules of the storage, every backup of the
+// retention days when no GFS rule is set, the minimum kept applies in both cases
+func (storage Storage) RetentionPolicy() retention.Policy {
+	policy := storage.Retention
+	if !policy.HasRules() {
+		policy.KeepWithinDays = storage.RetentionDays
+	}
+	return policy
+}
+
+// CompressionOptions returns how the backups of the storage are compressed
+func (storage Storage) CompressionOptions() compression.Options {
+	if !storage.Compression {
+		return compression.Options{Algorithm: compression.None}
+	}
+	algorithm := compression.Algorithm(storage.CompressionAlgorithm)
+	if algorithm == "" {
+		algorithm = compression.Zstd
+	}
+	return compression.Options{Algorithm: algorithm, Level: storage.CompressionLevel, Threads: storage.CompressionThreads}
+}
+
+// CipherKeys returns the decoded keyring of the storage, the active key first
+func (storage Storage) CipherKeys() ([][]byte, error) {
+	encoded := []string{storage.CipherKey}
+	for _, previous := range storage.PreviousCipherKeys {
+		encoded = append(encoded, previous.Key)
+	}
+
+	var keys [][]byte
+	for _, cipher_key := range encoded {
+		if cipher_key == "" {
+			continue
+		} else if isSealed(cipher_key) {
+			return nil, ErrConfigLocked
+		}
+		key, err := base64.StdEncoding.DecodeString(cipher_key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cipher key: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("storage %s has no cipher key", storage.Name)
+	}
+	return keys, nil
+}
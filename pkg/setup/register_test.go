@@ -3,11 +3,17 @@ package setup
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/martient/bifrost-backups/pkg/compression"
+	"github.com/martient/bifrost-backups/pkg/crypto"
+	"github.com/martient/bifrost-backups/pkg/drill"
+	"github.com/martient/bifrost-backups/pkg/hooks"
 	localfiles "github.com/martient/bifrost-backups/pkg/local_files"
 	localstorage "github.com/martient/bifrost-backups/pkg/local_storage"
 	"github.com/martient/bifrost-backups/pkg/postgresql"
+	"github.com/martient/bifrost-backups/pkg/retention"
 	"github.com/martient/bifrost-backups/pkg/s3"
 	"github.com/martient/bifrost-backups/pkg/sqlite3"
 )
@@ -243,3 +249,334 @@ func TestRegisterStorage(t *testing.T) {
 		})
 	}
 }
+
+// newTestConfig points the config at a temporary file holding the test_local storage and the
+// app database, the original config is restored at the end of the test
+func newTestConfig(t *testing.T) {
+	t.Helper()
+	originalConfigPath := configFilePath
+	t.Cleanup(func() { configFilePath = originalConfigPath })
+	configFilePath = filepath.Join(t.TempDir(), "config.yaml")
+
+	if err := writeConfig(Config{Version: "1.0"}); err != nil {
+		t.Fatalf("Failed to write initial config: %v", err)
+	}
+	if err := RegisterStorage(LocalStorage, "test_local", 7, "", true, testStorageRequirements); err != nil {
+		t.Fatalf("RegisterStorage() error = %v", err)
+	}
+	if err := RegisterDatabase(Postgresql, "app", "0 0 * * *", []string{}, testDatabaseRequirements); err != nil {
+		t.Fatalf("RegisterDatabase() error = %v", err)
+	}
+}
+
+var (
+	testStorageRequirements  = &localstorage.LocalStorageRequirements{FolderPath: "/tmp/backup"}
+	testDatabaseRequirements = &postgresql.PostgresqlRequirements{Hostname: "localhost", Name: "app", User: "app"}
+)
+
+func TestSetStorageRecipients(t *testing.T) {
+	newTestConfig(t)
+
+	_, recipient, err := crypto.GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, breakGlass, err := crypto.GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SetStorageRecipients("test_local", []string{"not-a-key"}); err == nil {
+		t.Error("SetStorageRecipients() with an invalid recipient should fail")
+	}
+	if err := SetStorageRecipients("missing", []string{recipient}); err == nil {
+		t.Error("SetStorageRecipients() of an unknown storage should fail")
+	}
+	if err := SetStorageRecipients("test_local", []string{recipient, breakGlass}); err != nil {
+		t.Fatalf("SetStorageRecipients() error = %v", err)
+	}
+
+	storage, err := ReadStorageConfig("test_local")
+	if err != nil {
+		t.Fatalf("ReadStorageConfig() error = %v", err)
+	}
+	if len(storage.Recipients) != 2 || storage.Recipients[0] != recipient || storage.Recipients[1] != breakGlass {
+		t.Errorf("ReadStorageConfig() recipients = %v", storage.Recipients)
+	}
+}
+
+func TestRotateCipherKey(t *testing.T) {
+	newTestConfig(t)
+	original, err := ReadStorageConfig("test_local")
+	if err != nil {
+		t.Fatalf("ReadStorageConfig() error = %v", err)
+	}
+
+	if _, err := RotateCipherKey("test_local", "not base64"); err == nil {
+		t.Error("RotateCipherKey() with an invalid key should fail")
+	}
+	if _, err := RotateCipherKey("test_local", original.CipherKey); err == nil {
+		t.Error("RotateCipherKey() with the active key should fail")
+	}
+	if _, err := RotateCipherKey("missing", ""); err == nil {
+		t.Error("RotateCipherKey() of an unknown storage should fail")
+	}
+
+	id, err := RotateCipherKey("test_local", "")
+	if err != nil {
+		t.Fatalf("RotateCipherKey() error = %v", err)
+	}
+	rotated, err := ReadStorageConfig("test_local")
+	if err != nil {
+		t.Fatalf("ReadStorageConfig() error = %v", err)
+	}
+	if rotated.CipherKey == original.CipherKey {
+		t.Fatal("RotateCipherKey() didn't replace the active key")
+	}
+	if len(rotated.PreviousCipherKeys) != 1 || rotated.PreviousCipherKeys[0].Key != original.CipherKey {
+		t.Fatalf("RotateCipherKey() previous keys = %+v", rotated.PreviousCipherKeys)
+	}
+
+	keys, err := rotated.CipherKeys()
+	if err != nil {
+		t.Fatalf("CipherKeys() error = %v", err)
+	}
+	if len(keys) != 2 || crypto.KeyID(keys[0]) != id || crypto.KeyID(keys[1]) != rotated.PreviousCipherKeys[0].ID {
+		t.Errorf("CipherKeys() returned an unexpected keyring")
+	}
+}
+
+func TestSetStoragePassphrase(t *testing.T) {
+	newTestConfig(t)
+
+	if err := SetStoragePassphrase("missing", "passphrase"); err == nil {
+		t.Error("SetStoragePassphrase() of an unknown storage should fail")
+	}
+	if err := SetStoragePassphrase("test_local", "memorised passphrase"); err != nil {
+		t.Fatalf("SetStoragePassphrase() error = %v", err)
+	}
+
+	content, err := os.ReadFile(configFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(content), "memorised passphrase") {
+		t.Error("the passphrase is stored in plain text")
+	}
+}
+
+func TestSetStorageCompression(t *testing.T) {
+	newTestConfig(t)
+	storage, err := ReadStorageConfig("test_local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if options := storage.CompressionOptions(); options.Algorithm != compression.Zstd {
+		t.Errorf("CompressionOptions() = %+v, want zstd by default", options)
+	}
+
+	if err := SetStorageCompression("test_local", compression.Options{Algorithm: compression.Gzip, Level: 12}); err == nil {
+		t.Error("SetStorageCompression() with an invalid level should fail")
+	}
+	if err := SetStorageCompression("missing", compression.Options{Algorithm: compression.Gzip}); err == nil {
+		t.Error("SetStorageCompression() of an unknown storage should fail")
+	}
+
+	if err := SetStorageCompression("test_local", compression.Options{Algorithm: compression.None}); err != nil {
+		t.Fatalf("SetStorageCompression() error = %v", err)
+	}
+	if storage, err = ReadStorageConfig("test_local"); err != nil {
+		t.Fatal(err)
+	}
+	if storage.Compression || storage.CompressionOptions().Algorithm != compression.None {
+		t.Errorf("SetStorageCompression(none) left %+v", storage.CompressionOptions())
+	}
+}
+
+func TestSetStorageRetention(t *testing.T) {
+	newTestConfig(t)
+	storage, err := ReadStorageConfig("test_local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy := storage.RetentionPolicy(); policy != (retention.Policy{KeepWithinDays: 7}) {
+		t.Errorf("RetentionPolicy() = %+v, want the retention days", policy)
+	}
+
+	if err := SetStorageRetention("test_local", retention.Policy{KeepDaily: -1}); err == nil {
+		t.Error("SetStorageRetention() with a negative rule should fail")
+	}
+	if err := SetStorageRetention("missing", retention.Policy{KeepDaily: 7}); err == nil {
+		t.Error("SetStorageRetention() of an unknown storage should fail")
+	}
+
+	// The minimum kept alone applies on top of the retention days
+	if err := SetStorageRetention("test_local", retention.Policy{MinKeep: 3}); err != nil {
+		t.Fatalf("SetStorageRetention() error = %v", err)
+	}
+	if storage, err = ReadStorageConfig("test_local"); err != nil {
+		t.Fatal(err)
+	}
+	if policy := storage.RetentionPolicy(); policy != (retention.Policy{KeepWithinDays: 7, MinKeep: 3}) {
+		t.Errorf("RetentionPolicy() = %+v, want the retention days and the minimum kept", policy)
+	}
+}
+
+func TestSetDatabaseDrill(t *testing.T) {
+	newTestConfig(t)
+	if err := RegisterDatabase(LocalFiles, "files", "0 0 * * *", []string{}, &localfiles.LocalFilesRequirements{Path: "/tmp/files"}); err != nil {
+		t.Fatalf("RegisterDatabase() error = %v", err)
+	}
+
+	minimum := 1.0
+	want := drill.Drill{
+		ScratchDatabase: "app_drill",
+		Assertions:      []drill.Assertion{{Name: "users", Query: "SELECT count(*) FROM users", Min: &minimum}},
+	}
+	if err := SetDatabaseDrill("app", drill.Drill{ScratchDatabase: "app"}); err == nil {
+		t.Error("SetDatabaseDrill() restoring into the database itself should fail")
+	}
+	if err := SetDatabaseDrill("files", want); err == nil {
+		t.Error("SetDatabaseDrill() with SQL assertions on local files should fail")
+	}
+	if err := SetDatabaseDrill("missing", want); err == nil {
+		t.Error("SetDatabaseDrill() of an unknown database should fail")
+	}
+	if err := SetDatabaseDrill("app", want); err != nil {
+		t.Fatalf("SetDatabaseDrill() error = %v", err)
+	}
+}
+
+func TestSetDatabaseHooks(t *testing.T) {
+	newTestConfig(t)
+
+	if err := SetDatabaseHooks("app", hooks.Hooks{PreBackup: []hooks.Hook{{}}}); err == nil {
+		t.Error("SetDatabaseHooks() with an empty hook should fail")
+	}
+	if err := SetDatabaseHooks("missing", hooks.Hooks{PreBackup: []hooks.Hook{{Command: "true"}}}); err == nil {
+		t.Error("SetDatabaseHooks() of an unknown database should fail")
+	}
+}
+
+// TestRegisterKeepsSettings checks that registering a storage or a database again keeps the
+// settings made by the dedicated commands
+func TestRegisterKeepsSettings(t *testing.T) {
+	minimum := 1.0
+	wantCompression := compression.Options{Algorithm: compression.Xz, Level: 9, Threads: 2}
+	wantRetention := retention.Policy{KeepLast: 3, KeepDaily: 7, KeepWeekly: 4, KeepMonthly: 12, KeepYearly: 5}
+	wantDrill := drill.Drill{
+		ScratchDatabase: "app_drill",
+		Assertions:      []drill.Assertion{{Name: "users", Query: "SELECT count(*) FROM users", Min: &minimum}},
+	}
+	wantHooks := hooks.Hooks{
+		PreBackup:  []hooks.Hook{{Name: "pause", Command: "systemctl stop worker", Timeout: "1m"}},
+		PostBackup: []hooks.Hook{{Name: "resume", Command: "systemctl start worker"}},
+		OnFailure:  []hooks.Hook{{URL: "https://example.com/alert", OnError: hooks.Warn}},
+	}
+	_, recipient, err := crypto.GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		set   func() error
+		check func(t *testing.T, storage Storage, database Database)
+	}{
+		{
+			name: "Recipients",
+			set:  func() error { return SetStorageRecipients("test_local", []string{recipient}) },
+			check: func(t *testing.T, storage Storage, database Database) {
+				if len(storage.Recipients) != 1 || storage.Recipients[0] != recipient {
+					t.Errorf("Recipients = %v, want %v", storage.Recipients, recipient)
+				}
+			},
+		},
+		{
+			name: "Cipher keyring",
+			set: func() error {
+				_, err := RotateCipherKey("test_local", "")
+				return err
+			},
+			check: func(t *testing.T, storage Storage, database Database) {
+				if len(storage.PreviousCipherKeys) != 1 {
+					t.Errorf("PreviousCipherKeys = %+v, want the retired key", storage.PreviousCipherKeys)
+				}
+			},
+		},
+		{
+			name: "Passphrase",
+			set:  func() error { return SetStoragePassphrase("test_local", "memorised passphrase") },
+			check: func(t *testing.T, storage Storage, database Database) {
+				if storage.Passphrase != "memorised passphrase" {
+					t.Errorf("Passphrase = %q", storage.Passphrase)
+				}
+			},
+		},
+		{
+			name: "Compression",
+			set:  func() error { return SetStorageCompression("test_local", wantCompression) },
+			check: func(t *testing.T, storage Storage, database Database) {
+				if options := storage.CompressionOptions(); options != wantCompression {
+					t.Errorf("CompressionOptions() = %+v, want %+v", options, wantCompression)
+				}
+			},
+		},
+		{
+			name: "Retention",
+			set:  func() error { return SetStorageRetention("test_local", wantRetention) },
+			check: func(t *testing.T, storage Storage, database Database) {
+				if policy := storage.RetentionPolicy(); policy != wantRetention {
+					t.Errorf("RetentionPolicy() = %+v, want %+v", policy, wantRetention)
+				}
+			},
+		},
+		{
+			name: "Drill",
+			set:  func() error { return SetDatabaseDrill("app", wantDrill) },
+			check: func(t *testing.T, storage Storage, database Database) {
+				if database.Drill.ScratchDatabase != wantDrill.ScratchDatabase || len(database.Drill.Assertions) != 1 || *database.Drill.Assertions[0].Min != minimum {
+					t.Errorf("Drill = %+v, want %+v", database.Drill, wantDrill)
+				}
+			},
+		},
+		{
+			name: "Hooks",
+			set:  func() error { return SetDatabaseHooks("app", wantHooks) },
+			check: func(t *testing.T, storage Storage, database Database) {
+				if len(database.Hooks.PreBackup) != 1 || database.Hooks.PreBackup[0].Command != wantHooks.PreBackup[0].Command || len(database.Hooks.OnFailure) != 1 || database.Hooks.OnFailure[0].URL != wantHooks.OnFailure[0].URL {
+					t.Errorf("Hooks = %+v, want %+v", database.Hooks, wantHooks)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestConfig(t)
+			if err := tt.set(); err != nil {
+				t.Fatalf("set error = %v", err)
+			}
+
+			if err := RegisterStorage(LocalStorage, "test_local", 14, "", true, testStorageRequirements); err != nil {
+				t.Fatalf("RegisterStorage() error = %v", err)
+			}
+			if err := RegisterDatabase(Postgresql, "app", "0 1 * * *", []string{}, testDatabaseRequirements); err != nil {
+				t.Fatalf("RegisterDatabase() error = %v", err)
+			}
+
+			storage, err := ReadStorageConfig("test_local")
+			if err != nil {
+				t.Fatal(err)
+			}
+			database, err := ReadDatabaseConfig("app")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if storage.RetentionDays != 14 || database.Cron != "0 1 * * *" {
+				t.Errorf("register didn't update the storage or the database: %d days, cron %q", storage.RetentionDays, database.Cron)
+			}
+			tt.check(t, storage, database)
+		})
+	}
+}
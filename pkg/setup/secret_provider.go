@@ -0,0 +1,268 @@
+package setup
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/martient/bifrost-backups/pkg/crypto"
+	"github.com/zalando/go-keyring"
+)
+
+const (
+	SecretProviderKeyring = "keyring"
+	SecretProviderEnv     = "env"
+	SecretProviderFile    = "file"
+	SecretProviderVault   = "vault"
+
+	defaultVaultTransitMount = "transit"
+	defaultVaultTransitKey   = "bifrost-backups"
+)
+
+var secretProvider string
+
+// KeyProvider supplies the master key protecting the secrets of the config
+type KeyProvider interface {
+	MasterKey() ([]byte, error)
+	// HostBound reports whether the config secrets are also bound to the host,
+	// the keys living outside of the host are shared by every replica
+	HostBound() bool
+}
+
+// SetSecretProvider selects the master key provider, empty falls back to
+// BIFROST_SECRET_PROVIDER then to the OS keyring
+func SetSecretProvider(name string) error {
+	switch name {
+	case "", SecretProviderKeyring, SecretProviderEnv, SecretProviderFile, SecretProviderVault:
+		secretProvider = name
+		return nil
+	default:
+		return fmt.Errorf("unknown secret provider %s, expected keyring, env, file or vault", name)
+	}
+}
+
+// SetNoEncryption disables the encryption of the config secrets
+func SetNoEncryption(disabled bool) {
+	noEncryption = disabled
+}
+
+func newKeyProvider() (KeyProvider, error) {
+	name := secretProvider
+	if name == "" {
+		name = os.Getenv("BIFROST_SECRET_PROVIDER")
+	}
+
+	switch name {
+	case "", SecretProviderKeyring:
+		return keyringProvider{}, nil
+	case SecretProviderEnv:
+		return envProvider{variable: "BIFROST_MASTER_KEY"}, nil
+	case SecretProviderFile:
+		path := os.Getenv("BIFROST_MASTER_KEY_FILE")
+		if path == "" {
+			return nil, fmt.Errorf("BIFROST_MASTER_KEY_FILE can't be empty with the file secret provider")
+		}
+		return fileProvider{path: path}, nil
+	case SecretProviderVault:
+		return newVaultProvider()
+	default:
+		return nil, fmt.Errorf("unknown secret provider %s, expected keyring, env, file or vault", name)
+	}
+}
+
+func decodeMasterKey(encoded string, source string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode the master key of %s: %w", source, err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("the master key of %s must be 32 bytes long", source)
+	}
+	return key, nil
+}
+
+// keyringProvider keeps the master key in the OS keyring, generated on first use
+type keyringProvider struct{}
+
+func (keyringProvider) MasterKey() ([]byte, error) {
+	currentUser, err := user.Current()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current user: %w", err)
+	}
+
+	// Try to get the master key from keyring
+	keyStr, err := keyring.Get(keyringService, currentUser.Username)
+	if err != nil {
+		// Generate a new master key if none exists
+		keyStr, err = crypto.GenerateCipherKey(32)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate master key: %w", err)
+		}
+
+		// Store the new key in keyring
+		err = keyring.Set(keyringService, currentUser.Username, keyStr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to store master key: %w", err)
+		}
+	}
+
+	masterKey, err := base64.StdEncoding.DecodeString(keyStr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode master key: %w", err)
+	}
+	return masterKey, nil
+}
+
+func (keyringProvider) HostBound() bool { return true }
+
+// envProvider reads the base64 master key from an environment variable
+type envProvider struct {
+	variable string
+}
+
+func (p envProvider) MasterKey() ([]byte, error) {
+	encoded := os.Getenv(p.variable)
+	if encoded == "" {
+		return nil, fmt.Errorf("%s can't be empty with the env secret provider", p.variable)
+	}
+	return decodeMasterKey(encoded, p.variable)
+}
+
+func (envProvider) HostBound() bool { return false }
+
+// fileProvider reads the base64 master key from a mounted file, e.g. a Kubernetes secret
+type fileProvider struct {
+	path string
+}
+
+func (p fileProvider) MasterKey() ([]byte, error) {
+	content, err := os.ReadFile(p.path) //#nosec G304 -- path provided by the operator
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the master key file: %w", err)
+	}
+	return decodeMasterKey(string(content), p.path)
+}
+
+func (fileProvider) HostBound() bool { return false }
+
+// vaultProvider unwraps the master key with a HashiCorp Vault transit key, only the
+// wrapped key is stored on disk and it's generated by Vault on first use
+type vaultProvider struct {
+	address     string
+	token       string
+	namespace   string
+	mount       string
+	key         string
+	wrappedPath string
+	client      *http.Client
+}
+
+func newVaultProvider() (*vaultProvider, error) {
+	provider := &vaultProvider{
+		address:     strings.TrimSuffix(os.Getenv("VAULT_ADDR"), "/"),
+		token:       os.Getenv("VAULT_TOKEN"),
+		namespace:   os.Getenv("VAULT_NAMESPACE"),
+		mount:       os.Getenv("BIFROST_VAULT_TRANSIT_MOUNT"),
+		key:         os.Getenv("BIFROST_VAULT_TRANSIT_KEY"),
+		wrappedPath: os.Getenv("BIFROST_VAULT_WRAPPED_KEY_FILE"),
+		client:      &http.Client{Timeout: 30 * time.Second},
+	}
+	if provider.address == "" || provider.token == "" {
+		return nil, fmt.Errorf("VAULT_ADDR, VAULT_TOKEN can't be empty with the vault secret provider")
+	}
+	if provider.mount == "" {
+		provider.mount = defaultVaultTransitMount
+	}
+	if provider.key == "" {
+		provider.key = defaultVaultTransitKey
+	}
+	if provider.wrappedPath == "" {
+		provider.wrappedPath = filepath.Join(filepath.Dir(configFilePath), "bifrost_backups.vault_key")
+	}
+	return provider, nil
+}
+
+func (p *vaultProvider) request(operation string, body map[string]string) (map[string]string, error) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	url := fmt.Sprintf("%s/v1/%s/%s/%s", p.address, p.mount, operation, p.key)
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", p.token)
+	if p.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", p.namespace)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("vault transit %s failed: %w", operation, err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Data   map[string]string `json:"data"`
+		Errors []string          `json:"errors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil && resp.StatusCode == http.StatusOK {
+		return nil, fmt.Errorf("invalid vault transit %s response: %w", operation, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("vault transit %s failed with status %d: %s", operation, resp.StatusCode, strings.Join(result.Errors, ", "))
+	}
+	return result.Data, nil
+}
+
+func (p *vaultProvider) MasterKey() ([]byte, error) {
+	wrapped, err := os.ReadFile(p.wrappedPath)
+	if err == nil {
+		data, err := p.request("decrypt", map[string]string{"ciphertext": strings.TrimSpace(string(wrapped))})
+		if err != nil {
+			return nil, err
+		}
+		return decodeMasterKey(data["plaintext"], "vault transit")
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read the wrapped master key: %w", err)
+	}
+
+	data, err := p.request("datakey/plaintext", map[string]string{"bits": "256"})
+	if err != nil {
+		return nil, err
+	}
+	key, err := decodeMasterKey(data["plaintext"], "vault transit")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(p.wrappedPath), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create the wrapped master key folder: %w", err)
+	}
+	if err := os.WriteFile(p.wrappedPath, []byte(data["ciphertext"]+"\n"), 0600); err != nil {
+		return nil, fmt.Errorf("failed to store the wrapped master key: %w", err)
+	}
+	return key, nil
+}
+
+func (*vaultProvider) HostBound() bool { return false }
+
+// hostID identifies the host the config secrets are bound to, BIFROST_HOST_ID
+// replaces the hostname where it isn't stable, e.g. in containers
+func hostID() (string, error) {
+	if id := os.Getenv("BIFROST_HOST_ID"); id != "" {
+		return id, nil
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", fmt.Errorf("failed to get hostname: %w", err)
+	}
+	return hostname, nil
+}
@@ -0,0 +1,140 @@
+package setup
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/martient/bifrost-backups/pkg/crypto"
+)
+
+func withSecretProvider(t *testing.T, name string) {
+	t.Helper()
+	if err := SetSecretProvider(name); err != nil {
+		t.Fatalf("SetSecretProvider() error = %v", err)
+	}
+	t.Cleanup(func() { _ = SetSecretProvider("") })
+}
+
+func TestSetSecretProvider(t *testing.T) {
+	if err := SetSecretProvider("aws-kms"); err == nil {
+		t.Error("SetSecretProvider() with an unknown provider should fail")
+	}
+	for _, name := range []string{"", SecretProviderKeyring, SecretProviderEnv, SecretProviderFile, SecretProviderVault} {
+		if err := SetSecretProvider(name); err != nil {
+			t.Errorf("SetSecretProvider(%q) error = %v", name, err)
+		}
+	}
+	_ = SetSecretProvider("")
+}
+
+func TestEnvAndFileSecretProviders(t *testing.T) {
+	masterKey, err := crypto.GenerateCipherKey(32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "master.key")
+	if err := os.WriteFile(keyFile, []byte(masterKey+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("BIFROST_MASTER_KEY", masterKey)
+	t.Setenv("BIFROST_MASTER_KEY_FILE", keyFile)
+
+	var encrypted string
+	for _, name := range []string{SecretProviderEnv, SecretProviderFile} {
+		t.Run(name, func(t *testing.T) {
+			withSecretProvider(t, name)
+			manager, err := NewSecureManager(false)
+			if err != nil {
+				t.Fatalf("NewSecureManager() error = %v", err)
+			}
+			if manager.hostBound {
+				t.Error("the secrets shouldn't be bound to the host")
+			}
+			if encrypted == "" {
+				if encrypted, err = manager.encrypt("secret"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// Both providers hold the same key, a random hostname must not matter
+			t.Setenv("BIFROST_HOST_ID", "replica-"+name)
+			decrypted, err := manager.decrypt("ENC[AES256," + encrypted + "]")
+			if err != nil || decrypted != "secret" {
+				t.Errorf("decrypt() = %q, %v, want secret", decrypted, err)
+			}
+		})
+	}
+
+	t.Setenv("BIFROST_MASTER_KEY", "too-short")
+	withSecretProvider(t, SecretProviderEnv)
+	if _, err := NewSecureManager(false); err == nil {
+		t.Error("NewSecureManager() with an invalid master key should fail")
+	}
+}
+
+func TestVaultSecretProvider(t *testing.T) {
+	dataKey := bytes.Repeat([]byte{7}, 32)
+	calls := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "root" {
+			w.WriteHeader(http.StatusForbidden)
+			_ = json.NewEncoder(w).Encode(map[string][]string{"errors": {"permission denied"}})
+			return
+		}
+		var body map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		calls[r.URL.Path]++
+
+		data := map[string]string{}
+		switch r.URL.Path {
+		case "/v1/transit/datakey/plaintext/bifrost-backups":
+			data["plaintext"] = base64.StdEncoding.EncodeToString(dataKey)
+			data["ciphertext"] = "vault:v1:wrapped"
+		case "/v1/transit/decrypt/bifrost-backups":
+			if body["ciphertext"] != "vault:v1:wrapped" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			data["plaintext"] = base64.StdEncoding.EncodeToString(dataKey)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+	}))
+	defer server.Close()
+
+	wrappedPath := filepath.Join(t.TempDir(), "vault_key")
+	t.Setenv("VAULT_ADDR", server.URL)
+	t.Setenv("VAULT_TOKEN", "root")
+	t.Setenv("BIFROST_VAULT_WRAPPED_KEY_FILE", wrappedPath)
+	withSecretProvider(t, SecretProviderVault)
+
+	for i := 0; i < 2; i++ {
+		manager, err := NewSecureManager(false)
+		if err != nil {
+			t.Fatalf("NewSecureManager() error = %v", err)
+		}
+		if !bytes.Equal(manager.masterKey, dataKey) {
+			t.Error("NewSecureManager() didn't use the vault data key")
+		}
+	}
+	if calls["/v1/transit/datakey/plaintext/bifrost-backups"] != 1 || calls["/v1/transit/decrypt/bifrost-backups"] != 1 {
+		t.Errorf("unexpected vault calls %v, the data key must be generated once then unwrapped", calls)
+	}
+	wrapped, err := os.ReadFile(wrappedPath)
+	if err != nil || strings.TrimSpace(string(wrapped)) != "vault:v1:wrapped" {
+		t.Errorf("the wrapped key file holds %q, %v", wrapped, err)
+	}
+
+	t.Setenv("VAULT_TOKEN", "wrong")
+	if _, err := NewSecureManager(false); err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Errorf("NewSecureManager() with a wrong token error = %v", err)
+	}
+}
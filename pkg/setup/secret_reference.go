@@ -0,0 +1,112 @@
+package setup
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	secretReferenceEnv  = "env:"
+	secretReferenceFile = "file:"
+	secretReferenceCmd  = "cmd:"
+
+	secretCommandTimeout = 30 * time.Second
+)
+
+// isSecretReference reports whether the value points to a secret kept outside of the config
+func isSecretReference(value string) bool {
+	return strings.HasPrefix(value, secretReferenceEnv) ||
+		strings.HasPrefix(value, secretReferenceFile) ||
+		strings.HasPrefix(value, secretReferenceCmd)
+}
+
+// resolveSecretReference returns the secret the reference points to, plain values are returned as is
+func resolveSecretReference(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, secretReferenceEnv):
+		name := strings.TrimPrefix(value, secretReferenceEnv)
+		secret, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return secret, nil
+	case strings.HasPrefix(value, secretReferenceFile):
+		path := strings.TrimPrefix(value, secretReferenceFile)
+		content, err := os.ReadFile(path) //#nosec G304 -- path set in the config by the operator
+		if err != nil {
+			return "", fmt.Errorf("failed to read secret file %s: %w", path, err)
+		}
+		return strings.TrimRight(string(content), "\r\n"), nil
+	case strings.HasPrefix(value, secretReferenceCmd):
+		command := strings.TrimSpace(strings.TrimPrefix(value, secretReferenceCmd))
+		if command == "" {
+			return "", fmt.Errorf("secret command can't be empty")
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), secretCommandTimeout)
+		defer cancel()
+
+		// The command runs through the shell so its quoted arguments are kept, e.g. pass show "db prod"
+		var stdout, stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, "sh", "-c", command) //#nosec G204 -- command set in the config by the operator
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return "", fmt.Errorf("secret command %s failed: %w: %s", command, err, strings.TrimSpace(stderr.String()))
+		}
+		// Only the first line is used, like "pass show" which prints the password first
+		secret, _, _ := strings.Cut(stdout.String(), "\n")
+		return strings.TrimRight(secret, "\r"), nil
+	default:
+		return value, nil
+	}
+}
+
+// resolveSecretField replaces the reference of a sensitive field by its secret
+func resolveSecretField(value *string, field string, owner string) error {
+	if !isSecretReference(*value) {
+		return nil
+	}
+	secret, err := resolveSecretReference(*value)
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s of %s: %w", field, owner, err)
+	}
+	*value = secret
+	return nil
+}
+
+// resolveDatabaseSecrets replaces the references of the sensitive fields of the database,
+// only the database being used is resolved so an unrelated broken reference doesn't stop it
+func resolveDatabaseSecrets(database *Database) error {
+	return resolveSecretField(&database.Postgresql.Password, "password", database.Name)
+}
+
+// resolveStorageSecrets replaces the references of the sensitive fields of the storage
+func resolveStorageSecrets(storage *Storage) error {
+	fields := []struct {
+		name  string
+		value *string
+	}{
+		{"cypher_key", &storage.CipherKey},
+		{"passphrase", &storage.Passphrase},
+		{"access_key_id", &storage.S3.AccessKeyId},
+		{"access_key_secret", &storage.S3.AccessKeySecret},
+		{"session_token", &storage.S3.SessionToken},
+		{"sse_customer_key", &storage.S3.SSECustomerKey},
+	}
+	for _, field := range fields {
+		if err := resolveSecretField(field.value, field.name, storage.Name); err != nil {
+			return err
+		}
+	}
+	for j := range storage.PreviousCipherKeys {
+		if err := resolveSecretField(&storage.PreviousCipherKeys[j].Key, "previous_cypher_keys", storage.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
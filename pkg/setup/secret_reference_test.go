@@ -0,0 +1,143 @@
+package setup
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	localstorage "github.com/martient/bifrost-backups/pkg/local_storage"
+	"github.com/martient/bifrost-backups/pkg/postgresql"
+	"github.com/martient/bifrost-backups/pkg/s3"
+)
+
+func TestResolveSecretReference(t *testing.T) {
+	secretFile := filepath.Join(t.TempDir(), "s3key")
+	if err := os.WriteFile(secretFile, []byte("from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("BIFROST_TEST_SECRET", "from-env")
+
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{value: "plain", want: "plain"},
+		{value: "env:BIFROST_TEST_SECRET", want: "from-env"},
+		{value: "env:BIFROST_TEST_MISSING", wantErr: true},
+		{value: "file:" + secretFile, want: "from-file"},
+		{value: "file:" + secretFile + ".missing", wantErr: true},
+		{value: "cmd:printf 'from-cmd\\nsecond-line'", want: "from-cmd"},
+		// Quoted arguments are kept whole
+		{value: `cmd:printf '%s' "db prod"`, want: "db prod"},
+		{value: "cmd:false", wantErr: true},
+		{value: "cmd:", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := resolveSecretReference(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveSecretReference() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("resolveSecretReference() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSecretReferencesAreNeverWrittenBack(t *testing.T) {
+	originalConfigPath := configFilePath
+	defer func() { configFilePath = originalConfigPath }()
+	configFilePath = filepath.Join(t.TempDir(), "config.yaml")
+
+	t.Setenv("PGPASSWORD_PROD", "pg-secret")
+	t.Setenv("S3_SECRET_PROD", "s3-secret")
+	config := Config{
+		Version: "1.0",
+		Databases: []Database{{
+			Type:       Postgresql,
+			Name:       "prod",
+			Postgresql: postgresql.PostgresqlRequirements{Hostname: "localhost", Name: "prod", User: "prod", Password: "env:PGPASSWORD_PROD"},
+			Storages:   []string{"s3"},
+		}},
+		Storages: []Storage{{
+			Type:      S3,
+			Name:      "s3",
+			CipherKey: "plain-key",
+			S3:        s3.S3Requirements{BucketName: "bucket", Region: "us-east-1", AccessKeyId: "id", AccessKeySecret: "env:S3_SECRET_PROD"},
+		}},
+	}
+	if err := writeConfig(config); err != nil {
+		t.Fatalf("writeConfig() error = %v", err)
+	}
+
+	database, err := ReadDatabaseConfig("prod")
+	if err != nil {
+		t.Fatalf("ReadDatabaseConfig() error = %v", err)
+	}
+	if database.Postgresql.Password != "pg-secret" {
+		t.Errorf("ReadDatabaseConfig() password = %q, want the resolved secret", database.Postgresql.Password)
+	}
+	storage, err := ReadStorageConfig("s3")
+	if err != nil {
+		t.Fatalf("ReadStorageConfig() error = %v", err)
+	}
+	if storage.S3.AccessKeySecret != "s3-secret" {
+		t.Errorf("ReadStorageConfig() secret = %q, want the resolved secret", storage.S3.AccessKeySecret)
+	}
+
+	// A write path must keep the references
+	if err := RegisterStorage(LocalStorage, "local", 7, "", true, &localstorage.LocalStorageRequirements{FolderPath: "/tmp/backup"}); err != nil {
+		t.Fatalf("RegisterStorage() error = %v", err)
+	}
+	content, err := os.ReadFile(configFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, reference := range []string{"env:PGPASSWORD_PROD", "env:S3_SECRET_PROD"} {
+		if !strings.Contains(string(content), reference) {
+			t.Errorf("the config lost the reference %s", reference)
+		}
+	}
+	for _, secret := range []string{"pg-secret", "s3-secret", "plain-key"} {
+		if strings.Contains(string(content), secret) {
+			t.Errorf("the config holds the secret %s in plain text", secret)
+		}
+	}
+}
+
+func TestSecretReferencesAreResolvedPerEntry(t *testing.T) {
+	originalConfigPath := configFilePath
+	defer func() { configFilePath = originalConfigPath }()
+	configFilePath = filepath.Join(t.TempDir(), "config.yaml")
+
+	t.Setenv("S3_SECRET_GOOD", "s3-secret")
+	config := Config{
+		Version: "1.0",
+		Storages: []Storage{
+			{Type: S3, Name: "good", S3: s3.S3Requirements{BucketName: "bucket", Region: "us-east-1", AccessKeyId: "id", AccessKeySecret: "env:S3_SECRET_GOOD"}},
+			{Type: S3, Name: "broken", S3: s3.S3Requirements{BucketName: "bucket", Region: "us-east-1", AccessKeyId: "id", AccessKeySecret: "env:BIFROST_TEST_UNSET"}},
+		},
+	}
+	if err := writeConfig(config); err != nil {
+		t.Fatalf("writeConfig() error = %v", err)
+	}
+
+	// A broken reference of another storage doesn't stop the listing nor the other storages
+	names, err := GetStorageConfigName()
+	if err != nil || len(names) != 2 {
+		t.Fatalf("GetStorageConfigName() = %v, %v", names, err)
+	}
+	storage, err := ReadStorageConfig("good")
+	if err != nil {
+		t.Fatalf("ReadStorageConfig() error = %v", err)
+	}
+	if storage.S3.AccessKeySecret != "s3-secret" {
+		t.Errorf("ReadStorageConfig() secret = %q, want the resolved secret", storage.S3.AccessKeySecret)
+	}
+	if _, err := ReadStorageConfig("broken"); err == nil {
+		t.Error("ReadStorageConfig() of a storage with a broken reference should fail")
+	}
+}
@@ -8,12 +8,7 @@ import (
 	"encoding/base64"
 	"fmt"
 	"io"
-	"os"
-	"os/user"
 	"strings"
-
-	"github.com/martient/bifrost-backups/pkg/crypto"
-	"github.com/zalando/go-keyring"
 )
 
 const (
@@ -22,7 +17,10 @@ const (
 
 type SecureManager struct {
 	masterKey    []byte
+	hostBound    bool
 	noEncryption bool
+	// identity opens the restore secrets of a protected config, nil while locked
+	identity []byte
 }
 
 // NewSecureManager creates a new secure manager instance
@@ -31,45 +29,32 @@ func NewSecureManager(noEncryption bool) (*SecureManager, error) {
 		return &SecureManager{noEncryption: true}, nil
 	}
 
-	currentUser, err := user.Current()
+	provider, err := newKeyProvider()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get current user: %w", err)
-	}
-
-	// Try to get the master key from keyring
-	keyStr, err := keyring.Get(keyringService, currentUser.Username)
-	if err != nil {
-		// Generate a new master key if none exists
-		keyStr, err = crypto.GenerateCipherKey(32)
-		if err != nil {
-			return nil, fmt.Errorf("failed to generate master key: %w", err)
-		}
-
-		// Store the new key in keyring
-		err = keyring.Set(keyringService, currentUser.Username, keyStr)
-		if err != nil {
-			return nil, fmt.Errorf("failed to store master key: %w", err)
-		}
+		return nil, err
 	}
-
-	masterKey, err := base64.StdEncoding.DecodeString(keyStr)
+	masterKey, err := provider.MasterKey()
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode master key: %w", err)
+		return nil, err
 	}
 
-	return &SecureManager{masterKey: masterKey, noEncryption: false}, nil
+	return &SecureManager{masterKey: masterKey, hostBound: provider.HostBound(), noEncryption: false, identity: unlockedIdentity}, nil
 }
 
-// deriveHostKey derives a host-specific key using the master key and host information
+// deriveHostKey derives a host-specific key using the master key and host information,
+// master keys kept outside of the host aren't bound to it
 func (sm *SecureManager) deriveHostKey() ([]byte, error) {
-	hostname, err := os.Hostname()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get hostname: %w", err)
+	var host string
+	if sm.hostBound {
+		var err error
+		if host, err = hostID(); err != nil {
+			return nil, err
+		}
 	}
 
 	// Create a unique host identifier by combining hostname with master key
 	h := sha256.New()
-	h.Write([]byte(hostname))
+	h.Write([]byte(host))
 	h.Write(sm.masterKey)
 
 	return h.Sum(nil), nil
@@ -154,6 +139,12 @@ func (sm *SecureManager) decrypt(ciphertext string) (string, error) {
 	return string(plaintext), nil
 }
 
+// needsEncryption reports whether a sensitive value is still in plain text, secret
+// references are kept as is since the secret itself isn't stored in the config
+func needsEncryption(value string) bool {
+	return value != "" && !strings.HasPrefix(value, "ENC[") && !isSecretReference(value)
+}
+
 // SecureConfig encrypts sensitive fields in the configuration
 func (sm *SecureManager) SecureConfig(config *Config) error {
 	if sm.noEncryption {
@@ -164,7 +155,7 @@ func (sm *SecureManager) SecureConfig(config *Config) error {
 	for i := range config.Databases {
 		switch config.Databases[i].Type {
 		case Postgresql:
-			if config.Databases[i].Postgresql.Password != "" && !strings.HasPrefix(config.Databases[i].Postgresql.Password, "ENC[AES256,") {
+			if needsEncryption(config.Databases[i].Postgresql.Password) {
 				encrypted, err := sm.encrypt(config.Databases[i].Postgresql.Password)
 				if err != nil {
 					return fmt.Errorf("failed to encrypt PostgreSQL password: %w", err)
@@ -178,21 +169,52 @@ func (sm *SecureManager) SecureConfig(config *Config) error {
 	for i := range config.Storages {
 		switch config.Storages[i].Type {
 		case S3:
-			if config.Storages[i].S3.AccessKeySecret != "" && !strings.HasPrefix(config.Storages[i].S3.AccessKeySecret, "ENC[AES256,") {
+			if needsEncryption(config.Storages[i].S3.AccessKeySecret) {
 				encrypted, err := sm.encrypt(config.Storages[i].S3.AccessKeySecret)
 				if err != nil {
 					return fmt.Errorf("failed to encrypt S3 access key secret: %w", err)
 				}
 				config.Storages[i].S3.AccessKeySecret = fmt.Sprintf("ENC[AES256,%s]", encrypted)
 			}
+			if needsEncryption(config.Storages[i].S3.SessionToken) {
+				encrypted, err := sm.encrypt(config.Storages[i].S3.SessionToken)
+				if err != nil {
+					return fmt.Errorf("failed to encrypt S3 session token: %w", err)
+				}
+				config.Storages[i].S3.SessionToken = fmt.Sprintf("ENC[AES256,%s]", encrypted)
+			}
+			if needsEncryption(config.Storages[i].S3.SSECustomerKey) {
+				encrypted, err := sm.encrypt(config.Storages[i].S3.SSECustomerKey)
+				if err != nil {
+					return fmt.Errorf("failed to encrypt S3 customer encryption key: %w", err)
+				}
+				config.Storages[i].S3.SSECustomerKey = fmt.Sprintf("ENC[AES256,%s]", encrypted)
+			}
 		}
 		// Encrypt CipherKey if not already encrypted
-		if config.Storages[i].CipherKey != "" && !strings.HasPrefix(config.Storages[i].CipherKey, "ENC[AES256,") {
-			encrypted, err := sm.encrypt(config.Storages[i].CipherKey)
+		if needsEncryption(config.Storages[i].CipherKey) {
+			encrypted, err := sm.sealRestoreSecret(config, config.Storages[i].CipherKey)
 			if err != nil {
 				return fmt.Errorf("failed to encrypt storage cipher key: %w", err)
 			}
-			config.Storages[i].CipherKey = fmt.Sprintf("ENC[AES256,%s]", encrypted)
+			config.Storages[i].CipherKey = encrypted
+		}
+		if needsEncryption(config.Storages[i].Passphrase) {
+			encrypted, err := sm.sealRestoreSecret(config, config.Storages[i].Passphrase)
+			if err != nil {
+				return fmt.Errorf("failed to encrypt storage passphrase: %w", err)
+			}
+			config.Storages[i].Passphrase = encrypted
+		}
+		for j := range config.Storages[i].PreviousCipherKeys {
+			previous := &config.Storages[i].PreviousCipherKeys[j]
+			if needsEncryption(previous.Key) {
+				encrypted, err := sm.sealRestoreSecret(config, previous.Key)
+				if err != nil {
+					return fmt.Errorf("failed to encrypt storage previous cipher key: %w", err)
+				}
+				previous.Key = encrypted
+			}
 		}
 	}
 
@@ -226,15 +248,46 @@ func (sm *SecureManager) DecryptConfig(config *Config) error {
 				}
 				config.Storages[i].S3.AccessKeySecret = decrypted
 			}
+			if config.Storages[i].S3.SessionToken != "" {
+				decrypted, err := sm.decrypt(config.Storages[i].S3.SessionToken)
+				if err != nil {
+					return fmt.Errorf("failed to decrypt S3 session token: %w", err)
+				}
+				config.Storages[i].S3.SessionToken = decrypted
+			}
+			if config.Storages[i].S3.SSECustomerKey != "" {
+				decrypted, err := sm.decrypt(config.Storages[i].S3.SSECustomerKey)
+				if err != nil {
+					return fmt.Errorf("failed to decrypt S3 customer encryption key: %w", err)
+				}
+				config.Storages[i].S3.SSECustomerKey = decrypted
+			}
 		}
 		// Decrypt CipherKey if encrypted
-		if strings.HasPrefix(config.Storages[i].CipherKey, "ENC[AES256,") {
-			decrypted, err := sm.decrypt(config.Storages[i].CipherKey)
+		if strings.HasPrefix(config.Storages[i].CipherKey, "ENC[") {
+			decrypted, err := sm.openRestoreSecret(config.Storages[i].CipherKey)
 			if err != nil {
 				return fmt.Errorf("failed to decrypt storage cipher key: %w", err)
 			}
 			config.Storages[i].CipherKey = decrypted
 		}
+		if strings.HasPrefix(config.Storages[i].Passphrase, "ENC[") {
+			decrypted, err := sm.openRestoreSecret(config.Storages[i].Passphrase)
+			if err != nil {
+				return fmt.Errorf("failed to decrypt storage passphrase: %w", err)
+			}
+			config.Storages[i].Passphrase = decrypted
+		}
+		for j := range config.Storages[i].PreviousCipherKeys {
+			previous := &config.Storages[i].PreviousCipherKeys[j]
+			if strings.HasPrefix(previous.Key, "ENC[") {
+				decrypted, err := sm.openRestoreSecret(previous.Key)
+				if err != nil {
+					return fmt.Errorf("failed to decrypt storage previous cipher key: %w", err)
+				}
+				previous.Key = decrypted
+			}
+		}
 	}
 
 	return nil
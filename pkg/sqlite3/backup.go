@@ -47,7 +47,8 @@ func buildCommandArgsBackup(database Sqlite3Requirements) []string {
 	var args []string
 
 	args = append(args, database.Path)
-	args = append(args, ".backup")
+	// .backup needs a destination file, .dump writes the SQL dump on the standard output
+	args = append(args, ".dump")
 
 	return args
 }
@@ -0,0 +1,46 @@
+package sqlite3
+
+import (
+	"bytes"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBuildCommandArgsBackup(t *testing.T) {
+	database := Sqlite3Requirements{Path: "/var/lib/app/app.db"}
+	want := []string{"/var/lib/app/app.db", ".dump"}
+	if args := buildCommandArgsBackup(database); !reflect.DeepEqual(args, want) {
+		t.Errorf("buildCommandArgsBackup() = %v, want %v", args, want)
+	}
+}
+
+func TestRunBackup(t *testing.T) {
+	if _, err := exec.LookPath(sqlite3Command); err != nil {
+		t.Skip("sqlite3 not installed, skipping integration test")
+	}
+
+	database := Sqlite3Requirements{Path: filepath.Join(t.TempDir(), "app.db")}
+	if err := RunRestoration(database, bytes.NewBufferString("CREATE TABLE users (name TEXT); INSERT INTO users VALUES ('alice');")); err != nil {
+		t.Fatalf("RunRestoration() error = %v", err)
+	}
+
+	// The backup is the SQL dump written on the standard output
+	dump, err := RunBackup(database)
+	if err != nil {
+		t.Fatalf("RunBackup() error = %v", err)
+	}
+	if !strings.Contains(dump.String(), "CREATE TABLE users") || !strings.Contains(dump.String(), "'alice'") {
+		t.Errorf("RunBackup() = %q, want the SQL dump of the database", dump.String())
+	}
+
+	restored := Sqlite3Requirements{Path: filepath.Join(t.TempDir(), "restored.db")}
+	if err := RunRestoration(restored, dump); err != nil {
+		t.Fatalf("RunRestoration() error = %v", err)
+	}
+	if output, err := RunQuery(restored, "SELECT name FROM users"); err != nil || output != "alice" {
+		t.Errorf("restored users = %q, %v, want alice", output, err)
+	}
+}
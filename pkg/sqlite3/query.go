@@ -0,0 +1,46 @@
+package sqlite3
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	internalutils "github.com/martient/bifrost-backups/pkg/utils"
+)
+
+// RunQuery runs a read only query on the database and returns its output, one row per line
+func RunQuery(database Sqlite3Requirements, query string) (string, error) {
+	if err := validateRequirements(database); err != nil {
+		return "", err
+	}
+	if err := internalutils.ValidateQuery(query); err != nil {
+		return "", err
+	}
+	sqlite3Path, err := exec.LookPath("sqlite3")
+	if err != nil {
+		return "", fmt.Errorf("sqlite3 command not found: %w", err)
+	}
+
+	args := []string{"-batch", "-noheader", "-readonly", database.Path, query}
+	if err := internalutils.ValidateCommand(sqlite3Path, args, allowedSqliteCommands); err != nil {
+		return "", fmt.Errorf("invalid command arguments: %w", err)
+	}
+
+	cmd := exec.Command(sqlite3Path, args...) //#nosec
+	cmd.Env = []string{
+		"PATH=" + os.Getenv("PATH"),
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("query failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	// sqlite3 exits successfully on some errors of its arguments
+	if stderr.Len() > 0 {
+		return "", fmt.Errorf("query failed: %s", strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
@@ -0,0 +1,50 @@
+package sqlite3
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunQueryRejectsMetaCommands(t *testing.T) {
+	tempDir := t.TempDir()
+	marker := filepath.Join(tempDir, "marker")
+	database := Sqlite3Requirements{Path: filepath.Join(tempDir, "test.db")}
+
+	for _, query := range []string{
+		".shell touch " + marker,
+		"  .system touch " + marker,
+		"\n.output " + marker,
+		`\! touch ` + marker,
+	} {
+		t.Run(query, func(t *testing.T) {
+			_, err := RunQuery(database, query)
+			if err == nil || !strings.Contains(err.Error(), "meta-commands aren't allowed") {
+				t.Errorf("RunQuery() error = %v, want the meta-command rejected", err)
+			}
+			if _, err := os.Stat(marker); err == nil {
+				t.Fatal("RunQuery() ran the meta-command")
+			}
+		})
+	}
+}
+
+func TestRunQuery(t *testing.T) {
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		t.Skip("sqlite3 not found")
+	}
+	database := Sqlite3Requirements{Path: filepath.Join(t.TempDir(), "test.db")}
+	if err := exec.Command("sqlite3", database.Path, "CREATE TABLE t (a); INSERT INTO t VALUES (1), (2);").Run(); err != nil { //#nosec
+		t.Fatal(err)
+	}
+
+	output, err := RunQuery(database, "SELECT count(*) FROM t")
+	if err != nil {
+		t.Fatalf("RunQuery() error = %v", err)
+	}
+	if output != "2" {
+		t.Errorf("RunQuery() = %s, want 2", output)
+	}
+}
@@ -3,9 +3,11 @@ package sqlite3
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"time"
 
 	internalutils "github.com/martient/bifrost-backups/pkg/utils"
 	"github.com/martient/golang-utils/utils"
@@ -19,9 +21,22 @@ var allowedSqliteCommands = map[string][]string{
 		".exit",
 		"-d",
 		"--database",
+		"-batch",
+		"-noheader",
+		"-readonly",
 	},
 }
 
+// safetyCopyLayout timestamps the copy of the database kept before a restore replaces it
+const safetyCopyLayout = "20060102T150405.000000000Z"
+
+// sidecarSuffixes are the files SQLite keeps next to a database, they belong to the replaced
+// database and would corrupt the restored one
+var sidecarSuffixes = []string{"-wal", "-shm", "-journal"}
+
+// RunRestoration restores the backup into a temporary file next to the database, validates
+// it, keeps the current database as a timestamped safety copy and atomically replaces it.
+// The current database is left untouched, or put back, when any step fails.
 func RunRestoration(database Sqlite3Requirements, backup *bytes.Buffer) error {
 	if backup.Len() <= 0 {
 		return fmt.Errorf("backup can't be empty for the restoration process")
@@ -29,7 +44,7 @@ func RunRestoration(database Sqlite3Requirements, backup *bytes.Buffer) error {
 		return err
 	}
 
-	sqlite3Path, err := exec.LookPath("sqlite3")
+	sqlite3Path, err := exec.LookPath(sqlite3Command)
 	if err != nil {
 		return fmt.Errorf("sqlite3 command not found: %w", err)
 	}
@@ -40,70 +55,135 @@ func RunRestoration(database Sqlite3Requirements, backup *bytes.Buffer) error {
 		return fmt.Errorf("invalid database path: %w", err)
 	}
 
-	args := buildCommandArgsClear(database)
-	if err := internalutils.ValidateCommand(sqlite3Path, args, allowedSqliteCommands); err != nil {
-		return fmt.Errorf("invalid command arguments: %w", err)
+	// The temporary file is in the folder of the database so the rename is atomic
+	tempFile, err := os.CreateTemp(dbDir, "."+filepath.Base(database.Path)+".restore-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
 	}
-
-	cmdClear := exec.Command(sqlite3Path, args...) //#nosec
-	cmdClear.Env = []string{
-		"PATH=" + os.Getenv("PATH"),
+	tempPath := tempFile.Name()
+	defer os.Remove(tempPath)
+	if err := tempFile.Close(); err != nil {
+		return fmt.Errorf("failed to close temporary file: %w", err)
+	}
+	if err := os.Remove(tempPath); err != nil {
+		return fmt.Errorf("failed to prepare temporary file: %w", err)
 	}
 
-	var stderrClear bytes.Buffer
-	cmdClear.Stderr = &stderrClear
+	if err := loadBackup(sqlite3Path, tempPath, backup); err != nil {
+		return fmt.Errorf("backup restoration failed: %w", err)
+	}
+	if err := integrityCheck(sqlite3Path, tempPath); err != nil {
+		return fmt.Errorf("restored database is invalid: %w", err)
+	}
 
-	_, err = os.Stat(database.Path)
-	if !os.IsNotExist(err) {
-		err = os.Remove(database.Path)
-		if err != nil {
-			utils.LogErrorInterface("Failed to remove database file '%s'", "SQLITE3", err, database.Path)
-			return fmt.Errorf("database file '%s' could not be removed: %w", database.Path, err)
+	info, err := os.Stat(database.Path)
+	if os.IsNotExist(err) {
+		if err := os.Rename(tempPath, database.Path); err != nil {
+			return fmt.Errorf("failed to move the restored database: %w", err)
 		}
-		utils.LogInfo("Database '%s' removed", "SQLITE3", database.Path)
+		utils.LogInfo("Database '%s' restored", "SQLITE3", database.Path)
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read database file '%s': %w", database.Path, err)
 	}
-
-	err = cmdClear.Run()
-	if err != nil {
-		utils.LogErrorInterface("Failed to recreate the database '%s', %s", "SQLITE3", stderrClear.String(), err)
-		return fmt.Errorf("database creation before restoration failed: %w", err)
+	if err := os.Chmod(tempPath, info.Mode().Perm()); err != nil {
+		return fmt.Errorf("failed to set the database permissions: %w", err)
 	}
-	utils.LogInfo("Database '%s' recreated", "SQLITE3", database.Path)
 
-	args = buildCommandArgsRestore(database)
-	if err := internalutils.ValidateCommand(sqlite3Path, args, allowedSqliteCommands); err != nil {
-		return fmt.Errorf("invalid restore command arguments: %w", err)
+	safetyPath := safetyCopyPath(database.Path, time.Now())
+	if err := copyDatabase(database.Path, safetyPath); err != nil {
+		removeDatabase(safetyPath)
+		return fmt.Errorf("failed to keep a safety copy of the database: %w", err)
 	}
+	utils.LogInfo("Database '%s' saved to '%s'", "SQLITE3", database.Path, safetyPath)
 
-	cmdRestore := exec.Command(sqlite3Path, args...) //#nosec
-	cmdRestore.Env = []string{
-		"PATH=" + os.Getenv("PATH"),
+	rollback := func(cause error) error {
+		if err := copyDatabase(safetyPath, database.Path); err != nil {
+			utils.LogErrorInterface("Failed to roll back database '%s', its safety copy is '%s'", "SQLITE3", err, database.Path, safetyPath)
+			return fmt.Errorf("%w, the rollback failed too: %v", cause, err)
+		}
+		utils.LogWarning("Database '%s' rolled back to its previous version", "SQLITE3", database.Path)
+		return cause
 	}
-	cmdRestore.Stdin = bytes.NewBuffer(backup.Bytes())
 
-	var stderrRestore bytes.Buffer
-	cmdRestore.Stderr = &stderrRestore
-
-	err = cmdRestore.Run()
-	if err != nil {
-		utils.LogErrorInterface("Failed to restore database '%s', %s", "SQLITE3", stderrRestore.String(), err)
-		return fmt.Errorf("backup restoration failed: %w", err)
+	for _, suffix := range sidecarSuffixes {
+		if err := os.Remove(database.Path + suffix); err != nil && !os.IsNotExist(err) {
+			return rollback(fmt.Errorf("failed to remove '%s': %w", database.Path+suffix, err))
+		}
+	}
+	if err := os.Rename(tempPath, database.Path); err != nil {
+		return rollback(fmt.Errorf("failed to replace the database: %w", err))
+	}
+	if err := integrityCheck(sqlite3Path, database.Path); err != nil {
+		return rollback(fmt.Errorf("restored database is invalid: %w", err))
 	}
 	utils.LogInfo("Database '%s' restored", "SQLITE3", database.Path)
 	return nil
 }
 
-func buildCommandArgsClear(database Sqlite3Requirements) []string {
-	var args []string
+// safetyCopyPath returns a safety copy path that isn't taken, a restore within the same
+// timestamp as a previous one gets a counter instead of overwriting its copy
+func safetyCopyPath(path string, now time.Time) string {
+	base := fmt.Sprintf("%s.%s", path, now.UTC().Format(safetyCopyLayout))
+	candidate := base + ".bak"
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(candidate); os.IsNotExist(err) {
+			return candidate
+		}
+		candidate = fmt.Sprintf("%s-%d.bak", base, i)
+	}
+}
 
-	args = append(args, database.Path)
-	args = append(args, ".database")
-	return args
+// copyDatabase copies a database and its write-ahead log to the destination, which is
+// replaced atomically
+func copyDatabase(source string, destination string) error {
+	for _, suffix := range sidecarSuffixes {
+		if err := os.Remove(destination + suffix); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := copyFile(source+"-wal", destination+"-wal"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return copyFile(source, destination)
 }
 
-func buildCommandArgsRestore(database Sqlite3Requirements) []string {
-	var args []string
+// removeDatabase removes a database and its write-ahead log
+func removeDatabase(path string) {
+	os.Remove(path)
+	os.Remove(path + "-wal")
+}
 
-	args = append(args, database.Path)
-	return args
+// copyFile copies a file through a temporary file renamed over the destination
+func copyFile(source string, destination string) error {
+	in, err := os.Open(filepath.Clean(source))
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.CreateTemp(filepath.Dir(destination), "."+filepath.Base(destination)+".copy-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(out.Name(), info.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Rename(out.Name(), destination)
 }
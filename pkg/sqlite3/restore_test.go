@@ -0,0 +1,98 @@
+package sqlite3
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunRestoration(t *testing.T) {
+	if _, err := exec.LookPath(sqlite3Command); err != nil {
+		t.Skip("sqlite3 not installed, skipping integration test")
+	}
+
+	tempDir := t.TempDir()
+	database := Sqlite3Requirements{Path: filepath.Join(tempDir, "app.db")}
+	query := func() string {
+		t.Helper()
+		output, err := RunQuery(database, "SELECT group_concat(name) FROM sqlite_master WHERE type = 'table'")
+		if err != nil {
+			t.Fatalf("RunQuery() error = %v", err)
+		}
+		return output
+	}
+
+	// A missing database is created
+	if err := RunRestoration(database, bytes.NewBufferString("CREATE TABLE old (a);")); err != nil {
+		t.Fatalf("RunRestoration() error = %v", err)
+	}
+	if tables := query(); tables != "old" {
+		t.Errorf("tables = %s, want old", tables)
+	}
+
+	// A broken dump leaves the database untouched
+	if err := RunRestoration(database, bytes.NewBufferString("CREATE TABLE new (a);\nCREATE TABLE (")); err == nil {
+		t.Fatal("RunRestoration() of a broken dump should fail")
+	}
+	if tables := query(); tables != "old" {
+		t.Errorf("tables = %s after a failed restore, want old", tables)
+	}
+	entries, err := os.ReadDir(tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("files after a failed restore = %v, want the database only", entries)
+	}
+
+	// The database is replaced and kept as a safety copy
+	if err := RunRestoration(database, bytes.NewBufferString("CREATE TABLE new (a);")); err != nil {
+		t.Fatalf("RunRestoration() error = %v", err)
+	}
+	if tables := query(); tables != "new" {
+		t.Errorf("tables = %s, want new", tables)
+	}
+	copies, err := filepath.Glob(database.Path + ".*.bak")
+	if err != nil || len(copies) != 1 {
+		t.Fatalf("safety copies = %v, %v, want one", copies, err)
+	}
+	output, err := RunQuery(Sqlite3Requirements{Path: copies[0]}, "SELECT name FROM sqlite_master")
+	if err != nil || strings.TrimSpace(output) != "old" {
+		t.Errorf("safety copy tables = %s, %v, want old", output, err)
+	}
+
+	// A second restore right away keeps its own safety copy
+	if err := RunRestoration(database, bytes.NewBufferString("CREATE TABLE newer (a);")); err != nil {
+		t.Fatalf("RunRestoration() error = %v", err)
+	}
+	if copies, err = filepath.Glob(database.Path + ".*.bak"); err != nil || len(copies) != 2 {
+		t.Fatalf("safety copies = %v, %v, want two", copies, err)
+	}
+}
+
+func TestSafetyCopyPath(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.db")
+	now := time.Date(2026, 10, 15, 3, 0, 0, 123456789, time.UTC)
+
+	first := safetyCopyPath(path, now)
+	if first != path+".20261015T030000.123456789Z.bak" {
+		t.Errorf("safetyCopyPath() = %s", first)
+	}
+	if err := os.WriteFile(first, []byte("old"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	second := safetyCopyPath(path, now)
+	if second != path+".20261015T030000.123456789Z-1.bak" {
+		t.Errorf("safetyCopyPath() of a taken path = %s", second)
+	}
+	if err := os.WriteFile(second, []byte("old"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if third := safetyCopyPath(path, now); third != path+".20261015T030000.123456789Z-2.bak" {
+		t.Errorf("safetyCopyPath() of two taken paths = %s", third)
+	}
+}
@@ -0,0 +1,77 @@
+package sqlite3
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// sqliteFileMagic starts the SQLite database files, the other backups are SQL dumps
+const sqliteFileMagic = "SQLite format 3\x00"
+
+// RunVerification loads a backup in a scratch database and runs PRAGMA integrity_check on it
+func RunVerification(backup *bytes.Buffer) error {
+	if backup == nil || backup.Len() <= 0 {
+		return fmt.Errorf("backup can't be empty for the verification process")
+	}
+
+	sqlite3Path, err := exec.LookPath(sqlite3Command)
+	if err != nil {
+		return fmt.Errorf("sqlite3 command not found: %w", err)
+	}
+
+	tempDir, err := os.MkdirTemp("", "sqlite3_verify_*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary folder: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+	databasePath := filepath.Join(tempDir, "verify.db")
+
+	if err := loadBackup(sqlite3Path, databasePath, backup); err != nil {
+		return err
+	}
+	return integrityCheck(sqlite3Path, databasePath)
+}
+
+// loadBackup writes a backup to a new database file, a SQL dump is replayed and stops at its first error
+func loadBackup(sqlite3Path string, databasePath string, backup *bytes.Buffer) error {
+	if bytes.HasPrefix(backup.Bytes(), []byte(sqliteFileMagic)) {
+		if err := os.WriteFile(databasePath, backup.Bytes(), 0600); err != nil {
+			return fmt.Errorf("failed to write backup to temporary file: %w", err)
+		}
+		return nil
+	}
+
+	cmdLoad := exec.Command(sqlite3Path, "-bail", databasePath) //#nosec
+	cmdLoad.Env = []string{
+		"PATH=" + os.Getenv("PATH"),
+	}
+	cmdLoad.Stdin = bytes.NewReader(backup.Bytes())
+	var stderr bytes.Buffer
+	cmdLoad.Stderr = &stderr
+	if err := cmdLoad.Run(); err != nil {
+		return fmt.Errorf("failed to load the dump: %w: %s", err, stderr.String())
+	}
+	return nil
+}
+
+// integrityCheck runs PRAGMA integrity_check on a database file
+func integrityCheck(sqlite3Path string, databasePath string) error {
+	cmdCheck := exec.Command(sqlite3Path, databasePath, "PRAGMA integrity_check;") //#nosec
+	cmdCheck.Env = []string{
+		"PATH=" + os.Getenv("PATH"),
+	}
+	var stdout, stderr bytes.Buffer
+	cmdCheck.Stdout = &stdout
+	cmdCheck.Stderr = &stderr
+	if err := cmdCheck.Run(); err != nil {
+		return fmt.Errorf("integrity check failed: %w: %s", err, stderr.String())
+	}
+	if result := strings.TrimSpace(stdout.String()); result != "ok" {
+		return fmt.Errorf("integrity check failed: %s", result)
+	}
+	return nil
+}
@@ -4,20 +4,8 @@ import (
 	"fmt"
 	"path/filepath"
 	"strings"
-	"time"
 )
 
-// FormatBackupTimestamp formats the timestamp in a Windows-compatible way
-func FormatBackupTimestamp(t time.Time) string {
-	return fmt.Sprintf("%d-%02d-%02dT%02d:%02d:%03dZ",
-		t.Year(),
-		t.Month(),
-		t.Day(),
-		t.Hour(),
-		t.Minute(),
-		t.Second())
-}
-
 // SanitizePath ensures the path is valid for the current OS
 func SanitizePath(path string) string {
 	// Convert path separators to the correct type for the OS
@@ -50,16 +38,22 @@ func ValidatePath(path string, allowedPaths []string) error {
 	return fmt.Errorf("path is not within allowed directories")
 }
 
-// ValidateCommand checks if a command and its arguments are allowed
+// ValidateCommand checks if a command and its options are allowed, the command is looked up
+// by its base name as it is usually resolved with exec.LookPath. The values (paths, hosts,
+// queries, ...) aren't options and are passed as is without a shell, a query is still
+// interpreted by the client and must be checked with ValidateQuery.
 func ValidateCommand(cmd string, args []string, allowedCmds map[string][]string) error {
 	// Check if command is in allowed list
-	allowedArgs, ok := allowedCmds[cmd]
+	allowedArgs, ok := allowedCmds[filepath.Base(cmd)]
 	if !ok {
 		return fmt.Errorf("command not allowed: %s", cmd)
 	}
 
-	// Validate each argument against allowed patterns
+	// Validate each option against allowed patterns
 	for _, arg := range args {
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
 		valid := false
 		for _, pattern := range allowedArgs {
 			if strings.HasPrefix(arg, pattern) {
@@ -74,3 +68,14 @@ func ValidateCommand(cmd string, args []string, allowedCmds map[string][]string)
 
 	return nil
 }
+
+// ValidateQuery rejects the client meta-commands, a query starting with a sqlite3 dot-command
+// or a psql backslash command (.shell, \!, ...) would run programs on the host, only SQL is
+// accepted.
+func ValidateQuery(query string) error {
+	trimmed := strings.TrimSpace(query)
+	if strings.HasPrefix(trimmed, ".") || strings.HasPrefix(trimmed, `\`) {
+		return fmt.Errorf("meta-commands aren't allowed in a query: %s", trimmed)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package utils
+
+import "testing"
+
+func TestValidateCommand(t *testing.T) {
+	allowed := map[string][]string{
+		"pg_restore": {"-h", "-U", "-d", "--no-owner"},
+	}
+
+	tests := []struct {
+		name    string
+		cmd     string
+		args    []string
+		wantErr bool
+	}{
+		{
+			name: "Command name",
+			cmd:  "pg_restore",
+			args: []string{"-h", "127.0.0.1", "-U", "postgres", "-d", "app"},
+		},
+		{
+			name: "Full path from LookPath",
+			cmd:  "/usr/lib/postgresql/16/bin/pg_restore",
+			args: []string{"-h", "127.0.0.1", "--no-owner"},
+		},
+		{
+			name: "Option with its value",
+			cmd:  "pg_restore",
+			args: []string{"-dapp"},
+		},
+		{
+			name: "Values aren't options",
+			cmd:  "pg_restore",
+			args: []string{"-d", "app; rm -rf /", "/tmp/dump file"},
+		},
+		{
+			name:    "Option not allowed",
+			cmd:     "/usr/bin/pg_restore",
+			args:    []string{"-h", "127.0.0.1", "--clean"},
+			wantErr: true,
+		},
+		{
+			name:    "Command not allowed",
+			cmd:     "/usr/bin/psql",
+			args:    []string{"-h", "127.0.0.1"},
+			wantErr: true,
+		},
+		{
+			name:    "Directory named like the command",
+			cmd:     "/opt/pg_restore/bin/sh",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateCommand(tt.cmd, tt.args, allowed); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateCommand() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
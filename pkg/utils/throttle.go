@@ -0,0 +1,42 @@
+package utils
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimiter spreads the bytes written over time to stay under a bandwidth cap,
+// it is safe to share between concurrent writers
+type RateLimiter struct {
+	mu          sync.Mutex
+	bytesPerSec int64
+	next        time.Time
+}
+
+// NewRateLimiter returns a limiter allowing bytesPerSec bytes per second, nil when unlimited
+func NewRateLimiter(bytesPerSec int64) *RateLimiter {
+	if bytesPerSec <= 0 {
+		return nil
+	}
+	return &RateLimiter{bytesPerSec: bytesPerSec}
+}
+
+// Wait blocks until n more bytes can be sent without exceeding the cap
+func (l *RateLimiter) Wait(n int) {
+	if l == nil || n <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	wait := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(int64(n) * int64(time.Second) / l.bytesPerSec))
+	l.mu.Unlock()
+
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
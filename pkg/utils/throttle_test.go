@@ -0,0 +1,25 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	if limiter := NewRateLimiter(0); limiter != nil {
+		t.Error("NewRateLimiter(0) should be unlimited")
+	}
+	var unlimited *RateLimiter
+	unlimited.Wait(1 << 30)
+
+	// 100 KiB at 200 KiB/s, the first write goes through without waiting
+	limiter := NewRateLimiter(200 * 1024)
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		limiter.Wait(20 * 1024)
+	}
+	elapsed := time.Since(start)
+	if elapsed < 350*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("Wait() took %s, want about 400ms", elapsed)
+	}
+}
@@ -0,0 +1,66 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{name: "Hours", value: "36h", want: 36 * time.Hour},
+		{name: "Go duration", value: "1h30m", want: 90 * time.Minute},
+		{name: "Days", value: "2d", want: 48 * time.Hour},
+		{name: "Fractional days", value: "1.5d", want: 36 * time.Hour},
+		{name: "Weeks", value: " 1w ", want: 7 * 24 * time.Hour},
+		{name: "Negative days", value: "-1d", wantErr: true},
+		{name: "Missing number", value: "d", wantErr: true},
+		{name: "Unknown unit", value: "3y", wantErr: true},
+		{name: "Empty", value: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseDuration(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseDuration() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseDuration() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    time.Time
+		wantErr bool
+	}{
+		{name: "RFC3339", value: "2026-10-15T03:00:00Z", want: time.Date(2026, 10, 15, 3, 0, 0, 0, time.UTC)},
+		{name: "RFC3339 with an offset", value: "2026-10-15T05:00:00+02:00", want: time.Date(2026, 10, 15, 3, 0, 0, 0, time.UTC)},
+		{name: "Local time", value: "2026-10-15 03:00", want: time.Date(2026, 10, 15, 3, 0, 0, 0, time.Local)},
+		{name: "Local time with seconds", value: " 2026-10-15T03:00:05 ", want: time.Date(2026, 10, 15, 3, 0, 5, 0, time.Local)},
+		{name: "Date", value: "2026-10-15", want: time.Date(2026, 10, 15, 0, 0, 0, 0, time.Local)},
+		{name: "Invalid date", value: "2026-13-01", wantErr: true},
+		{name: "Not a time", value: "yesterday", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseTime(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseTime() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("ParseTime() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}